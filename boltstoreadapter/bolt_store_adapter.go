@@ -0,0 +1,608 @@
+package boltstoreadapter
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/internal/watchfeed"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	errRootReadOnly = errors.New("the root directory is read only")
+	errNotConnected = errors.New("the store is not connected")
+)
+
+const (
+	// How often nodes whose TTL has run out are looked for.
+	expiryInterval = 500 * time.Millisecond
+
+	// How many of the latest events are kept for WatchFrom, at least.
+	historySize = 1000
+)
+
+type BoltOptions struct {
+	// The file the store is kept in. It is created if it does not exist.
+	Path string
+
+	// How long Connect waits for another process to close the file. Defaults
+	// to 1 second.
+	Timeout time.Duration
+
+	// Drives TTL expiry. Defaults to the real clock.
+	Clock clock.Clock
+}
+
+// BoltStoreAdapter is a StoreAdapter on a local bbolt file, which only one
+// process can have open at a time.
+//
+// As in etcd v2, every node, leaf or directory, is stored under its key, and
+// directories are created as keys are put under them. Every write is made at
+// the next index, which is kept in the file, and a node's Index is the index
+// it was last modified at. A node is removed once its TTL runs out, along with
+// everything under it.
+//
+// Watches see the writes made through this adapter. WatchFrom can resume from
+// any of the latest 1000 events since Connect, and reports
+// ErrorWatchIndexCleared for an earlier index.
+type BoltStoreAdapter struct {
+	options BoltOptions
+	db      *bolt.DB
+
+	// writeLock orders each write with publishing its events, so that
+	// watchers see events in index order.
+	writeLock sync.Mutex
+	index     uint64
+	feed      *watchfeed.Feed
+
+	// ctx is cancelled by Disconnect, stopping watches, maintained nodes and
+	// expiry.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func New(options *BoltOptions) (*BoltStoreAdapter, error) {
+	if options.Path == "" {
+		return nil, errors.New("no path given for the store")
+	}
+
+	adapterOptions := *options
+	if adapterOptions.Timeout == 0 {
+		adapterOptions.Timeout = time.Second
+	}
+	if adapterOptions.Clock == nil {
+		adapterOptions.Clock = clock.NewClock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &BoltStoreAdapter{
+		options: adapterOptions,
+		feed:    watchfeed.New(historySize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}, nil
+}
+
+func (adapter *BoltStoreAdapter) Connect() error {
+	return adapter.ConnectContext(context.Background())
+}
+
+// ConnectContext opens the file, waiting up to the Timeout option for
+// another process to close it.
+func (adapter *BoltStoreAdapter) ConnectContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	adapter.writeLock.Lock()
+	defer adapter.writeLock.Unlock()
+
+	if adapter.db != nil {
+		return nil
+	}
+
+	db, err := bolt.Open(adapter.options.Path, 0600, &bolt.Options{Timeout: adapter.options.Timeout})
+	if err != nil {
+		return adapter.convertError("Connect", "", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{nodesBucket, expiriesBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+
+		adapter.index = newBoltTxn(tx, time.Time{}).index
+		adapter.feed.Reset(adapter.index)
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return adapter.convertError("Connect", "", err)
+	}
+
+	adapter.db = db
+	go adapter.expireNodes()
+
+	return nil
+}
+
+func (adapter *BoltStoreAdapter) Disconnect() error {
+	adapter.cancel()
+
+	adapter.writeLock.Lock()
+	defer adapter.writeLock.Unlock()
+
+	if adapter.db == nil {
+		return nil
+	}
+
+	return adapter.convertError("Disconnect", "", adapter.db.Close())
+}
+
+// cleanKey returns key as it is stored: rooted, without a trailing slash, and
+// empty for the root itself.
+func cleanKey(key string) string {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" {
+		return ""
+	}
+
+	return cleaned
+}
+
+// dirPrefix returns the prefix of every key under the directory at key.
+func dirPrefix(key string) string {
+	return cleanKey(key) + "/"
+}
+
+// ancestors returns the directories above key, outermost first.
+func ancestors(key string) []string {
+	dirs := []string{}
+	for i := 1; i < len(key); i++ {
+		if key[i] == '/' {
+			dirs = append(dirs, key[:i])
+		}
+	}
+
+	return dirs
+}
+
+// parentKey returns the directory key is in.
+func parentKey(key string) string {
+	return key[:strings.LastIndex(key, "/")]
+}
+
+// convertError wraps err in a *storeadapter.Error for the given operation and
+// key, unwrapping to the matching storeadapter sentinel. Context errors are
+// returned as is.
+func (adapter *BoltStoreAdapter) convertError(op string, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch err.(type) {
+	case *storeadapter.Error, *storeadapter.MultiError:
+		return err
+	}
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+
+	converted := &storeadapter.Error{Op: op, Key: key, Err: err, Cause: err}
+
+	if err == bolt.ErrTimeout {
+		converted.Err = storeadapter.ErrorTimeout
+	}
+
+	return converted
+}
+
+// keyError returns a *storeadapter.Error for one of the storeadapter
+// sentinels, reported at the given index.
+func keyError(op string, key string, index uint64, err error) error {
+	return &storeadapter.Error{Op: op, Key: key, Index: index, Err: err, Cause: err}
+}
+
+// view runs read in a read-only transaction.
+func (adapter *BoltStoreAdapter) view(op string, key string, read func(txn *boltTxn) error) error {
+	if adapter.db == nil {
+		return adapter.convertError(op, key, errNotConnected)
+	}
+
+	err := adapter.db.View(func(tx *bolt.Tx) error {
+		return read(newBoltTxn(tx, adapter.options.Clock.Now()))
+	})
+
+	return adapter.convertError(op, key, err)
+}
+
+// update runs write in a read-write transaction, once the nodes whose TTL
+// has run out have been removed, and publishes the events of its writes once
+// they are committed. It returns the index they were committed at. If write
+// fails, nothing is written.
+func (adapter *BoltStoreAdapter) update(op string, key string, write func(txn *boltTxn) error) (uint64, error) {
+	if adapter.db == nil {
+		return 0, adapter.convertError(op, key, errNotConnected)
+	}
+
+	adapter.writeLock.Lock()
+	defer adapter.writeLock.Unlock()
+
+	var txn *boltTxn
+	err := adapter.db.Update(func(tx *bolt.Tx) error {
+		txn = newBoltTxn(tx, adapter.options.Clock.Now())
+
+		if err := txn.expire(); err != nil {
+			return err
+		}
+
+		if err := write(txn); err != nil {
+			return err
+		}
+
+		return txn.saveIndex()
+	})
+	if err != nil {
+		return 0, adapter.convertError(op, key, err)
+	}
+
+	adapter.publish(txn.committedIndex(), txn.events)
+
+	return txn.committedIndex(), nil
+}
+
+// expireNodes removes the nodes whose TTL has run out, on every tick, until
+// the adapter is disconnected.
+func (adapter *BoltStoreAdapter) expireNodes() {
+	ticker := adapter.options.Clock.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			due := false
+			adapter.view("Expire", "", func(txn *boltTxn) error {
+				due = txn.expiryDue()
+				return nil
+			})
+
+			if due {
+				adapter.update("Expire", "", func(txn *boltTxn) error {
+					return nil
+				})
+			}
+
+		case <-adapter.ctx.Done():
+			return
+		}
+	}
+}
+
+// fanOut makes one write per key, all in one transaction. A write that fails
+// with a *storeadapter.Error changes nothing, and the others still take
+// effect; any other failure fails them all. If any of them fail, it returns a
+// *storeadapter.MultiError holding the outcome for every key.
+func (adapter *BoltStoreAdapter) fanOut(op string, keys []string, write func(txn *boltTxn, i int) error) error {
+	results := make([]storeadapter.KeyResult, len(keys))
+
+	index, err := adapter.update(op, "", func(txn *boltTxn) error {
+		for i, key := range keys {
+			results[i] = storeadapter.KeyResult{Key: key}
+
+			err := write(txn, i)
+			if _, ok := err.(*storeadapter.Error); ok {
+				results[i].Err = err
+			} else if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Index = index
+		}
+	}
+
+	return storeadapter.NewMultiError(results)
+}
+
+func nodeKeys(nodes []storeadapter.StoreNode) []string {
+	keys := make([]string, len(nodes))
+	for i, node := range nodes {
+		keys[i] = node.Key
+	}
+
+	return keys
+}
+
+func (adapter *BoltStoreAdapter) Get(key string) (storeadapter.StoreNode, error) {
+	return adapter.GetContext(context.Background(), key)
+}
+
+func (adapter *BoltStoreAdapter) GetContext(ctx context.Context, key string) (storeadapter.StoreNode, error) {
+	if err := ctx.Err(); err != nil {
+		return storeadapter.StoreNode{}, err
+	}
+
+	key = cleanKey(key)
+
+	var node storeadapter.StoreNode
+	err := adapter.view("Get", key, func(txn *boltTxn) error {
+		current, err := txn.lookup(key)
+		if err != nil {
+			return err
+		}
+
+		if key == "" || current != nil && current.dir {
+			return keyError("Get", key, txn.index, storeadapter.ErrorNodeIsDirectory)
+		}
+
+		if current == nil {
+			return keyError("Get", key, txn.index, storeadapter.ErrorKeyNotFound)
+		}
+
+		node = txn.storeNode(key, *current)
+		return nil
+	})
+
+	return node, err
+}
+
+func (adapter *BoltStoreAdapter) ListRecursively(key string) (storeadapter.StoreNode, error) {
+	return adapter.ListRecursivelyContext(context.Background(), key)
+}
+
+func (adapter *BoltStoreAdapter) ListRecursivelyContext(ctx context.Context, key string) (storeadapter.StoreNode, error) {
+	if err := ctx.Err(); err != nil {
+		return storeadapter.StoreNode{}, err
+	}
+
+	key = cleanKey(key)
+
+	var node storeadapter.StoreNode
+	err := adapter.view("ListRecursively", key, func(txn *boltTxn) error {
+		dir := &record{dir: true, index: txn.index}
+		if key != "" {
+			var err error
+			dir, err = txn.lookup(key)
+			if err != nil {
+				return err
+			}
+		}
+
+		if dir == nil {
+			return keyError("ListRecursively", key, txn.index, storeadapter.ErrorKeyNotFound)
+		}
+
+		if !dir.dir {
+			return keyError("ListRecursively", key, txn.index, storeadapter.ErrorNodeIsNotDirectory)
+		}
+
+		var err error
+		node, err = txn.list(key, *dir)
+		node.Index = txn.index
+		return err
+	})
+
+	return node, err
+}
+
+func (adapter *BoltStoreAdapter) Create(node storeadapter.StoreNode) error {
+	return adapter.CreateContext(context.Background(), node)
+}
+
+func (adapter *BoltStoreAdapter) CreateContext(ctx context.Context, node storeadapter.StoreNode) error {
+	return adapter.txn(ctx, "Create", node.Key,
+		[]storeadapter.TxnCompare{storeadapter.KeyMissing(node.Key)},
+		[]storeadapter.TxnOp{storeadapter.Put(node)},
+	)
+}
+
+func (adapter *BoltStoreAdapter) Update(node storeadapter.StoreNode) error {
+	return adapter.UpdateContext(context.Background(), node)
+}
+
+func (adapter *BoltStoreAdapter) UpdateContext(ctx context.Context, node storeadapter.StoreNode) error {
+	return adapter.txn(ctx, "Update", node.Key,
+		[]storeadapter.TxnCompare{storeadapter.KeyExists(node.Key)},
+		[]storeadapter.TxnOp{storeadapter.Put(node)},
+	)
+}
+
+func (adapter *BoltStoreAdapter) CompareAndSwap(oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) error {
+	return adapter.CompareAndSwapContext(context.Background(), oldNode, newNode)
+}
+
+func (adapter *BoltStoreAdapter) CompareAndSwapContext(ctx context.Context, oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) error {
+	return adapter.txn(ctx, "CompareAndSwap", newNode.Key,
+		[]storeadapter.TxnCompare{storeadapter.ValueEquals(newNode.Key, oldNode.Value)},
+		[]storeadapter.TxnOp{storeadapter.Put(newNode)},
+	)
+}
+
+func (adapter *BoltStoreAdapter) CompareAndSwapByIndex(oldNodeIndex uint64, newNode storeadapter.StoreNode) error {
+	return adapter.CompareAndSwapByIndexContext(context.Background(), oldNodeIndex, newNode)
+}
+
+func (adapter *BoltStoreAdapter) CompareAndSwapByIndexContext(ctx context.Context, oldNodeIndex uint64, newNode storeadapter.StoreNode) error {
+	return adapter.txn(ctx, "CompareAndSwapByIndex", newNode.Key,
+		[]storeadapter.TxnCompare{storeadapter.IndexEquals(newNode.Key, oldNodeIndex)},
+		[]storeadapter.TxnOp{storeadapter.Put(newNode)},
+	)
+}
+
+func (adapter *BoltStoreAdapter) SetMulti(nodes []storeadapter.StoreNode) error {
+	return adapter.SetMultiContext(context.Background(), nodes)
+}
+
+func (adapter *BoltStoreAdapter) SetMultiContext(ctx context.Context, nodes []storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return adapter.fanOut("SetMulti", nodeKeys(nodes), func(txn *boltTxn, i int) error {
+		return txn.putLeaf("SetMulti", nodes[i])
+	})
+}
+
+func (adapter *BoltStoreAdapter) Delete(keys ...string) error {
+	return adapter.DeleteContext(context.Background(), keys...)
+}
+
+func (adapter *BoltStoreAdapter) DeleteContext(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return adapter.fanOut("Delete", keys, func(txn *boltTxn, i int) error {
+		return txn.deleteNode("Delete", cleanKey(keys[i]))
+	})
+}
+
+func (adapter *BoltStoreAdapter) DeleteLeaves(keys ...string) error {
+	return adapter.DeleteLeavesContext(context.Background(), keys...)
+}
+
+func (adapter *BoltStoreAdapter) DeleteLeavesContext(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return adapter.fanOut("DeleteLeaves", keys, func(txn *boltTxn, i int) error {
+		key := cleanKey(keys[i])
+		if key == "" {
+			return keyError("DeleteLeaves", key, txn.index, errRootReadOnly)
+		}
+
+		current, err := txn.record(key)
+		if err != nil {
+			return err
+		}
+
+		if current == nil {
+			return keyError("DeleteLeaves", key, txn.index, storeadapter.ErrorKeyNotFound)
+		}
+
+		if current.dir && txn.hasChildren(key) {
+			return keyError("DeleteLeaves", key, txn.index, storeadapter.ErrorDirectoryNotEmpty)
+		}
+
+		return txn.deleteTree(key, *current, storeadapter.DeleteEvent)
+	})
+}
+
+func (adapter *BoltStoreAdapter) CompareAndDelete(nodes ...storeadapter.StoreNode) error {
+	return adapter.CompareAndDeleteContext(context.Background(), nodes...)
+}
+
+func (adapter *BoltStoreAdapter) CompareAndDeleteContext(ctx context.Context, nodes ...storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return adapter.fanOut("CompareAndDelete", nodeKeys(nodes), func(txn *boltTxn, i int) error {
+		return txn.apply("CompareAndDelete",
+			[]storeadapter.TxnCompare{storeadapter.ValueEquals(nodes[i].Key, nodes[i].Value)},
+			[]storeadapter.TxnOp{storeadapter.DeleteKey(nodes[i].Key)},
+		)
+	})
+}
+
+func (adapter *BoltStoreAdapter) CompareAndDeleteByIndex(nodes ...storeadapter.StoreNode) error {
+	return adapter.CompareAndDeleteByIndexContext(context.Background(), nodes...)
+}
+
+func (adapter *BoltStoreAdapter) CompareAndDeleteByIndexContext(ctx context.Context, nodes ...storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return adapter.fanOut("CompareAndDeleteByIndex", nodeKeys(nodes), func(txn *boltTxn, i int) error {
+		return txn.apply("CompareAndDeleteByIndex",
+			[]storeadapter.TxnCompare{storeadapter.IndexEquals(nodes[i].Key, nodes[i].Index)},
+			[]storeadapter.TxnOp{storeadapter.DeleteKey(nodes[i].Key)},
+		)
+	})
+}
+
+func (adapter *BoltStoreAdapter) Txn(comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	return adapter.TxnContext(context.Background(), comparisons, operations)
+}
+
+func (adapter *BoltStoreAdapter) TxnContext(ctx context.Context, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	return adapter.txn(ctx, "Txn", "", comparisons, operations)
+}
+
+func (adapter *BoltStoreAdapter) txn(ctx context.Context, op string, key string, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, err := adapter.update(op, cleanKey(key), func(txn *boltTxn) error {
+		return txn.apply(op, comparisons, operations)
+	})
+
+	return err
+}
+
+func (adapter *BoltStoreAdapter) UpdateDirTTL(key string, ttl uint64) error {
+	return adapter.UpdateDirTTLContext(context.Background(), key, ttl)
+}
+
+// As in etcd v2, the directory itself is given the TTL, and once it runs out
+// the directory is removed with everything under it. A TTL of 0 removes it.
+func (adapter *BoltStoreAdapter) UpdateDirTTLContext(ctx context.Context, key string, ttl uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	key = cleanKey(key)
+
+	_, err := adapter.update("UpdateDirTTL", key, func(txn *boltTxn) error {
+		if key == "" {
+			return keyError("UpdateDirTTL", key, txn.index, errRootReadOnly)
+		}
+
+		current, err := txn.record(key)
+		if err != nil {
+			return err
+		}
+
+		if current == nil {
+			return keyError("UpdateDirTTL", key, txn.index, storeadapter.ErrorKeyNotFound)
+		}
+
+		if !current.dir {
+			return keyError("UpdateDirTTL", key, txn.index, storeadapter.ErrorNodeIsNotDirectory)
+		}
+
+		updated := *current
+		updated.index = txn.writeIndex()
+		updated.expires = txn.expiresAt(ttl)
+
+		if err := txn.put(key, current, updated); err != nil {
+			return err
+		}
+
+		txn.emit(storeadapter.UpdateEvent, key, &updated, current)
+		return nil
+	})
+
+	return err
+}
@@ -0,0 +1,32 @@
+package boltstoreadapter_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+var storeDir string
+
+func TestStoreAdapter(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	SetDefaultEventuallyTimeout(5 * time.Second)
+
+	RunSpecs(t, "Bolt Store Adapter Suite")
+}
+
+var _ = BeforeSuite(func() {
+	var err error
+	storeDir, err = ioutil.TempDir("", "boltstoreadapter")
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = AfterSuite(func() {
+	os.RemoveAll(storeDir)
+})
@@ -0,0 +1,388 @@
+package boltstoreadapter_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	. "github.com/cloudfoundry/storeadapter"
+	. "github.com/cloudfoundry/storeadapter/boltstoreadapter"
+	. "github.com/cloudfoundry/storeadapter/storenodematchers"
+	"github.com/cloudfoundry/storeadapter/test_helpers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var counter = 0
+
+// The behaviour every adapter shares is covered by the conformance suite, in
+// conformance_test.go; these are the specs particular to this adapter.
+var _ = Describe("Bolt Store Adapter", func() {
+	var (
+		adapter       *BoltStoreAdapter
+		storePath     string
+		breakfastNode StoreNode
+		lunchNode     StoreNode
+	)
+
+	BeforeEach(func() {
+		breakfastNode = StoreNode{
+			Key:   "/menu/breakfast",
+			Value: []byte("waffles"),
+		}
+
+		lunchNode = StoreNode{
+			Key:   "/menu/lunch",
+			Value: []byte("burgers"),
+		}
+
+		storePath = filepath.Join(storeDir, fmt.Sprintf("store-%d.db", counter))
+		counter++
+
+		var err error
+		adapter, err = New(&BoltOptions{Path: storePath})
+		Expect(err).NotTo(HaveOccurred())
+		err = adapter.Connect()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		adapter.Disconnect()
+	})
+
+	It("is a ContextStoreAdapter", func() {
+		var contextAdapter ContextStoreAdapter = adapter
+		Expect(NewContextStoreAdapter(adapter)).To(BeIdenticalTo(contextAdapter))
+	})
+
+	Describe("Connect", func() {
+		Context("when another adapter has the file open", func() {
+			It("times out", func() {
+				otherAdapter, err := New(&BoltOptions{Path: storePath, Timeout: 100 * time.Millisecond})
+				Expect(err).NotTo(HaveOccurred())
+				defer otherAdapter.Disconnect()
+
+				err = otherAdapter.Connect()
+				Expect(err).To(MatchError(ErrorTimeout))
+			})
+		})
+
+		Context("when the file is opened again", func() {
+			It("has kept the nodes and the index", func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				breakfast, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+
+				adapter.Disconnect()
+
+				adapter, err = New(&BoltOptions{Path: storePath})
+				Expect(err).NotTo(HaveOccurred())
+				err = adapter.Connect()
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(Equal(breakfast))
+
+				err = adapter.SetMulti([]StoreNode{lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				lunch, err := adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lunch.Index).To(BeNumerically(">", breakfast.Index))
+			})
+		})
+	})
+
+	Describe("Get", func() {
+		BeforeEach(func() {
+			err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the node with the index it was written at", func() {
+			value, err := adapter.Get("/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value.Index).To(BeEquivalentTo(1))
+		})
+
+		It("cleans the key", func() {
+			value, err := adapter.Get("menu//breakfast/")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(MatchStoreNode(breakfastNode))
+		})
+
+		It("reports the index in an error", func() {
+			_, err := adapter.Get("/not_a_key")
+
+			storeErr, ok := err.(*Error)
+			Expect(ok).To(BeTrue())
+			Expect(storeErr.Index).NotTo(BeZero())
+		})
+	})
+
+	Context("When setting a key with a non-zero TTL", func() {
+		var fakeClock *fakeclock.FakeClock
+
+		BeforeEach(func() {
+			adapter.Disconnect()
+
+			fakeClock = fakeclock.NewFakeClock(time.Now())
+
+			var err error
+			adapter, err = New(&BoltOptions{Path: storePath, Clock: fakeClock})
+			Expect(err).NotTo(HaveOccurred())
+			err = adapter.Connect()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("reports the time left on the clock, and disappears once it runs out", func() {
+			breakfastNode.TTL = 10
+			err := adapter.SetMulti([]StoreNode{breakfastNode})
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeClock.Increment(3500 * time.Millisecond)
+
+			value, err := adapter.Get("/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value.TTL).To(BeEquivalentTo(7))
+
+			fakeClock.Increment(6500 * time.Millisecond)
+
+			_, err = adapter.Get("/menu/breakfast")
+			Expect(err).To(MatchError(ErrorKeyNotFound))
+		})
+
+		It("is removed from the file, and reported as expired", func() {
+			breakfastNode.TTL = 1
+			err := adapter.SetMulti([]StoreNode{breakfastNode})
+			Expect(err).NotTo(HaveOccurred())
+
+			events, _, _ := adapter.Watch("/menu")
+
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+
+			var event WatchEvent
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(ExpireEvent))
+			Expect(event.PrevNode.Key).To(Equal("/menu/breakfast"))
+
+			menu, err := adapter.ListRecursively("/menu")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(menu.ChildNodes).To(BeEmpty())
+		})
+	})
+
+	Describe("Txn", func() {
+		It("applies all of the operations at one index", func() {
+			err := adapter.SetMulti([]StoreNode{lunchNode})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = adapter.Txn(
+				[]TxnCompare{KeyMissing("/menu/dinner")},
+				[]TxnOp{
+					Put(StoreNode{Key: "/menu/breakfast", Value: []byte("crepes")}),
+					Put(StoreNode{Key: "/menu/dinner", Value: []byte("steak")}),
+					DeleteKey("/menu/lunch"),
+				},
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			breakfast, err := adapter.Get("/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+
+			dinner, err := adapter.Get("/menu/dinner")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dinner.Index).To(Equal(breakfast.Index))
+		})
+	})
+
+	Describe("Watching", func() {
+		It("sends one event for a directory that is deleted, to watchers under it too", func(done Done) {
+			err := adapter.SetMulti([]StoreNode{{Key: "/foo/a/b", Value: []byte("some value")}})
+			Expect(err).ToNot(HaveOccurred())
+
+			events, _, _ := adapter.Watch("/foo/a/b")
+
+			err = adapter.Delete("/foo")
+			Expect(err).ToNot(HaveOccurred())
+
+			event := <-events
+			Expect(event.Type).To(Equal(DeleteEvent))
+			Expect(event.PrevNode.Key).To(Equal("/foo"))
+			Expect(event.PrevNode.Dir).To(BeTrue())
+
+			close(done)
+		}, 5.0)
+
+		It("sends the events of every writer in index order", func() {
+			events, stop, _ := adapter.Watch("/foo")
+			defer func() { stop <- true }()
+
+			written := make(chan bool)
+			for i := 0; i < 10; i++ {
+				go func(i int) {
+					defer GinkgoRecover()
+					err := adapter.SetMulti([]StoreNode{{Key: fmt.Sprintf("/foo/%d", i), Value: []byte("some value")}})
+					Expect(err).ToNot(HaveOccurred())
+					written <- true
+				}(i)
+			}
+
+			lastIndex := uint64(0)
+			for i := 0; i < 10; i++ {
+				var event WatchEvent
+				Eventually(events).Should(Receive(&event))
+				Expect(event.Index).To(BeNumerically(">", lastIndex))
+				lastIndex = event.Index
+				<-written
+			}
+		})
+	})
+
+	Describe("Watching from an index", func() {
+		Context("when the events after the index were written before the file was opened", func() {
+			var firstIndex, latestIndex uint64
+
+			BeforeEach(func() {
+				for _, value := range []string{"1", "2", "3"} {
+					err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte(value)}})
+					Expect(err).ToNot(HaveOccurred())
+
+					node, err := adapter.Get("/foo/a")
+					Expect(err).ToNot(HaveOccurred())
+					if value == "1" {
+						firstIndex = node.Index
+					}
+					latestIndex = node.Index
+				}
+
+				adapter.Disconnect()
+
+				var err error
+				adapter, err = New(&BoltOptions{Path: storePath})
+				Expect(err).NotTo(HaveOccurred())
+				err = adapter.Connect()
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("reports ErrorWatchIndexCleared at the latest index, and stops", func() {
+				events, _, errChan := adapter.WatchFrom("/foo", firstIndex)
+
+				var err error
+				Eventually(errChan).Should(Receive(&err))
+				Expect(err).To(MatchError(ErrorWatchIndexCleared))
+
+				storeErr, ok := err.(*Error)
+				Expect(ok).To(BeTrue())
+				Expect(storeErr.Index).To(Equal(latestIndex))
+
+				Eventually(events).Should(BeClosed())
+			})
+
+			It("resumes from the latest index", func(done Done) {
+				events, stop, _ := adapter.WatchFrom("/foo", latestIndex)
+
+				err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte("4")}})
+				Expect(err).ToNot(HaveOccurred())
+
+				event := <-events
+				Expect(string(event.Node.Value)).To(Equal("4"))
+
+				stop <- true
+
+				close(done)
+			}, 5.0)
+		})
+	})
+
+	Describe("Maintaining a node's presence (and lack thereof)", func() {
+		var uniqueStoreNodeForThisTest StoreNode
+
+		BeforeEach(func() {
+			uniqueStoreNodeForThisTest = StoreNode{
+				Key: fmt.Sprintf("/analyzer-%d", counter),
+				TTL: 2,
+			}
+
+			counter++
+		})
+
+		It("reports the released node as deleted rather than expired", func() {
+			nodeStatus, releaseLock, err := adapter.MaintainNode(uniqueStoreNodeForThisTest)
+			Expect(err).NotTo(HaveOccurred())
+
+			reporter := test_helpers.NewStatusReporter(nodeStatus)
+			Eventually(reporter.Locked, 2).Should(BeTrue())
+
+			events, stop, _ := adapter.Watch(uniqueStoreNodeForThisTest.Key)
+			defer func() { stop <- true }()
+
+			released := make(chan bool)
+			releaseLock <- released
+			Eventually(released).Should(BeClosed())
+
+			var event WatchEvent
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(DeleteEvent))
+		})
+	})
+
+	Describe("with a context", func() {
+		Context("when the context is already done", func() {
+			It("refuses to maintain a node", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				nodeStatus, releaseLock, err := adapter.MaintainNodeContext(ctx, StoreNode{Key: "/lock", TTL: 2})
+				Expect(err).To(Equal(context.Canceled))
+				Expect(nodeStatus).To(BeNil())
+				Expect(releaseLock).To(BeNil())
+			})
+		})
+
+		Context("when the context is done while maintaining a node", func() {
+			It("releases the node and closes the status channel", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				storeNode := StoreNode{Key: fmt.Sprintf("/analyzer-%d", counter), TTL: 2}
+				counter++
+
+				nodeStatus, _, err := adapter.MaintainNodeContext(ctx, storeNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				reporter := test_helpers.NewStatusReporter(nodeStatus)
+				Eventually(reporter.Locked, 2).Should(BeTrue())
+
+				cancel()
+
+				Eventually(reporter.Reporting).Should(BeFalse())
+
+				_, err = adapter.Get(storeNode.Key)
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("acknowledges a release sent afterwards", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				storeNode := StoreNode{Key: fmt.Sprintf("/analyzer-%d", counter), TTL: 2}
+				counter++
+
+				nodeStatus, releaseLock, err := adapter.MaintainNodeContext(ctx, storeNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				reporter := test_helpers.NewStatusReporter(nodeStatus)
+				Eventually(reporter.Locked, 2).Should(BeTrue())
+
+				cancel()
+				Eventually(reporter.Reporting).Should(BeFalse())
+
+				released := make(chan bool)
+				Eventually(releaseLock).Should(BeSent(released))
+				Eventually(released).Should(BeClosed())
+			})
+		})
+	})
+})
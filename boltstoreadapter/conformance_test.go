@@ -0,0 +1,21 @@
+package boltstoreadapter_test
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/cloudfoundry/storeadapter"
+	. "github.com/cloudfoundry/storeadapter/boltstoreadapter"
+	"github.com/cloudfoundry/storeadapter/storeadaptertest"
+	. "github.com/onsi/gomega"
+)
+
+var _ = storeadaptertest.DescribeConformance("Bolt Store Adapter conformance", storeadaptertest.Backend{
+	NewStoreAdapter: func() storeadapter.StoreAdapter {
+		counter++
+		adapter, err := New(&BoltOptions{Path: filepath.Join(storeDir, fmt.Sprintf("conformance-%d.db", counter))})
+		Expect(err).NotTo(HaveOccurred())
+		return adapter
+	},
+	EmptyDirectoriesRemain: true,
+})
@@ -0,0 +1,540 @@
+package boltstoreadapter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/storeadapter"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// Every node, under its key.
+	nodesBucket = []byte("nodes")
+
+	// An empty value for every node with a TTL, under the time it expires
+	// followed by its key, so that they are in the order they expire.
+	expiriesBucket = []byte("expiries")
+
+	// The index of the latest write, under indexKey.
+	metaBucket = []byte("meta")
+	indexKey   = []byte("index")
+)
+
+// record is a node as stored: a byte that is 1 for a directory, the index the
+// node was last modified at, when it expires in Unix nanoseconds or 0 for
+// never, and the value of a leaf.
+type record struct {
+	dir     bool
+	index   uint64
+	expires int64
+	value   []byte
+}
+
+const recordHeaderSize = 17
+
+func (r record) encode() []byte {
+	data := make([]byte, recordHeaderSize+len(r.value))
+	if r.dir {
+		data[0] = 1
+	}
+	binary.BigEndian.PutUint64(data[1:], r.index)
+	binary.BigEndian.PutUint64(data[9:], uint64(r.expires))
+	copy(data[recordHeaderSize:], r.value)
+
+	return data
+}
+
+// decodeRecord copies what it needs out of data, which bbolt only keeps for
+// the length of the transaction.
+func decodeRecord(data []byte) (record, error) {
+	if len(data) < recordHeaderSize || data[0] > 1 {
+		return record{}, storeadapter.ErrorInvalidFormat
+	}
+
+	return record{
+		dir:     data[0] == 1,
+		index:   binary.BigEndian.Uint64(data[1:]),
+		expires: int64(binary.BigEndian.Uint64(data[9:])),
+		value:   append([]byte{}, data[recordHeaderSize:]...),
+	}, nil
+}
+
+func expiryKey(expires int64, key string) []byte {
+	data := make([]byte, 8+len(key))
+	binary.BigEndian.PutUint64(data, uint64(expires))
+	copy(data[8:], key)
+
+	return data
+}
+
+// boltTxn is the tree of nodes as a bbolt transaction sees it at one moment,
+// with the events its writes add up to. Every write in it is made at the index
+// after the one it read.
+type boltTxn struct {
+	nodes    *bolt.Bucket
+	expiries *bolt.Bucket
+	meta     *bolt.Bucket
+
+	now    time.Time
+	index  uint64
+	events []storeadapter.WatchEvent
+}
+
+func newBoltTxn(tx *bolt.Tx, now time.Time) *boltTxn {
+	txn := &boltTxn{
+		nodes:    tx.Bucket(nodesBucket),
+		expiries: tx.Bucket(expiriesBucket),
+		meta:     tx.Bucket(metaBucket),
+		now:      now,
+	}
+
+	if data := txn.meta.Get(indexKey); len(data) == 8 {
+		txn.index = binary.BigEndian.Uint64(data)
+	}
+
+	return txn
+}
+
+// writeIndex returns the index the transaction's writes are made at.
+func (txn *boltTxn) writeIndex() uint64 {
+	return txn.index + 1
+}
+
+// committedIndex returns the index of the latest write once the transaction
+// is committed.
+func (txn *boltTxn) committedIndex() uint64 {
+	if len(txn.events) == 0 {
+		return txn.index
+	}
+
+	return txn.writeIndex()
+}
+
+func (txn *boltTxn) saveIndex() error {
+	if len(txn.events) == 0 {
+		return nil
+	}
+
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, txn.writeIndex())
+
+	return txn.meta.Put(indexKey, data)
+}
+
+// expiresAt returns when a node given ttl now expires, or 0 for never.
+func (txn *boltTxn) expiresAt(ttl uint64) int64 {
+	if ttl == 0 {
+		return 0
+	}
+
+	return txn.now.Add(time.Duration(ttl) * time.Second).UnixNano()
+}
+
+func (txn *boltTxn) expired(r record) bool {
+	return r.expires != 0 && r.expires <= txn.now.UnixNano()
+}
+
+// ttl returns the whole seconds left before r expires, rounded up.
+func (txn *boltTxn) ttl(r record) uint64 {
+	if r.expires == 0 {
+		return 0
+	}
+
+	left := time.Duration(r.expires - txn.now.UnixNano())
+	if left < time.Second {
+		return 1
+	}
+
+	return uint64((left + time.Second - 1) / time.Second)
+}
+
+// record returns the node stored at key, or nil if there is none. It does
+// not check whether the node has expired; a read-write transaction has no
+// expired nodes left.
+func (txn *boltTxn) record(key string) (*record, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	data := txn.nodes.Get([]byte(key))
+	if data == nil {
+		return nil, nil
+	}
+
+	r, err := decodeRecord(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// lookup returns the node at key, or nil if there is none, or if it or a
+// directory above it has expired.
+func (txn *boltTxn) lookup(key string) (*record, error) {
+	for _, dir := range ancestors(key) {
+		r, err := txn.record(dir)
+		if err != nil || r == nil || txn.expired(*r) {
+			return nil, err
+		}
+	}
+
+	r, err := txn.record(key)
+	if err != nil || r == nil || txn.expired(*r) {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (txn *boltTxn) storeNode(key string, r record) storeadapter.StoreNode {
+	node := storeadapter.StoreNode{
+		Key:   key,
+		Value: r.value,
+		Dir:   r.dir,
+		TTL:   txn.ttl(r),
+		Index: r.index,
+	}
+
+	if r.dir {
+		node.Value = []byte{}
+		node.ChildNodes = []storeadapter.StoreNode{}
+	}
+
+	return node
+}
+
+// node returns the node at key, or nil if there is none, for a comparison.
+func (txn *boltTxn) node(key string) (*storeadapter.StoreNode, error) {
+	if key == "" {
+		return &storeadapter.StoreNode{Key: key, Dir: true}, nil
+	}
+
+	r, err := txn.lookup(key)
+	if err != nil || r == nil {
+		return nil, err
+	}
+
+	node := txn.storeNode(key, *r)
+	return &node, nil
+}
+
+// subtree returns the keys and nodes under the directory at key, in key
+// order.
+func (txn *boltTxn) subtree(key string) ([]string, []record, error) {
+	prefix := []byte(dirPrefix(key))
+
+	keys := []string{}
+	records := []record{}
+
+	cursor := txn.nodes.Cursor()
+	for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+		r, err := decodeRecord(v)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keys = append(keys, string(k))
+		records = append(records, r)
+	}
+
+	return keys, records, nil
+}
+
+func (txn *boltTxn) hasChildren(key string) bool {
+	prefix := []byte(dirPrefix(key))
+	k, _ := txn.nodes.Cursor().Seek(prefix)
+
+	return k != nil && bytes.HasPrefix(k, prefix)
+}
+
+// list returns the directory dir at key with everything under it that has
+// not expired.
+func (txn *boltTxn) list(key string, dir record) (storeadapter.StoreNode, error) {
+	keys, records, err := txn.subtree(key)
+	if err != nil {
+		return storeadapter.StoreNode{}, err
+	}
+
+	byKey := map[string]record{}
+	children := map[string][]string{}
+	for i, childKey := range keys {
+		byKey[childKey] = records[i]
+		children[parentKey(childKey)] = append(children[parentKey(childKey)], childKey)
+	}
+
+	var build func(key string, r record) storeadapter.StoreNode
+	build = func(key string, r record) storeadapter.StoreNode {
+		node := txn.storeNode(key, r)
+		for _, childKey := range children[key] {
+			child := byKey[childKey]
+			if txn.expired(child) {
+				continue
+			}
+
+			if child.dir {
+				node.ChildNodes = append(node.ChildNodes, build(childKey, child))
+			} else {
+				node.ChildNodes = append(node.ChildNodes, txn.storeNode(childKey, child))
+			}
+		}
+
+		return node
+	}
+
+	return build(key, dir), nil
+}
+
+// put stores r at key in place of old, keeping the expiry index in step.
+func (txn *boltTxn) put(key string, old *record, r record) error {
+	if old != nil && old.expires != 0 {
+		if err := txn.expiries.Delete(expiryKey(old.expires, key)); err != nil {
+			return err
+		}
+	}
+
+	if r.expires != 0 {
+		if err := txn.expiries.Put(expiryKey(r.expires, key), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return txn.nodes.Put([]byte(key), r.encode())
+}
+
+// remove deletes old from key, keeping the expiry index in step.
+func (txn *boltTxn) remove(key string, old record) error {
+	if old.expires != 0 {
+		if err := txn.expiries.Delete(expiryKey(old.expires, key)); err != nil {
+			return err
+		}
+	}
+
+	return txn.nodes.Delete([]byte(key))
+}
+
+func (txn *boltTxn) emit(eventType storeadapter.EventType, key string, r *record, prev *record) {
+	event := storeadapter.WatchEvent{Type: eventType, Index: txn.writeIndex()}
+
+	if r != nil {
+		node := txn.storeNode(key, *r)
+		event.Node = &node
+	}
+
+	if prev != nil {
+		prevNode := txn.storeNode(key, *prev)
+		event.PrevNode = &prevNode
+	}
+
+	txn.events = append(txn.events, event)
+}
+
+// putLeaf writes node as a leaf, creating the directories above it. It checks
+// that it can before writing anything.
+func (txn *boltTxn) putLeaf(op string, node storeadapter.StoreNode) error {
+	key := cleanKey(node.Key)
+	if key == "" {
+		return keyError(op, key, txn.index, storeadapter.ErrorNodeIsDirectory)
+	}
+
+	current, err := txn.record(key)
+	if err != nil {
+		return err
+	}
+
+	if current != nil && current.dir {
+		return keyError(op, key, txn.index, storeadapter.ErrorNodeIsDirectory)
+	}
+
+	missing := []string{}
+	for _, dir := range ancestors(key) {
+		r, err := txn.record(dir)
+		if err != nil {
+			return err
+		}
+
+		if r == nil {
+			missing = append(missing, dir)
+		} else if !r.dir {
+			return keyError(op, key, txn.index, storeadapter.ErrorNodeIsNotDirectory)
+		}
+	}
+
+	for _, dir := range missing {
+		if err := txn.put(dir, nil, record{dir: true, index: txn.writeIndex()}); err != nil {
+			return err
+		}
+	}
+
+	leaf := record{
+		index:   txn.writeIndex(),
+		expires: txn.expiresAt(node.TTL),
+		value:   node.Value,
+	}
+
+	if err := txn.put(key, current, leaf); err != nil {
+		return err
+	}
+
+	if current == nil {
+		txn.emit(storeadapter.CreateEvent, key, &leaf, nil)
+	} else {
+		txn.emit(storeadapter.UpdateEvent, key, &leaf, current)
+	}
+
+	return nil
+}
+
+// deleteNode deletes the node at key with everything under it. Deleting the
+// root deletes everything in the store.
+func (txn *boltTxn) deleteNode(op string, key string) error {
+	if key != "" {
+		current, err := txn.record(key)
+		if err != nil {
+			return err
+		}
+
+		if current == nil {
+			return keyError(op, key, txn.index, storeadapter.ErrorKeyNotFound)
+		}
+
+		return txn.deleteTree(key, *current, storeadapter.DeleteEvent)
+	}
+
+	keys, records, err := txn.subtree(key)
+	if err != nil {
+		return err
+	}
+
+	deleted := false
+	for i, childKey := range keys {
+		if strings.Count(childKey, "/") != 1 {
+			continue
+		}
+
+		if err := txn.deleteTree(childKey, records[i], storeadapter.DeleteEvent); err != nil {
+			return err
+		}
+
+		deleted = true
+	}
+
+	if !deleted {
+		return keyError(op, key, txn.index, storeadapter.ErrorKeyNotFound)
+	}
+
+	return nil
+}
+
+// deleteTree deletes r from key, with everything under it, reporting it as
+// one event.
+func (txn *boltTxn) deleteTree(key string, r record, eventType storeadapter.EventType) error {
+	if r.dir {
+		keys, records, err := txn.subtree(key)
+		if err != nil {
+			return err
+		}
+
+		for i, childKey := range keys {
+			if err := txn.remove(childKey, records[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := txn.remove(key, r); err != nil {
+		return err
+	}
+
+	txn.emit(eventType, key, nil, &r)
+	return nil
+}
+
+// expiryDue reports whether some node's TTL has run out.
+func (txn *boltTxn) expiryDue() bool {
+	k, _ := txn.expiries.Cursor().First()
+	return k != nil && int64(binary.BigEndian.Uint64(k)) <= txn.now.UnixNano()
+}
+
+// expire removes every node whose TTL has run out, with everything under it.
+func (txn *boltTxn) expire() error {
+	due := []string{}
+
+	cursor := txn.expiries.Cursor()
+	for k, _ := cursor.First(); k != nil && int64(binary.BigEndian.Uint64(k)) <= txn.now.UnixNano(); k, _ = cursor.Next() {
+		due = append(due, string(k[8:]))
+	}
+
+	for _, key := range due {
+		current, err := txn.record(key)
+		if err != nil {
+			return err
+		}
+
+		// It may have gone with an expired directory above it.
+		if current == nil {
+			continue
+		}
+
+		if err := txn.deleteTree(key, *current, storeadapter.ExpireEvent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apply checks the comparisons, then plays the operations in order. If one
+// fails, it returns why, and the transaction must not be committed, as the
+// operations before it have been written.
+func (txn *boltTxn) apply(op string, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	for _, comparison := range comparisons {
+		key := cleanKey(comparison.Node.Key)
+
+		current, err := txn.node(key)
+		if err != nil {
+			return err
+		}
+
+		if err := comparison.Check(current); err != nil {
+			return keyError(op, key, txn.index, err)
+		}
+	}
+
+	for _, operation := range operations {
+		key := cleanKey(operation.Node.Key)
+
+		switch operation.Type {
+		case storeadapter.PutOp:
+			if err := txn.putLeaf(op, operation.Node); err != nil {
+				return err
+			}
+
+		case storeadapter.DeleteOp:
+			current, err := txn.record(key)
+			if err != nil {
+				return err
+			}
+
+			if current == nil {
+				return keyError(op, key, txn.index, storeadapter.ErrorKeyNotFound)
+			}
+
+			if current.dir {
+				return keyError(op, key, txn.index, storeadapter.ErrorNodeIsDirectory)
+			}
+
+			if err := txn.deleteTree(key, *current, storeadapter.DeleteEvent); err != nil {
+				return err
+			}
+
+		default:
+			return keyError(op, key, txn.index, storeadapter.ErrorInvalidFormat)
+		}
+	}
+
+	return nil
+}
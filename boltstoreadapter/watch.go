@@ -0,0 +1,70 @@
+package boltstoreadapter
+
+import (
+	"context"
+
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/internal/watchfeed"
+)
+
+// publish records the events of a committed write, at index, and queues them
+// for the watches they concern. It is called with writeLock held.
+func (adapter *BoltStoreAdapter) publish(index uint64, events []storeadapter.WatchEvent) {
+	adapter.index = index
+	adapter.feed.Publish(events)
+}
+
+func (adapter *BoltStoreAdapter) Watch(key string) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	return adapter.WatchContext(context.Background(), key)
+}
+
+func (adapter *BoltStoreAdapter) WatchContext(ctx context.Context, key string) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	return adapter.watch(ctx, "Watch", cleanKey(key), 0, false)
+}
+
+func (adapter *BoltStoreAdapter) WatchFrom(key string, afterIndex uint64) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	return adapter.WatchFromContext(context.Background(), key, afterIndex)
+}
+
+func (adapter *BoltStoreAdapter) WatchFromContext(ctx context.Context, key string, afterIndex uint64) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	return adapter.watch(ctx, "WatchFrom", cleanKey(key), afterIndex, true)
+}
+
+// watch registers a watcher under writeLock, so that it misses no event
+// committed after it is registered, and sees none twice. A resumed watcher is
+// first given the events after its index from the history.
+func (adapter *BoltStoreAdapter) watch(ctx context.Context, op string, key string, afterIndex uint64, resume bool) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	events := make(chan storeadapter.WatchEvent)
+	errs := make(chan error)
+	stop := make(chan bool, 1)
+
+	var w *watchfeed.Watcher
+	err := ctx.Err()
+
+	if err == nil {
+		adapter.writeLock.Lock()
+
+		if adapter.db == nil {
+			err = adapter.convertError(op, key, errNotConnected)
+		} else if registered, ok := adapter.feed.Register(key, afterIndex, resume); ok {
+			w = registered
+		} else {
+			err = keyError(op, key, adapter.index, storeadapter.ErrorWatchIndexCleared)
+		}
+
+		adapter.writeLock.Unlock()
+	}
+
+	if err != nil {
+		go watchfeed.Refuse(ctx, adapter.ctx.Done(), err, events, errs)
+		return events, stop, errs
+	}
+
+	go w.Dispatch(ctx, adapter.ctx.Done(), events, stop, errs, func() {
+		adapter.writeLock.Lock()
+		adapter.feed.Unregister(w)
+		adapter.writeLock.Unlock()
+	})
+
+	return events, stop, errs
+}
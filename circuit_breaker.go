@@ -0,0 +1,609 @@
+package storeadapter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+const (
+	defaultCircuitFailureRate     = 0.5
+	defaultCircuitWindow          = 10 * time.Second
+	defaultCircuitMinimumRequests = 10
+	defaultCircuitOpenTimeout     = 30 * time.Second
+	defaultCircuitHalfOpenProbes  = 1
+
+	// The window is counted in this many buckets, each covering a slice of it.
+	circuitWindowBuckets = 10
+)
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// Requests are sent to the store, and their failures counted.
+	CircuitClosed CircuitState = iota
+
+	// Requests fail at once with ErrorCircuitOpen.
+	CircuitOpen
+
+	// A few probe requests are sent to the store to find out whether it has
+	// recovered. Any others fail with ErrorCircuitOpen.
+	CircuitHalfOpen
+)
+
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerOptions configures when a circuit breaker trips and how it
+// recovers. The zero value of each field selects its default.
+type CircuitBreakerOptions struct {
+	// The circuit trips once at least FailureRate of the requests made in the
+	// last Window have failed, as long as at least MinimumRequests were made.
+	// Default to half, 10 seconds and 10 requests.
+	FailureRate     float64
+	Window          time.Duration
+	MinimumRequests uint
+
+	// Which errors are failures. Any other outcome means the store answered.
+	// Defaults to TemporaryRetryClassifier: timeouts and errors reaching the
+	// store.
+	Classifier RetryClassifier
+
+	// How long the circuit stays open before probing the store. Defaults to
+	// 30 seconds.
+	OpenTimeout time.Duration
+
+	// How many probe requests are sent while the circuit is half open. It
+	// closes once they have all succeeded, and opens again as soon as one
+	// fails. Defaults to 1.
+	HalfOpenProbes uint
+
+	// Called whenever the circuit changes state. It is called with the breaker
+	// locked, so it must not make requests through the breaker.
+	OnStateChange func(from, to CircuitState)
+
+	// Times the window and the open timeout. Defaults to the real clock.
+	Clock clock.Clock
+}
+
+// WithDefaults fills in the defaults.
+func (options CircuitBreakerOptions) WithDefaults() CircuitBreakerOptions {
+	if options.FailureRate <= 0 {
+		options.FailureRate = defaultCircuitFailureRate
+	}
+
+	if options.Window <= 0 {
+		options.Window = defaultCircuitWindow
+	}
+
+	if options.MinimumRequests == 0 {
+		options.MinimumRequests = defaultCircuitMinimumRequests
+	}
+
+	if options.Classifier == nil {
+		options.Classifier = TemporaryRetryClassifier{}
+	}
+
+	if options.OpenTimeout <= 0 {
+		options.OpenTimeout = defaultCircuitOpenTimeout
+	}
+
+	if options.HalfOpenProbes == 0 {
+		options.HalfOpenProbes = defaultCircuitHalfOpenProbes
+	}
+
+	if options.Clock == nil {
+		options.Clock = clock.NewClock()
+	}
+
+	return options
+}
+
+type circuitBucket struct {
+	// Which slice of time the bucket covers, counted in bucket widths.
+	slice    int64
+	requests uint
+	failures uint
+}
+
+type circuitBreaker struct {
+	StoreAdapter
+	contextAdapter ContextStoreAdapter
+	options        CircuitBreakerOptions
+
+	mutex    sync.Mutex
+	state    CircuitState
+	openedAt time.Time
+	buckets  [circuitWindowBuckets]circuitBucket
+
+	// The probes let through, and those that have succeeded, since the
+	// circuit half opened.
+	probes         uint
+	probeSuccesses uint
+
+	// Changes with every change of state, so that requests let through
+	// before it are not counted after it.
+	generation uint64
+}
+
+// NewCircuitBreaker wraps storeAdapter so that requests fail at once with
+// ErrorCircuitOpen while the store is failing, rather than waiting on it. See
+// CircuitBreakerOptions for when the circuit trips and how it recovers.
+//
+// Watches are refused unless the circuit is closed, but are not counted, as
+// their errors arrive after they have started. Requests given up on because
+// their context is done are not counted either, and Disconnect is always
+// passed through. The returned adapter also implements ContextStoreAdapter;
+// pass it to NewContextStoreAdapter to use it.
+func NewCircuitBreaker(storeAdapter StoreAdapter, options CircuitBreakerOptions) StoreAdapter {
+	return &circuitBreaker{
+		StoreAdapter:   storeAdapter,
+		contextAdapter: NewContextStoreAdapter(storeAdapter),
+		options:        options.WithDefaults(),
+	}
+}
+
+func (breaker *circuitBreaker) Connect() error {
+	return breaker.call(func() error {
+		return breaker.StoreAdapter.Connect()
+	})
+}
+
+func (breaker *circuitBreaker) Create(node StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.StoreAdapter.Create(node)
+	})
+}
+
+func (breaker *circuitBreaker) Update(node StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.StoreAdapter.Update(node)
+	})
+}
+
+func (breaker *circuitBreaker) CompareAndSwap(nodeA StoreNode, nodeB StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.StoreAdapter.CompareAndSwap(nodeA, nodeB)
+	})
+}
+
+func (breaker *circuitBreaker) CompareAndSwapByIndex(index uint64, node StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.StoreAdapter.CompareAndSwapByIndex(index, node)
+	})
+}
+
+func (breaker *circuitBreaker) SetMulti(nodes []StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.StoreAdapter.SetMulti(nodes)
+	})
+}
+
+func (breaker *circuitBreaker) Get(key string) (StoreNode, error) {
+	var node StoreNode
+	err := breaker.call(func() error {
+		var err error
+		node, err = breaker.StoreAdapter.Get(key)
+		return err
+	})
+
+	return node, err
+}
+
+func (breaker *circuitBreaker) Delete(keys ...string) error {
+	return breaker.call(func() error {
+		return breaker.StoreAdapter.Delete(keys...)
+	})
+}
+
+func (breaker *circuitBreaker) DeleteLeaves(keys ...string) error {
+	return breaker.call(func() error {
+		return breaker.StoreAdapter.DeleteLeaves(keys...)
+	})
+}
+
+func (breaker *circuitBreaker) ListRecursively(key string) (StoreNode, error) {
+	var node StoreNode
+	err := breaker.call(func() error {
+		var err error
+		node, err = breaker.StoreAdapter.ListRecursively(key)
+		return err
+	})
+
+	return node, err
+}
+
+func (breaker *circuitBreaker) CompareAndDelete(nodes ...StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.StoreAdapter.CompareAndDelete(nodes...)
+	})
+}
+
+func (breaker *circuitBreaker) CompareAndDeleteByIndex(nodes ...StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.StoreAdapter.CompareAndDeleteByIndex(nodes...)
+	})
+}
+
+func (breaker *circuitBreaker) Txn(comparisons []TxnCompare, operations []TxnOp) error {
+	return breaker.call(func() error {
+		return breaker.StoreAdapter.Txn(comparisons, operations)
+	})
+}
+
+func (breaker *circuitBreaker) UpdateDirTTL(dir string, ttl uint64) error {
+	return breaker.call(func() error {
+		return breaker.StoreAdapter.UpdateDirTTL(dir, ttl)
+	})
+}
+
+func (breaker *circuitBreaker) Watch(key string) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	if err := breaker.allowWatch(); err != nil {
+		return refusedWatch(err)
+	}
+
+	return breaker.StoreAdapter.Watch(key)
+}
+
+func (breaker *circuitBreaker) WatchFrom(key string, afterIndex uint64) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	if err := breaker.allowWatch(); err != nil {
+		return refusedWatch(err)
+	}
+
+	return breaker.StoreAdapter.WatchFrom(key, afterIndex)
+}
+
+func (breaker *circuitBreaker) MaintainNode(storeNode StoreNode) (<-chan bool, chan chan bool, error) {
+	var lostNode <-chan bool
+	var releaseNode chan chan bool
+	err := breaker.call(func() error {
+		var err error
+		lostNode, releaseNode, err = breaker.StoreAdapter.MaintainNode(storeNode)
+		return err
+	})
+
+	return lostNode, releaseNode, err
+}
+
+func (breaker *circuitBreaker) MaintainNodeWithToken(storeNode StoreNode) (<-chan NodeStatus, chan chan bool, error) {
+	var status <-chan NodeStatus
+	var releaseNode chan chan bool
+	err := breaker.call(func() error {
+		var err error
+		status, releaseNode, err = breaker.StoreAdapter.MaintainNodeWithToken(storeNode)
+		return err
+	})
+
+	return status, releaseNode, err
+}
+
+func (breaker *circuitBreaker) MaintainNodeWithOptions(storeNode StoreNode, options MaintainOptions) (<-chan NodeStatus, chan chan bool, error) {
+	var status <-chan NodeStatus
+	var releaseNode chan chan bool
+	err := breaker.call(func() error {
+		var err error
+		status, releaseNode, err = breaker.StoreAdapter.MaintainNodeWithOptions(storeNode, options)
+		return err
+	})
+
+	return status, releaseNode, err
+}
+
+func (breaker *circuitBreaker) ConnectContext(ctx context.Context) error {
+	return breaker.call(func() error {
+		return breaker.contextAdapter.ConnectContext(ctx)
+	})
+}
+
+func (breaker *circuitBreaker) CreateContext(ctx context.Context, node StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.contextAdapter.CreateContext(ctx, node)
+	})
+}
+
+func (breaker *circuitBreaker) UpdateContext(ctx context.Context, node StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.contextAdapter.UpdateContext(ctx, node)
+	})
+}
+
+func (breaker *circuitBreaker) CompareAndSwapContext(ctx context.Context, nodeA StoreNode, nodeB StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.contextAdapter.CompareAndSwapContext(ctx, nodeA, nodeB)
+	})
+}
+
+func (breaker *circuitBreaker) CompareAndSwapByIndexContext(ctx context.Context, index uint64, node StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.contextAdapter.CompareAndSwapByIndexContext(ctx, index, node)
+	})
+}
+
+func (breaker *circuitBreaker) SetMultiContext(ctx context.Context, nodes []StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.contextAdapter.SetMultiContext(ctx, nodes)
+	})
+}
+
+func (breaker *circuitBreaker) GetContext(ctx context.Context, key string) (StoreNode, error) {
+	var node StoreNode
+	err := breaker.call(func() error {
+		var err error
+		node, err = breaker.contextAdapter.GetContext(ctx, key)
+		return err
+	})
+
+	return node, err
+}
+
+func (breaker *circuitBreaker) DeleteContext(ctx context.Context, keys ...string) error {
+	return breaker.call(func() error {
+		return breaker.contextAdapter.DeleteContext(ctx, keys...)
+	})
+}
+
+func (breaker *circuitBreaker) DeleteLeavesContext(ctx context.Context, keys ...string) error {
+	return breaker.call(func() error {
+		return breaker.contextAdapter.DeleteLeavesContext(ctx, keys...)
+	})
+}
+
+func (breaker *circuitBreaker) ListRecursivelyContext(ctx context.Context, key string) (StoreNode, error) {
+	var node StoreNode
+	err := breaker.call(func() error {
+		var err error
+		node, err = breaker.contextAdapter.ListRecursivelyContext(ctx, key)
+		return err
+	})
+
+	return node, err
+}
+
+func (breaker *circuitBreaker) CompareAndDeleteContext(ctx context.Context, nodes ...StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.contextAdapter.CompareAndDeleteContext(ctx, nodes...)
+	})
+}
+
+func (breaker *circuitBreaker) CompareAndDeleteByIndexContext(ctx context.Context, nodes ...StoreNode) error {
+	return breaker.call(func() error {
+		return breaker.contextAdapter.CompareAndDeleteByIndexContext(ctx, nodes...)
+	})
+}
+
+func (breaker *circuitBreaker) TxnContext(ctx context.Context, comparisons []TxnCompare, operations []TxnOp) error {
+	return breaker.call(func() error {
+		return breaker.contextAdapter.TxnContext(ctx, comparisons, operations)
+	})
+}
+
+func (breaker *circuitBreaker) UpdateDirTTLContext(ctx context.Context, dir string, ttl uint64) error {
+	return breaker.call(func() error {
+		return breaker.contextAdapter.UpdateDirTTLContext(ctx, dir, ttl)
+	})
+}
+
+func (breaker *circuitBreaker) WatchContext(ctx context.Context, key string) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	if err := breaker.allowWatch(); err != nil {
+		return refusedWatch(err)
+	}
+
+	return breaker.contextAdapter.WatchContext(ctx, key)
+}
+
+func (breaker *circuitBreaker) WatchFromContext(ctx context.Context, key string, afterIndex uint64) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	if err := breaker.allowWatch(); err != nil {
+		return refusedWatch(err)
+	}
+
+	return breaker.contextAdapter.WatchFromContext(ctx, key, afterIndex)
+}
+
+func (breaker *circuitBreaker) MaintainNodeContext(ctx context.Context, storeNode StoreNode) (<-chan bool, chan chan bool, error) {
+	var lostNode <-chan bool
+	var releaseNode chan chan bool
+	err := breaker.call(func() error {
+		var err error
+		lostNode, releaseNode, err = breaker.contextAdapter.MaintainNodeContext(ctx, storeNode)
+		return err
+	})
+
+	return lostNode, releaseNode, err
+}
+
+func (breaker *circuitBreaker) MaintainNodeWithTokenContext(ctx context.Context, storeNode StoreNode) (<-chan NodeStatus, chan chan bool, error) {
+	var status <-chan NodeStatus
+	var releaseNode chan chan bool
+	err := breaker.call(func() error {
+		var err error
+		status, releaseNode, err = breaker.contextAdapter.MaintainNodeWithTokenContext(ctx, storeNode)
+		return err
+	})
+
+	return status, releaseNode, err
+}
+
+func (breaker *circuitBreaker) MaintainNodeWithOptionsContext(ctx context.Context, storeNode StoreNode, options MaintainOptions) (<-chan NodeStatus, chan chan bool, error) {
+	var status <-chan NodeStatus
+	var releaseNode chan chan bool
+	err := breaker.call(func() error {
+		var err error
+		status, releaseNode, err = breaker.contextAdapter.MaintainNodeWithOptionsContext(ctx, storeNode, options)
+		return err
+	})
+
+	return status, releaseNode, err
+}
+
+// call makes the request if the circuit lets it through, and counts its
+// outcome.
+func (breaker *circuitBreaker) call(action func() error) error {
+	generation, err := breaker.allow()
+	if err != nil {
+		return err
+	}
+
+	err = action()
+	breaker.done(generation, err)
+
+	return err
+}
+
+// allow returns the generation a request is let through in, or
+// ErrorCircuitOpen.
+func (breaker *circuitBreaker) allow() (uint64, error) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.halfOpenIfDue()
+
+	switch breaker.state {
+	case CircuitOpen:
+		return 0, ErrorCircuitOpen
+	case CircuitHalfOpen:
+		if breaker.probes >= breaker.options.HalfOpenProbes {
+			return 0, ErrorCircuitOpen
+		}
+		breaker.probes++
+	}
+
+	return breaker.generation, nil
+}
+
+func (breaker *circuitBreaker) allowWatch() error {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.halfOpenIfDue()
+
+	if breaker.state != CircuitClosed {
+		return ErrorCircuitOpen
+	}
+
+	return nil
+}
+
+// done counts the outcome of a request let through in the given generation.
+func (breaker *circuitBreaker) done(generation uint64, err error) {
+	abandoned := errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+	failed := err != nil && !abandoned && breaker.options.Classifier.ShouldRetry(err)
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if generation != breaker.generation {
+		return
+	}
+
+	switch breaker.state {
+	case CircuitClosed:
+		if abandoned {
+			return
+		}
+
+		requests, failures := breaker.count(failed)
+		if requests >= breaker.options.MinimumRequests && float64(failures) >= breaker.options.FailureRate*float64(requests) {
+			breaker.setState(CircuitOpen)
+		}
+
+	case CircuitHalfOpen:
+		switch {
+		case abandoned:
+			breaker.probes--
+		case failed:
+			breaker.setState(CircuitOpen)
+		default:
+			breaker.probeSuccesses++
+			if breaker.probeSuccesses >= breaker.options.HalfOpenProbes {
+				breaker.setState(CircuitClosed)
+			}
+		}
+	}
+}
+
+// count adds a request to the current bucket, and returns how many requests
+// were made, and how many failed, in the window.
+func (breaker *circuitBreaker) count(failed bool) (requests uint, failures uint) {
+	width := int64(breaker.options.Window / circuitWindowBuckets)
+	if width <= 0 {
+		width = 1
+	}
+
+	slice := breaker.options.Clock.Now().UnixNano() / width
+
+	bucket := &breaker.buckets[(slice%circuitWindowBuckets+circuitWindowBuckets)%circuitWindowBuckets]
+	if bucket.slice != slice {
+		*bucket = circuitBucket{slice: slice}
+	}
+
+	bucket.requests++
+	if failed {
+		bucket.failures++
+	}
+
+	for _, bucket := range breaker.buckets {
+		if bucket.slice > slice-circuitWindowBuckets && bucket.slice <= slice {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+
+	return requests, failures
+}
+
+// halfOpenIfDue half opens the circuit once it has been open for long enough.
+// It is called with the lock held.
+func (breaker *circuitBreaker) halfOpenIfDue() {
+	if breaker.state == CircuitOpen && breaker.options.Clock.Since(breaker.openedAt) >= breaker.options.OpenTimeout {
+		breaker.setState(CircuitHalfOpen)
+	}
+}
+
+// setState is called with the lock held.
+func (breaker *circuitBreaker) setState(state CircuitState) {
+	from := breaker.state
+
+	breaker.state = state
+	breaker.generation++
+
+	switch state {
+	case CircuitClosed:
+		breaker.buckets = [circuitWindowBuckets]circuitBucket{}
+	case CircuitOpen:
+		breaker.openedAt = breaker.options.Clock.Now()
+	case CircuitHalfOpen:
+		breaker.probes = 0
+		breaker.probeSuccesses = 0
+	}
+
+	if breaker.options.OnStateChange != nil {
+		breaker.options.OnStateChange(from, state)
+	}
+}
+
+// refusedWatch returns the channels of a watch that failed to start with err.
+func refusedWatch(err error) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	events := make(chan WatchEvent)
+	stop := make(chan bool, 1)
+	errs := make(chan error, 1)
+
+	errs <- err
+	close(events)
+	close(errs)
+
+	return events, stop, errs
+}
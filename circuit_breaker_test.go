@@ -0,0 +1,263 @@
+package storeadapter_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	. "github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CircuitBreaker", func() {
+	type stateChange struct {
+		from, to CircuitState
+	}
+
+	var (
+		innerStoreAdapter *fakes.FakeStoreAdapter
+		clock             *fakeclock.FakeClock
+		options           CircuitBreakerOptions
+		stateChanges      []stateChange
+
+		adapter StoreAdapter
+	)
+
+	BeforeEach(func() {
+		innerStoreAdapter = new(fakes.FakeStoreAdapter)
+		clock = fakeclock.NewFakeClock(time.Now())
+		stateChanges = nil
+
+		options = CircuitBreakerOptions{
+			FailureRate:     0.5,
+			Window:          10 * time.Second,
+			MinimumRequests: 4,
+			OpenTimeout:     30 * time.Second,
+			HalfOpenProbes:  2,
+			Clock:           clock,
+			OnStateChange: func(from, to CircuitState) {
+				stateChanges = append(stateChanges, stateChange{from, to})
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		adapter = NewCircuitBreaker(innerStoreAdapter, options)
+	})
+
+	get := func() error {
+		_, err := adapter.Get("some-key")
+		return err
+	}
+
+	fail := func(times int) {
+		innerStoreAdapter.GetReturns(StoreNode{}, ErrorTimeout)
+		for i := 0; i < times; i++ {
+			Expect(get()).To(Equal(ErrorTimeout))
+		}
+	}
+
+	succeed := func(times int) {
+		innerStoreAdapter.GetReturns(StoreNode{Key: "some-key"}, nil)
+		for i := 0; i < times; i++ {
+			Expect(get()).To(Succeed())
+		}
+	}
+
+	trip := func() {
+		fail(4)
+		Expect(stateChanges).To(Equal([]stateChange{{CircuitClosed, CircuitOpen}}))
+	}
+
+	It("passes requests through while closed", func() {
+		innerStoreAdapter.GetReturns(StoreNode{Key: "some-key"}, nil)
+
+		node, err := adapter.Get("some-key")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(node).To(Equal(StoreNode{Key: "some-key"}))
+		Expect(innerStoreAdapter.GetArgsForCall(0)).To(Equal("some-key"))
+	})
+
+	Context("when requests fail at the failure rate", func() {
+		It("trips once enough requests have been made", func() {
+			succeed(1)
+			fail(2)
+			Expect(stateChanges).To(BeEmpty())
+
+			fail(1)
+			Expect(stateChanges).To(Equal([]stateChange{{CircuitClosed, CircuitOpen}}))
+		})
+
+		It("fails fast while open", func() {
+			trip()
+
+			Expect(get()).To(Equal(ErrorCircuitOpen))
+			Expect(adapter.Create(StoreNode{Key: "some-key"})).To(Equal(ErrorCircuitOpen))
+			Expect(innerStoreAdapter.GetCallCount()).To(Equal(4))
+			Expect(innerStoreAdapter.CreateCallCount()).To(Equal(0))
+		})
+
+		It("refuses watches while open", func() {
+			trip()
+
+			events, _, errs := adapter.Watch("some-key")
+			Expect(errs).To(Receive(Equal(ErrorCircuitOpen)))
+			Expect(events).To(BeClosed())
+			Expect(innerStoreAdapter.WatchCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when requests fail below the failure rate", func() {
+		It("stays closed", func() {
+			succeed(3)
+			fail(2)
+			Expect(stateChanges).To(BeEmpty())
+		})
+	})
+
+	Context("when the failures are spread over more than the window", func() {
+		It("stays closed", func() {
+			fail(3)
+			clock.Increment(11 * time.Second)
+			fail(1)
+			succeed(1)
+			Expect(stateChanges).To(BeEmpty())
+		})
+	})
+
+	Context("when requests fail with errors that are not failures of the store", func() {
+		It("stays closed", func() {
+			innerStoreAdapter.GetReturns(StoreNode{}, ErrorKeyNotFound)
+			for i := 0; i < 10; i++ {
+				Expect(get()).To(Equal(ErrorKeyNotFound))
+			}
+			Expect(stateChanges).To(BeEmpty())
+		})
+
+		Context("but the classifier says they are", func() {
+			BeforeEach(func() {
+				options.Classifier = RetryClassifierFunc(func(err error) bool {
+					return errors.Is(err, ErrorKeyNotFound)
+				})
+			})
+
+			It("trips", func() {
+				innerStoreAdapter.GetReturns(StoreNode{}, ErrorKeyNotFound)
+				for i := 0; i < 4; i++ {
+					get()
+				}
+				Expect(stateChanges).To(Equal([]stateChange{{CircuitClosed, CircuitOpen}}))
+			})
+		})
+	})
+
+	Context("when requests are given up on because their context is done", func() {
+		It("does not count them", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			contextAdapter := NewContextStoreAdapter(adapter)
+			for i := 0; i < 10; i++ {
+				_, err := contextAdapter.GetContext(ctx, "some-key")
+				Expect(err).To(Equal(context.Canceled))
+			}
+			Expect(stateChanges).To(BeEmpty())
+		})
+	})
+
+	Context("once the open timeout has passed", func() {
+		JustBeforeEach(func() {
+			trip()
+			clock.Increment(30 * time.Second)
+		})
+
+		It("half opens, letting the probes through", func() {
+			innerStoreAdapter.GetStub = func(string) (StoreNode, error) {
+				if innerStoreAdapter.GetCallCount() == 5 {
+					Expect(get()).To(Succeed())
+					Expect(get()).To(Equal(ErrorCircuitOpen))
+				}
+				return StoreNode{}, nil
+			}
+
+			Expect(get()).To(Succeed())
+			Expect(innerStoreAdapter.GetCallCount()).To(Equal(6))
+			Expect(stateChanges[1]).To(Equal(stateChange{CircuitOpen, CircuitHalfOpen}))
+		})
+
+		It("still refuses watches", func() {
+			_, _, errs := adapter.Watch("some-key")
+			Expect(errs).To(Receive(Equal(ErrorCircuitOpen)))
+			Expect(stateChanges[1:]).To(Equal([]stateChange{{CircuitOpen, CircuitHalfOpen}}))
+		})
+
+		Context("when the probes succeed", func() {
+			It("closes", func() {
+				succeed(2)
+				Expect(stateChanges[1:]).To(Equal([]stateChange{
+					{CircuitOpen, CircuitHalfOpen},
+					{CircuitHalfOpen, CircuitClosed},
+				}))
+
+				fail(3)
+				Expect(stateChanges).To(HaveLen(3))
+			})
+		})
+
+		Context("when a probe fails", func() {
+			It("opens again for another open timeout", func() {
+				succeed(1)
+				fail(1)
+				Expect(stateChanges[1:]).To(Equal([]stateChange{
+					{CircuitOpen, CircuitHalfOpen},
+					{CircuitHalfOpen, CircuitOpen},
+				}))
+
+				clock.Increment(29 * time.Second)
+				Expect(get()).To(Equal(ErrorCircuitOpen))
+
+				clock.Increment(time.Second)
+				succeed(1)
+			})
+		})
+
+		Context("when a probe is given up on", func() {
+			It("lets another probe through", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				_, err := NewContextStoreAdapter(adapter).GetContext(ctx, "some-key")
+				Expect(err).To(Equal(context.Canceled))
+
+				succeed(2)
+				Expect(stateChanges[len(stateChanges)-1]).To(Equal(stateChange{CircuitHalfOpen, CircuitClosed}))
+			})
+		})
+	})
+
+	Describe("CircuitBreakerOptions", func() {
+		It("defaults each zero field", func() {
+			options := CircuitBreakerOptions{}.WithDefaults()
+
+			Expect(options.FailureRate).To(Equal(0.5))
+			Expect(options.Window).To(Equal(10 * time.Second))
+			Expect(options.MinimumRequests).To(BeEquivalentTo(10))
+			Expect(options.Classifier).To(Equal(TemporaryRetryClassifier{}))
+			Expect(options.OpenTimeout).To(Equal(30 * time.Second))
+			Expect(options.HalfOpenProbes).To(BeEquivalentTo(1))
+			Expect(options.Clock).NotTo(BeNil())
+		})
+	})
+
+	Describe("CircuitState", func() {
+		It("describes itself", func() {
+			Expect(CircuitClosed.String()).To(Equal("closed"))
+			Expect(CircuitOpen.String()).To(Equal("open"))
+			Expect(CircuitHalfOpen.String()).To(Equal("half-open"))
+		})
+	})
+})
@@ -0,0 +1,275 @@
+package storeadapter
+
+import "context"
+
+// ContextStoreAdapter is a StoreAdapter whose operations can be cancelled or
+// bounded by a deadline. When the context is done before the store responds,
+// the operation returns the context's error.
+type ContextStoreAdapter interface {
+	StoreAdapter
+
+	ConnectContext(ctx context.Context) error
+
+	CreateContext(ctx context.Context, node StoreNode) error
+	UpdateContext(ctx context.Context, node StoreNode) error
+
+	CompareAndSwapContext(ctx context.Context, oldNode, newNode StoreNode) error
+	CompareAndSwapByIndexContext(ctx context.Context, prevIndex uint64, newNode StoreNode) error
+
+	SetMultiContext(ctx context.Context, nodes []StoreNode) error
+
+	GetContext(ctx context.Context, key string) (StoreNode, error)
+	ListRecursivelyContext(ctx context.Context, key string) (StoreNode, error)
+
+	DeleteContext(ctx context.Context, keys ...string) error
+	DeleteLeavesContext(ctx context.Context, keys ...string) error
+
+	CompareAndDeleteContext(ctx context.Context, nodes ...StoreNode) error
+	CompareAndDeleteByIndexContext(ctx context.Context, nodes ...StoreNode) error
+
+	TxnContext(ctx context.Context, comparisons []TxnCompare, operations []TxnOp) error
+
+	UpdateDirTTLContext(ctx context.Context, key string, ttl uint64) error
+
+	// Like Watch, but watching also stops once the context is done.
+	WatchContext(ctx context.Context, key string) (events <-chan WatchEvent, stop chan<- bool, errors <-chan error)
+	WatchFromContext(ctx context.Context, key string, afterIndex uint64) (events <-chan WatchEvent, stop chan<- bool, errors <-chan error)
+
+	// Like MaintainNode, but the node is also released once the context is done.
+	MaintainNodeContext(ctx context.Context, storeNode StoreNode) (lostNode <-chan bool, releaseNode chan chan bool, err error)
+	MaintainNodeWithTokenContext(ctx context.Context, storeNode StoreNode) (status <-chan NodeStatus, releaseNode chan chan bool, err error)
+	MaintainNodeWithOptionsContext(ctx context.Context, storeNode StoreNode, options MaintainOptions) (status <-chan NodeStatus, releaseNode chan chan bool, err error)
+}
+
+// NewContextStoreAdapter returns a ContextStoreAdapter for the given adapter.
+// Adapters that already implement ContextStoreAdapter are returned as is;
+// any other adapter is wrapped so that callers stop waiting on it when their
+// context is done, even though the underlying request may still complete.
+func NewContextStoreAdapter(adapter StoreAdapter) ContextStoreAdapter {
+	if contextAdapter, ok := adapter.(ContextStoreAdapter); ok {
+		return contextAdapter
+	}
+
+	return &contextShim{StoreAdapter: adapter}
+}
+
+type contextShim struct {
+	StoreAdapter
+}
+
+func (shim *contextShim) ConnectContext(ctx context.Context) error {
+	return shim.await(ctx, func() error {
+		return shim.StoreAdapter.Connect()
+	})
+}
+
+func (shim *contextShim) CreateContext(ctx context.Context, node StoreNode) error {
+	return shim.await(ctx, func() error {
+		return shim.StoreAdapter.Create(node)
+	})
+}
+
+func (shim *contextShim) UpdateContext(ctx context.Context, node StoreNode) error {
+	return shim.await(ctx, func() error {
+		return shim.StoreAdapter.Update(node)
+	})
+}
+
+func (shim *contextShim) CompareAndSwapContext(ctx context.Context, oldNode, newNode StoreNode) error {
+	return shim.await(ctx, func() error {
+		return shim.StoreAdapter.CompareAndSwap(oldNode, newNode)
+	})
+}
+
+func (shim *contextShim) CompareAndSwapByIndexContext(ctx context.Context, prevIndex uint64, newNode StoreNode) error {
+	return shim.await(ctx, func() error {
+		return shim.StoreAdapter.CompareAndSwapByIndex(prevIndex, newNode)
+	})
+}
+
+func (shim *contextShim) SetMultiContext(ctx context.Context, nodes []StoreNode) error {
+	return shim.await(ctx, func() error {
+		return shim.StoreAdapter.SetMulti(nodes)
+	})
+}
+
+func (shim *contextShim) GetContext(ctx context.Context, key string) (StoreNode, error) {
+	var node StoreNode
+	err := shim.await(ctx, func() error {
+		var err error
+		node, err = shim.StoreAdapter.Get(key)
+		return err
+	})
+	if err != nil {
+		return StoreNode{}, err
+	}
+
+	return node, nil
+}
+
+func (shim *contextShim) ListRecursivelyContext(ctx context.Context, key string) (StoreNode, error) {
+	var node StoreNode
+	err := shim.await(ctx, func() error {
+		var err error
+		node, err = shim.StoreAdapter.ListRecursively(key)
+		return err
+	})
+	if err != nil {
+		return StoreNode{}, err
+	}
+
+	return node, nil
+}
+
+func (shim *contextShim) DeleteContext(ctx context.Context, keys ...string) error {
+	return shim.await(ctx, func() error {
+		return shim.StoreAdapter.Delete(keys...)
+	})
+}
+
+func (shim *contextShim) DeleteLeavesContext(ctx context.Context, keys ...string) error {
+	return shim.await(ctx, func() error {
+		return shim.StoreAdapter.DeleteLeaves(keys...)
+	})
+}
+
+func (shim *contextShim) CompareAndDeleteContext(ctx context.Context, nodes ...StoreNode) error {
+	return shim.await(ctx, func() error {
+		return shim.StoreAdapter.CompareAndDelete(nodes...)
+	})
+}
+
+func (shim *contextShim) CompareAndDeleteByIndexContext(ctx context.Context, nodes ...StoreNode) error {
+	return shim.await(ctx, func() error {
+		return shim.StoreAdapter.CompareAndDeleteByIndex(nodes...)
+	})
+}
+
+func (shim *contextShim) TxnContext(ctx context.Context, comparisons []TxnCompare, operations []TxnOp) error {
+	return shim.await(ctx, func() error {
+		return shim.StoreAdapter.Txn(comparisons, operations)
+	})
+}
+
+func (shim *contextShim) UpdateDirTTLContext(ctx context.Context, key string, ttl uint64) error {
+	return shim.await(ctx, func() error {
+		return shim.StoreAdapter.UpdateDirTTL(key, ttl)
+	})
+}
+
+func (shim *contextShim) WatchContext(ctx context.Context, key string) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	events, stop, errors := shim.StoreAdapter.Watch(key)
+	stopOnDone(ctx, stop)
+
+	return events, stop, errors
+}
+
+func (shim *contextShim) WatchFromContext(ctx context.Context, key string, afterIndex uint64) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	events, stop, errors := shim.StoreAdapter.WatchFrom(key, afterIndex)
+	stopOnDone(ctx, stop)
+
+	return events, stop, errors
+}
+
+// stopOnDone stops a watch once the context is done.
+func stopOnDone(ctx context.Context, stop chan<- bool) {
+	if stop == nil || ctx.Done() == nil {
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		select {
+		case stop <- true:
+		default:
+		}
+	}()
+}
+
+func (shim *contextShim) MaintainNodeContext(ctx context.Context, storeNode StoreNode) (<-chan bool, chan chan bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	lostNode, releaseNode, err := shim.StoreAdapter.MaintainNode(storeNode)
+	if err != nil {
+		return lostNode, releaseNode, err
+	}
+
+	return lostNode, releaseOnDone(ctx, releaseNode), nil
+}
+
+func (shim *contextShim) MaintainNodeWithTokenContext(ctx context.Context, storeNode StoreNode) (<-chan NodeStatus, chan chan bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	status, releaseNode, err := shim.StoreAdapter.MaintainNodeWithToken(storeNode)
+	if err != nil {
+		return status, releaseNode, err
+	}
+
+	return status, releaseOnDone(ctx, releaseNode), nil
+}
+
+func (shim *contextShim) MaintainNodeWithOptionsContext(ctx context.Context, storeNode StoreNode, options MaintainOptions) (<-chan NodeStatus, chan chan bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	status, releaseNode, err := shim.StoreAdapter.MaintainNodeWithOptions(storeNode, options)
+	if err != nil {
+		return status, releaseNode, err
+	}
+
+	return status, releaseOnDone(ctx, releaseNode), nil
+}
+
+func (shim *contextShim) await(ctx context.Context, action func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- action()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseOnDone returns a channel to hand to callers in place of releaseNode.
+// Whatever is sent on it is forwarded to releaseNode; if the context is done
+// first, the node is released on the caller's behalf, and any release the
+// caller sends later is acknowledged straight away.
+func releaseOnDone(ctx context.Context, releaseNode chan chan bool) chan chan bool {
+	callerRelease := make(chan chan bool)
+
+	go func() {
+		select {
+		case released := <-callerRelease:
+			releaseNode <- released
+			return
+		case <-ctx.Done():
+		}
+
+		forward := releaseNode
+		for {
+			select {
+			case forward <- nil:
+				forward = nil
+			case released := <-callerRelease:
+				if released != nil {
+					close(released)
+				}
+			}
+		}
+	}()
+
+	return callerRelease
+}
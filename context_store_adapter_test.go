@@ -0,0 +1,219 @@
+package storeadapter_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewContextStoreAdapter", func() {
+	var (
+		innerStoreAdapter *fakes.FakeStoreAdapter
+
+		adapter ContextStoreAdapter
+	)
+
+	BeforeEach(func() {
+		innerStoreAdapter = new(fakes.FakeStoreAdapter)
+
+		adapter = NewContextStoreAdapter(innerStoreAdapter)
+	})
+
+	Context("when the adapter already supports contexts", func() {
+		It("returns it unwrapped", func() {
+			retryable := NewRetryable(innerStoreAdapter, new(fakes.FakeSleeper), new(fakes.FakeRetryPolicy))
+			Expect(NewContextStoreAdapter(retryable)).To(BeIdenticalTo(retryable))
+		})
+	})
+
+	Describe("GetContext", func() {
+		var (
+			ctx    context.Context
+			cancel context.CancelFunc
+		)
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		Context("when the inner adapter responds", func() {
+			BeforeEach(func() {
+				innerStoreAdapter.GetReturns(StoreNode{Key: "some-key"}, nil)
+			})
+
+			It("returns its result", func() {
+				node, err := adapter.GetContext(ctx, "some-key")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(node).To(Equal(StoreNode{Key: "some-key"}))
+				Expect(innerStoreAdapter.GetArgsForCall(0)).To(Equal("some-key"))
+			})
+		})
+
+		Context("when the inner adapter fails", func() {
+			BeforeEach(func() {
+				innerStoreAdapter.GetReturns(StoreNode{}, errors.New("oh no!"))
+			})
+
+			It("returns its error", func() {
+				_, err := adapter.GetContext(ctx, "some-key")
+				Expect(err).To(Equal(errors.New("oh no!")))
+			})
+		})
+
+		Context("when the context is already done", func() {
+			BeforeEach(func() {
+				cancel()
+			})
+
+			It("returns the context's error without calling the inner adapter", func() {
+				_, err := adapter.GetContext(ctx, "some-key")
+				Expect(err).To(Equal(context.Canceled))
+				Expect(innerStoreAdapter.GetCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when the context is done while the inner adapter blocks", func() {
+			var unblock chan struct{}
+
+			BeforeEach(func() {
+				unblock = make(chan struct{})
+				innerStoreAdapter.GetStub = func(string) (StoreNode, error) {
+					<-unblock
+					return StoreNode{Key: "some-key"}, nil
+				}
+			})
+
+			AfterEach(func() {
+				close(unblock)
+			})
+
+			It("stops waiting and returns the context's error", func() {
+				errs := make(chan error, 1)
+				go func() {
+					_, err := adapter.GetContext(ctx, "some-key")
+					errs <- err
+				}()
+
+				Consistently(errs).ShouldNot(Receive())
+				cancel()
+				Eventually(errs).Should(Receive(Equal(context.Canceled)))
+			})
+		})
+	})
+
+	Describe("WatchContext", func() {
+		var stop chan bool
+
+		BeforeEach(func() {
+			stop = make(chan bool, 1)
+			innerStoreAdapter.WatchReturns(nil, stop, nil)
+		})
+
+		It("stops the watch when the context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			adapter.WatchContext(ctx, "some-key")
+
+			Consistently(stop).ShouldNot(Receive())
+			cancel()
+			Eventually(stop).Should(Receive(BeTrue()))
+		})
+	})
+
+	Describe("WatchFromContext", func() {
+		var stop chan bool
+
+		BeforeEach(func() {
+			stop = make(chan bool, 1)
+			innerStoreAdapter.WatchFromReturns(nil, stop, nil)
+		})
+
+		It("watches from the given index", func() {
+			adapter.WatchFromContext(context.Background(), "some-key", 42)
+
+			Expect(innerStoreAdapter.WatchFromCallCount()).To(Equal(1))
+			key, afterIndex := innerStoreAdapter.WatchFromArgsForCall(0)
+			Expect(key).To(Equal("some-key"))
+			Expect(afterIndex).To(BeEquivalentTo(42))
+		})
+
+		It("stops the watch when the context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			adapter.WatchFromContext(ctx, "some-key", 42)
+
+			Consistently(stop).ShouldNot(Receive())
+			cancel()
+			Eventually(stop).Should(Receive(BeTrue()))
+		})
+	})
+
+	Describe("MaintainNodeContext", func() {
+		var innerRelease chan chan bool
+
+		BeforeEach(func() {
+			innerRelease = make(chan chan bool, 1)
+			innerStoreAdapter.MaintainNodeReturns(make(chan bool), innerRelease, nil)
+		})
+
+		It("forwards releases sent by the caller", func() {
+			_, release, err := adapter.MaintainNodeContext(context.Background(), StoreNode{Key: "some-key", TTL: 1})
+			Expect(err).NotTo(HaveOccurred())
+
+			released := make(chan bool)
+			release <- released
+			Eventually(innerRelease).Should(Receive(Equal(released)))
+		})
+
+		It("releases the node when the context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			_, _, err := adapter.MaintainNodeContext(ctx, StoreNode{Key: "some-key", TTL: 1})
+			Expect(err).NotTo(HaveOccurred())
+
+			Consistently(innerRelease).ShouldNot(Receive())
+			cancel()
+			Eventually(innerRelease).Should(Receive())
+		})
+
+		It("acknowledges a release sent after the context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			_, release, err := adapter.MaintainNodeContext(ctx, StoreNode{Key: "some-key", TTL: 1})
+			Expect(err).NotTo(HaveOccurred())
+
+			cancel()
+			Eventually(innerRelease).Should(Receive())
+
+			released := make(chan bool)
+			Eventually(release).Should(BeSent(released))
+			Eventually(released).Should(BeClosed())
+			Consistently(innerRelease).ShouldNot(Receive())
+		})
+	})
+
+	Describe("MaintainNodeWithTokenContext", func() {
+		var innerRelease chan chan bool
+
+		BeforeEach(func() {
+			innerRelease = make(chan chan bool, 1)
+			innerStoreAdapter.MaintainNodeWithTokenReturns(make(chan NodeStatus), innerRelease, nil)
+		})
+
+		It("releases the node when the context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			_, _, err := adapter.MaintainNodeWithTokenContext(ctx, StoreNode{Key: "some-key", TTL: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(innerStoreAdapter.MaintainNodeWithTokenCallCount()).To(Equal(1))
+
+			Consistently(innerRelease).ShouldNot(Receive())
+			cancel()
+			Eventually(innerRelease).Should(Receive())
+		})
+	})
+})
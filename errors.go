@@ -1,16 +1,81 @@
 package storeadapter
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net"
 )
 
 var (
 	ErrorKeyNotFound         = errors.New("the requested key could not be found")
 	ErrorNodeIsDirectory     = errors.New("node is a directory, not a leaf")
 	ErrorNodeIsNotDirectory  = errors.New("node is a leaf, not a directory")
+	ErrorDirectoryNotEmpty   = errors.New("directory is not empty")
 	ErrorTimeout             = errors.New("store request timed out")
 	ErrorInvalidFormat       = errors.New("node has invalid format")
 	ErrorInvalidTTL          = errors.New("got an invalid TTL")
 	ErrorKeyExists           = errors.New("a node already exists at the requested key")
 	ErrorKeyComparisonFailed = errors.New("node comparison failed")
+	ErrorWatchIndexCleared   = errors.New("the store no longer has the events requested by the watch")
+	ErrorCircuitOpen         = errors.New("the circuit breaker is open: requests are not being sent to the failing store")
 )
+
+// Error describes a failed store operation on a key. It unwraps to Err, which
+// is one of the errors above when the failure corresponds to one of them, so
+// errors.Is(err, ErrorKeyNotFound) holds for an Error about a missing key.
+type Error struct {
+	// The operation that failed, e.g. "Get" or "CompareAndSwap".
+	Op  string
+	Key string
+
+	// The backend's own error code and the store index it reported, if any.
+	Code  int
+	Index uint64
+
+	// Whether the store could not serve the request for the time being: it
+	// could not be reached, was electing a leader, or failed internally. The
+	// same request may succeed if it is made again.
+	Temporary bool
+
+	Err   error
+	Cause error
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("%s %s: %s", e.Op, e.Key, e.Err)
+	if e.Key == "" {
+		message = fmt.Sprintf("%s: %s", e.Op, e.Err)
+	}
+
+	if e.Cause != nil && e.Cause != e.Err {
+		message += fmt.Sprintf(" (%s)", e.Cause)
+	}
+
+	return message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsTemporary reports whether err is a failure that may go away if the request
+// is made again: a timeout, an *Error marked Temporary, or a network error.
+// A done context is never temporary.
+func IsTemporary(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, ErrorTimeout) {
+		return true
+	}
+
+	var storeErr *Error
+	if errors.As(err, &storeErr) && storeErr.Temporary {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
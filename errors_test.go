@@ -0,0 +1,79 @@
+package storeadapter_test
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	. "github.com/cloudfoundry/storeadapter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Error", func() {
+	It("unwraps to the sentinel it wraps", func() {
+		var err error = &Error{Op: "Get", Key: "/a", Err: ErrorKeyNotFound}
+
+		Expect(errors.Is(err, ErrorKeyNotFound)).To(BeTrue())
+		Expect(errors.Is(err, ErrorKeyExists)).To(BeFalse())
+	})
+
+	It("can be found inside a MultiError", func() {
+		err := NewMultiError([]KeyResult{
+			{Key: "/a", Err: &Error{Op: "Delete", Key: "/a", Err: ErrorKeyNotFound}},
+		})
+
+		var storeErr *Error
+		Expect(errors.As(err, &storeErr)).To(BeTrue())
+		Expect(storeErr.Key).To(Equal("/a"))
+		Expect(errors.Is(err, ErrorKeyNotFound)).To(BeTrue())
+	})
+
+	Describe("Error()", func() {
+		It("includes the operation, key and error", func() {
+			err := &Error{Op: "Get", Key: "/a", Err: ErrorKeyNotFound}
+			Expect(err.Error()).To(Equal("Get /a: the requested key could not be found"))
+		})
+
+		It("includes the cause when it differs from the error", func() {
+			err := &Error{Op: "Get", Key: "/a", Code: 100, Err: ErrorKeyNotFound, Cause: errors.New("100: Key not found (/a) [12]")}
+			Expect(err.Error()).To(Equal("Get /a: the requested key could not be found (100: Key not found (/a) [12])"))
+		})
+
+		It("leaves out an empty key", func() {
+			err := &Error{Op: "Connect", Err: ErrorTimeout}
+			Expect(err.Error()).To(Equal("Connect: store request timed out"))
+		})
+
+		It("does not repeat a cause that is the error itself", func() {
+			cause := errors.New("connection refused")
+			err := &Error{Op: "Set", Key: "/a", Err: cause, Cause: cause}
+			Expect(err.Error()).To(Equal("Set /a: connection refused"))
+		})
+	})
+
+	Describe("IsTemporary", func() {
+		It("holds for timeouts, errors marked Temporary and network errors", func() {
+			Expect(IsTemporary(ErrorTimeout)).To(BeTrue())
+			Expect(IsTemporary(&Error{Op: "Set", Key: "/a", Code: 300, Err: errors.New("raft internal error"), Temporary: true})).To(BeTrue())
+			Expect(IsTemporary(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})).To(BeTrue())
+		})
+
+		It("holds for a MultiError with a temporary failure", func() {
+			err := NewMultiError([]KeyResult{
+				{Key: "/a"},
+				{Key: "/b", Err: &Error{Op: "Set", Key: "/b", Err: ErrorTimeout}},
+			})
+
+			Expect(IsTemporary(err)).To(BeTrue())
+		})
+
+		It("does not hold for other errors, or a done context", func() {
+			Expect(IsTemporary(nil)).To(BeFalse())
+			Expect(IsTemporary(&Error{Op: "Get", Key: "/a", Err: ErrorKeyNotFound})).To(BeFalse())
+			Expect(IsTemporary(context.DeadlineExceeded)).To(BeFalse())
+			Expect(IsTemporary(context.Canceled)).To(BeFalse())
+		})
+	})
+})
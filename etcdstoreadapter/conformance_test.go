@@ -0,0 +1,27 @@
+package etcdstoreadapter_test
+
+import (
+	"code.cloudfoundry.org/workpool"
+	"github.com/cloudfoundry/storeadapter"
+	. "github.com/cloudfoundry/storeadapter/etcdstoreadapter"
+	"github.com/cloudfoundry/storeadapter/storeadaptertest"
+	. "github.com/onsi/gomega"
+)
+
+var _ = storeadaptertest.DescribeConformance("ETCD Store Adapter conformance", storeadaptertest.Backend{
+	NewStoreAdapter: func() storeadapter.StoreAdapter {
+		workPool, err := workpool.NewWorkPool(10)
+		Expect(err).NotTo(HaveOccurred())
+
+		adapter, err := New(&ETCDOptions{
+			CertFile:    "../assets/client.crt",
+			KeyFile:     "../assets/client.key",
+			CAFile:      "../assets/ca.crt",
+			ClusterUrls: etcdRunner.NodeURLS(),
+			IsSSL:       true,
+		}, workPool)
+		Expect(err).NotTo(HaveOccurred())
+		return adapter
+	},
+	EmptyDirectoriesRemain: true,
+})
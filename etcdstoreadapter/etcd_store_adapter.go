@@ -1,6 +1,7 @@
 package etcdstoreadapter
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 
 	"code.cloudfoundry.org/workpool"
 	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/internal/maintain"
 	"github.com/coreos/go-etcd/etcd"
 	"github.com/nu7hatch/gouuid"
 )
@@ -86,11 +88,25 @@ func newAdapter(client *etcd.Client, workPool *workpool.WorkPool) *ETCDStoreAdap
 }
 
 func (adapter *ETCDStoreAdapter) Connect() error {
-	if !adapter.client.SyncCluster() {
-		return errors.New("sync cluster failed")
-	}
+	return adapter.ConnectContext(context.Background())
+}
 
-	return nil
+func (adapter *ETCDStoreAdapter) ConnectContext(ctx context.Context) error {
+	synced := make(chan bool, 1)
+
+	go func() {
+		synced <- adapter.client.SyncCluster()
+	}()
+
+	select {
+	case ok := <-synced:
+		if !ok {
+			return &storeadapter.Error{Op: "Connect", Err: errSyncClusterFailed, Cause: errSyncClusterFailed, Temporary: true}
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (adapter *ETCDStoreAdapter) Disconnect() error {
@@ -105,77 +121,189 @@ func (adapter *ETCDStoreAdapter) isEventIndexClearedError(err error) bool {
 }
 
 func (adapter *ETCDStoreAdapter) etcdErrorCode(err error) int {
+	if etcdErr, ok := asEtcdError(err); ok {
+		return etcdErr.ErrorCode
+	}
+	return 0
+}
+
+func asEtcdError(err error) (etcd.EtcdError, bool) {
 	if err != nil {
 		switch err.(type) {
 		case etcd.EtcdError:
-			return err.(etcd.EtcdError).ErrorCode
+			return err.(etcd.EtcdError), true
 		case *etcd.EtcdError:
-			return err.(*etcd.EtcdError).ErrorCode
+			return *err.(*etcd.EtcdError), true
 		}
 	}
-	return 0
+	return etcd.EtcdError{}, false
 }
 
-func (adapter *ETCDStoreAdapter) convertError(err error) error {
-	switch adapter.etcdErrorCode(err) {
+var errSyncClusterFailed = errors.New("sync cluster failed")
+
+// convertError wraps err in a *storeadapter.Error for the given operation and
+// key, keeping etcd's error code, index and message while unwrapping to the
+// matching storeadapter sentinel. Network errors, an unreachable cluster,
+// leader elections and etcd's internal errors are marked Temporary. Context
+// errors are returned as is.
+func (adapter *ETCDStoreAdapter) convertError(op string, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch err.(type) {
+	case *storeadapter.Error, *storeadapter.MultiError:
+		return err
+	}
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+
+	converted := &storeadapter.Error{Op: op, Key: key, Err: err, Cause: err}
+
+	etcdErr, ok := asEtcdError(err)
+	if !ok {
+		var netErr net.Error
+		converted.Temporary = errors.As(err, &netErr)
+		return converted
+	}
+
+	converted.Code = etcdErr.ErrorCode
+	converted.Index = etcdErr.Index
+
+	switch etcdErr.ErrorCode {
+	case 300, 301, 502:
+		// raft internal error, leader election, unhandled HTTP status
+		converted.Temporary = true
 	case 501:
-		return storeadapter.ErrorTimeout
+		converted.Err = storeadapter.ErrorTimeout
+		converted.Temporary = true
 	case 100:
-		return storeadapter.ErrorKeyNotFound
+		converted.Err = storeadapter.ErrorKeyNotFound
 	case 102:
-		return storeadapter.ErrorNodeIsDirectory
+		converted.Err = storeadapter.ErrorNodeIsDirectory
+	case 104:
+		converted.Err = storeadapter.ErrorNodeIsNotDirectory
 	case 105:
-		return storeadapter.ErrorKeyExists
+		converted.Err = storeadapter.ErrorKeyExists
+	case 108:
+		converted.Err = storeadapter.ErrorDirectoryNotEmpty
 	case 101:
-		return storeadapter.ErrorKeyComparisonFailed
+		converted.Err = storeadapter.ErrorKeyComparisonFailed
+	case 401:
+		converted.Err = storeadapter.ErrorWatchIndexCleared
 	}
 
-	return err
+	return converted
 }
 
-func (adapter *ETCDStoreAdapter) SetMulti(nodes []storeadapter.StoreNode) error {
-	results := make(chan error, len(nodes))
+// submit runs work on the work pool and sends its result on results. Work
+// that is still queued when ctx is done is skipped.
+func (adapter *ETCDStoreAdapter) submit(ctx context.Context, results chan<- error, work func() error) {
+	adapter.workPool.Submit(func() {
+		if err := ctx.Err(); err != nil {
+			results <- err
+			return
+		}
+
+		results <- work()
+	})
+}
+
+// awaitResult waits for a result, or for the context to be done.
+func awaitResult(ctx context.Context, results <-chan error) error {
+	select {
+	case err := <-results:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fanOut runs one request per key on the work pool. If any of them fail, it
+// returns a *storeadapter.MultiError holding the outcome for every key.
+func (adapter *ETCDStoreAdapter) fanOut(ctx context.Context, op string, keys []string, request func(i int) (*etcd.Response, error)) error {
+	results := make([]storeadapter.KeyResult, len(keys))
+	done := make(chan bool, len(keys))
+
+	for i, key := range keys {
+		i := i
+		results[i].Key = key
 
-	for _, node := range nodes {
-		node := node
 		adapter.workPool.Submit(func() {
-			_, err := adapter.client.Set(node.Key, string(node.Value), node.TTL)
-			results <- err
+			defer func() {
+				done <- true
+			}()
+
+			if err := ctx.Err(); err != nil {
+				results[i].Err = err
+				return
+			}
+
+			response, err := request(i)
+			if err != nil {
+				results[i].Err = adapter.convertError(op, key, err)
+			} else if response != nil && response.Node != nil {
+				results[i].Index = response.Node.ModifiedIndex
+			}
 		})
 	}
 
-	var err error
 	numReceived := 0
-	for numReceived < len(nodes) {
-		result := <-results
-		numReceived++
-		if err == nil {
-			err = result
+	for numReceived < len(keys) {
+		select {
+		case <-done:
+			numReceived++
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 
-	return adapter.convertError(err)
+	return storeadapter.NewMultiError(results)
+}
+
+func nodeKeys(nodes []storeadapter.StoreNode) []string {
+	keys := make([]string, len(nodes))
+	for i, node := range nodes {
+		keys[i] = node.Key
+	}
+
+	return keys
+}
+
+func (adapter *ETCDStoreAdapter) SetMulti(nodes []storeadapter.StoreNode) error {
+	return adapter.SetMultiContext(context.Background(), nodes)
+}
+
+func (adapter *ETCDStoreAdapter) SetMultiContext(ctx context.Context, nodes []storeadapter.StoreNode) error {
+	return adapter.fanOut(ctx, "SetMulti", nodeKeys(nodes), func(i int) (*etcd.Response, error) {
+		return adapter.client.Set(nodes[i].Key, string(nodes[i].Value), nodes[i].TTL)
+	})
 }
 
 func (adapter *ETCDStoreAdapter) Get(key string) (storeadapter.StoreNode, error) {
-	done := make(chan bool, 1)
+	return adapter.GetContext(context.Background(), key)
+}
+
+func (adapter *ETCDStoreAdapter) GetContext(ctx context.Context, key string) (storeadapter.StoreNode, error) {
+	results := make(chan error, 1)
 	var response *etcd.Response
-	var err error
 
 	//we route through the worker pool to enable usage tracking
-	adapter.workPool.Submit(func() {
+	adapter.submit(ctx, results, func() error {
+		var err error
 		response, err = adapter.client.Get(key, false, false)
-		done <- true
+		return err
 	})
 
-	<-done
-
+	err := awaitResult(ctx, results)
 	if err != nil {
-		return storeadapter.StoreNode{}, adapter.convertError(err)
+		return storeadapter.StoreNode{}, adapter.convertError("Get", key, err)
 	}
 
 	if response.Node.Dir {
-		return storeadapter.StoreNode{}, storeadapter.ErrorNodeIsDirectory
+		return storeadapter.StoreNode{}, adapter.convertError("Get", key, storeadapter.ErrorNodeIsDirectory)
 	}
 
 	return storeadapter.StoreNode{
@@ -188,59 +316,78 @@ func (adapter *ETCDStoreAdapter) Get(key string) (storeadapter.StoreNode, error)
 }
 
 func (adapter *ETCDStoreAdapter) ListRecursively(key string) (storeadapter.StoreNode, error) {
-	done := make(chan bool, 1)
+	return adapter.ListRecursivelyContext(context.Background(), key)
+}
+
+func (adapter *ETCDStoreAdapter) ListRecursivelyContext(ctx context.Context, key string) (storeadapter.StoreNode, error) {
+	results := make(chan error, 1)
 	var response *etcd.Response
-	var err error
 
 	//we route through the worker pool to enable usage tracking
-	adapter.workPool.Submit(func() {
+	adapter.submit(ctx, results, func() error {
+		var err error
 		response, err = adapter.client.Get(key, false, true)
-		done <- true
+		return err
 	})
 
-	<-done
-
+	err := awaitResult(ctx, results)
 	if err != nil {
-		return storeadapter.StoreNode{}, adapter.convertError(err)
+		return storeadapter.StoreNode{}, adapter.convertError("ListRecursively", key, err)
 	}
 
 	if !response.Node.Dir {
-		return storeadapter.StoreNode{}, storeadapter.ErrorNodeIsNotDirectory
+		return storeadapter.StoreNode{}, adapter.convertError("ListRecursively", key, storeadapter.ErrorNodeIsNotDirectory)
 	}
 
 	if len(response.Node.Nodes) == 0 {
-		return storeadapter.StoreNode{Key: key, Dir: true, Value: []byte{}, ChildNodes: []storeadapter.StoreNode{}, Index: response.Node.ModifiedIndex}, nil
+		return storeadapter.StoreNode{Key: key, Dir: true, Value: []byte{}, ChildNodes: []storeadapter.StoreNode{}, Index: response.EtcdIndex}, nil
 	}
 
-	return *adapter.makeStoreNode(response.Node), nil
+	// The directory is given the index the listing was read at, rather than
+	// its own, so that a watch from it misses nothing.
+	dir := *adapter.makeStoreNode(response.Node)
+	dir.Index = response.EtcdIndex
+	return dir, nil
 }
 
 func (adapter *ETCDStoreAdapter) Create(node storeadapter.StoreNode) error {
+	return adapter.CreateContext(context.Background(), node)
+}
+
+func (adapter *ETCDStoreAdapter) CreateContext(ctx context.Context, node storeadapter.StoreNode) error {
 	results := make(chan error, 1)
 
-	adapter.workPool.Submit(func() {
+	adapter.submit(ctx, results, func() error {
 		_, err := adapter.client.Create(node.Key, string(node.Value), node.TTL)
-		results <- err
+		return err
 	})
 
-	return adapter.convertError(<-results)
+	return adapter.convertError("Create", node.Key, awaitResult(ctx, results))
 }
 
 func (adapter *ETCDStoreAdapter) Update(node storeadapter.StoreNode) error {
+	return adapter.UpdateContext(context.Background(), node)
+}
+
+func (adapter *ETCDStoreAdapter) UpdateContext(ctx context.Context, node storeadapter.StoreNode) error {
 	results := make(chan error, 1)
 
-	adapter.workPool.Submit(func() {
+	adapter.submit(ctx, results, func() error {
 		_, err := adapter.client.Update(node.Key, string(node.Value), node.TTL)
-		results <- err
+		return err
 	})
 
-	return adapter.convertError(<-results)
+	return adapter.convertError("Update", node.Key, awaitResult(ctx, results))
 }
 
 func (adapter *ETCDStoreAdapter) CompareAndSwap(oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) error {
+	return adapter.CompareAndSwapContext(context.Background(), oldNode, newNode)
+}
+
+func (adapter *ETCDStoreAdapter) CompareAndSwapContext(ctx context.Context, oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) error {
 	results := make(chan error, 1)
 
-	adapter.workPool.Submit(func() {
+	adapter.submit(ctx, results, func() error {
 		_, err := adapter.client.CompareAndSwap(
 			newNode.Key,
 			string(newNode.Value),
@@ -249,16 +396,20 @@ func (adapter *ETCDStoreAdapter) CompareAndSwap(oldNode storeadapter.StoreNode,
 			0,
 		)
 
-		results <- err
+		return err
 	})
 
-	return adapter.convertError(<-results)
+	return adapter.convertError("CompareAndSwap", newNode.Key, awaitResult(ctx, results))
 }
 
 func (adapter *ETCDStoreAdapter) CompareAndSwapByIndex(oldNodeIndex uint64, newNode storeadapter.StoreNode) error {
+	return adapter.CompareAndSwapByIndexContext(context.Background(), oldNodeIndex, newNode)
+}
+
+func (adapter *ETCDStoreAdapter) CompareAndSwapByIndexContext(ctx context.Context, oldNodeIndex uint64, newNode storeadapter.StoreNode) error {
 	results := make(chan error, 1)
 
-	adapter.workPool.Submit(func() {
+	adapter.submit(ctx, results, func() error {
 		_, err := adapter.client.CompareAndSwap(
 			newNode.Key,
 			string(newNode.Value),
@@ -267,172 +418,328 @@ func (adapter *ETCDStoreAdapter) CompareAndSwapByIndex(oldNodeIndex uint64, newN
 			oldNodeIndex,
 		)
 
-		results <- err
+		return err
 	})
 
-	return adapter.convertError(<-results)
+	return adapter.convertError("CompareAndSwapByIndex", newNode.Key, awaitResult(ctx, results))
 }
 
 func (adapter *ETCDStoreAdapter) Delete(keys ...string) error {
-	results := make(chan error, len(keys))
+	return adapter.DeleteContext(context.Background(), keys...)
+}
 
-	for _, key := range keys {
-		key := key
-		adapter.workPool.Submit(func() {
-			_, err := adapter.client.Delete(key, true)
-			results <- err
-		})
-	}
+func (adapter *ETCDStoreAdapter) DeleteContext(ctx context.Context, keys ...string) error {
+	return adapter.fanOut(ctx, "Delete", keys, func(i int) (*etcd.Response, error) {
+		return adapter.client.Delete(keys[i], true)
+	})
+}
 
-	var err error
-	numReceived := 0
-	for numReceived < len(keys) {
-		result := <-results
-		numReceived++
-		if err == nil {
-			err = result
-		}
-	}
+func (adapter *ETCDStoreAdapter) DeleteLeaves(keys ...string) error {
+	return adapter.DeleteLeavesContext(context.Background(), keys...)
+}
 
-	return adapter.convertError(err)
+func (adapter *ETCDStoreAdapter) DeleteLeavesContext(ctx context.Context, keys ...string) error {
+	return adapter.fanOut(ctx, "DeleteLeaves", keys, func(i int) (*etcd.Response, error) {
+		return adapter.client.DeleteDir(keys[i])
+	})
 }
 
-func (adapter *ETCDStoreAdapter) DeleteLeaves(keys ...string) error {
-	results := make(chan error, len(keys))
+func (adapter *ETCDStoreAdapter) CompareAndDelete(nodes ...storeadapter.StoreNode) error {
+	return adapter.CompareAndDeleteContext(context.Background(), nodes...)
+}
 
-	for _, key := range keys {
-		key := key
-		adapter.workPool.Submit(func() {
-			_, err := adapter.client.DeleteDir(key)
-			results <- err
-		})
+func (adapter *ETCDStoreAdapter) CompareAndDeleteContext(ctx context.Context, nodes ...storeadapter.StoreNode) error {
+	return adapter.fanOut(ctx, "CompareAndDelete", nodeKeys(nodes), func(i int) (*etcd.Response, error) {
+		return adapter.client.CompareAndDelete(
+			nodes[i].Key,
+			string(nodes[i].Value),
+			0,
+		)
+	})
+}
+
+func (adapter *ETCDStoreAdapter) CompareAndDeleteByIndex(nodes ...storeadapter.StoreNode) error {
+	return adapter.CompareAndDeleteByIndexContext(context.Background(), nodes...)
+}
+
+func (adapter *ETCDStoreAdapter) CompareAndDeleteByIndexContext(ctx context.Context, nodes ...storeadapter.StoreNode) error {
+	return adapter.fanOut(ctx, "CompareAndDeleteByIndex", nodeKeys(nodes), func(i int) (*etcd.Response, error) {
+		return adapter.client.CompareAndDelete(
+			nodes[i].Key,
+			"",
+			nodes[i].Index,
+		)
+	})
+}
+
+func (adapter *ETCDStoreAdapter) Txn(comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	return adapter.TxnContext(context.Background(), comparisons, operations)
+}
+
+// The etcd v2 API has no multi-key transactions. Instead, every key involved
+// is read once, the comparisons are checked against what was read, and the
+// operations are applied one at a time, each guarded by the index the key is
+// expected to be at. If an operation fails, including because another writer
+// got in between, the operations already applied are undone in reverse order.
+// If undoing any of them fails too, a *MultiError is returned, with the
+// operation's error and an error wrapping ErrorTxnNotUndone for each key left
+// applied.
+//
+// A v2 Txn is therefore neither isolated nor atomic under concurrent writers:
+// a key that is only compared is not guarded once it has been read, so it may
+// change before the operations are applied, and other readers may see some of
+// the operations applied before the rest are, or before they are undone.
+//
+// The transaction runs to completion on the work pool even if ctx is done
+// while it is in flight.
+func (adapter *ETCDStoreAdapter) TxnContext(ctx context.Context, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	results := make(chan error, 1)
+
+	adapter.submit(ctx, results, func() error {
+		return adapter.txn(comparisons, operations)
+	})
+
+	return adapter.convertError("Txn", "", awaitResult(ctx, results))
+}
+
+// ErrorTxnNotUndone is wrapped by the result for a key whose operation Txn
+// applied and then failed to undo, after a later operation failed.
+var ErrorTxnNotUndone = errors.New("the transaction's operation on the key was applied and could not be undone")
+
+type txnUndo struct {
+	key      string
+	previous *etcd.Node
+	index    uint64
+}
+
+func (adapter *ETCDStoreAdapter) txn(comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	current := map[string]*etcd.Node{}
+	lookup := func(key string) (*etcd.Node, error) {
+		if node, ok := current[key]; ok {
+			return node, nil
+		}
+
+		response, err := adapter.client.Get(key, false, false)
+		if err != nil {
+			err = adapter.convertError("Txn", key, err)
+			if errors.Is(err, storeadapter.ErrorKeyNotFound) {
+				current[key] = nil
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		current[key] = response.Node
+		return response.Node, nil
 	}
 
-	var err error
-	numReceived := 0
-	for numReceived < len(keys) {
-		result := <-results
-		numReceived++
+	for _, comparison := range comparisons {
+		node, err := lookup(comparison.Node.Key)
+		if err != nil {
+			return err
+		}
+
+		err = comparison.Check(adapter.makeStoreNode(node))
+		if err != nil {
+			return adapter.convertError("Txn", comparison.Node.Key, err)
+		}
+	}
+
+	undo := []txnUndo{}
+	for _, operation := range operations {
+		previous, err := lookup(operation.Node.Key)
 		if err == nil {
-			err = result
+			var applied *etcd.Node
+			applied, err = adapter.applyTxnOp(operation, previous)
+			if err == nil {
+				current[operation.Node.Key] = applied
+
+				index := uint64(0)
+				if applied != nil {
+					index = applied.ModifiedIndex
+				}
+				undo = append(undo, txnUndo{key: operation.Node.Key, previous: previous, index: index})
+				continue
+			}
 		}
+
+		err = adapter.convertError("Txn", operation.Node.Key, err)
+
+		leftApplied := adapter.rollbackTxn(undo)
+		if len(leftApplied) > 0 {
+			results := append([]storeadapter.KeyResult{{Key: operation.Node.Key, Err: err}}, leftApplied...)
+			return storeadapter.NewMultiError(results)
+		}
+
+		return err
 	}
 
-	return adapter.convertError(err)
+	return nil
 }
 
-func (adapter *ETCDStoreAdapter) CompareAndDelete(nodes ...storeadapter.StoreNode) error {
-	results := make(chan error, len(nodes))
+func (adapter *ETCDStoreAdapter) applyTxnOp(operation storeadapter.TxnOp, previous *etcd.Node) (*etcd.Node, error) {
+	node := operation.Node
 
-	for _, node := range nodes {
-		node := node
-		adapter.workPool.Submit(func() {
-			_, err := adapter.client.CompareAndDelete(
-				node.Key,
-				string(node.Value),
-				0,
-			)
-			results <- err
-		})
-	}
+	switch operation.Type {
+	case storeadapter.PutOp:
+		var response *etcd.Response
+		var err error
 
-	var err error
-	numReceived := 0
-	for numReceived < len(nodes) {
-		result := <-results
-		numReceived++
-		if err == nil {
-			err = result
+		if previous == nil {
+			response, err = adapter.client.Create(node.Key, string(node.Value), node.TTL)
+		} else if previous.Dir {
+			return nil, storeadapter.ErrorNodeIsDirectory
+		} else {
+			response, err = adapter.client.CompareAndSwap(node.Key, string(node.Value), node.TTL, "", previous.ModifiedIndex)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		return response.Node, nil
+
+	case storeadapter.DeleteOp:
+		if previous == nil {
+			return nil, storeadapter.ErrorKeyNotFound
+		}
+		if previous.Dir {
+			return nil, storeadapter.ErrorNodeIsDirectory
 		}
+
+		_, err := adapter.client.CompareAndDelete(node.Key, "", previous.ModifiedIndex)
+		return nil, err
 	}
 
-	return adapter.convertError(err)
+	return nil, storeadapter.ErrorInvalidFormat
 }
 
-func (adapter *ETCDStoreAdapter) CompareAndDeleteByIndex(nodes ...storeadapter.StoreNode) error {
-	results := make(chan error, len(nodes))
+// rollbackTxn undoes the applied operations in reverse order, returning a
+// result for each key it could not restore.
+func (adapter *ETCDStoreAdapter) rollbackTxn(undo []txnUndo) []storeadapter.KeyResult {
+	leftApplied := []storeadapter.KeyResult{}
 
-	for _, node := range nodes {
-		node := node
-		adapter.workPool.Submit(func() {
-			_, err := adapter.client.CompareAndDelete(
-				node.Key,
-				"",
-				node.Index,
-			)
-			results <- err
-		})
-	}
+	for i := len(undo) - 1; i >= 0; i-- {
+		step := undo[i]
 
-	var err error
-	numReceived := 0
-	for numReceived < len(nodes) {
-		result := <-results
-		numReceived++
-		if err == nil {
-			err = result
+		var err error
+		switch {
+		case step.previous == nil:
+			_, err = adapter.client.CompareAndDelete(step.key, "", step.index)
+		case step.index == 0:
+			_, err = adapter.client.Create(step.key, step.previous.Value, uint64(step.previous.TTL))
+		default:
+			_, err = adapter.client.CompareAndSwap(step.key, step.previous.Value, uint64(step.previous.TTL), "", step.index)
+		}
+
+		if err != nil {
+			leftApplied = append(leftApplied, storeadapter.KeyResult{
+				Key: step.key,
+				Err: &storeadapter.Error{Op: "Txn", Key: step.key, Err: ErrorTxnNotUndone, Cause: adapter.convertError("Txn", step.key, err)},
+			})
 		}
 	}
 
-	return adapter.convertError(err)
+	return leftApplied
 }
 
 func (adapter *ETCDStoreAdapter) UpdateDirTTL(key string, ttl uint64) error {
-	response, err := adapter.Get(key)
+	return adapter.UpdateDirTTLContext(context.Background(), key, ttl)
+}
+
+func (adapter *ETCDStoreAdapter) UpdateDirTTLContext(ctx context.Context, key string, ttl uint64) error {
+	response, err := adapter.GetContext(ctx, key)
 	if err == nil && response.Dir == false {
-		return storeadapter.ErrorNodeIsNotDirectory
+		return adapter.convertError("UpdateDirTTL", key, storeadapter.ErrorNodeIsNotDirectory)
 	}
 
 	results := make(chan error, 1)
 
-	adapter.workPool.Submit(func() {
-		_, err = adapter.client.UpdateDir(key, ttl)
-		results <- err
+	adapter.submit(ctx, results, func() error {
+		_, err := adapter.client.UpdateDir(key, ttl)
+		return err
 	})
 
-	return adapter.convertError(<-results)
+	return adapter.convertError("UpdateDirTTL", key, awaitResult(ctx, results))
 }
 
 func (adapter *ETCDStoreAdapter) Watch(key string) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	return adapter.WatchContext(context.Background(), key)
+}
+
+func (adapter *ETCDStoreAdapter) WatchContext(ctx context.Context, key string) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
 	events := make(chan storeadapter.WatchEvent)
 	errors := make(chan error)
 	stop := make(chan bool, 1)
 
-	go adapter.dispatchWatchEvents(key, events, stop, errors)
+	go adapter.dispatchWatchEvents(ctx, key, 0, false, events, stop, errors)
 
 	time.Sleep(100 * time.Millisecond) //give the watcher a chance to connect
 
 	return events, stop, errors
 }
 
-func (adapter *ETCDStoreAdapter) dispatchWatchEvents(key string, events chan<- storeadapter.WatchEvent, stop chan bool, errors chan<- error) {
-	var index uint64
+func (adapter *ETCDStoreAdapter) WatchFrom(key string, afterIndex uint64) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	return adapter.WatchFromContext(context.Background(), key, afterIndex)
+}
+
+func (adapter *ETCDStoreAdapter) WatchFromContext(ctx context.Context, key string, afterIndex uint64) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	events := make(chan storeadapter.WatchEvent)
+	errors := make(chan error)
+	stop := make(chan bool, 1)
+
+	go adapter.dispatchWatchEvents(ctx, key, afterIndex+1, true, events, stop, errors)
+
+	return events, stop, errors
+}
+
+// dispatchWatchEvents watches from index, or from the current index when it
+// is zero. If etcd has already cleared the events being waited for, a
+// resumable watch reports ErrorWatchIndexCleared and stops; any other watch
+// carries on from the current index.
+func (adapter *ETCDStoreAdapter) dispatchWatchEvents(ctx context.Context, key string, index uint64, resumable bool, events chan<- storeadapter.WatchEvent, stop chan bool, errors chan<- error) {
 	adapter.registerInflightWatch(stop)
 
 	defer close(events)
 	defer close(errors)
 	defer adapter.unregisterInflightWatch(stop)
 
+	if ctx.Done() != nil {
+		watchDone := make(chan struct{})
+		defer close(watchDone)
+
+		go adapter.cancelInflightWatchOnDone(ctx, stop, watchDone)
+	}
+
 	for {
 		response, err := adapter.client.Watch(key, index, true, nil, stop)
 		if err != nil {
-			if adapter.isEventIndexClearedError(err) {
+			if adapter.isEventIndexClearedError(err) && !resumable {
 				index = 0
 				continue
 			} else if err == etcd.ErrWatchStoppedByUser {
 				return
 			} else {
-				errors <- adapter.convertError(err)
+				select {
+				case errors <- adapter.convertError("Watch", key, err):
+				case <-ctx.Done():
+				}
 				return
 			}
 		}
 
 		event, err := adapter.makeWatchEvent(response)
 		if err != nil {
-			errors <- err
+			select {
+			case errors <- err:
+			case <-ctx.Done():
+			}
 			return
 		} else {
-			events <- event
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
 		}
 
 		index = response.Node.ModifiedIndex + 1
@@ -456,14 +763,31 @@ func (adapter *ETCDStoreAdapter) cancelInflightWatches() {
 	defer adapter.inflightWatchLock.Unlock()
 
 	for stop := range adapter.inflightWatches {
-		select {
-		case _, ok := <-stop:
-			if ok {
-				close(stop)
-			}
-		default:
+		closeStopChannel(stop)
+	}
+}
+
+func (adapter *ETCDStoreAdapter) cancelInflightWatchOnDone(ctx context.Context, stop chan bool, watchDone <-chan struct{}) {
+	select {
+	case <-ctx.Done():
+		adapter.inflightWatchLock.Lock()
+		defer adapter.inflightWatchLock.Unlock()
+
+		if adapter.inflightWatches[stop] {
+			closeStopChannel(stop)
+		}
+	case <-watchDone:
+	}
+}
+
+func closeStopChannel(stop chan bool) {
+	select {
+	case _, ok := <-stop:
+		if ok {
 			close(stop)
 		}
+	default:
+		close(stop)
 	}
 }
 
@@ -520,12 +844,53 @@ func (adapter *ETCDStoreAdapter) makeWatchEvent(event *etcd.Response) (storeadap
 		Type:     eventType,
 		Node:     adapter.makeStoreNode(node),
 		PrevNode: adapter.makeStoreNode(event.PrevNode),
+		Index:    event.Node.ModifiedIndex,
 	}, nil
 }
 
 func (adapter *ETCDStoreAdapter) MaintainNode(storeNode storeadapter.StoreNode) (<-chan bool, chan (chan bool), error) {
-	if storeNode.TTL == 0 {
-		return nil, nil, storeadapter.ErrorInvalidTTL
+	return adapter.MaintainNodeContext(context.Background(), storeNode)
+}
+
+func (adapter *ETCDStoreAdapter) MaintainNodeContext(ctx context.Context, storeNode storeadapter.StoreNode) (<-chan bool, chan (chan bool), error) {
+	status, releaseNode, err := adapter.MaintainNodeWithTokenContext(ctx, storeNode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owned := make(chan bool)
+	go func() {
+		defer close(owned)
+		for nodeStatus := range status {
+			owned <- nodeStatus.Owned
+		}
+	}()
+
+	return owned, releaseNode, nil
+}
+
+func (adapter *ETCDStoreAdapter) MaintainNodeWithToken(storeNode storeadapter.StoreNode) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	return adapter.MaintainNodeWithTokenContext(context.Background(), storeNode)
+}
+
+// The fencing token is the node's ModifiedIndex when it is created, or when
+// refreshing it succeeds again after failing.
+func (adapter *ETCDStoreAdapter) MaintainNodeWithTokenContext(ctx context.Context, storeNode storeadapter.StoreNode) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	return adapter.MaintainNodeWithOptionsContext(ctx, storeNode, storeadapter.MaintainOptions{})
+}
+
+func (adapter *ETCDStoreAdapter) MaintainNodeWithOptions(storeNode storeadapter.StoreNode, options storeadapter.MaintainOptions) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	return adapter.MaintainNodeWithOptionsContext(context.Background(), storeNode, options)
+}
+
+func (adapter *ETCDStoreAdapter) MaintainNodeWithOptionsContext(ctx context.Context, storeNode storeadapter.StoreNode, options storeadapter.MaintainOptions) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	options, err := options.WithDefaults(storeNode.TTL)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	if len(storeNode.Value) == 0 {
@@ -537,113 +902,56 @@ func (adapter *ETCDStoreAdapter) MaintainNode(storeNode storeadapter.StoreNode)
 		storeNode.Value = []byte(guid.String())
 	}
 
-	releaseNode := make(chan chan bool)
-	nodeStatus := make(chan bool)
-
-	go adapter.maintainNode(storeNode, nodeStatus, releaseNode)
+	nodeStatus, releaseNode := maintain.Node(ctx, options, maintain.Backend{
+		Acquire: func(context.Context) (uint64, error) {
+			return adapter.acquireNode(storeNode)
+		},
+		Refresh: func(context.Context, uint64) error {
+			return adapter.refreshNode(storeNode)
+		},
+		Release: func(uint64) {
+			adapter.releaseNode(storeNode)
+		},
+	})
 
 	return nodeStatus, releaseNode, nil
 }
 
-func (adapter *ETCDStoreAdapter) maintainNode(storeNode storeadapter.StoreNode, nodeStatus chan bool, releaseNode chan (chan bool)) {
-	frequency := 2
-	maintenanceInterval := time.Duration(storeNode.TTL) * time.Second / time.Duration(frequency)
-	timer := time.NewTimer(0)
+// acquireNode creates the node, or takes it over if it holds our value,
+// returning the index it was written at. A node holding another value is
+// reported as ErrorKeyExists.
+func (adapter *ETCDStoreAdapter) acquireNode(storeNode storeadapter.StoreNode) (uint64, error) {
+	response, err := adapter.client.Create(storeNode.Key, string(storeNode.Value), storeNode.TTL)
+	err = adapter.convertError("MaintainNode", storeNode.Key, err)
+	if errors.Is(err, storeadapter.ErrorKeyExists) {
+		response, err = adapter.client.CompareAndSwap(storeNode.Key, string(storeNode.Value), storeNode.TTL, string(storeNode.Value), 0)
+		err = adapter.convertError("MaintainNode", storeNode.Key, err)
+		if errors.Is(err, storeadapter.ErrorKeyComparisonFailed) || errors.Is(err, storeadapter.ErrorKeyNotFound) {
+			return 0, adapter.convertError("MaintainNode", storeNode.Key, storeadapter.ErrorKeyExists)
+		}
+	}
 
-	created := false
-	owned := false
-	frequencyCycle := 0
+	if err != nil {
+		return 0, err
+	}
 
-	for {
-		retryInterval := 2 * time.Second
-		select {
-		case <-timer.C:
-			for {
-				if created {
-					_, err := adapter.client.CompareAndSwap(
-						storeNode.Key,
-						string(storeNode.Value),
-						storeNode.TTL,
-						string(storeNode.Value),
-						0,
-					)
-
-					if err == nil {
-						frequencyCycle++
-						owned = true
-						elapsed := time.Duration(0)
-						if frequencyCycle == frequency {
-							elapsed = elapsedChannelSend(nodeStatus, true)
-							frequencyCycle = 0
-						}
-						timer.Reset(maintenanceInterval - elapsed)
-						break
-					}
-
-					frequencyCycle = 0
-
-					if owned {
-						owned = false
-						nodeStatus <- false
-					}
-
-					err = adapter.convertError(err)
-					if err == storeadapter.ErrorKeyNotFound {
-						created = false
-						continue
-					}
-
-					retryInterval = retryBackOff(retryInterval, maintenanceInterval)
-					timer.Reset(retryInterval)
-					break
-				} else {
-					frequencyCycle = 0
-
-					_, err := adapter.client.Create(storeNode.Key, string(storeNode.Value), storeNode.TTL)
-					if err == nil {
-						created = true
-						owned = true
-
-						elapsed := elapsedChannelSend(nodeStatus, true)
-						timer.Reset(maintenanceInterval - elapsed)
-
-						break
-					}
-
-					err = adapter.convertError(err)
-					if err == storeadapter.ErrorKeyExists {
-						created = true
-						continue
-					}
-
-					retryInterval = retryBackOff(retryInterval, maintenanceInterval)
-					timer.Reset(retryInterval)
-					break
-				}
-			}
+	return response.Node.ModifiedIndex, nil
+}
 
-		case released := <-releaseNode:
-			adapter.client.CompareAndDelete(storeNode.Key, string(storeNode.Value), 0)
-			timer.Stop()
-			close(nodeStatus)
-			if released != nil {
-				close(released)
-			}
-			return
-		}
+// refreshNode gives the node its full TTL again, returning
+// maintain.ErrNodeLost if it no longer holds our value. The node is held by
+// value, as the token moves on with every refresh.
+func (adapter *ETCDStoreAdapter) refreshNode(storeNode storeadapter.StoreNode) error {
+	_, err := adapter.client.CompareAndSwap(storeNode.Key, string(storeNode.Value), storeNode.TTL, string(storeNode.Value), 0)
+	err = adapter.convertError("MaintainNode", storeNode.Key, err)
+	if errors.Is(err, storeadapter.ErrorKeyNotFound) || errors.Is(err, storeadapter.ErrorKeyComparisonFailed) {
+		return maintain.ErrNodeLost
 	}
-}
 
-func elapsedChannelSend(channel chan bool, val bool) time.Duration {
-	start := time.Now()
-	channel <- val
-	return time.Now().Sub(start)
+	return err
 }
 
-func retryBackOff(current, max time.Duration) time.Duration {
-	current = current * 2
-	if current > max {
-		current = max
-	}
-	return current
+// releaseNode deletes the node if it still holds our value.
+func (adapter *ETCDStoreAdapter) releaseNode(storeNode storeadapter.StoreNode) {
+	adapter.client.CompareAndDelete(storeNode.Key, string(storeNode.Value), 0)
 }
@@ -1,12 +1,15 @@
 package etcdstoreadapter_test
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	"code.cloudfoundry.org/clock/fakeclock"
 	"code.cloudfoundry.org/workpool"
 	. "github.com/cloudfoundry/storeadapter"
 	. "github.com/cloudfoundry/storeadapter/etcdstoreadapter"
+	"github.com/cloudfoundry/storeadapter/fakes"
 	. "github.com/cloudfoundry/storeadapter/storenodematchers"
 	"github.com/cloudfoundry/storeadapter/test_helpers"
 	. "github.com/onsi/ginkgo"
@@ -89,15 +92,28 @@ var _ = Describe("ETCD Store Adapter", func() {
 		Context("When getting a non-existent key", func() {
 			It("should return an error", func() {
 				value, err := adapter.Get("/not_a_key")
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 				Expect(value).To(BeZero())
 			})
+
+			It("should report the operation, key and etcd error", func() {
+				_, err := adapter.Get("/not_a_key")
+
+				storeErr, ok := err.(*Error)
+				Expect(ok).To(BeTrue())
+				Expect(storeErr.Op).To(Equal("Get"))
+				Expect(storeErr.Key).To(Equal("/not_a_key"))
+				Expect(storeErr.Code).To(Equal(100))
+				Expect(storeErr.Index).NotTo(BeZero())
+				Expect(storeErr.Cause).To(HaveOccurred())
+				Expect(storeErr.Error()).To(ContainSubstring("/not_a_key"))
+			})
 		})
 
 		Context("when getting a directory", func() {
 			It("should return an error", func() {
 				value, err := adapter.Get("/menu")
-				Expect(err).To(Equal(ErrorNodeIsDirectory))
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
 				Expect(value).To(BeZero())
 			})
 		})
@@ -156,7 +172,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 				}
 
 				err := adapter.SetMulti([]StoreNode{dirNode})
-				Expect(err).To(Equal(ErrorNodeIsDirectory))
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
 			})
 		})
 
@@ -292,7 +308,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 		Context("when listing a non-existent key", func() {
 			It("should return an error", func() {
 				value, err := adapter.ListRecursively("/nothing-here")
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 				Expect(value).To(BeZero())
 			})
 		})
@@ -301,7 +317,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 			It("should return an error", func() {
 				value, err := adapter.ListRecursively("/menu/breakfast")
 				Expect(err).To(HaveOccurred())
-				Expect(err).To(Equal(ErrorNodeIsNotDirectory))
+				Expect(err).To(MatchError(ErrorNodeIsNotDirectory))
 				Expect(value).To(BeZero())
 			})
 		})
@@ -335,11 +351,11 @@ var _ = Describe("ETCD Store Adapter", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				value, err := adapter.Get("/menu/breakfast")
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 				Expect(value).To(BeZero())
 
 				value, err = adapter.Get("/menu/lunch")
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 				Expect(value).To(BeZero())
 			})
 		})
@@ -347,7 +363,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 		Context("when deleting a non-existing key", func() {
 			It("should error", func() {
 				err := adapter.Delete("/not-a-key")
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 		})
 
@@ -357,10 +373,10 @@ var _ = Describe("ETCD Store Adapter", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				_, err = adapter.Get("/menu/breakfast")
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 
 				_, err = adapter.Get("/menu")
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 		})
 
@@ -403,10 +419,10 @@ var _ = Describe("ETCD Store Adapter", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				_, err = adapter.Get(nodeFoo.Key)
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 
 				_, err = adapter.Get(nodeBar.Key)
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 
 			Context("but the comparison fails for one node", func() {
@@ -414,15 +430,28 @@ var _ = Describe("ETCD Store Adapter", func() {
 					nodeFoo.Value = []byte("some mismatched foo value")
 				})
 
+				It("reports the outcome for each node", func() {
+					err := adapter.CompareAndDelete(nodeFoo, nodeBar)
+
+					multiErr, ok := err.(*MultiError)
+					Expect(ok).To(BeTrue())
+					Expect(multiErr.Failed()).To(HaveLen(1))
+					Expect(multiErr.Failed()[0].Key).To(Equal(nodeFoo.Key))
+					Expect(multiErr.Failed()[0].Err).To(MatchError(ErrorKeyComparisonFailed))
+					Expect(multiErr.Succeeded()).To(HaveLen(1))
+					Expect(multiErr.Succeeded()[0].Key).To(Equal(nodeBar.Key))
+					Expect(multiErr.Succeeded()[0].Index).NotTo(BeZero())
+				})
+
 				It("returns an error", func() {
 					err := adapter.CompareAndDelete(nodeFoo, nodeBar)
-					Expect(err).To(Equal(ErrorKeyComparisonFailed))
+					Expect(err).To(MatchError(ErrorKeyComparisonFailed))
 
 					_, err = adapter.Get(nodeFoo.Key)
 					Expect(err).NotTo(HaveOccurred())
 
 					_, err = adapter.Get(nodeBar.Key)
-					Expect(err).To(Equal(ErrorKeyNotFound))
+					Expect(err).To(MatchError(ErrorKeyNotFound))
 				})
 			})
 		})
@@ -430,7 +459,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 		Context("when a node does not exist at the key", func() {
 			It("returns an error", func() {
 				err := adapter.CompareAndDelete(nodeFoo)
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 		})
 
@@ -442,7 +471,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 				parentNode := StoreNode{Key: "/dir", Value: []byte("some value")}
 
 				err = adapter.CompareAndDelete(parentNode)
-				Expect(err).To(Equal(ErrorNodeIsDirectory))
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
 			})
 		})
 	})
@@ -479,10 +508,10 @@ var _ = Describe("ETCD Store Adapter", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				_, err = adapter.Get(nodeFoo.Key)
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 
 				_, err = adapter.Get(nodeBar.Key)
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 
 			Context("but the comparison fails for one node", func() {
@@ -491,13 +520,13 @@ var _ = Describe("ETCD Store Adapter", func() {
 					Expect(err).NotTo(HaveOccurred())
 
 					err = adapter.CompareAndDeleteByIndex(etcdNodeFoo, etcdNodeBar)
-					Expect(err).To(Equal(ErrorKeyComparisonFailed))
+					Expect(err).To(MatchError(ErrorKeyComparisonFailed))
 
 					_, err = adapter.Get(nodeFoo.Key)
 					Expect(err).NotTo(HaveOccurred())
 
 					_, err = adapter.Get(nodeBar.Key)
-					Expect(err).To(Equal(ErrorKeyNotFound))
+					Expect(err).To(MatchError(ErrorKeyNotFound))
 				})
 			})
 		})
@@ -509,7 +538,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 
 			It("returns an error", func() {
 				err := adapter.CompareAndDeleteByIndex(nodeFoo)
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 		})
 
@@ -522,7 +551,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				err = adapter.CompareAndDeleteByIndex(parentNode)
-				Expect(err).To(Equal(ErrorNodeIsDirectory))
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
 			})
 		})
 	})
@@ -539,7 +568,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 			Eventually(func() interface{} {
 				_, err = adapter.Get("/menu/breakfast")
 				return err
-			}, 2, 0.01).Should(Equal(ErrorKeyNotFound)) // as of etcd v0.2rc1, etcd seems to take an extra 0.5 seconds to expire its TTLs
+			}, 2, 0.01).Should(MatchError(ErrorKeyNotFound)) // as of etcd v0.2rc1, etcd seems to take an extra 0.5 seconds to expire its TTLs
 		})
 	})
 
@@ -579,7 +608,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 				uniqueStoreNodeForThisTest.TTL = 0
 
 				nodeStatus, releaseLock, err := adapter.MaintainNode(uniqueStoreNodeForThisTest)
-				Expect(err).To(Equal(ErrorInvalidTTL))
+				Expect(err).To(MatchError(ErrorInvalidTTL))
 				Expect(nodeStatus).To(BeNil())
 				Expect(releaseLock).To(BeNil())
 			})
@@ -696,6 +725,198 @@ var _ = Describe("ETCD Store Adapter", func() {
 			})
 		})
 
+		Context("with a fencing token", func() {
+			It("reports the node's index on acquisition, and keeps it while the node is held", func() {
+				status, release, err := adapter.MaintainNodeWithToken(uniqueStoreNodeForThisTest)
+				Expect(err).NotTo(HaveOccurred())
+
+				var acquired NodeStatus
+				Eventually(status, 2.0).Should(Receive(&acquired))
+				Expect(acquired.Owned).To(BeTrue())
+
+				node, err := adapter.Get(uniqueStoreNodeForThisTest.Key)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(acquired.Token).To(BeNumerically(">", 0))
+				Expect(acquired.Token).To(BeNumerically("<=", node.Index))
+
+				var refreshed NodeStatus
+				Eventually(status, 4.0).Should(Receive(&refreshed))
+				Expect(refreshed).To(Equal(acquired))
+
+				releaseMaintainedNode(release)
+			})
+
+			It("reports a larger token each time the node is acquired", func() {
+				status, release, err := adapter.MaintainNodeWithToken(uniqueStoreNodeForThisTest)
+				Expect(err).NotTo(HaveOccurred())
+
+				var first NodeStatus
+				Eventually(status, 2.0).Should(Receive(&first))
+				releaseMaintainedNode(release)
+
+				status, release, err = adapter.MaintainNodeWithToken(uniqueStoreNodeForThisTest)
+				Expect(err).NotTo(HaveOccurred())
+
+				var second NodeStatus
+				Eventually(status, 2.0).Should(Receive(&second))
+				Expect(second.Owned).To(BeTrue())
+				Expect(second.Token).To(BeNumerically(">", first.Token))
+
+				releaseMaintainedNode(release)
+			})
+
+			It("fences off writes made with an earlier token", func() {
+				status, release, err := adapter.MaintainNodeWithToken(uniqueStoreNodeForThisTest)
+				Expect(err).NotTo(HaveOccurred())
+
+				var first NodeStatus
+				Eventually(status, 2.0).Should(Receive(&first))
+				releaseMaintainedNode(release)
+
+				status, release, err = adapter.MaintainNodeWithToken(uniqueStoreNodeForThisTest)
+				Expect(err).NotTo(HaveOccurred())
+
+				var second NodeStatus
+				Eventually(status, 2.0).Should(Receive(&second))
+				defer releaseMaintainedNode(release)
+
+				fenceKey := "/fences/" + uniqueStoreNodeForThisTest.Key
+				data := StoreNode{Key: "/fenced/" + uniqueStoreNodeForThisTest.Key, Value: []byte("current")}
+
+				Expect(NewFencedWriter(adapter, fenceKey, second.Token).Set(data)).To(Succeed())
+
+				stale := StoreNode{Key: data.Key, Value: []byte("stale")}
+				Expect(NewFencedWriter(adapter, fenceKey, first.Token).Set(stale)).To(Equal(ErrorStaleFencingToken))
+
+				value, err := adapter.Get(data.Key)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value.Value).To(Equal([]byte("current")))
+			})
+		})
+
+		Context("with options", func() {
+			var fakeClock *fakeclock.FakeClock
+
+			BeforeEach(func() {
+				fakeClock = fakeclock.NewFakeClock(time.Now())
+			})
+
+			It("refreshes the node on the clock, reporting every StatusEvery refreshes", func() {
+				status, release, err := adapter.MaintainNodeWithOptions(uniqueStoreNodeForThisTest, MaintainOptions{
+					RefreshInterval: 500 * time.Millisecond,
+					StatusEvery:     2,
+					Clock:           fakeClock,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				defer releaseMaintainedNode(release)
+
+				var acquired NodeStatus
+				Eventually(status, 2.0).Should(Receive(&acquired))
+				Expect(acquired.Owned).To(BeTrue())
+
+				fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+				Consistently(status).ShouldNot(Receive())
+
+				fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+				Eventually(status).Should(Receive(Equal(acquired)))
+			})
+
+			It("returns ErrorInvalidTTL if the refresh interval is not shorter than the TTL", func() {
+				status, release, err := adapter.MaintainNodeWithOptions(uniqueStoreNodeForThisTest, MaintainOptions{
+					RefreshInterval: 2 * time.Second,
+				})
+				Expect(err).To(MatchError(ErrorInvalidTTL))
+				Expect(status).To(BeNil())
+				Expect(release).To(BeNil())
+			})
+
+			Context("when someone else takes the node", func() {
+				It("reports it lost, and keeps trying for it without counting a failure or touching the other holder's node", func() {
+					retryPolicy := new(fakes.FakeRetryPolicy)
+					retryPolicy.DelayForReturns(0, false)
+
+					status, release, err := adapter.MaintainNodeWithOptions(uniqueStoreNodeForThisTest, MaintainOptions{
+						RefreshInterval: 500 * time.Millisecond,
+						RetryPolicy:     retryPolicy,
+						Clock:           fakeClock,
+					})
+					Expect(err).NotTo(HaveOccurred())
+					defer releaseMaintainedNode(release)
+
+					var acquired NodeStatus
+					Eventually(status, 2.0).Should(Receive(&acquired))
+					Expect(acquired.Owned).To(BeTrue())
+
+					taken := uniqueStoreNodeForThisTest
+					taken.Value = []byte("someone else")
+					Expect(adapter.SetMulti([]StoreNode{taken})).To(Succeed())
+
+					fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+					Eventually(status).Should(Receive(Equal(NodeStatus{Owned: false})))
+
+					fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+					Consistently(status).ShouldNot(Receive())
+					Expect(retryPolicy.DelayForCallCount()).To(BeZero())
+
+					node, err := adapter.Get(taken.Key)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(node.Value).To(Equal(taken.Value))
+
+					Expect(adapter.Delete(taken.Key)).To(Succeed())
+					fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+					Eventually(status).Should(Receive(&acquired))
+					Expect(acquired.Owned).To(BeTrue())
+				})
+			})
+
+			Context("when the store is not available", func() {
+				var retryPolicy *fakes.FakeRetryPolicy
+
+				BeforeEach(func() {
+					retryPolicy = new(fakes.FakeRetryPolicy)
+					etcdRunner.Stop()
+				})
+
+				AfterEach(func() {
+					etcdRunner.Start()
+				})
+
+				It("waits as long as the retry policy says", func() {
+					retryPolicy.DelayForReturns(time.Minute, true)
+
+					status, release, err := adapter.MaintainNodeWithOptions(uniqueStoreNodeForThisTest, MaintainOptions{
+						RetryPolicy: retryPolicy,
+						Clock:       fakeClock,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Eventually(retryPolicy.DelayForCallCount, 5).Should(Equal(1))
+					Expect(retryPolicy.DelayForArgsForCall(0)).To(Equal(uint(1)))
+
+					fakeClock.WaitForWatcherAndIncrement(time.Minute)
+					Eventually(retryPolicy.DelayForCallCount, 5).Should(Equal(2))
+					Expect(retryPolicy.DelayForArgsForCall(1)).To(Equal(uint(2)))
+					Consistently(status).ShouldNot(Receive())
+
+					releaseMaintainedNode(release)
+				})
+
+				It("closes the status channel when the retry policy gives up", func() {
+					retryPolicy.DelayForReturns(0, false)
+
+					status, release, err := adapter.MaintainNodeWithOptions(uniqueStoreNodeForThisTest, MaintainOptions{
+						RetryPolicy: retryPolicy,
+						Clock:       fakeClock,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Eventually(status, 5).Should(BeClosed())
+
+					releaseMaintainedNode(release)
+				})
+			})
+		})
+
 		Context("when releasing the lock", func() {
 			It("makes it available for others trying to acquire it", func() {
 				releaseLock1 := waitTilLocked(uniqueStoreNodeForThisTest)
@@ -762,7 +983,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 		Context("when a node already exists at the key", func() {
 			It("returns an error", func() {
 				err := adapter.Create(node)
-				Expect(err).To(Equal(ErrorKeyExists))
+				Expect(err).To(MatchError(ErrorKeyExists))
 			})
 		})
 
@@ -772,7 +993,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				err = adapter.Create(StoreNode{Key: "/dir", Value: []byte("some value")})
-				Expect(err).To(Equal(ErrorKeyExists))
+				Expect(err).To(MatchError(ErrorKeyExists))
 			})
 		})
 	})
@@ -801,7 +1022,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 		Context("when a node does not exist at the key", func() {
 			It("returns an error", func() {
 				err := adapter.Update(node)
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 		})
 
@@ -811,7 +1032,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				err = adapter.Update(StoreNode{Key: "/dir", Value: []byte("some value")})
-				Expect(err).To(Equal(ErrorNodeIsDirectory))
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
 			})
 		})
 	})
@@ -850,7 +1071,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 				newNode.Value = []byte("some new value")
 
 				err = adapter.CompareAndSwap(wrongNode, newNode)
-				Expect(err).To(Equal(ErrorKeyComparisonFailed))
+				Expect(err).To(MatchError(ErrorKeyComparisonFailed))
 
 				retrievedNode, err := adapter.Get("/foo")
 				Expect(err).NotTo(HaveOccurred())
@@ -861,7 +1082,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 		Context("when a node does not exist at the key", func() {
 			It("returns an error", func() {
 				err := adapter.CompareAndSwap(node, node)
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 		})
 
@@ -873,7 +1094,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 				newNode := StoreNode{Key: "/dir", Value: []byte("some value")}
 
 				err = adapter.CompareAndSwap(newNode, newNode)
-				Expect(err).To(Equal(ErrorNodeIsDirectory))
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
 			})
 		})
 	})
@@ -912,7 +1133,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 				newNode.Value = []byte("some new value")
 
 				err = adapter.CompareAndSwapByIndex(4271138, newNode)
-				Expect(err).To(Equal(ErrorKeyComparisonFailed))
+				Expect(err).To(MatchError(ErrorKeyComparisonFailed))
 
 				retrievedNode, err := adapter.Get("/foo")
 				Expect(err).NotTo(HaveOccurred())
@@ -923,7 +1144,7 @@ var _ = Describe("ETCD Store Adapter", func() {
 		Context("when a node does not exist at the key", func() {
 			It("returns an error", func() {
 				err := adapter.CompareAndSwapByIndex(4271338, node)
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 		})
 
@@ -935,7 +1156,83 @@ var _ = Describe("ETCD Store Adapter", func() {
 				newNode := StoreNode{Key: "/dir", Value: []byte("some value")}
 
 				err = adapter.CompareAndSwapByIndex(4271338, newNode)
-				Expect(err).To(Equal(ErrorNodeIsDirectory))
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
+			})
+		})
+	})
+
+	Describe("Txn", func() {
+		BeforeEach(func() {
+			err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when every comparison holds", func() {
+			It("applies all of the operations", func() {
+				breakfast, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.Txn(
+					[]TxnCompare{
+						IndexEquals("/menu/breakfast", breakfast.Index),
+						ValueEquals("/menu/lunch", []byte("burgers")),
+						KeyMissing("/menu/dinner"),
+					},
+					[]TxnOp{
+						Put(StoreNode{Key: "/menu/breakfast", Value: []byte("crepes")}),
+						Put(StoreNode{Key: "/menu/dinner", Value: []byte("steak")}),
+						DeleteKey("/menu/lunch"),
+					},
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(value.Value)).To(Equal("crepes"))
+
+				value, err = adapter.Get("/menu/dinner")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(value.Value)).To(Equal("steak"))
+
+				_, err = adapter.Get("/menu/lunch")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+
+		Context("when a comparison fails", func() {
+			It("returns the comparison's error and applies nothing", func() {
+				err := adapter.Txn(
+					[]TxnCompare{ValueEquals("/menu/breakfast", []byte("crepes"))},
+					[]TxnOp{DeleteKey("/menu/lunch")},
+				)
+				Expect(err).To(MatchError(ErrorKeyComparisonFailed))
+
+				value, err := adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(lunchNode))
+			})
+		})
+
+		Context("when an operation fails part way through", func() {
+			It("rolls back the operations already applied", func() {
+				err := adapter.Txn(nil, []TxnOp{
+					Put(StoreNode{Key: "/menu/breakfast", Value: []byte("crepes")}),
+					Put(StoreNode{Key: "/menu/dinner", Value: []byte("steak")}),
+					DeleteKey("/menu/lunch"),
+					DeleteKey("/menu/elevenses"),
+				})
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(breakfastNode))
+
+				value, err = adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(lunchNode))
+
+				_, err = adapter.Get("/menu/dinner")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 		})
 	})
@@ -1244,6 +1541,85 @@ var _ = Describe("ETCD Store Adapter", func() {
 				close(done)
 			}, 5)
 		})
+
+		It("sends the index of each event", func(done Done) {
+			events, _, _ := adapter.Watch("/foo")
+
+			err := adapter.Create(StoreNode{Key: "/foo/a", Value: []byte("new value")})
+			Expect(err).ToNot(HaveOccurred())
+
+			node, err := adapter.Get("/foo/a")
+			Expect(err).ToNot(HaveOccurred())
+
+			event := <-events
+			Expect(event.Index).To(Equal(node.Index))
+
+			close(done)
+		}, 5.0)
+	})
+
+	Describe("Watching from an index", func() {
+		var firstIndex uint64
+
+		BeforeEach(func() {
+			for _, value := range []string{"1", "2", "3"} {
+				err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte(value)}})
+				Expect(err).ToNot(HaveOccurred())
+
+				if value == "1" {
+					node, err := adapter.Get("/foo/a")
+					Expect(err).ToNot(HaveOccurred())
+					firstIndex = node.Index
+				}
+			}
+		})
+
+		It("sends every event after the index, in order", func(done Done) {
+			events, stop, _ := adapter.WatchFrom("/foo", firstIndex)
+
+			event := <-events
+			Expect(event.Type).To(Equal(UpdateEvent))
+			Expect(string(event.Node.Value)).To(Equal("2"))
+			Expect(string(event.PrevNode.Value)).To(Equal("1"))
+			Expect(event.Index).To(BeNumerically(">", firstIndex))
+
+			event = <-events
+			Expect(string(event.Node.Value)).To(Equal("3"))
+
+			err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte("4")}})
+			Expect(err).ToNot(HaveOccurred())
+
+			event = <-events
+			Expect(string(event.Node.Value)).To(Equal("4"))
+
+			stop <- true
+
+			close(done)
+		}, 5.0)
+
+		Context("when etcd has cleared the events after the index", func() {
+			BeforeEach(func() {
+				for i := range make([]bool, 1003) {
+					err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte(fmt.Sprintf("%d", i))}})
+					Expect(err).ToNot(HaveOccurred())
+				}
+			})
+
+			It("reports ErrorWatchIndexCleared and stops", func() {
+				events, _, errChan := adapter.WatchFrom("/foo", firstIndex)
+
+				var err error
+				Eventually(errChan).Should(Receive(&err))
+				Expect(err).To(MatchError(ErrorWatchIndexCleared))
+
+				storeErr, ok := err.(*Error)
+				Expect(ok).To(BeTrue())
+				Expect(storeErr.Code).To(Equal(401))
+				Expect(storeErr.Index).To(BeNumerically(">", firstIndex+1000))
+
+				Eventually(events).Should(BeClosed())
+			})
+		})
 	})
 
 	Describe("UpdateDirTTL", func() {
@@ -1265,14 +1641,14 @@ var _ = Describe("ETCD Store Adapter", func() {
 				time.Sleep(2 * time.Second)
 
 				_, err = adapter.Get("/menu/breakfast")
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 		})
 
 		Context("When the directory does not exist", func() {
 			It("should return a ErrorKeyNotFound", func() {
 				err := adapter.UpdateDirTTL("/non-existent-key", 1)
-				Expect(err).To(Equal(ErrorKeyNotFound))
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 		})
 
@@ -1282,7 +1658,103 @@ var _ = Describe("ETCD Store Adapter", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				err = adapter.UpdateDirTTL("/menu/breakfast", 1)
-				Expect(err).To(Equal(ErrorNodeIsNotDirectory))
+				Expect(err).To(MatchError(ErrorNodeIsNotDirectory))
+			})
+		})
+	})
+
+	Describe("with a context", func() {
+		var contextAdapter ContextStoreAdapter
+
+		BeforeEach(func() {
+			contextAdapter = NewContextStoreAdapter(adapter)
+			Expect(contextAdapter).To(BeIdenticalTo(adapter))
+		})
+
+		Context("when the context is not done", func() {
+			It("performs the operation", func() {
+				err := contextAdapter.SetMultiContext(context.Background(), []StoreNode{breakfastNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := contextAdapter.GetContext(context.Background(), "/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(breakfastNode))
+			})
+		})
+
+		Context("when the context is already done", func() {
+			var ctx context.Context
+
+			BeforeEach(func() {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(context.Background())
+				cancel()
+			})
+
+			It("returns the context's error without writing", func() {
+				err := contextAdapter.CreateContext(ctx, breakfastNode)
+				Expect(err).To(Equal(context.Canceled))
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("refuses to maintain a node", func() {
+				nodeStatus, releaseLock, err := contextAdapter.MaintainNodeContext(ctx, StoreNode{Key: "/lock", TTL: 2})
+				Expect(err).To(Equal(context.Canceled))
+				Expect(nodeStatus).To(BeNil())
+				Expect(releaseLock).To(BeNil())
+			})
+		})
+
+		Context("when the deadline passes before the store responds", func() {
+			BeforeEach(func() {
+				etcdRunner.Stop()
+			})
+
+			AfterEach(func() {
+				etcdRunner.Start()
+			})
+
+			It("returns the context's error", func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+
+				_, err := contextAdapter.GetContext(ctx, "/menu/breakfast")
+				Expect(err).To(Equal(context.DeadlineExceeded))
+			})
+		})
+
+		Context("when the context is done while watching", func() {
+			It("stops watching", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				events, _, errors := contextAdapter.WatchContext(ctx, "/foo")
+				cancel()
+
+				Eventually(events, 2).Should(BeClosed())
+				Eventually(errors, 2).Should(BeClosed())
+			})
+		})
+
+		Context("when the context is done while maintaining a node", func() {
+			It("releases the node and closes the status channel", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				storeNode := StoreNode{Key: fmt.Sprintf("analyzer-%d", counter), TTL: 2}
+				counter++
+
+				nodeStatus, _, err := contextAdapter.MaintainNodeContext(ctx, storeNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				reporter := test_helpers.NewStatusReporter(nodeStatus)
+				Eventually(reporter.Locked, 2).Should(BeTrue())
+
+				cancel()
+
+				Eventually(reporter.Reporting).Should(BeFalse())
+
+				_, err = adapter.Get(storeNode.Key)
+				Expect(err).To(MatchError(ErrorKeyNotFound))
 			})
 		})
 	})
@@ -0,0 +1,22 @@
+package etcdv3storeadapter_test
+
+import (
+	"code.cloudfoundry.org/workpool"
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/etcdstoreadapter"
+	. "github.com/cloudfoundry/storeadapter/etcdv3storeadapter"
+	"github.com/cloudfoundry/storeadapter/storeadaptertest"
+	. "github.com/onsi/gomega"
+)
+
+var _ = storeadaptertest.DescribeConformance("ETCD v3 Store Adapter conformance", storeadaptertest.Backend{
+	NewStoreAdapter: func() storeadapter.StoreAdapter {
+		workPool, err := workpool.NewWorkPool(10)
+		Expect(err).NotTo(HaveOccurred())
+
+		adapter, err := New(&etcdstoreadapter.ETCDOptions{ClusterUrls: []string{etcdURL}}, workPool)
+		Expect(err).NotTo(HaveOccurred())
+		return adapter
+	},
+	EmptyDirectoriesRemain: false,
+})
@@ -0,0 +1,726 @@
+package etcdv3storeadapter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/workpool"
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/etcdstoreadapter"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errRootReadOnly = errors.New("the root directory is read only")
+
+// ETCDv3StoreAdapter is a StoreAdapter on the etcd v3 API.
+//
+// The v3 key space is flat, so the v2 tree is laid over it: a leaf at "/a/b"
+// is stored at the v3 key "/a/b", and a directory is not stored at all. It
+// exists for as long as some key lies under it, and disappears with its last
+// key. A node's Index is the revision at which it was last modified, and its
+// TTL is the time left on the lease it was written with.
+type ETCDv3StoreAdapter struct {
+	client   *clientv3.Client
+	workPool *workpool.WorkPool
+
+	// ctx is cancelled by Disconnect, stopping watches and maintained nodes.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func New(options *etcdstoreadapter.ETCDOptions, workPool *workpool.WorkPool) (*ETCDv3StoreAdapter, error) {
+	config := clientv3.Config{
+		Endpoints:   options.ClusterUrls,
+		DialTimeout: 5 * time.Second,
+	}
+
+	if options.IsSSL {
+		tlsConfig, err := newTLSConfig(options.CertFile, options.KeyFile, options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.TLS = tlsConfig
+	}
+
+	client, err := clientv3.New(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWithClient(client, workPool), nil
+}
+
+// NewWithClient returns an adapter using client, which Disconnect closes.
+func NewWithClient(client *clientv3.Client, workPool *workpool.WorkPool) *ETCDv3StoreAdapter {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &ETCDv3StoreAdapter{
+		client:   client,
+		workPool: workPool,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func newTLSConfig(certFile, keyFile, caCertFile string) (*tls.Config, error) {
+	tlsCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates:       []tls.Certificate{tlsCert},
+		InsecureSkipVerify: false,
+	}
+
+	if caCertFile != "" {
+		caCert, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", caCertFile)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// The client waits for a connection for as long as a request's context
+// allows, so the methods without a context are given requestTimeout, and
+// report running out of time as ErrorTimeout.
+const requestTimeout = 10 * time.Second
+
+func (adapter *ETCDv3StoreAdapter) withTimeout(op string, key string, request func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(adapter.ctx, requestTimeout)
+	defer cancel()
+
+	err := request(ctx)
+	if err == context.DeadlineExceeded {
+		return &storeadapter.Error{Op: op, Key: cleanKey(key), Err: storeadapter.ErrorTimeout, Cause: err}
+	}
+
+	return err
+}
+
+func (adapter *ETCDv3StoreAdapter) Connect() error {
+	return adapter.withTimeout("Connect", "", func(ctx context.Context) error {
+		return adapter.ConnectContext(ctx)
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) ConnectContext(ctx context.Context) error {
+	_, err := adapter.client.Get(ctx, "/", clientv3.WithCountOnly())
+	return adapter.convertError("Connect", "", err)
+}
+
+func (adapter *ETCDv3StoreAdapter) Disconnect() error {
+	adapter.cancel()
+	adapter.workPool.Stop()
+	adapter.client.Close()
+
+	return nil
+}
+
+// cleanKey returns key as it is stored: rooted, without a trailing slash, and
+// empty for the root itself.
+func cleanKey(key string) string {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" {
+		return ""
+	}
+
+	return cleaned
+}
+
+// dirPrefix returns the prefix of every key under the directory at key.
+func dirPrefix(key string) string {
+	return cleanKey(key) + "/"
+}
+
+// ancestors returns the directories above key, outermost first.
+func ancestors(key string) []string {
+	dirs := []string{}
+	for i := 1; i < len(key); i++ {
+		if key[i] == '/' {
+			dirs = append(dirs, key[:i])
+		}
+	}
+
+	return dirs
+}
+
+func isUnder(key, dir string) bool {
+	return key == dir || strings.HasPrefix(key, dirPrefix(dir))
+}
+
+// convertError wraps err in a *storeadapter.Error for the given operation and
+// key, unwrapping to the matching storeadapter sentinel. Timeouts, lost
+// leaders and unavailable or overloaded servers are marked Temporary. Context
+// errors are returned as is.
+func (adapter *ETCDv3StoreAdapter) convertError(op string, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch err.(type) {
+	case *storeadapter.Error, *storeadapter.MultiError:
+		return err
+	}
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+
+	converted := &storeadapter.Error{Op: op, Key: key, Err: err, Cause: err}
+
+	code := status.Code(err)
+	if coded, ok := err.(interface{ Code() codes.Code }); ok {
+		code = coded.Code()
+	}
+	if code != codes.Unknown {
+		converted.Code = int(code)
+	}
+
+	switch {
+	case errors.Is(err, rpctypes.ErrTimeout),
+		errors.Is(err, rpctypes.ErrTimeoutDueToLeaderFail),
+		errors.Is(err, rpctypes.ErrTimeoutDueToConnectionLost),
+		errors.Is(err, rpctypes.ErrTimeoutWaitAppliedIndex),
+		errors.Is(err, rpctypes.ErrNoLeader),
+		code == codes.Unavailable,
+		code == codes.DeadlineExceeded:
+		converted.Err = storeadapter.ErrorTimeout
+		converted.Temporary = true
+	case code == codes.Internal, code == codes.ResourceExhausted:
+		converted.Temporary = true
+	case errors.Is(err, rpctypes.ErrCompacted):
+		converted.Err = storeadapter.ErrorWatchIndexCleared
+	}
+
+	return converted
+}
+
+// keyError returns a *storeadapter.Error for one of the storeadapter
+// sentinels, reported at the given revision.
+func keyError(op string, key string, revision int64, err error) error {
+	return &storeadapter.Error{Op: op, Key: key, Index: uint64(revision), Err: err, Cause: err}
+}
+
+// fanOut runs one request per key on the work pool. If any of them fail, it
+// returns a *storeadapter.MultiError holding the outcome for every key.
+func (adapter *ETCDv3StoreAdapter) fanOut(ctx context.Context, op string, keys []string, request func(i int) (uint64, error)) error {
+	results := make([]storeadapter.KeyResult, len(keys))
+	done := make(chan bool, len(keys))
+
+	for i, key := range keys {
+		i := i
+		results[i].Key = key
+
+		adapter.workPool.Submit(func() {
+			defer func() {
+				done <- true
+			}()
+
+			if err := ctx.Err(); err != nil {
+				results[i].Err = err
+				return
+			}
+
+			index, err := request(i)
+			if err != nil {
+				results[i].Err = adapter.convertError(op, key, err)
+			} else {
+				results[i].Index = index
+			}
+		})
+	}
+
+	numReceived := 0
+	for numReceived < len(keys) {
+		select {
+		case <-done:
+			numReceived++
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return storeadapter.NewMultiError(results)
+}
+
+func nodeKeys(nodes []storeadapter.StoreNode) []string {
+	keys := make([]string, len(nodes))
+	for i, node := range nodes {
+		keys[i] = node.Key
+	}
+
+	return keys
+}
+
+type nodeRead struct {
+	leaf     *mvccpb.KeyValue
+	children []*mvccpb.KeyValue
+	count    int64
+	revision int64
+}
+
+// read reads the leaf at key and the keys under it, in one request.
+func (adapter *ETCDv3StoreAdapter) read(ctx context.Context, key string, childOptions ...clientv3.OpOption) (nodeRead, error) {
+	ops := []clientv3.Op{
+		clientv3.OpGet(dirPrefix(key), append([]clientv3.OpOption{clientv3.WithPrefix()}, childOptions...)...),
+	}
+	if key != "" {
+		ops = append(ops, clientv3.OpGet(key))
+	}
+
+	response, err := adapter.client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return nodeRead{}, err
+	}
+
+	children := response.Responses[0].GetResponseRange()
+	read := nodeRead{
+		children: children.Kvs,
+		count:    children.Count,
+		revision: response.Header.Revision,
+	}
+
+	if key != "" {
+		if leaves := response.Responses[1].GetResponseRange().Kvs; len(leaves) > 0 {
+			read.leaf = leaves[0]
+		}
+	}
+
+	return read, nil
+}
+
+// leaseTTLs looks up the time left on leases, asking etcd about each lease
+// only once however many keys share it. One is used for a single listing or
+// watch response, so the times it reports are as fresh as the keys.
+type leaseTTLs struct {
+	ctx    context.Context
+	client *clientv3.Client
+	ttls   map[int64]int64
+}
+
+func (adapter *ETCDv3StoreAdapter) leaseTTLs(ctx context.Context) *leaseTTLs {
+	return &leaseTTLs{
+		ctx:    ctx,
+		client: adapter.client,
+		ttls:   map[int64]int64{},
+	}
+}
+
+// lookup returns the seconds left on a lease, -1 if it has expired, or 0 if
+// it could not be looked up.
+func (leases *leaseTTLs) lookup(lease int64) int64 {
+	ttl, ok := leases.ttls[lease]
+	if !ok {
+		response, err := leases.client.TimeToLive(leases.ctx, clientv3.LeaseID(lease))
+		if err == nil {
+			ttl = response.TTL
+		}
+		leases.ttls[lease] = ttl
+	}
+
+	return ttl
+}
+
+// ttl returns the seconds left on a lease, or 0 if there is no lease.
+func (leases *leaseTTLs) ttl(lease int64) uint64 {
+	if lease == 0 {
+		return 0
+	}
+
+	ttl := leases.lookup(lease)
+	if ttl <= 0 {
+		return 0
+	}
+
+	return uint64(ttl)
+}
+
+func (leases *leaseTTLs) expired(lease int64) bool {
+	return lease != 0 && leases.lookup(lease) == -1
+}
+
+func makeStoreNode(leases *leaseTTLs, kv *mvccpb.KeyValue) *storeadapter.StoreNode {
+	if kv == nil {
+		return nil
+	}
+
+	return &storeadapter.StoreNode{
+		Key:   string(kv.Key),
+		Value: kv.Value,
+		TTL:   leases.ttl(kv.Lease),
+		Index: uint64(kv.ModRevision),
+	}
+}
+
+// makeDir builds the directory at key from the keys under it, which are
+// sorted. Directories have no revision of their own, so each is given the
+// revision the listing was read at.
+func makeDir(leases *leaseTTLs, key string, kvs []*mvccpb.KeyValue, revision int64) storeadapter.StoreNode {
+	dir := storeadapter.StoreNode{
+		Key:        key,
+		Dir:        true,
+		Value:      []byte{},
+		ChildNodes: []storeadapter.StoreNode{},
+		Index:      uint64(revision),
+	}
+
+	prefix := key + "/"
+	for i := 0; i < len(kvs); {
+		rest := strings.TrimPrefix(string(kvs[i].Key), prefix)
+		slash := strings.Index(rest, "/")
+		if slash < 0 {
+			dir.ChildNodes = append(dir.ChildNodes, *makeStoreNode(leases, kvs[i]))
+			i++
+			continue
+		}
+
+		childKey := prefix + rest[:slash]
+		end := i
+		for end < len(kvs) && strings.HasPrefix(string(kvs[end].Key), childKey+"/") {
+			end++
+		}
+
+		dir.ChildNodes = append(dir.ChildNodes, makeDir(leases, childKey, kvs[i:end], revision))
+		i = end
+	}
+
+	return dir
+}
+
+func (adapter *ETCDv3StoreAdapter) Get(key string) (storeadapter.StoreNode, error) {
+	var node storeadapter.StoreNode
+	err := adapter.withTimeout("Get", key, func(ctx context.Context) error {
+		var err error
+		node, err = adapter.GetContext(ctx, key)
+		return err
+	})
+	return node, err
+}
+
+func (adapter *ETCDv3StoreAdapter) GetContext(ctx context.Context, key string) (storeadapter.StoreNode, error) {
+	key = cleanKey(key)
+
+	read, err := adapter.read(ctx, key, clientv3.WithCountOnly())
+	if err != nil {
+		return storeadapter.StoreNode{}, adapter.convertError("Get", key, err)
+	}
+
+	if read.leaf != nil {
+		return *makeStoreNode(adapter.leaseTTLs(ctx), read.leaf), nil
+	}
+
+	if key == "" || read.count > 0 {
+		return storeadapter.StoreNode{}, keyError("Get", key, read.revision, storeadapter.ErrorNodeIsDirectory)
+	}
+
+	return storeadapter.StoreNode{}, keyError("Get", key, read.revision, storeadapter.ErrorKeyNotFound)
+}
+
+func (adapter *ETCDv3StoreAdapter) ListRecursively(key string) (storeadapter.StoreNode, error) {
+	var node storeadapter.StoreNode
+	err := adapter.withTimeout("ListRecursively", key, func(ctx context.Context) error {
+		var err error
+		node, err = adapter.ListRecursivelyContext(ctx, key)
+		return err
+	})
+	return node, err
+}
+
+func (adapter *ETCDv3StoreAdapter) ListRecursivelyContext(ctx context.Context, key string) (storeadapter.StoreNode, error) {
+	key = cleanKey(key)
+
+	read, err := adapter.read(ctx, key)
+	if err != nil {
+		return storeadapter.StoreNode{}, adapter.convertError("ListRecursively", key, err)
+	}
+
+	if read.leaf != nil {
+		return storeadapter.StoreNode{}, keyError("ListRecursively", key, read.revision, storeadapter.ErrorNodeIsNotDirectory)
+	}
+
+	if key != "" && len(read.children) == 0 {
+		return storeadapter.StoreNode{}, keyError("ListRecursively", key, read.revision, storeadapter.ErrorKeyNotFound)
+	}
+
+	return makeDir(adapter.leaseTTLs(ctx), key, read.children, read.revision), nil
+}
+
+func (adapter *ETCDv3StoreAdapter) Create(node storeadapter.StoreNode) error {
+	return adapter.withTimeout("Create", node.Key, func(ctx context.Context) error {
+		return adapter.CreateContext(ctx, node)
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) CreateContext(ctx context.Context, node storeadapter.StoreNode) error {
+	_, err := adapter.txn(ctx, "Create", node.Key,
+		[]storeadapter.TxnCompare{storeadapter.KeyMissing(node.Key)},
+		[]storeadapter.TxnOp{storeadapter.Put(node)},
+	)
+	return err
+}
+
+func (adapter *ETCDv3StoreAdapter) Update(node storeadapter.StoreNode) error {
+	return adapter.withTimeout("Update", node.Key, func(ctx context.Context) error {
+		return adapter.UpdateContext(ctx, node)
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) UpdateContext(ctx context.Context, node storeadapter.StoreNode) error {
+	_, err := adapter.txn(ctx, "Update", node.Key,
+		[]storeadapter.TxnCompare{storeadapter.KeyExists(node.Key)},
+		[]storeadapter.TxnOp{storeadapter.Put(node)},
+	)
+	return err
+}
+
+func (adapter *ETCDv3StoreAdapter) CompareAndSwap(oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) error {
+	return adapter.withTimeout("CompareAndSwap", newNode.Key, func(ctx context.Context) error {
+		return adapter.CompareAndSwapContext(ctx, oldNode, newNode)
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) CompareAndSwapContext(ctx context.Context, oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) error {
+	_, err := adapter.txn(ctx, "CompareAndSwap", newNode.Key,
+		[]storeadapter.TxnCompare{storeadapter.ValueEquals(newNode.Key, oldNode.Value)},
+		[]storeadapter.TxnOp{storeadapter.Put(newNode)},
+	)
+	return err
+}
+
+func (adapter *ETCDv3StoreAdapter) CompareAndSwapByIndex(oldNodeIndex uint64, newNode storeadapter.StoreNode) error {
+	return adapter.withTimeout("CompareAndSwapByIndex", newNode.Key, func(ctx context.Context) error {
+		return adapter.CompareAndSwapByIndexContext(ctx, oldNodeIndex, newNode)
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) CompareAndSwapByIndexContext(ctx context.Context, oldNodeIndex uint64, newNode storeadapter.StoreNode) error {
+	_, err := adapter.txn(ctx, "CompareAndSwapByIndex", newNode.Key,
+		[]storeadapter.TxnCompare{storeadapter.IndexEquals(newNode.Key, oldNodeIndex)},
+		[]storeadapter.TxnOp{storeadapter.Put(newNode)},
+	)
+	return err
+}
+
+func (adapter *ETCDv3StoreAdapter) SetMulti(nodes []storeadapter.StoreNode) error {
+	return adapter.withTimeout("SetMulti", "", func(ctx context.Context) error {
+		return adapter.SetMultiContext(ctx, nodes)
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) SetMultiContext(ctx context.Context, nodes []storeadapter.StoreNode) error {
+	return adapter.fanOut(ctx, "SetMulti", nodeKeys(nodes), func(i int) (uint64, error) {
+		return adapter.txn(ctx, "SetMulti", nodes[i].Key, nil, []storeadapter.TxnOp{storeadapter.Put(nodes[i])})
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) Delete(keys ...string) error {
+	return adapter.withTimeout("Delete", "", func(ctx context.Context) error {
+		return adapter.DeleteContext(ctx, keys...)
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) DeleteContext(ctx context.Context, keys ...string) error {
+	return adapter.fanOut(ctx, "Delete", keys, func(i int) (uint64, error) {
+		key := cleanKey(keys[i])
+
+		ops := []clientv3.Op{clientv3.OpDelete(dirPrefix(key), clientv3.WithPrefix())}
+		if key != "" {
+			ops = append(ops, clientv3.OpDelete(key))
+		}
+
+		response, err := adapter.client.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			return 0, err
+		}
+
+		deleted := int64(0)
+		for _, op := range response.Responses {
+			deleted += op.GetResponseDeleteRange().Deleted
+		}
+
+		if deleted == 0 {
+			return 0, keyError("Delete", key, response.Header.Revision, storeadapter.ErrorKeyNotFound)
+		}
+
+		return uint64(response.Header.Revision), nil
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) DeleteLeaves(keys ...string) error {
+	return adapter.withTimeout("DeleteLeaves", "", func(ctx context.Context) error {
+		return adapter.DeleteLeavesContext(ctx, keys...)
+	})
+}
+
+// Directories only exist while they have keys under them, so DeleteLeaves
+// only ever deletes leaves; it fails for any directory.
+func (adapter *ETCDv3StoreAdapter) DeleteLeavesContext(ctx context.Context, keys ...string) error {
+	return adapter.fanOut(ctx, "DeleteLeaves", keys, func(i int) (uint64, error) {
+		key := cleanKey(keys[i])
+		if key == "" {
+			return 0, errRootReadOnly
+		}
+
+		response, err := adapter.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(dirPrefix(key)), "=", 0).WithPrefix()).
+			Then(clientv3.OpDelete(key)).
+			Commit()
+		if err != nil {
+			return 0, err
+		}
+
+		if !response.Succeeded {
+			return 0, keyError("DeleteLeaves", key, response.Header.Revision, storeadapter.ErrorDirectoryNotEmpty)
+		}
+
+		if response.Responses[0].GetResponseDeleteRange().Deleted == 0 {
+			return 0, keyError("DeleteLeaves", key, response.Header.Revision, storeadapter.ErrorKeyNotFound)
+		}
+
+		return uint64(response.Header.Revision), nil
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) CompareAndDelete(nodes ...storeadapter.StoreNode) error {
+	return adapter.withTimeout("CompareAndDelete", "", func(ctx context.Context) error {
+		return adapter.CompareAndDeleteContext(ctx, nodes...)
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) CompareAndDeleteContext(ctx context.Context, nodes ...storeadapter.StoreNode) error {
+	return adapter.fanOut(ctx, "CompareAndDelete", nodeKeys(nodes), func(i int) (uint64, error) {
+		return adapter.txn(ctx, "CompareAndDelete", nodes[i].Key,
+			[]storeadapter.TxnCompare{storeadapter.ValueEquals(nodes[i].Key, nodes[i].Value)},
+			[]storeadapter.TxnOp{storeadapter.DeleteKey(nodes[i].Key)},
+		)
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) CompareAndDeleteByIndex(nodes ...storeadapter.StoreNode) error {
+	return adapter.withTimeout("CompareAndDeleteByIndex", "", func(ctx context.Context) error {
+		return adapter.CompareAndDeleteByIndexContext(ctx, nodes...)
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) CompareAndDeleteByIndexContext(ctx context.Context, nodes ...storeadapter.StoreNode) error {
+	return adapter.fanOut(ctx, "CompareAndDeleteByIndex", nodeKeys(nodes), func(i int) (uint64, error) {
+		return adapter.txn(ctx, "CompareAndDeleteByIndex", nodes[i].Key,
+			[]storeadapter.TxnCompare{storeadapter.IndexEquals(nodes[i].Key, nodes[i].Index)},
+			[]storeadapter.TxnOp{storeadapter.DeleteKey(nodes[i].Key)},
+		)
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) Txn(comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	return adapter.withTimeout("Txn", "", func(ctx context.Context) error {
+		return adapter.TxnContext(ctx, comparisons, operations)
+	})
+}
+
+func (adapter *ETCDv3StoreAdapter) TxnContext(ctx context.Context, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	_, err := adapter.txn(ctx, "Txn", "", comparisons, operations)
+	return err
+}
+
+func (adapter *ETCDv3StoreAdapter) UpdateDirTTL(key string, ttl uint64) error {
+	return adapter.withTimeout("UpdateDirTTL", key, func(ctx context.Context) error {
+		return adapter.UpdateDirTTLContext(ctx, key, ttl)
+	})
+}
+
+// A v3 key can only be given a TTL by writing it again with a lease, so
+// UpdateDirTTL rewrites every key under the directory with a new lease, and
+// each of them is reported to watchers as updated. Keys written to the
+// directory afterwards do not share the lease.
+func (adapter *ETCDv3StoreAdapter) UpdateDirTTLContext(ctx context.Context, key string, ttl uint64) error {
+	key = cleanKey(key)
+	if key == "" {
+		return adapter.convertError("UpdateDirTTL", key, errRootReadOnly)
+	}
+
+	var leaseOptions []clientv3.OpOption
+	if ttl > 0 {
+		lease, err := adapter.client.Grant(ctx, int64(ttl))
+		if err != nil {
+			return adapter.convertError("UpdateDirTTL", key, err)
+		}
+
+		leaseOptions = append(leaseOptions, clientv3.WithLease(lease.ID))
+	}
+
+	for {
+		read, err := adapter.read(ctx, key)
+		if err != nil {
+			return adapter.convertError("UpdateDirTTL", key, err)
+		}
+
+		if read.leaf != nil {
+			return keyError("UpdateDirTTL", key, read.revision, storeadapter.ErrorNodeIsNotDirectory)
+		}
+
+		if len(read.children) == 0 {
+			return keyError("UpdateDirTTL", key, read.revision, storeadapter.ErrorKeyNotFound)
+		}
+
+		updated, err := adapter.rewriteKeys(ctx, read.children, leaseOptions)
+		if err != nil {
+			return adapter.convertError("UpdateDirTTL", key, err)
+		}
+
+		if updated {
+			return nil
+		}
+	}
+}
+
+// etcd limits the number of operations in a transaction, 128 by default.
+const maxRewritesPerTxn = 64
+
+// rewriteKeys puts every key again with the given options, as long as none of
+// them has changed since it was read. It returns false if one has.
+func (adapter *ETCDv3StoreAdapter) rewriteKeys(ctx context.Context, kvs []*mvccpb.KeyValue, options []clientv3.OpOption) (bool, error) {
+	for start := 0; start < len(kvs); start += maxRewritesPerTxn {
+		end := start + maxRewritesPerTxn
+		if end > len(kvs) {
+			end = len(kvs)
+		}
+
+		guards := []clientv3.Cmp{}
+		puts := []clientv3.Op{}
+		for _, kv := range kvs[start:end] {
+			guards = append(guards, clientv3.Compare(clientv3.ModRevision(string(kv.Key)), "=", kv.ModRevision))
+			puts = append(puts, clientv3.OpPut(string(kv.Key), string(kv.Value), options...))
+		}
+
+		response, err := adapter.client.Txn(ctx).If(guards...).Then(puts...).Commit()
+		if err != nil {
+			return false, err
+		}
+
+		if !response.Succeeded {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
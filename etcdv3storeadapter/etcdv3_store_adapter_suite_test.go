@@ -0,0 +1,115 @@
+package etcdv3storeadapter_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/config"
+	. "github.com/onsi/gomega"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+var (
+	etcdServer  *embed.Etcd
+	etcdDataDir string
+	etcdURL     string
+	etcdClient  *clientv3.Client
+)
+
+func TestStoreAdapter(t *testing.T) {
+	registerSignalHandler()
+	RegisterFailHandler(Fail)
+
+	SetDefaultEventuallyTimeout(5 * time.Second)
+
+	RunSpecs(t, "ETCD v3 Store Adapter Suite")
+}
+
+var _ = SynchronizedBeforeSuite(func() []byte {
+	return nil
+}, func([]byte) {
+	var err error
+	etcdDataDir, err = ioutil.TempDir("", "etcdv3storeadapter")
+	Expect(err).NotTo(HaveOccurred())
+
+	port := 5100 + (config.GinkgoConfig.ParallelNode)*10
+	clientURL := mustParseURL(fmt.Sprintf("http://127.0.0.1:%d", port))
+	peerURL := mustParseURL(fmt.Sprintf("http://127.0.0.1:%d", port+1))
+
+	cfg := embed.NewConfig()
+	cfg.Dir = etcdDataDir
+	cfg.LogLevel = "error"
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	etcdServer, err = embed.StartEtcd(cfg)
+	Expect(err).NotTo(HaveOccurred())
+
+	select {
+	case <-etcdServer.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		Fail("embedded etcd did not start")
+	}
+
+	etcdURL = clientURL.String()
+	etcdClient, err = clientv3.New(clientv3.Config{
+		Endpoints:   []string{etcdURL},
+		DialTimeout: 5 * time.Second,
+	})
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = SynchronizedAfterSuite(func() {
+	stopStores()
+}, func() {
+})
+
+var _ = BeforeEach(func() {
+	_, err := etcdClient.Delete(context.Background(), "/", clientv3.WithPrefix())
+	Expect(err).NotTo(HaveOccurred())
+})
+
+func mustParseURL(rawURL string) url.URL {
+	parsed, err := url.Parse(rawURL)
+	Expect(err).NotTo(HaveOccurred())
+	return *parsed
+}
+
+func stopStores() {
+	if etcdClient != nil {
+		etcdClient.Close()
+	}
+
+	if etcdServer != nil {
+		etcdServer.Close()
+	}
+
+	if etcdDataDir != "" {
+		os.RemoveAll(etcdDataDir)
+	}
+}
+
+func registerSignalHandler() {
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, os.Kill)
+
+		select {
+		case <-c:
+			stopStores()
+			os.Exit(0)
+		}
+	}()
+}
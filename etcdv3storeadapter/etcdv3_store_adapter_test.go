@@ -0,0 +1,1183 @@
+package etcdv3storeadapter_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/workpool"
+	. "github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/etcdstoreadapter"
+	. "github.com/cloudfoundry/storeadapter/etcdv3storeadapter"
+	. "github.com/cloudfoundry/storeadapter/storenodematchers"
+	"github.com/cloudfoundry/storeadapter/test_helpers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+var counter = 0
+
+var _ = Describe("ETCD v3 Store Adapter", func() {
+	var (
+		adapter       *ETCDv3StoreAdapter
+		breakfastNode StoreNode
+		lunchNode     StoreNode
+	)
+
+	BeforeEach(func() {
+		breakfastNode = StoreNode{
+			Key:   "/menu/breakfast",
+			Value: []byte("waffles"),
+		}
+
+		lunchNode = StoreNode{
+			Key:   "/menu/lunch",
+			Value: []byte("burgers"),
+		}
+
+		workPool, err := workpool.NewWorkPool(10)
+		Expect(err).NotTo(HaveOccurred())
+		adapter, err = New(&etcdstoreadapter.ETCDOptions{ClusterUrls: []string{etcdURL}}, workPool)
+		Expect(err).NotTo(HaveOccurred())
+		err = adapter.Connect()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		adapter.Disconnect()
+	})
+
+	It("is a ContextStoreAdapter", func() {
+		var contextAdapter ContextStoreAdapter = adapter
+		Expect(NewContextStoreAdapter(adapter)).To(BeIdenticalTo(contextAdapter))
+	})
+
+	Describe("Connect", func() {
+		Context("when server is down", func() {
+			It("should return an error", func() {
+				workPool, err := workpool.NewWorkPool(10)
+				Expect(err).NotTo(HaveOccurred())
+
+				downAdapter, err := New(&etcdstoreadapter.ETCDOptions{ClusterUrls: []string{"http://127.0.0.1:6000"}}, workPool)
+				Expect(err).NotTo(HaveOccurred())
+				defer downAdapter.Disconnect()
+
+				ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+				defer cancel()
+
+				err = downAdapter.ConnectContext(ctx)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("Get", func() {
+		BeforeEach(func() {
+			err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when getting a key", func() {
+			It("should return the appropriate store node, with the revision it was written at", func() {
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(breakfastNode))
+
+				response, err := etcdClient.Get(context.Background(), "/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value.Index).To(Equal(uint64(response.Kvs[0].ModRevision)))
+			})
+
+			It("cleans the key", func() {
+				value, err := adapter.Get("menu//breakfast/")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(breakfastNode))
+			})
+		})
+
+		Context("When getting a non-existent key", func() {
+			It("should return an error", func() {
+				value, err := adapter.Get("/not_a_key")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+				Expect(value).To(BeZero())
+			})
+
+			It("should report the operation, key and revision", func() {
+				_, err := adapter.Get("/not_a_key")
+
+				storeErr, ok := err.(*Error)
+				Expect(ok).To(BeTrue())
+				Expect(storeErr.Op).To(Equal("Get"))
+				Expect(storeErr.Key).To(Equal("/not_a_key"))
+				Expect(storeErr.Index).NotTo(BeZero())
+			})
+		})
+
+		Context("when getting a directory", func() {
+			It("should return an error", func() {
+				value, err := adapter.Get("/menu")
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
+				Expect(value).To(BeZero())
+			})
+		})
+
+		Context("when getting a key that only shares a prefix with others", func() {
+			It("should return an error", func() {
+				_, err := adapter.Get("/men")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+	})
+
+	Describe("SetMulti", func() {
+		It("should be able to set multiple things to the store at once", func() {
+			err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+			Expect(err).NotTo(HaveOccurred())
+
+			menu, err := adapter.ListRecursively("/menu")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(menu.ChildNodes).To(HaveLen(2))
+			Expect(menu.ChildNodes).To(ContainElement(MatchStoreNode(breakfastNode)))
+			Expect(menu.ChildNodes).To(ContainElement(MatchStoreNode(lunchNode)))
+		})
+
+		Context("Setting to an existing node", func() {
+			BeforeEach(func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("should be able to update existing entries", func() {
+				lunchNode.Value = []byte("steak")
+				err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				menu, err := adapter.ListRecursively("/menu")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(menu.ChildNodes).To(HaveLen(2))
+				Expect(menu.ChildNodes).To(ContainElement(MatchStoreNode(breakfastNode)))
+				Expect(menu.ChildNodes).To(ContainElement(MatchStoreNode(lunchNode)))
+			})
+
+			It("should error when attempting to set to a directory", func() {
+				err := adapter.SetMulti([]StoreNode{{Key: "/menu", Value: []byte("oops!")}})
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
+			})
+
+			It("should error when attempting to set under a leaf", func() {
+				err := adapter.SetMulti([]StoreNode{{Key: "/menu/breakfast/eggs", Value: []byte("oops!")}})
+				Expect(err).To(MatchError(ErrorNodeIsNotDirectory))
+
+				response, err := etcdClient.Get(context.Background(), "/menu/breakfast/eggs")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.Kvs).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("List", func() {
+		BeforeEach(func() {
+			err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("When listing a directory", func() {
+			It("Should list directory contents", func() {
+				value, err := adapter.ListRecursively("/menu")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value.Key).To(Equal("/menu"))
+				Expect(value.Dir).To(BeTrue())
+				Expect(value.ChildNodes).To(HaveLen(2))
+				Expect(value.ChildNodes[0].Index).NotTo(BeZero())
+				Expect(value.ChildNodes).To(ContainElement(MatchStoreNode(breakfastNode)))
+				Expect(value.ChildNodes).To(ContainElement(MatchStoreNode(lunchNode)))
+			})
+		})
+
+		Context("when listing a directory that contains directories", func() {
+			var (
+				firstCourseDinnerNode  StoreNode
+				secondCourseDinnerNode StoreNode
+			)
+
+			BeforeEach(func() {
+				firstCourseDinnerNode = StoreNode{
+					Key:   "/menu/dinner/first_course",
+					Value: []byte("Salad"),
+				}
+				secondCourseDinnerNode = StoreNode{
+					Key:   "/menu/dinner/second_course",
+					Value: []byte("Brisket"),
+				}
+				err := adapter.SetMulti([]StoreNode{firstCourseDinnerNode, secondCourseDinnerNode})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("should list the root directory recursively", func() {
+				value, err := adapter.ListRecursively("/")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value.Key).To(Equal(""))
+				Expect(value.Dir).To(BeTrue())
+				Expect(value.ChildNodes).To(HaveLen(1))
+
+				menuNode := value.ChildNodes[0]
+				Expect(menuNode.Key).To(Equal("/menu"))
+				Expect(menuNode.Value).To(BeEmpty())
+				Expect(menuNode.Dir).To(BeTrue())
+				Expect(menuNode.ChildNodes).To(HaveLen(3))
+				Expect(menuNode.ChildNodes).To(ContainElement(MatchStoreNode(breakfastNode)))
+				Expect(menuNode.ChildNodes).To(ContainElement(MatchStoreNode(lunchNode)))
+
+				var dinnerNode StoreNode
+				for _, node := range menuNode.ChildNodes {
+					if node.Key == "/menu/dinner" {
+						dinnerNode = node
+						break
+					}
+				}
+				Expect(dinnerNode.Dir).To(BeTrue())
+				Expect(dinnerNode.ChildNodes).To(ContainElement(MatchStoreNode(firstCourseDinnerNode)))
+				Expect(dinnerNode.ChildNodes).To(ContainElement(MatchStoreNode(secondCourseDinnerNode)))
+			})
+		})
+
+		Context("when the last key in a directory is deleted", func() {
+			It("the directory no longer exists", func() {
+				err := adapter.SetMulti([]StoreNode{{Key: "/empty_dir/temp", Value: []byte("foo")}})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.Delete("/empty_dir/temp")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.ListRecursively("/empty_dir")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+
+		Context("when listing a non-existent key", func() {
+			It("should return an error", func() {
+				value, err := adapter.ListRecursively("/nothing-here")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+				Expect(value).To(BeZero())
+			})
+		})
+
+		Context("when listing an entry", func() {
+			It("should return an error", func() {
+				value, err := adapter.ListRecursively("/menu/breakfast")
+				Expect(err).To(MatchError(ErrorNodeIsNotDirectory))
+				Expect(value).To(BeZero())
+			})
+		})
+	})
+
+	Describe("Delete", func() {
+		BeforeEach(func() {
+			err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when deleting existing keys", func() {
+			It("should delete the keys", func() {
+				err := adapter.Delete("/menu/breakfast", "/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				_, err = adapter.Get("/menu/lunch")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+
+		Context("when deleting a non-existing key", func() {
+			It("should error", func() {
+				err := adapter.Delete("/not-a-key")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+
+		Context("when deleting a directory", func() {
+			It("deletes the key and its contents", func() {
+				err := adapter.SetMulti([]StoreNode{{Key: "/menus", Value: []byte("unrelated")}})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.Delete("/menu")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				_, err = adapter.Get("/menu")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				_, err = adapter.Get("/menus")
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("DeleteLeaves", func() {
+		BeforeEach(func() {
+			err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("deletes leaves", func() {
+			err := adapter.DeleteLeaves("/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = adapter.Get("/menu/breakfast")
+			Expect(err).To(MatchError(ErrorKeyNotFound))
+		})
+
+		It("refuses to delete a directory with keys under it", func() {
+			err := adapter.DeleteLeaves("/menu")
+			Expect(err).To(HaveOccurred())
+
+			_, err = adapter.Get("/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("reports a missing key", func() {
+			err := adapter.DeleteLeaves("/not-a-key")
+			Expect(err).To(MatchError(ErrorKeyNotFound))
+		})
+	})
+
+	Describe("Comparing-and-deleting", func() {
+		var nodeFoo StoreNode
+		var nodeBar StoreNode
+
+		BeforeEach(func() {
+			nodeFoo = StoreNode{Key: "/foo", Value: []byte("some foo value")}
+			nodeBar = StoreNode{Key: "/bar", Value: []byte("some bar value")}
+		})
+
+		Context("when nodes exist in the store", func() {
+			BeforeEach(func() {
+				err := adapter.Create(nodeFoo)
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.Create(nodeBar)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("deletes the given nodes", func() {
+				err := adapter.CompareAndDelete(nodeFoo, nodeBar)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.Get(nodeFoo.Key)
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				_, err = adapter.Get(nodeBar.Key)
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			Context("but the comparison fails for one node", func() {
+				BeforeEach(func() {
+					nodeFoo.Value = []byte("some mismatched foo value")
+				})
+
+				It("reports the outcome for each node", func() {
+					err := adapter.CompareAndDelete(nodeFoo, nodeBar)
+
+					multiErr, ok := err.(*MultiError)
+					Expect(ok).To(BeTrue())
+					Expect(multiErr.Failed()).To(HaveLen(1))
+					Expect(multiErr.Failed()[0].Key).To(Equal(nodeFoo.Key))
+					Expect(multiErr.Failed()[0].Err).To(MatchError(ErrorKeyComparisonFailed))
+					Expect(multiErr.Succeeded()).To(HaveLen(1))
+					Expect(multiErr.Succeeded()[0].Key).To(Equal(nodeBar.Key))
+					Expect(multiErr.Succeeded()[0].Index).NotTo(BeZero())
+
+					_, err = adapter.Get(nodeFoo.Key)
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+		})
+
+		Context("when a node does not exist at the key", func() {
+			It("returns an error", func() {
+				err := adapter.CompareAndDelete(nodeFoo)
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+
+		Context("when a directory exists at the given key", func() {
+			It("returns an error", func() {
+				err := adapter.Create(StoreNode{Key: "/dir/foo", Value: []byte("some value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.CompareAndDelete(StoreNode{Key: "/dir", Value: []byte("some value")})
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
+			})
+		})
+	})
+
+	Describe("Comparing-and-deleting-by-index", func() {
+		var etcdNodeFoo StoreNode
+
+		BeforeEach(func() {
+			err := adapter.Create(StoreNode{Key: "/foo", Value: []byte("some foo value")})
+			Expect(err).NotTo(HaveOccurred())
+
+			etcdNodeFoo, err = adapter.Get("/foo")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("deletes the node if its revision matches", func() {
+			err := adapter.CompareAndDeleteByIndex(etcdNodeFoo)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = adapter.Get("/foo")
+			Expect(err).To(MatchError(ErrorKeyNotFound))
+		})
+
+		It("returns an error if the node has been written since", func() {
+			err := adapter.CompareAndSwap(etcdNodeFoo, etcdNodeFoo)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = adapter.CompareAndDeleteByIndex(etcdNodeFoo)
+			Expect(err).To(MatchError(ErrorKeyComparisonFailed))
+
+			_, err = adapter.Get("/foo")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns an error for a directory", func() {
+			err := adapter.Create(StoreNode{Key: "/dir/foo", Value: []byte("some value")})
+			Expect(err).NotTo(HaveOccurred())
+
+			parentNode, err := adapter.ListRecursively("/dir")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = adapter.CompareAndDeleteByIndex(parentNode)
+			Expect(err).To(MatchError(ErrorNodeIsDirectory))
+		})
+	})
+
+	Context("When setting a key with a non-zero TTL", func() {
+		It("is written with a lease, and disappears when the lease expires", func() {
+			breakfastNode.TTL = 1
+			err := adapter.SetMulti([]StoreNode{breakfastNode})
+			Expect(err).NotTo(HaveOccurred())
+
+			value, err := adapter.Get("/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value.TTL).NotTo(BeZero())
+
+			response, err := etcdClient.Get(context.Background(), "/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.Kvs[0].Lease).NotTo(BeZero())
+
+			Eventually(func() interface{} {
+				_, err = adapter.Get("/menu/breakfast")
+				return err
+			}, 4, 0.01).Should(MatchError(ErrorKeyNotFound)) // etcd rounds TTLs up to its minimum lease TTL, about 2 seconds
+		})
+	})
+
+	Describe("Creating", func() {
+		var node StoreNode
+
+		BeforeEach(func() {
+			node = StoreNode{Key: "/foo", Value: []byte("some value")}
+			err := adapter.Create(node)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("creates the node at the given key", func() {
+			retrievedNode, err := adapter.Get("/foo")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retrievedNode).To(MatchStoreNode(node))
+		})
+
+		Context("when a node already exists at the key", func() {
+			It("returns an error", func() {
+				err := adapter.Create(node)
+				Expect(err).To(MatchError(ErrorKeyExists))
+			})
+		})
+
+		Context("when a directory exists at the given key", func() {
+			It("returns an error", func() {
+				err := adapter.Create(StoreNode{Key: "/dir/foo", Value: []byte("some value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.Create(StoreNode{Key: "/dir", Value: []byte("some value")})
+				Expect(err).To(MatchError(ErrorKeyExists))
+			})
+		})
+	})
+
+	Describe("Updating", func() {
+		It("updates an existing node", func() {
+			err := adapter.Create(StoreNode{Key: "/foo", Value: []byte("some value")})
+			Expect(err).NotTo(HaveOccurred())
+
+			updatedNode := StoreNode{Key: "/foo", Value: []byte("some new value")}
+			err = adapter.Update(updatedNode)
+			Expect(err).NotTo(HaveOccurred())
+
+			retrievedNode, err := adapter.Get("/foo")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retrievedNode).To(MatchStoreNode(updatedNode))
+		})
+
+		Context("when a node does not exist at the key", func() {
+			It("returns an error", func() {
+				err := adapter.Update(StoreNode{Key: "/foo", Value: []byte("some value")})
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+
+		Context("when a directory exists at the given key", func() {
+			It("returns an error", func() {
+				err := adapter.Create(StoreNode{Key: "/dir/foo", Value: []byte("some value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.Update(StoreNode{Key: "/dir", Value: []byte("some value")})
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
+			})
+		})
+	})
+
+	Describe("Comparing-and-swapping", func() {
+		var node StoreNode
+
+		BeforeEach(func() {
+			node = StoreNode{Key: "/foo", Value: []byte("some value")}
+			err := adapter.Create(node)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("swaps the node if its value matches", func() {
+			newNode := StoreNode{Key: "/foo", Value: []byte("some new value")}
+			err := adapter.CompareAndSwap(node, newNode)
+			Expect(err).NotTo(HaveOccurred())
+
+			retrievedNode, err := adapter.Get("/foo")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retrievedNode).To(MatchStoreNode(newNode))
+		})
+
+		It("returns an error if the value does not match", func() {
+			err := adapter.CompareAndSwap(
+				StoreNode{Key: "/foo", Value: []byte("some other value")},
+				StoreNode{Key: "/foo", Value: []byte("some new value")},
+			)
+			Expect(err).To(MatchError(ErrorKeyComparisonFailed))
+
+			retrievedNode, err := adapter.Get("/foo")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retrievedNode).To(MatchStoreNode(node))
+		})
+
+		It("returns an error if there is no node at the key", func() {
+			err := adapter.CompareAndSwap(
+				StoreNode{Key: "/bar", Value: []byte("some value")},
+				StoreNode{Key: "/bar", Value: []byte("some new value")},
+			)
+			Expect(err).To(MatchError(ErrorKeyNotFound))
+		})
+	})
+
+	Describe("Comparing-and-swapping by index", func() {
+		var node StoreNode
+
+		BeforeEach(func() {
+			err := adapter.Create(StoreNode{Key: "/foo", Value: []byte("some value")})
+			Expect(err).NotTo(HaveOccurred())
+
+			node, err = adapter.Get("/foo")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("swaps the node if its revision matches", func() {
+			newNode := StoreNode{Key: "/foo", Value: []byte("some new value")}
+			err := adapter.CompareAndSwapByIndex(node.Index, newNode)
+			Expect(err).NotTo(HaveOccurred())
+
+			retrievedNode, err := adapter.Get("/foo")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retrievedNode).To(MatchStoreNode(newNode))
+			Expect(retrievedNode.Index).To(BeNumerically(">", node.Index))
+		})
+
+		It("returns an error if the revision does not match", func() {
+			err := adapter.CompareAndSwapByIndex(node.Index+100, StoreNode{Key: "/foo", Value: []byte("some new value")})
+			Expect(err).To(MatchError(ErrorKeyComparisonFailed))
+		})
+	})
+
+	Describe("Txn", func() {
+		BeforeEach(func() {
+			err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when every comparison holds", func() {
+			It("applies all of the operations at one revision", func() {
+				breakfast, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.Txn(
+					[]TxnCompare{
+						IndexEquals("/menu/breakfast", breakfast.Index),
+						ValueEquals("/menu/lunch", []byte("burgers")),
+						KeyMissing("/menu/dinner"),
+					},
+					[]TxnOp{
+						Put(StoreNode{Key: "/menu/breakfast", Value: []byte("crepes")}),
+						Put(StoreNode{Key: "/menu/dinner", Value: []byte("steak")}),
+						DeleteKey("/menu/lunch"),
+					},
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(value.Value)).To(Equal("crepes"))
+
+				dinner, err := adapter.Get("/menu/dinner")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(dinner.Value)).To(Equal("steak"))
+				Expect(dinner.Index).To(Equal(value.Index))
+
+				_, err = adapter.Get("/menu/lunch")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+
+		Context("when a comparison fails", func() {
+			It("returns the comparison's error and applies nothing", func() {
+				err := adapter.Txn(
+					[]TxnCompare{ValueEquals("/menu/breakfast", []byte("crepes"))},
+					[]TxnOp{DeleteKey("/menu/lunch")},
+				)
+				Expect(err).To(MatchError(ErrorKeyComparisonFailed))
+
+				value, err := adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(lunchNode))
+			})
+		})
+
+		Context("when an operation fails part way through", func() {
+			It("applies none of the operations", func() {
+				err := adapter.Txn(nil, []TxnOp{
+					Put(StoreNode{Key: "/menu/breakfast", Value: []byte("crepes")}),
+					Put(StoreNode{Key: "/menu/dinner", Value: []byte("steak")}),
+					DeleteKey("/menu/lunch"),
+					DeleteKey("/menu/elevenses"),
+				})
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(breakfastNode))
+
+				_, err = adapter.Get("/menu/dinner")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+
+		Context("when an operation depends on an earlier one", func() {
+			It("sees the earlier operation's effect", func() {
+				err := adapter.Txn(nil, []TxnOp{
+					DeleteKey("/menu/breakfast"),
+					DeleteKey("/menu/lunch"),
+					Put(StoreNode{Key: "/menu", Value: []byte("closed")}),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(value.Value)).To(Equal("closed"))
+			})
+		})
+	})
+
+	Describe("Watching", func() {
+		It("sends an event with CreateEvent type and the node's value, and no previous node", func(done Done) {
+			events, _, _ := adapter.Watch("/foo")
+
+			err := adapter.Create(StoreNode{Key: "/foo/a", Value: []byte("new value")})
+			Expect(err).ToNot(HaveOccurred())
+
+			event := <-events
+			Expect(event.Type).To(Equal(CreateEvent))
+			Expect(event.Node.Key).To(Equal("/foo/a"))
+			Expect(string(event.Node.Value)).To(Equal("new value"))
+			Expect(event.PrevNode).To(BeNil())
+			Expect(event.Index).To(Equal(event.Node.Index))
+
+			close(done)
+		}, 5.0)
+
+		It("sends an event with UpdateEvent type and the previous node", func(done Done) {
+			err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte("some value")}})
+			Expect(err).ToNot(HaveOccurred())
+
+			events, _, _ := adapter.Watch("/foo")
+
+			err = adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte("new value")}})
+			Expect(err).ToNot(HaveOccurred())
+
+			event := <-events
+			Expect(event.Type).To(Equal(UpdateEvent))
+			Expect(string(event.Node.Value)).To(Equal("new value"))
+			Expect(event.PrevNode.Key).To(Equal("/foo/a"))
+			Expect(string(event.PrevNode.Value)).To(Equal("some value"))
+
+			close(done)
+		}, 5.0)
+
+		It("sends an event with DeleteEvent type and the previous node", func(done Done) {
+			err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte("some value")}})
+			Expect(err).ToNot(HaveOccurred())
+
+			events, _, _ := adapter.Watch("/foo")
+
+			err = adapter.Delete("/foo/a")
+			Expect(err).ToNot(HaveOccurred())
+
+			event := <-events
+			Expect(event.Type).To(Equal(DeleteEvent))
+			Expect(event.Node).To(BeNil())
+			Expect(event.PrevNode.Key).To(Equal("/foo/a"))
+			Expect(string(event.PrevNode.Value)).To(Equal("some value"))
+
+			close(done)
+		}, 5.0)
+
+		It("sends an event with ExpireEvent type when a node's lease expires", func(done Done) {
+			err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte("some value"), TTL: 1}})
+			Expect(err).ToNot(HaveOccurred())
+
+			events, _, _ := adapter.Watch("/foo")
+
+			event := <-events
+			Expect(event.Type).To(Equal(ExpireEvent))
+			Expect(event.Node).To(BeNil())
+			Expect(event.PrevNode.Key).To(Equal("/foo/a"))
+
+			close(done)
+		}, 5.0)
+
+		It("watches the key itself, but not keys that merely share its prefix", func(done Done) {
+			events, _, _ := adapter.Watch("/foo")
+
+			err := adapter.SetMulti([]StoreNode{{Key: "/foobar", Value: []byte("unrelated")}})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = adapter.SetMulti([]StoreNode{{Key: "/foo", Value: []byte("related")}})
+			Expect(err).ToNot(HaveOccurred())
+
+			event := <-events
+			Expect(event.Node.Key).To(Equal("/foo"))
+
+			close(done)
+		}, 5.0)
+
+		Context("when told to stop watching", func() {
+			It("closes the event and error channels", func() {
+				events, stop, errors := adapter.Watch("/foo")
+
+				stop <- true
+
+				Eventually(events, 2).Should(BeClosed())
+				Eventually(errors, 2).Should(BeClosed())
+			})
+		})
+
+		Context("when told to disconnect", func() {
+			It("closes the event and error channels", func() {
+				events, _, errors := adapter.Watch("/foo")
+
+				adapter.Disconnect()
+
+				Eventually(events).Should(BeClosed())
+				Eventually(errors).Should(BeClosed())
+			})
+		})
+	})
+
+	Describe("Watching from an index", func() {
+		var firstIndex uint64
+
+		BeforeEach(func() {
+			for _, value := range []string{"1", "2", "3"} {
+				err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte(value)}})
+				Expect(err).ToNot(HaveOccurred())
+
+				if value == "1" {
+					node, err := adapter.Get("/foo/a")
+					Expect(err).ToNot(HaveOccurred())
+					firstIndex = node.Index
+				}
+			}
+		})
+
+		It("sends every event after the index, in order", func(done Done) {
+			events, stop, _ := adapter.WatchFrom("/foo", firstIndex)
+
+			event := <-events
+			Expect(event.Type).To(Equal(UpdateEvent))
+			Expect(string(event.Node.Value)).To(Equal("2"))
+			Expect(string(event.PrevNode.Value)).To(Equal("1"))
+			Expect(event.Index).To(BeNumerically(">", firstIndex))
+
+			event = <-events
+			Expect(string(event.Node.Value)).To(Equal("3"))
+
+			err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte("4")}})
+			Expect(err).ToNot(HaveOccurred())
+
+			event = <-events
+			Expect(string(event.Node.Value)).To(Equal("4"))
+
+			stop <- true
+
+			close(done)
+		}, 5.0)
+
+		Context("when etcd has compacted the revisions after the index", func() {
+			var compactedTo int64
+
+			BeforeEach(func() {
+				response, err := etcdClient.Get(context.Background(), "/foo/a")
+				Expect(err).ToNot(HaveOccurred())
+
+				compactedTo = response.Header.Revision
+				_, err = etcdClient.Compact(context.Background(), compactedTo, clientv3.WithCompactPhysical())
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("reports ErrorWatchIndexCleared and stops", func() {
+				events, _, errChan := adapter.WatchFrom("/foo", firstIndex)
+
+				var err error
+				Eventually(errChan).Should(Receive(&err))
+				Expect(err).To(MatchError(ErrorWatchIndexCleared))
+
+				storeErr, ok := err.(*Error)
+				Expect(ok).To(BeTrue())
+				Expect(storeErr.Index).To(BeNumerically(">=", compactedTo))
+
+				Eventually(events).Should(BeClosed())
+			})
+		})
+	})
+
+	Describe("UpdateDirTTL", func() {
+		Context("When the directory exists", func() {
+			It("gives every key under it the TTL", func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode, {Key: "/menu/dinner/first_course", Value: []byte("Salad")}})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.UpdateDirTTL("/menu", 1)
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value.TTL).NotTo(BeZero())
+				Expect(string(value.Value)).To(Equal("waffles"))
+
+				Eventually(func() interface{} {
+					_, err := adapter.ListRecursively("/menu")
+					return err
+				}, 4, 0.05).Should(MatchError(ErrorKeyNotFound))
+			})
+		})
+
+		Context("When the directory does not exist", func() {
+			It("should return a ErrorKeyNotFound", func() {
+				err := adapter.UpdateDirTTL("/non-existent-key", 1)
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+
+		Context("When the key represents a leaf, not a directory", func() {
+			It("should return a ErrorNodeIsNotDirectory error", func() {
+				err := adapter.Create(breakfastNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.UpdateDirTTL("/menu/breakfast", 1)
+				Expect(err).To(MatchError(ErrorNodeIsNotDirectory))
+			})
+		})
+	})
+
+	Describe("Maintaining a node's presence (and lack thereof)", func() {
+		var uniqueStoreNodeForThisTest StoreNode
+
+		releaseMaintainedNode := func(release chan chan bool) {
+			waiting := make(chan bool)
+			release <- waiting
+			Eventually(waiting).Should(BeClosed())
+		}
+
+		waitTilLocked := func(storeNode StoreNode) chan chan bool {
+			nodeStatus, releaseLock, err := adapter.MaintainNode(storeNode)
+			Expect(err).NotTo(HaveOccurred())
+
+			reporter := test_helpers.NewStatusReporter(nodeStatus)
+			Eventually(reporter.Reporting, 2.0).Should(BeTrue())
+			Eventually(reporter.Locked).Should(BeTrue())
+
+			return releaseLock
+		}
+
+		BeforeEach(func() {
+			uniqueStoreNodeForThisTest = StoreNode{
+				Key: fmt.Sprintf("/analyzer-%d", counter),
+				TTL: 2,
+			}
+
+			counter++
+		})
+
+		Context("when passed a TTL of 0", func() {
+			It("should be like, no way man", func() {
+				uniqueStoreNodeForThisTest.TTL = 0
+
+				nodeStatus, releaseLock, err := adapter.MaintainNode(uniqueStoreNodeForThisTest)
+				Expect(err).To(MatchError(ErrorInvalidTTL))
+				Expect(nodeStatus).To(BeNil())
+				Expect(releaseLock).To(BeNil())
+			})
+		})
+
+		Context("when the lock is available", func() {
+			It("receives a status of true every TTL", func() {
+				nodeStatus, releaseLock, err := adapter.MaintainNode(uniqueStoreNodeForThisTest)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(nodeStatus, 2.0).Should(Receive(BeTrue()))
+
+				start := time.Now()
+				Eventually(nodeStatus, 4.0).Should(Receive(BeTrue()))
+				Expect(time.Now().Sub(start)).To(BeNumerically("~", 2*time.Second, 500*time.Millisecond))
+
+				releaseMaintainedNode(releaseLock)
+			})
+
+			It("writes the node with a lease that is kept alive", func() {
+				releaseLock := waitTilLocked(uniqueStoreNodeForThisTest)
+				defer releaseMaintainedNode(releaseLock)
+
+				response, err := etcdClient.Get(context.Background(), uniqueStoreNodeForThisTest.Key)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.Kvs).To(HaveLen(1))
+				Expect(response.Kvs[0].Lease).NotTo(BeZero())
+
+				time.Sleep(3 * time.Second)
+
+				_, err = adapter.Get(uniqueStoreNodeForThisTest.Key)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("keeps others from acquiring it", func() {
+				releaseLock1 := waitTilLocked(uniqueStoreNodeForThisTest)
+
+				otherStoreNode := uniqueStoreNodeForThisTest
+				otherStoreNode.Value = []byte("other")
+
+				nodeStatus2, releaseLock2, _ := adapter.MaintainNode(otherStoreNode)
+
+				reporter := test_helpers.NewStatusReporter(nodeStatus2)
+				Consistently(reporter.Reporting, 2).Should(BeFalse())
+
+				releaseMaintainedNode(releaseLock1)
+				Eventually(reporter.Locked, 2).Should(BeTrue())
+
+				releaseMaintainedNode(releaseLock2)
+			})
+
+			It("creates the lock with the given value", func() {
+				uniqueStoreNodeForThisTest.Value = []byte("some value")
+
+				releaseLock := waitTilLocked(uniqueStoreNodeForThisTest)
+				defer releaseMaintainedNode(releaseLock)
+
+				val, err := adapter.Get(uniqueStoreNodeForThisTest.Key)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(val.Value)).To(Equal("some value"))
+			})
+
+			Context("when the node disappears after it has been acquired", func() {
+				It("reports it lost, and then acquires it again", func() {
+					nodeStatus, releaseLock, err := adapter.MaintainNode(uniqueStoreNodeForThisTest)
+					Expect(err).NotTo(HaveOccurred())
+					Eventually(nodeStatus, 2).Should(Receive(BeTrue()))
+
+					_, err = etcdClient.Delete(context.Background(), uniqueStoreNodeForThisTest.Key)
+					Expect(err).NotTo(HaveOccurred())
+
+					Eventually(nodeStatus, 3).Should(Receive(BeFalse()))
+					Eventually(nodeStatus, 2).Should(Receive(BeTrue()))
+
+					releaseMaintainedNode(releaseLock)
+				})
+			})
+		})
+
+		Context("with a fencing token", func() {
+			It("reports the revision the node was written at", func() {
+				status, release, err := adapter.MaintainNodeWithToken(uniqueStoreNodeForThisTest)
+				Expect(err).NotTo(HaveOccurred())
+
+				var acquired NodeStatus
+				Eventually(status, 2.0).Should(Receive(&acquired))
+				Expect(acquired.Owned).To(BeTrue())
+
+				node, err := adapter.Get(uniqueStoreNodeForThisTest.Key)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(acquired.Token).To(Equal(node.Index))
+
+				var refreshed NodeStatus
+				Eventually(status, 4.0).Should(Receive(&refreshed))
+				Expect(refreshed).To(Equal(acquired))
+
+				releaseMaintainedNode(release)
+			})
+
+			It("reports a larger token each time the node is acquired", func() {
+				status, release, err := adapter.MaintainNodeWithToken(uniqueStoreNodeForThisTest)
+				Expect(err).NotTo(HaveOccurred())
+
+				var first NodeStatus
+				Eventually(status, 2.0).Should(Receive(&first))
+				releaseMaintainedNode(release)
+
+				status, release, err = adapter.MaintainNodeWithToken(uniqueStoreNodeForThisTest)
+				Expect(err).NotTo(HaveOccurred())
+
+				var second NodeStatus
+				Eventually(status, 2.0).Should(Receive(&second))
+				Expect(second.Owned).To(BeTrue())
+				Expect(second.Token).To(BeNumerically(">", first.Token))
+
+				releaseMaintainedNode(release)
+			})
+		})
+
+		Context("with options", func() {
+			It("refreshes the node on the clock, reporting every StatusEvery refreshes", func() {
+				fakeClock := fakeclock.NewFakeClock(time.Now())
+
+				status, release, err := adapter.MaintainNodeWithOptions(uniqueStoreNodeForThisTest, MaintainOptions{
+					RefreshInterval: 500 * time.Millisecond,
+					StatusEvery:     2,
+					Clock:           fakeClock,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				defer releaseMaintainedNode(release)
+
+				var acquired NodeStatus
+				Eventually(status, 2.0).Should(Receive(&acquired))
+				Expect(acquired.Owned).To(BeTrue())
+
+				fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+				Consistently(status).ShouldNot(Receive())
+
+				fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+				Eventually(status).Should(Receive(Equal(acquired)))
+			})
+		})
+
+		Context("when releasing the lock", func() {
+			It("deletes the node, reported as deleted rather than expired", func() {
+				releaseLock := waitTilLocked(uniqueStoreNodeForThisTest)
+
+				events, stop, _ := adapter.Watch(uniqueStoreNodeForThisTest.Key)
+				defer func() { stop <- true }()
+
+				releaseMaintainedNode(releaseLock)
+
+				_, err := adapter.Get(uniqueStoreNodeForThisTest.Key)
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				var event WatchEvent
+				Eventually(events).Should(Receive(&event))
+				Expect(event.Type).To(Equal(DeleteEvent))
+			})
+
+			It("closes the status channel", func() {
+				nodeStatus, releaseLock, _ := adapter.MaintainNode(uniqueStoreNodeForThisTest)
+
+				reporter := test_helpers.NewStatusReporter(nodeStatus)
+				Eventually(reporter.Locked, 2).Should(BeTrue())
+
+				releaseLock <- nil
+
+				Eventually(reporter.Reporting).Should(BeFalse())
+			})
+		})
+	})
+
+	Describe("with a context", func() {
+		Context("when the context is already done", func() {
+			var ctx context.Context
+
+			BeforeEach(func() {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(context.Background())
+				cancel()
+			})
+
+			It("returns the context's error without writing", func() {
+				err := adapter.CreateContext(ctx, breakfastNode)
+				Expect(err).To(Equal(context.Canceled))
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("refuses to maintain a node", func() {
+				nodeStatus, releaseLock, err := adapter.MaintainNodeContext(ctx, StoreNode{Key: "/lock", TTL: 2})
+				Expect(err).To(Equal(context.Canceled))
+				Expect(nodeStatus).To(BeNil())
+				Expect(releaseLock).To(BeNil())
+			})
+		})
+
+		Context("when the context is done while watching", func() {
+			It("stops watching", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				events, _, errors := adapter.WatchContext(ctx, "/foo")
+				cancel()
+
+				Eventually(events, 2).Should(BeClosed())
+				Eventually(errors, 2).Should(BeClosed())
+			})
+		})
+
+		Context("when the context is done while maintaining a node", func() {
+			It("releases the node and closes the status channel", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				storeNode := StoreNode{Key: fmt.Sprintf("/analyzer-%d", counter), TTL: 2}
+				counter++
+
+				nodeStatus, _, err := adapter.MaintainNodeContext(ctx, storeNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				reporter := test_helpers.NewStatusReporter(nodeStatus)
+				Eventually(reporter.Locked, 2).Should(BeTrue())
+
+				cancel()
+
+				Eventually(reporter.Reporting).Should(BeFalse())
+
+				_, err = adapter.Get(storeNode.Key)
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+	})
+})
@@ -0,0 +1,206 @@
+package etcdv3storeadapter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/internal/maintain"
+	"github.com/nu7hatch/gouuid"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func (adapter *ETCDv3StoreAdapter) MaintainNode(storeNode storeadapter.StoreNode) (<-chan bool, chan (chan bool), error) {
+	return adapter.MaintainNodeContext(context.Background(), storeNode)
+}
+
+func (adapter *ETCDv3StoreAdapter) MaintainNodeContext(ctx context.Context, storeNode storeadapter.StoreNode) (<-chan bool, chan (chan bool), error) {
+	status, releaseNode, err := adapter.MaintainNodeWithTokenContext(ctx, storeNode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owned := make(chan bool)
+	go func() {
+		defer close(owned)
+		for nodeStatus := range status {
+			owned <- nodeStatus.Owned
+		}
+	}()
+
+	return owned, releaseNode, nil
+}
+
+func (adapter *ETCDv3StoreAdapter) MaintainNodeWithToken(storeNode storeadapter.StoreNode) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	return adapter.MaintainNodeWithTokenContext(context.Background(), storeNode)
+}
+
+func (adapter *ETCDv3StoreAdapter) MaintainNodeWithTokenContext(ctx context.Context, storeNode storeadapter.StoreNode) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	return adapter.MaintainNodeWithOptionsContext(ctx, storeNode, storeadapter.MaintainOptions{})
+}
+
+func (adapter *ETCDv3StoreAdapter) MaintainNodeWithOptions(storeNode storeadapter.StoreNode, options storeadapter.MaintainOptions) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	return adapter.MaintainNodeWithOptionsContext(context.Background(), storeNode, options)
+}
+
+// The node is written with a lease of its TTL, which is kept alive on every
+// refresh. The fencing token is the revision the node was written at.
+func (adapter *ETCDv3StoreAdapter) MaintainNodeWithOptionsContext(ctx context.Context, storeNode storeadapter.StoreNode, options storeadapter.MaintainOptions) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	options, err := options.WithDefaults(storeNode.TTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(storeNode.Value) == 0 {
+		guid, err := uuid.NewV4()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		storeNode.Value = []byte(guid.String())
+	}
+
+	storeNode.Key = cleanKey(storeNode.Key)
+
+	lease := clientv3.NoLease
+
+	nodeStatus, releaseNode := maintain.Node(ctx, options, maintain.Backend{
+		Acquire: func(ctx context.Context) (uint64, error) {
+			var revision int64
+			var err error
+			lease, revision, err = adapter.acquireNode(ctx, storeNode, lease)
+			return uint64(revision), err
+		},
+		Refresh: func(ctx context.Context, _ uint64) error {
+			err := adapter.refreshNode(ctx, storeNode.Key, lease)
+			if errors.Is(err, maintain.ErrNodeLost) {
+				lease = clientv3.NoLease
+			}
+			return err
+		},
+		// The node is released with the lease it was held with last, which
+		// is kept through failed refreshes.
+		Release: func(uint64) {
+			adapter.releaseNode(storeNode, lease)
+		},
+		Disconnected: adapter.ctx.Done(),
+	})
+
+	return nodeStatus, releaseNode, nil
+}
+
+// acquireNode writes the node with a lease if nobody else holds it,
+// returning the lease and the revision it was written at. A lease the node
+// was held with before is used again if it is still alive.
+func (adapter *ETCDv3StoreAdapter) acquireNode(ctx context.Context, storeNode storeadapter.StoreNode, lease clientv3.LeaseID) (clientv3.LeaseID, int64, error) {
+	key := storeNode.Key
+	value := string(storeNode.Value)
+
+	if lease != clientv3.NoLease {
+		_, err := adapter.client.KeepAliveOnce(ctx, lease)
+		if err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			return lease, 0, err
+		}
+
+		if err == nil {
+			response, err := adapter.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.LeaseValue(key), "=", lease)).
+				Then(clientv3.OpPut(key, value, clientv3.WithLease(lease))).
+				Commit()
+			if err != nil {
+				return lease, 0, err
+			}
+
+			if response.Succeeded {
+				return lease, response.Header.Revision, nil
+			}
+		}
+	}
+
+	granted, err := adapter.client.Grant(ctx, int64(storeNode.TTL))
+	if err != nil {
+		return clientv3.NoLease, 0, err
+	}
+
+	response, err := adapter.client.Txn(ctx).
+		If(
+			clientv3.Compare(clientv3.CreateRevision(key), "=", 0),
+			clientv3.Compare(clientv3.CreateRevision(dirPrefix(key)), "=", 0).WithPrefix(),
+		).
+		Then(clientv3.OpPut(key, value, clientv3.WithLease(granted.ID))).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err == nil && response.Succeeded {
+		return granted.ID, response.Header.Revision, nil
+	}
+
+	// A node holding our value is one we held before, so it is taken over.
+	if err == nil {
+		existing := response.Responses[0].GetResponseRange().Kvs
+		if len(existing) > 0 && bytes.Equal(existing[0].Value, storeNode.Value) {
+			response, err = adapter.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.ModRevision(key), "=", existing[0].ModRevision)).
+				Then(clientv3.OpPut(key, value, clientv3.WithLease(granted.ID))).
+				Commit()
+			if err == nil && response.Succeeded {
+				return granted.ID, response.Header.Revision, nil
+			}
+		}
+	}
+
+	adapter.client.Revoke(ctx, granted.ID)
+
+	if err != nil {
+		return clientv3.NoLease, 0, err
+	}
+
+	return clientv3.NoLease, 0, storeadapter.ErrorKeyExists
+}
+
+// refreshNode keeps the node's lease alive, returning maintain.ErrNodeLost if the
+// lease has expired or the node is no longer written with it.
+func (adapter *ETCDv3StoreAdapter) refreshNode(ctx context.Context, key string, lease clientv3.LeaseID) error {
+	_, err := adapter.client.KeepAliveOnce(ctx, lease)
+	if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		return maintain.ErrNodeLost
+	}
+	if err != nil {
+		return err
+	}
+
+	response, err := adapter.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.LeaseValue(key), "=", lease)).
+		Commit()
+	if err != nil {
+		return err
+	}
+
+	if !response.Succeeded {
+		return maintain.ErrNodeLost
+	}
+
+	return nil
+}
+
+// releaseNode deletes the node if it is still held with lease. The lease is
+// left to expire, so that watchers see the node deleted rather than expired.
+func (adapter *ETCDv3StoreAdapter) releaseNode(storeNode storeadapter.StoreNode, lease clientv3.LeaseID) {
+	if lease == clientv3.NoLease {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(adapter.ctx, time.Duration(storeNode.TTL)*time.Second)
+	defer cancel()
+
+	adapter.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.LeaseValue(storeNode.Key), "=", lease)).
+		Then(clientv3.OpDelete(storeNode.Key)).
+		Commit()
+}
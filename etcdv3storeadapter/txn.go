@@ -0,0 +1,308 @@
+package etcdv3storeadapter
+
+import (
+	"context"
+
+	"github.com/cloudfoundry/storeadapter"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// txn reads every key involved in one request, checks the comparisons and
+// plays the operations against what it read, then commits the resulting
+// writes on condition that none of those keys has changed since. If one has,
+// it starts over. This gives the v2 tree's rules, such as not writing a leaf
+// over a directory, the same atomicity as the writes themselves.
+//
+// Errors are reported for op, and for the key of the comparison or operation
+// that failed, or key if there is none. It returns the revision the
+// transaction was committed or read at.
+func (adapter *ETCDv3StoreAdapter) txn(ctx context.Context, op string, key string, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) (uint64, error) {
+	key = cleanKey(key)
+	leases := map[int]clientv3.LeaseID{}
+
+	for {
+		state, err := adapter.readTxnState(ctx, comparisons, operations)
+		if err != nil {
+			return 0, adapter.convertError(op, key, err)
+		}
+
+		for _, comparison := range comparisons {
+			comparison.Node.Key = cleanKey(comparison.Node.Key)
+
+			err := comparison.Check(state.node(comparison.Node.Key))
+			if err != nil {
+				return 0, keyError(op, comparison.Node.Key, state.revision, err)
+			}
+		}
+
+		writes, failedKey, err := state.apply(operations)
+		if err != nil {
+			return 0, keyError(op, failedKey, state.revision, err)
+		}
+
+		if len(writes) == 0 {
+			return uint64(state.revision), nil
+		}
+
+		ops := []clientv3.Op{}
+		for _, write := range writes {
+			if write.node == nil {
+				ops = append(ops, clientv3.OpDelete(write.key))
+				continue
+			}
+
+			var options []clientv3.OpOption
+			if write.node.TTL > 0 {
+				lease, granted := leases[write.op]
+				if !granted {
+					response, err := adapter.client.Grant(ctx, int64(write.node.TTL))
+					if err != nil {
+						return 0, adapter.convertError(op, write.key, err)
+					}
+
+					lease = response.ID
+					leases[write.op] = lease
+				}
+
+				options = append(options, clientv3.WithLease(lease))
+			}
+
+			ops = append(ops, clientv3.OpPut(write.key, string(write.node.Value), options...))
+		}
+
+		response, err := adapter.client.Txn(ctx).If(state.guards()...).Then(ops...).Commit()
+		if err != nil {
+			return 0, adapter.convertError(op, key, err)
+		}
+
+		if response.Succeeded {
+			return uint64(response.Header.Revision), nil
+		}
+	}
+}
+
+// txnState is what a transaction has read of the keys involved in it, as
+// changed by the operations it has played so far.
+type txnState struct {
+	revision int64
+
+	// The leaf at each key, or nil. This covers the keys compared and
+	// operated on, and the directories above the keys put.
+	leaves map[string]*mvccpb.KeyValue
+
+	// The ModRevision of each leaf as read, 0 for none.
+	readRevisions map[string]int64
+
+	// The keys under each key compared or operated on.
+	dirs map[string]*txnDir
+}
+
+type txnDir struct {
+	count int64
+
+	// As read: how many keys there were, and one of them.
+	readCount int64
+	readFirst *mvccpb.KeyValue
+}
+
+type txnWrite struct {
+	key string
+
+	// The node to put, or nil to delete the key.
+	node *storeadapter.StoreNode
+
+	// The index of the operation that wrote the key last.
+	op int
+}
+
+func (adapter *ETCDv3StoreAdapter) readTxnState(ctx context.Context, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) (*txnState, error) {
+	keys := []string{}
+	parents := []string{}
+	seen := map[string]bool{}
+
+	for _, comparison := range comparisons {
+		keys = appendUnseen(keys, seen, cleanKey(comparison.Node.Key))
+	}
+	for _, operation := range operations {
+		keys = appendUnseen(keys, seen, cleanKey(operation.Node.Key))
+	}
+	for _, operation := range operations {
+		if operation.Type == storeadapter.PutOp {
+			for _, parent := range ancestors(cleanKey(operation.Node.Key)) {
+				parents = appendUnseen(parents, seen, parent)
+			}
+		}
+	}
+
+	ops := []clientv3.Op{}
+	for _, key := range append(keys, parents...) {
+		if key != "" {
+			ops = append(ops, clientv3.OpGet(key))
+		}
+	}
+	for _, key := range keys {
+		ops = append(ops, clientv3.OpGet(dirPrefix(key), clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithLimit(1)))
+	}
+
+	response, err := adapter.client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &txnState{
+		revision:      response.Header.Revision,
+		leaves:        map[string]*mvccpb.KeyValue{},
+		readRevisions: map[string]int64{},
+		dirs:          map[string]*txnDir{},
+	}
+
+	results := response.Responses
+	for _, key := range append(keys, parents...) {
+		if key == "" {
+			continue
+		}
+
+		var leaf *mvccpb.KeyValue
+		if kvs := results[0].GetResponseRange().Kvs; len(kvs) > 0 {
+			leaf = kvs[0]
+			state.readRevisions[key] = leaf.ModRevision
+		} else {
+			state.readRevisions[key] = 0
+		}
+		state.leaves[key] = leaf
+		results = results[1:]
+	}
+
+	for _, key := range keys {
+		children := results[0].GetResponseRange()
+		dir := &txnDir{count: children.Count, readCount: children.Count}
+		if len(children.Kvs) > 0 {
+			dir.readFirst = children.Kvs[0]
+		}
+		state.dirs[key] = dir
+		results = results[1:]
+	}
+
+	return state, nil
+}
+
+func appendUnseen(keys []string, seen map[string]bool, key string) []string {
+	if seen[key] {
+		return keys
+	}
+
+	seen[key] = true
+	return append(keys, key)
+}
+
+func (state *txnState) isDir(key string) bool {
+	return key == "" || state.dirs[key] != nil && state.dirs[key].count > 0
+}
+
+// node returns the node at key as it stands, or nil if there is none.
+func (state *txnState) node(key string) *storeadapter.StoreNode {
+	if leaf := state.leaves[key]; leaf != nil {
+		return &storeadapter.StoreNode{Key: key, Value: leaf.Value, Index: uint64(leaf.ModRevision)}
+	}
+
+	if state.isDir(key) {
+		return &storeadapter.StoreNode{Key: key, Dir: true}
+	}
+
+	return nil
+}
+
+// apply plays the operations in order, returning the writes they add up to,
+// one per key. If an operation cannot be applied, it returns its key and why.
+func (state *txnState) apply(operations []storeadapter.TxnOp) ([]txnWrite, string, error) {
+	writes := []txnWrite{}
+	positions := map[string]int{}
+
+	for i, operation := range operations {
+		key := cleanKey(operation.Node.Key)
+		write := txnWrite{key: key, op: i}
+
+		switch operation.Type {
+		case storeadapter.PutOp:
+			if state.isDir(key) {
+				return nil, key, storeadapter.ErrorNodeIsDirectory
+			}
+
+			for _, parent := range ancestors(key) {
+				if state.leaves[parent] != nil {
+					return nil, key, storeadapter.ErrorNodeIsNotDirectory
+				}
+			}
+
+			if state.leaves[key] == nil {
+				state.countUnder(key, 1)
+			}
+
+			node := operation.Node
+			node.Key = key
+			state.leaves[key] = &mvccpb.KeyValue{Key: []byte(key), Value: node.Value}
+			write.node = &node
+
+		case storeadapter.DeleteOp:
+			if state.leaves[key] == nil {
+				if state.isDir(key) {
+					return nil, key, storeadapter.ErrorNodeIsDirectory
+				}
+				return nil, key, storeadapter.ErrorKeyNotFound
+			}
+
+			state.countUnder(key, -1)
+			state.leaves[key] = nil
+
+		default:
+			return nil, key, storeadapter.ErrorInvalidFormat
+		}
+
+		if position, ok := positions[key]; ok {
+			writes[position] = write
+		} else {
+			positions[key] = len(writes)
+			writes = append(writes, write)
+		}
+	}
+
+	// A key both created and deleted here is left as it was.
+	needed := []txnWrite{}
+	for _, write := range writes {
+		if write.node != nil || state.readRevisions[write.key] != 0 {
+			needed = append(needed, write)
+		}
+	}
+
+	return needed, "", nil
+}
+
+// countUnder adds delta to the count of keys under each directory above key.
+func (state *txnState) countUnder(key string, delta int64) {
+	for dirKey, dir := range state.dirs {
+		if dirKey != key && isUnder(key, dirKey) {
+			dir.count += delta
+		}
+	}
+}
+
+// guards returns the comparisons that hold as long as nothing the
+// transaction read has changed.
+func (state *txnState) guards() []clientv3.Cmp {
+	guards := []clientv3.Cmp{}
+
+	for key, revision := range state.readRevisions {
+		guards = append(guards, clientv3.Compare(clientv3.ModRevision(key), "=", revision))
+	}
+
+	for key, dir := range state.dirs {
+		if dir.readCount == 0 {
+			guards = append(guards, clientv3.Compare(clientv3.CreateRevision(dirPrefix(key)), "=", 0).WithPrefix())
+		} else {
+			guards = append(guards, clientv3.Compare(clientv3.CreateRevision(string(dir.readFirst.Key)), "=", dir.readFirst.CreateRevision))
+		}
+	}
+
+	return guards
+}
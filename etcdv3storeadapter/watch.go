@@ -0,0 +1,187 @@
+package etcdv3storeadapter
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cloudfoundry/storeadapter"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func (adapter *ETCDv3StoreAdapter) Watch(key string) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	return adapter.WatchContext(context.Background(), key)
+}
+
+// Watching starts at the revision the store is at when Watch returns, so no
+// later event is missed.
+func (adapter *ETCDv3StoreAdapter) WatchContext(ctx context.Context, key string) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	events := make(chan storeadapter.WatchEvent)
+	errs := make(chan error)
+	stop := make(chan bool, 1)
+
+	key = cleanKey(key)
+
+	requestCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	response, err := adapter.client.Get(requestCtx, dirPrefix(key), clientv3.WithCountOnly())
+	cancel()
+
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		err = &storeadapter.Error{Op: "Watch", Key: key, Err: storeadapter.ErrorTimeout, Cause: err}
+	}
+
+	if err != nil {
+		go func() {
+			defer close(events)
+			defer close(errs)
+
+			select {
+			case errs <- adapter.convertError("Watch", key, err):
+			case <-ctx.Done():
+			case <-adapter.ctx.Done():
+			}
+		}()
+
+		return events, stop, errs
+	}
+
+	go adapter.dispatchWatchEvents(ctx, key, response.Header.Revision+1, false, events, stop, errs)
+
+	return events, stop, errs
+}
+
+func (adapter *ETCDv3StoreAdapter) WatchFrom(key string, afterIndex uint64) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	return adapter.WatchFromContext(context.Background(), key, afterIndex)
+}
+
+func (adapter *ETCDv3StoreAdapter) WatchFromContext(ctx context.Context, key string, afterIndex uint64) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	events := make(chan storeadapter.WatchEvent)
+	errs := make(chan error)
+	stop := make(chan bool, 1)
+
+	go adapter.dispatchWatchEvents(ctx, cleanKey(key), int64(afterIndex)+1, true, events, stop, errs)
+
+	return events, stop, errs
+}
+
+// dispatchWatchEvents watches from revision. If the store has compacted the
+// revisions being waited for, a resumable watch reports
+// ErrorWatchIndexCleared and stops; any other watch carries on from the
+// current revision.
+func (adapter *ETCDv3StoreAdapter) dispatchWatchEvents(ctx context.Context, key string, revision int64, resumable bool, events chan<- storeadapter.WatchEvent, stop chan bool, errs chan<- error) {
+	defer close(events)
+	defer close(errs)
+
+	watchCtx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+		case <-adapter.ctx.Done():
+		case <-watchCtx.Done():
+		}
+		cancel()
+	}()
+
+	for {
+		responses := adapter.client.Watch(watchCtx, watchKey(key), watchOptions(key, revision)...)
+
+		for response := range responses {
+			if err := response.Err(); err != nil {
+				if errors.Is(err, rpctypes.ErrCompacted) && !resumable {
+					revision = 0
+					break
+				}
+
+				converted := adapter.convertError("Watch", key, err)
+				if storeErr, ok := converted.(*storeadapter.Error); ok && response.Header.Revision != 0 {
+					storeErr.Index = uint64(response.Header.Revision)
+				}
+
+				select {
+				case errs <- converted:
+				case <-watchCtx.Done():
+				}
+				return
+			}
+
+			leases := adapter.leaseTTLs(watchCtx)
+			for _, event := range response.Events {
+				if !isUnder(string(event.Kv.Key), key) {
+					continue
+				}
+
+				select {
+				case events <- makeWatchEvent(leases, event):
+				case <-watchCtx.Done():
+					return
+				}
+
+				revision = event.Kv.ModRevision + 1
+			}
+		}
+
+		if watchCtx.Err() != nil {
+			return
+		}
+	}
+}
+
+// watchKey and watchOptions watch the key itself and every key under it.
+// The range also covers keys that merely start with the key, such as "/a.b"
+// for "/a", which are filtered out.
+func watchKey(key string) string {
+	if key == "" {
+		return "/"
+	}
+
+	return key
+}
+
+func watchOptions(key string, revision int64) []clientv3.OpOption {
+	options := []clientv3.OpOption{clientv3.WithPrevKV()}
+
+	if key == "" {
+		options = append(options, clientv3.WithPrefix())
+	} else {
+		options = append(options, clientv3.WithRange(clientv3.GetPrefixRangeEnd(dirPrefix(key))))
+	}
+
+	if revision > 0 {
+		options = append(options, clientv3.WithRev(revision))
+	}
+
+	return options
+}
+
+// makeWatchEvent reports a deleted key whose lease has gone as having
+// expired, since etcd does not tell the two apart.
+func makeWatchEvent(leases *leaseTTLs, event *clientv3.Event) storeadapter.WatchEvent {
+	watchEvent := storeadapter.WatchEvent{
+		PrevNode: makeStoreNode(leases, event.PrevKv),
+		Index:    uint64(event.Kv.ModRevision),
+	}
+
+	switch {
+	case event.IsCreate():
+		watchEvent.Type = storeadapter.CreateEvent
+		watchEvent.Node = makeStoreNode(leases, event.Kv)
+
+	case event.IsModify():
+		watchEvent.Type = storeadapter.UpdateEvent
+		watchEvent.Node = makeStoreNode(leases, event.Kv)
+
+	default:
+		watchEvent.Type = storeadapter.DeleteEvent
+		if event.PrevKv != nil && leases.expired(event.PrevKv.Lease) {
+			watchEvent.Type = storeadapter.ExpireEvent
+		}
+
+		if watchEvent.PrevNode == nil {
+			watchEvent.PrevNode = &storeadapter.StoreNode{Key: string(event.Kv.Key)}
+		}
+	}
+
+	return watchEvent
+}
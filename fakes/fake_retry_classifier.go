@@ -0,0 +1,53 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry/storeadapter"
+)
+
+type FakeRetryClassifier struct {
+	ShouldRetryStub        func(error) bool
+	shouldRetryMutex       sync.RWMutex
+	shouldRetryArgsForCall []struct {
+		arg1 error
+	}
+	shouldRetryReturns struct {
+		result1 bool
+	}
+}
+
+func (fake *FakeRetryClassifier) ShouldRetry(arg1 error) bool {
+	fake.shouldRetryMutex.Lock()
+	fake.shouldRetryArgsForCall = append(fake.shouldRetryArgsForCall, struct {
+		arg1 error
+	}{arg1})
+	fake.shouldRetryMutex.Unlock()
+	if fake.ShouldRetryStub != nil {
+		return fake.ShouldRetryStub(arg1)
+	} else {
+		return fake.shouldRetryReturns.result1
+	}
+}
+
+func (fake *FakeRetryClassifier) ShouldRetryCallCount() int {
+	fake.shouldRetryMutex.RLock()
+	defer fake.shouldRetryMutex.RUnlock()
+	return len(fake.shouldRetryArgsForCall)
+}
+
+func (fake *FakeRetryClassifier) ShouldRetryArgsForCall(i int) error {
+	fake.shouldRetryMutex.RLock()
+	defer fake.shouldRetryMutex.RUnlock()
+	return fake.shouldRetryArgsForCall[i].arg1
+}
+
+func (fake *FakeRetryClassifier) ShouldRetryReturns(result1 bool) {
+	fake.ShouldRetryStub = nil
+	fake.shouldRetryReturns = struct {
+		result1 bool
+	}{result1}
+}
+
+var _ storeadapter.RetryClassifier = new(FakeRetryClassifier)
@@ -106,6 +106,15 @@ type FakeStoreAdapter struct {
 	compareAndDeleteByIndexReturns struct {
 		result1 error
 	}
+	TxnStub        func(comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error
+	txnMutex       sync.RWMutex
+	txnArgsForCall []struct {
+		comparisons []storeadapter.TxnCompare
+		operations  []storeadapter.TxnOp
+	}
+	txnReturns struct {
+		result1 error
+	}
 	UpdateDirTTLStub        func(key string, ttl uint64) error
 	updateDirTTLMutex       sync.RWMutex
 	updateDirTTLArgsForCall []struct {
@@ -125,6 +134,17 @@ type FakeStoreAdapter struct {
 		result2 chan<- bool
 		result3 <-chan error
 	}
+	WatchFromStub        func(key string, afterIndex uint64) (events <-chan storeadapter.WatchEvent, stop chan<- bool, errors <-chan error)
+	watchFromMutex       sync.RWMutex
+	watchFromArgsForCall []struct {
+		key        string
+		afterIndex uint64
+	}
+	watchFromReturns struct {
+		result1 <-chan storeadapter.WatchEvent
+		result2 chan<- bool
+		result3 <-chan error
+	}
 	DisconnectStub        func() error
 	disconnectMutex       sync.RWMutex
 	disconnectArgsForCall []struct{}
@@ -141,6 +161,27 @@ type FakeStoreAdapter struct {
 		result2 chan chan bool
 		result3 error
 	}
+	MaintainNodeWithTokenStub        func(storeNode storeadapter.StoreNode) (status <-chan storeadapter.NodeStatus, releaseNode chan chan bool, err error)
+	maintainNodeWithTokenMutex       sync.RWMutex
+	maintainNodeWithTokenArgsForCall []struct {
+		storeNode storeadapter.StoreNode
+	}
+	maintainNodeWithTokenReturns struct {
+		result1 <-chan storeadapter.NodeStatus
+		result2 chan chan bool
+		result3 error
+	}
+	MaintainNodeWithOptionsStub        func(storeNode storeadapter.StoreNode, options storeadapter.MaintainOptions) (status <-chan storeadapter.NodeStatus, releaseNode chan chan bool, err error)
+	maintainNodeWithOptionsMutex       sync.RWMutex
+	maintainNodeWithOptionsArgsForCall []struct {
+		storeNode storeadapter.StoreNode
+		options   storeadapter.MaintainOptions
+	}
+	maintainNodeWithOptionsReturns struct {
+		result1 <-chan storeadapter.NodeStatus
+		result2 chan chan bool
+		result3 error
+	}
 }
 
 func (fake *FakeStoreAdapter) Connect() error {
@@ -523,6 +564,39 @@ func (fake *FakeStoreAdapter) CompareAndDeleteByIndexReturns(result1 error) {
 	}{result1}
 }
 
+func (fake *FakeStoreAdapter) Txn(comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	fake.txnMutex.Lock()
+	fake.txnArgsForCall = append(fake.txnArgsForCall, struct {
+		comparisons []storeadapter.TxnCompare
+		operations  []storeadapter.TxnOp
+	}{comparisons, operations})
+	fake.txnMutex.Unlock()
+	if fake.TxnStub != nil {
+		return fake.TxnStub(comparisons, operations)
+	} else {
+		return fake.txnReturns.result1
+	}
+}
+
+func (fake *FakeStoreAdapter) TxnCallCount() int {
+	fake.txnMutex.RLock()
+	defer fake.txnMutex.RUnlock()
+	return len(fake.txnArgsForCall)
+}
+
+func (fake *FakeStoreAdapter) TxnArgsForCall(i int) ([]storeadapter.TxnCompare, []storeadapter.TxnOp) {
+	fake.txnMutex.RLock()
+	defer fake.txnMutex.RUnlock()
+	return fake.txnArgsForCall[i].comparisons, fake.txnArgsForCall[i].operations
+}
+
+func (fake *FakeStoreAdapter) TxnReturns(result1 error) {
+	fake.TxnStub = nil
+	fake.txnReturns = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeStoreAdapter) UpdateDirTTL(key string, ttl uint64) error {
 	fake.updateDirTTLMutex.Lock()
 	fake.updateDirTTLArgsForCall = append(fake.updateDirTTLArgsForCall, struct {
@@ -590,6 +664,41 @@ func (fake *FakeStoreAdapter) WatchReturns(result1 <-chan storeadapter.WatchEven
 	}{result1, result2, result3}
 }
 
+func (fake *FakeStoreAdapter) WatchFrom(key string, afterIndex uint64) (events <-chan storeadapter.WatchEvent, stop chan<- bool, errors <-chan error) {
+	fake.watchFromMutex.Lock()
+	fake.watchFromArgsForCall = append(fake.watchFromArgsForCall, struct {
+		key        string
+		afterIndex uint64
+	}{key, afterIndex})
+	fake.watchFromMutex.Unlock()
+	if fake.WatchFromStub != nil {
+		return fake.WatchFromStub(key, afterIndex)
+	} else {
+		return fake.watchFromReturns.result1, fake.watchFromReturns.result2, fake.watchFromReturns.result3
+	}
+}
+
+func (fake *FakeStoreAdapter) WatchFromCallCount() int {
+	fake.watchFromMutex.RLock()
+	defer fake.watchFromMutex.RUnlock()
+	return len(fake.watchFromArgsForCall)
+}
+
+func (fake *FakeStoreAdapter) WatchFromArgsForCall(i int) (string, uint64) {
+	fake.watchFromMutex.RLock()
+	defer fake.watchFromMutex.RUnlock()
+	return fake.watchFromArgsForCall[i].key, fake.watchFromArgsForCall[i].afterIndex
+}
+
+func (fake *FakeStoreAdapter) WatchFromReturns(result1 <-chan storeadapter.WatchEvent, result2 chan<- bool, result3 <-chan error) {
+	fake.WatchFromStub = nil
+	fake.watchFromReturns = struct {
+		result1 <-chan storeadapter.WatchEvent
+		result2 chan<- bool
+		result3 <-chan error
+	}{result1, result2, result3}
+}
+
 func (fake *FakeStoreAdapter) Disconnect() error {
 	fake.disconnectMutex.Lock()
 	fake.disconnectArgsForCall = append(fake.disconnectArgsForCall, struct{}{})
@@ -648,4 +757,73 @@ func (fake *FakeStoreAdapter) MaintainNodeReturns(result1 <-chan bool, result2 c
 	}{result1, result2, result3}
 }
 
+func (fake *FakeStoreAdapter) MaintainNodeWithToken(storeNode storeadapter.StoreNode) (status <-chan storeadapter.NodeStatus, releaseNode chan chan bool, err error) {
+	fake.maintainNodeWithTokenMutex.Lock()
+	fake.maintainNodeWithTokenArgsForCall = append(fake.maintainNodeWithTokenArgsForCall, struct {
+		storeNode storeadapter.StoreNode
+	}{storeNode})
+	fake.maintainNodeWithTokenMutex.Unlock()
+	if fake.MaintainNodeWithTokenStub != nil {
+		return fake.MaintainNodeWithTokenStub(storeNode)
+	} else {
+		return fake.maintainNodeWithTokenReturns.result1, fake.maintainNodeWithTokenReturns.result2, fake.maintainNodeWithTokenReturns.result3
+	}
+}
+
+func (fake *FakeStoreAdapter) MaintainNodeWithTokenCallCount() int {
+	fake.maintainNodeWithTokenMutex.RLock()
+	defer fake.maintainNodeWithTokenMutex.RUnlock()
+	return len(fake.maintainNodeWithTokenArgsForCall)
+}
+
+func (fake *FakeStoreAdapter) MaintainNodeWithTokenArgsForCall(i int) storeadapter.StoreNode {
+	fake.maintainNodeWithTokenMutex.RLock()
+	defer fake.maintainNodeWithTokenMutex.RUnlock()
+	return fake.maintainNodeWithTokenArgsForCall[i].storeNode
+}
+
+func (fake *FakeStoreAdapter) MaintainNodeWithTokenReturns(result1 <-chan storeadapter.NodeStatus, result2 chan chan bool, result3 error) {
+	fake.MaintainNodeWithTokenStub = nil
+	fake.maintainNodeWithTokenReturns = struct {
+		result1 <-chan storeadapter.NodeStatus
+		result2 chan chan bool
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeStoreAdapter) MaintainNodeWithOptions(storeNode storeadapter.StoreNode, options storeadapter.MaintainOptions) (status <-chan storeadapter.NodeStatus, releaseNode chan chan bool, err error) {
+	fake.maintainNodeWithOptionsMutex.Lock()
+	fake.maintainNodeWithOptionsArgsForCall = append(fake.maintainNodeWithOptionsArgsForCall, struct {
+		storeNode storeadapter.StoreNode
+		options   storeadapter.MaintainOptions
+	}{storeNode, options})
+	fake.maintainNodeWithOptionsMutex.Unlock()
+	if fake.MaintainNodeWithOptionsStub != nil {
+		return fake.MaintainNodeWithOptionsStub(storeNode, options)
+	} else {
+		return fake.maintainNodeWithOptionsReturns.result1, fake.maintainNodeWithOptionsReturns.result2, fake.maintainNodeWithOptionsReturns.result3
+	}
+}
+
+func (fake *FakeStoreAdapter) MaintainNodeWithOptionsCallCount() int {
+	fake.maintainNodeWithOptionsMutex.RLock()
+	defer fake.maintainNodeWithOptionsMutex.RUnlock()
+	return len(fake.maintainNodeWithOptionsArgsForCall)
+}
+
+func (fake *FakeStoreAdapter) MaintainNodeWithOptionsArgsForCall(i int) (storeadapter.StoreNode, storeadapter.MaintainOptions) {
+	fake.maintainNodeWithOptionsMutex.RLock()
+	defer fake.maintainNodeWithOptionsMutex.RUnlock()
+	return fake.maintainNodeWithOptionsArgsForCall[i].storeNode, fake.maintainNodeWithOptionsArgsForCall[i].options
+}
+
+func (fake *FakeStoreAdapter) MaintainNodeWithOptionsReturns(result1 <-chan storeadapter.NodeStatus, result2 chan chan bool, result3 error) {
+	fake.MaintainNodeWithOptionsStub = nil
+	fake.maintainNodeWithOptionsReturns = struct {
+		result1 <-chan storeadapter.NodeStatus
+		result2 chan chan bool
+		result3 error
+	}{result1, result2, result3}
+}
+
 var _ storeadapter.StoreAdapter = new(FakeStoreAdapter)
@@ -0,0 +1,154 @@
+package fakestoreadapter
+
+import (
+	"math/rand"
+	"regexp"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+// FakeStoreAdapterErrorInjector fails the calls it is set on whose key
+// matches KeyRegexp. By default every matching call fails with Error; the
+// remaining fields narrow down which calls fail, and with what.
+type FakeStoreAdapterErrorInjector struct {
+	KeyRegexp *regexp.Regexp
+	Error     error
+
+	// Errors, if set, are returned in turn by the matching calls instead of
+	// Error. A nil entry lets its call succeed, and once they run out every
+	// call succeeds.
+	Errors []error
+
+	// Times, if positive, is how many matching calls fail before the rest
+	// succeed.
+	Times int
+
+	// Probability, if positive, is the chance that a matching call fails, as
+	// drawn from Rand, or from math/rand if Rand is nil.
+	Probability float64
+	Rand        *rand.Rand
+
+	// Latency delays every matching call, on the adapter's clock, whether it
+	// fails or not. The adapter is not locked while a call is delayed.
+	Latency time.Duration
+
+	mutex sync.Mutex
+	calls int
+}
+
+func NewFakeStoreAdapterErrorInjector(keyRegexp string, err error) *FakeStoreAdapterErrorInjector {
+	return &FakeStoreAdapterErrorInjector{
+		KeyRegexp: regexp.MustCompile(keyRegexp),
+		Error:     err,
+	}
+}
+
+// NewFakeStoreAdapterErrorSequence returns an injector whose matching calls
+// fail with each of errs in turn, and then succeed. A nil error lets its call
+// succeed.
+func NewFakeStoreAdapterErrorSequence(keyRegexp string, errs ...error) *FakeStoreAdapterErrorInjector {
+	return &FakeStoreAdapterErrorInjector{
+		KeyRegexp: regexp.MustCompile(keyRegexp),
+		Errors:    errs,
+	}
+}
+
+// FailTimes makes only the first n matching calls fail.
+func (injector *FakeStoreAdapterErrorInjector) FailTimes(n int) *FakeStoreAdapterErrorInjector {
+	injector.Times = n
+	return injector
+}
+
+// FailWithProbability makes each matching call fail with probability p, drawn
+// from a source seeded with seed, so that a test sees the same failures on
+// every run.
+func (injector *FakeStoreAdapterErrorInjector) FailWithProbability(p float64, seed int64) *FakeStoreAdapterErrorInjector {
+	injector.Probability = p
+	injector.Rand = rand.New(rand.NewSource(seed))
+	return injector
+}
+
+// WithLatency delays every matching call by latency.
+func (injector *FakeStoreAdapterErrorInjector) WithLatency(latency time.Duration) *FakeStoreAdapterErrorInjector {
+	injector.Latency = latency
+	return injector
+}
+
+// Calls returns how many matching calls the injector has seen.
+func (injector *FakeStoreAdapterErrorInjector) Calls() int {
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+
+	return injector.calls
+}
+
+// errorFor is own.errorFor, unless own is nil. The call then fails as the
+// fallbacks say: the injectors of the operations the method is built on, which
+// were the only ones that could fail it before it had its own injector.
+func errorFor(key string, own *FakeStoreAdapterErrorInjector, fallbacks ...*FakeStoreAdapterErrorInjector) error {
+	if own != nil {
+		return own.errorFor(key)
+	}
+
+	for _, injector := range fallbacks {
+		if err := injector.errorFor(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// errorFor counts a call about key, returning the error it should fail with,
+// if any.
+func (injector *FakeStoreAdapterErrorInjector) errorFor(key string) error {
+	if injector == nil || !injector.KeyRegexp.MatchString(key) {
+		return nil
+	}
+
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+
+	call := injector.calls
+	injector.calls++
+
+	if injector.Times > 0 && call >= injector.Times {
+		return nil
+	}
+
+	if injector.Probability > 0 && injector.draw() >= injector.Probability {
+		return nil
+	}
+
+	if len(injector.Errors) > 0 {
+		if call >= len(injector.Errors) {
+			return nil
+		}
+		return injector.Errors[call]
+	}
+
+	return injector.Error
+}
+
+func (injector *FakeStoreAdapterErrorInjector) draw() float64 {
+	if injector.Rand != nil {
+		return injector.Rand.Float64()
+	}
+	return rand.Float64()
+}
+
+// delay waits out the injector's latency if any of keys matches.
+func (injector *FakeStoreAdapterErrorInjector) delay(clock clock.Clock, keys ...string) {
+	if injector == nil || injector.Latency <= 0 {
+		return
+	}
+
+	for _, key := range keys {
+		if injector.KeyRegexp.MatchString(key) {
+			clock.Sleep(injector.Latency)
+			return
+		}
+	}
+}
@@ -1,31 +1,34 @@
 package fakestoreadapter
 
 import (
-	"regexp"
+	"context"
 	"strings"
 	"sync"
 	"time"
 
+	"code.cloudfoundry.org/clock"
 	"github.com/cloudfoundry/storeadapter"
 )
 
 type containerNode struct {
-	dir   bool
-	nodes map[string]*containerNode
+	dir     bool
+	nodes   map[string]*containerNode
+	expires time.Time
 
 	storeNode storeadapter.StoreNode
 }
 
-type FakeStoreAdapterErrorInjector struct {
-	KeyRegexp *regexp.Regexp
-	Error     error
-}
+// report returns the node as the store reports it at now, with the time it
+// has left to live as its TTL.
+func (node *containerNode) report(now time.Time) storeadapter.StoreNode {
+	storeNode := node.storeNode
+	storeNode.TTL = 0
 
-func NewFakeStoreAdapterErrorInjector(keyRegexp string, err error) *FakeStoreAdapterErrorInjector {
-	return &FakeStoreAdapterErrorInjector{
-		KeyRegexp: regexp.MustCompile(keyRegexp),
-		Error:     err,
+	if !node.expires.IsZero() {
+		storeNode.TTL = uint64((node.expires.Sub(now) + time.Second - 1) / time.Second)
 	}
+
+	return storeNode
 }
 
 type FakeStoreAdapter struct {
@@ -40,29 +43,85 @@ type FakeStoreAdapter struct {
 	DeleteErrInjector *FakeStoreAdapterErrorInjector
 	CreateErrInjector *FakeStoreAdapterErrorInjector
 
-	WatchErrChannel chan error
+	// Injectors for the remaining methods. Connect and Disconnect are matched
+	// against the empty key; the ByIndex and option variants of a method share
+	// its injector. While a write's own injector is nil, it is failed by the
+	// Get, Set and Delete injectors of the operations it is made of instead.
+	ConnectErrInjector          *FakeStoreAdapterErrorInjector
+	DisconnectErrInjector       *FakeStoreAdapterErrorInjector
+	UpdateErrInjector           *FakeStoreAdapterErrorInjector
+	CompareAndSwapErrInjector   *FakeStoreAdapterErrorInjector
+	DeleteLeavesErrInjector     *FakeStoreAdapterErrorInjector
+	CompareAndDeleteErrInjector *FakeStoreAdapterErrorInjector
+	TxnErrInjector              *FakeStoreAdapterErrorInjector
+	UpdateDirTTLErrInjector     *FakeStoreAdapterErrorInjector
+	WatchErrInjector            *FakeStoreAdapterErrorInjector
+	MaintainNodeErrInjector     *FakeStoreAdapterErrorInjector
 
-	rootNode *containerNode
+	WatchErrChannel chan error
 
-	maintainedNodeName   string
-	MaintainedNodeValue  []byte
-	MaintainNodeError    error
-	MaintainNodeStatus   chan bool
-	releaseNodeChannel   chan chan bool
-	OnReleaseNodeChannel func(chan chan bool)
+	// While set, every call is recorded in a journal. See Operations.
+	RecordOperations bool
+	journal          []Operation
 
-	eventChannel chan storeadapter.WatchEvent
-	sendEvents   bool
+	rootNode *containerNode
+	index    uint64
+
+	clock clock.Clock
+
+	// done is closed on Disconnect or Reset, ending the watches and the TTL
+	// timers.
+	done     chan struct{}
+	watchers map[*fakeWatcher]bool
+
+	// The latest events, for WatchFrom, which can resume from any index from
+	// historyStart on.
+	history      []storeadapter.WatchEvent
+	historyStart uint64
+
+	// While set, MaintainNode and its variants contend for the node as a real
+	// store does, instead of reporting the statuses sent on MaintainNodeStatus
+	// and MaintainNodeTokens. The first contender writes the node, without a
+	// TTL, and the rest wait until it releases the node, the node is deleted,
+	// or ExpireMaintainedNode takes it away.
+	SimulateMaintainNode bool
+	maintainers          map[string][]*fakeMaintainer
+	maintainerCount      int
+
+	maintainedNodeName    string
+	MaintainedNodeValue   []byte
+	MaintainNodeError     error
+	MaintainNodeStatus    chan bool
+	MaintainNodeTokens    chan storeadapter.NodeStatus
+	MaintainedNodeOptions storeadapter.MaintainOptions
+	releaseNodeChannel    chan chan bool
+	OnReleaseNodeChannel  func(chan chan bool)
+
+	bufferEvents bool
+	txnEvents    []storeadapter.WatchEvent
 	sync.Mutex
 }
 
 func New() *FakeStoreAdapter {
-	adapter := &FakeStoreAdapter{}
+	return NewWithClock(clock.NewClock())
+}
+
+// NewWithClock returns a FakeStoreAdapter whose TTLs run on the given clock, so
+// that a fake clock can expire nodes on demand.
+func NewWithClock(clock clock.Clock) *FakeStoreAdapter {
+	adapter := &FakeStoreAdapter{clock: clock}
 	adapter.Reset()
 	return adapter
 }
 
 func (adapter *FakeStoreAdapter) Reset() {
+	if adapter.done != nil && !adapter.DidDisconnect {
+		close(adapter.done)
+	}
+	adapter.done = make(chan struct{})
+	adapter.watchers = make(map[*fakeWatcher]bool)
+	adapter.WatchErrChannel = make(chan error, 1)
+
 	adapter.DidConnect = false
 	adapter.DidDisconnect = false
 
@@ -73,15 +132,31 @@ func (adapter *FakeStoreAdapter) Reset() {
 	adapter.ListErrInjector = nil
 	adapter.DeleteErrInjector = nil
 	adapter.CreateErrInjector = nil
+	adapter.ConnectErrInjector = nil
+	adapter.DisconnectErrInjector = nil
+	adapter.UpdateErrInjector = nil
+	adapter.CompareAndSwapErrInjector = nil
+	adapter.DeleteLeavesErrInjector = nil
+	adapter.CompareAndDeleteErrInjector = nil
+	adapter.TxnErrInjector = nil
+	adapter.UpdateDirTTLErrInjector = nil
+	adapter.WatchErrInjector = nil
+	adapter.MaintainNodeErrInjector = nil
+	adapter.SimulateMaintainNode = false
+	adapter.maintainers = make(map[string][]*fakeMaintainer)
 	adapter.MaintainNodeStatus = make(chan bool, 1)
+	adapter.MaintainNodeTokens = make(chan storeadapter.NodeStatus, 1)
 
 	adapter.rootNode = &containerNode{
 		dir:   true,
 		nodes: make(map[string]*containerNode),
 	}
+	adapter.index = 0
+	adapter.history = nil
+	adapter.historyStart = 0
 
-	adapter.sendEvents = false
-	adapter.eventChannel = make(chan storeadapter.WatchEvent)
+	adapter.RecordOperations = false
+	adapter.journal = nil
 }
 
 func (adapter *FakeStoreAdapter) GetMaintainedNodeName() string {
@@ -90,119 +165,214 @@ func (adapter *FakeStoreAdapter) GetMaintainedNodeName() string {
 	return adapter.maintainedNodeName
 }
 
-func (adapter *FakeStoreAdapter) Connect() error {
+func (adapter *FakeStoreAdapter) Connect() (err error) {
+	adapter.ConnectErrInjector.delay(adapter.clock, "")
+
+	adapter.Lock()
+	defer adapter.Unlock()
+	defer adapter.record("Connect", nil, nil, &err)
+
+	if err := adapter.ConnectErrInjector.errorFor(""); err != nil {
+		return err
+	}
+
 	adapter.DidConnect = true
 	return adapter.ConnectErr
 }
 
+// Disconnect ends every watch, returning once their channels are closed, and
+// closes WatchErrChannel.
 func (adapter *FakeStoreAdapter) Disconnect() error {
+	adapter.DisconnectErrInjector.delay(adapter.clock, "")
+
 	adapter.Lock()
-	defer adapter.Unlock()
+
+	if err := adapter.DisconnectErrInjector.errorFor(""); err != nil {
+		adapter.record("Disconnect", nil, nil, &err)
+		adapter.Unlock()
+		return err
+	}
 
 	if !adapter.DidDisconnect {
-		close(adapter.eventChannel)
+		close(adapter.done)
 		if adapter.WatchErrChannel != nil {
 			close(adapter.WatchErrChannel)
 		}
 	}
 
+	watchers := make([]*fakeWatcher, 0, len(adapter.watchers))
+	for w := range adapter.watchers {
+		watchers = append(watchers, w)
+	}
+
 	adapter.DidDisconnect = true
-	return adapter.DisconnectErr
-}
+	adapter.record("Disconnect", nil, nil, &adapter.DisconnectErr)
+	adapter.Unlock()
 
-func (adapter *FakeStoreAdapter) sendEvent(prevNode *storeadapter.StoreNode, node *storeadapter.StoreNode, eventType storeadapter.EventType) {
-	if adapter.sendEvents {
-		go func() {
-			adapter.Lock()
-			defer adapter.Unlock()
-			adapter.eventChannel <- storeadapter.WatchEvent{
-				Type:     eventType,
-				Node:     node,
-				PrevNode: prevNode,
-			}
-		}()
+	for _, w := range watchers {
+		<-w.stopped
 	}
+
+	return adapter.DisconnectErr
 }
 
-func (adapter *FakeStoreAdapter) SetMulti(nodes []storeadapter.StoreNode) error {
+// lock takes the adapter's lock, and expires every node whose TTL has run
+// out, so that no operation sees a node that should be gone.
+func (adapter *FakeStoreAdapter) lock() {
 	adapter.Lock()
+	adapter.expireNodes()
+}
+
+func (adapter *FakeStoreAdapter) SetMulti(nodes []storeadapter.StoreNode) (err error) {
+	adapter.SetErrInjector.delay(adapter.clock, nodeKeys(nodes)...)
+
+	adapter.lock()
 	defer adapter.Unlock()
+	defer adapter.record("SetMulti", nodeKeys(nodes), nodes, &err)
 
-	return adapter.setMulti(nodes)
-}
+	return adapter.fanOut(nodeKeys(nodes), func(i int) error {
+		if err := adapter.SetErrInjector.errorFor(nodes[i].Key); err != nil {
+			return err
+		}
 
-func (adapter *FakeStoreAdapter) setMulti(nodes []storeadapter.StoreNode) error {
-	var eventType storeadapter.EventType
+		return adapter.set(nodes[i], storeadapter.UpdateEvent)
+	})
+}
 
-	for _, node := range nodes {
-		prevNode, err := adapter.get(node.Key)
-		if err == nil {
-			eventType = storeadapter.UpdateEvent
-		} else {
-			eventType = storeadapter.CreateEvent
+// fanOut runs write for each key in turn, reporting the outcome for each in a
+// MultiError, with the index each successful write left its key at.
+func (adapter *FakeStoreAdapter) fanOut(keys []string, write func(i int) error) error {
+	results := make([]storeadapter.KeyResult, len(keys))
+	for i, key := range keys {
+		results[i] = storeadapter.KeyResult{Key: key, Err: write(i)}
+		if results[i].Err == nil {
+			results[i].Index = adapter.index
 		}
+	}
+
+	return storeadapter.NewMultiError(results)
+}
 
-		if adapter.SetErrInjector != nil && adapter.SetErrInjector.KeyRegexp.MatchString(node.Key) {
-			return adapter.SetErrInjector.Error
+func nodeKeys(nodes []storeadapter.StoreNode) []string {
+	keys := make([]string, len(nodes))
+	for i, node := range nodes {
+		keys[i] = node.Key
+	}
+
+	return keys
+}
+
+func txnKeys(operations []storeadapter.TxnOp) []string {
+	keys := make([]string, len(operations))
+	for i, operation := range operations {
+		keys[i] = operation.Node.Key
+	}
+
+	return keys
+}
+
+func txnNodes(operations []storeadapter.TxnOp) []storeadapter.StoreNode {
+	var nodes []storeadapter.StoreNode
+	for _, operation := range operations {
+		if operation.Type == storeadapter.PutOp {
+			nodes = append(nodes, operation.Node)
 		}
-		components := adapter.keyComponents(node.Key)
-
-		container := adapter.rootNode
-		for i, component := range components {
-			existingNode, exists := container.nodes[component]
-			if i == len(components)-1 {
-				if exists && existingNode.dir {
-					return storeadapter.ErrorNodeIsDirectory
+	}
+
+	return nodes
+}
+
+// set writes the leaf, reporting it with an event of eventType, and the leaf
+// it replaced, if any, as the event's PrevNode.
+func (adapter *FakeStoreAdapter) set(node storeadapter.StoreNode, eventType storeadapter.EventType) error {
+	var prevNode *storeadapter.StoreNode
+	if existing, err := adapter.walkToNode(node.Key); err == nil && !existing.dir {
+		reported := existing.report(adapter.clock.Now())
+		prevNode = &reported
+	}
+
+	components := adapter.keyComponents(node.Key)
+	index := adapter.index + 1
+
+	container := adapter.rootNode
+	for i, component := range components {
+		existingNode, exists := container.nodes[component]
+		if i == len(components)-1 {
+			if exists && existingNode.dir {
+				return storeadapter.ErrorNodeIsDirectory
+			}
+			node.Index = index
+			leaf := &containerNode{storeNode: node}
+			if node.TTL > 0 {
+				leaf.expires = adapter.expireAfter(node.TTL)
+			}
+			container.nodes[component] = leaf
+		} else {
+			if exists {
+				if !existingNode.dir {
+					return storeadapter.ErrorNodeIsNotDirectory
 				}
-				container.nodes[component] = &containerNode{storeNode: node}
+				container = existingNode
 			} else {
-				if exists {
-					if !existingNode.dir {
-						return storeadapter.ErrorNodeIsNotDirectory
-					}
-					container = existingNode
-				} else {
-					newContainer := &containerNode{dir: true, nodes: make(map[string]*containerNode)}
-					container.nodes[component] = newContainer
-					container = newContainer
+				newContainer := &containerNode{
+					dir:   true,
+					nodes: make(map[string]*containerNode),
+					storeNode: storeadapter.StoreNode{
+						Key:   "/" + strings.Join(components[:i+1], "/"),
+						Dir:   true,
+						Index: index,
+					},
 				}
+				container.nodes[component] = newContainer
+				container = newContainer
 			}
 		}
-
-		adapter.sendEvent(&prevNode, &node, eventType)
 	}
 
+	adapter.index = index
+
+	adapter.sendEvent(prevNode, &node, eventType)
+
 	return nil
 }
 
-func (adapter *FakeStoreAdapter) Create(node storeadapter.StoreNode) error {
-	adapter.Lock()
+func (adapter *FakeStoreAdapter) Create(node storeadapter.StoreNode) (err error) {
+	adapter.CreateErrInjector.delay(adapter.clock, node.Key)
+
+	adapter.lock()
 	defer adapter.Unlock()
+	defer adapter.record("Create", []string{node.Key}, []storeadapter.StoreNode{node}, &err)
 
-	if adapter.CreateErrInjector != nil && adapter.CreateErrInjector.KeyRegexp.MatchString(node.Key) {
-		return adapter.CreateErrInjector.Error
+	if err := errorFor(node.Key, adapter.CreateErrInjector, adapter.GetErrInjector, adapter.SetErrInjector); err != nil {
+		return err
 	}
 
-	_, err := adapter.get(node.Key)
+	_, err = adapter.get(node.Key)
 	if err == nil {
 		return storeadapter.ErrorKeyExists
 	}
 
-	return adapter.setMulti([]storeadapter.StoreNode{node})
+	return adapter.set(node, storeadapter.CreateEvent)
 }
 
-func (adapter *FakeStoreAdapter) Get(key string) (storeadapter.StoreNode, error) {
-	adapter.Lock()
+func (adapter *FakeStoreAdapter) Get(key string) (node storeadapter.StoreNode, err error) {
+	adapter.GetErrInjector.delay(adapter.clock, key)
+
+	adapter.lock()
 	defer adapter.Unlock()
+	defer adapter.record("Get", []string{key}, nil, &err)
+
+	if err := adapter.GetErrInjector.errorFor(key); err != nil {
+		return storeadapter.StoreNode{}, err
+	}
 
 	return adapter.get(key)
 }
 
+// get, like the other helpers the operations share, leaves the error
+// injectors to the operation, so that each fails only once, as its own
+// injector, or its fallbacks, say.
 func (adapter *FakeStoreAdapter) get(key string) (storeadapter.StoreNode, error) {
-	if adapter.GetErrInjector != nil && adapter.GetErrInjector.KeyRegexp.MatchString(key) {
-		return storeadapter.StoreNode{}, adapter.GetErrInjector.Error
-	}
-
 	container, err := adapter.walkToNode(key)
 	if err != nil {
 		return storeadapter.StoreNode{}, err
@@ -211,7 +381,7 @@ func (adapter *FakeStoreAdapter) get(key string) (storeadapter.StoreNode, error)
 	if container.dir {
 		return storeadapter.StoreNode{}, storeadapter.ErrorNodeIsDirectory
 	} else {
-		return container.storeNode, nil
+		return container.report(adapter.clock.Now()), nil
 	}
 }
 
@@ -228,12 +398,15 @@ func (adapter *FakeStoreAdapter) walkToNode(key string) (*containerNode, error)
 	return container, nil
 }
 
-func (adapter *FakeStoreAdapter) ListRecursively(key string) (storeadapter.StoreNode, error) {
-	adapter.Lock()
+func (adapter *FakeStoreAdapter) ListRecursively(key string) (node storeadapter.StoreNode, err error) {
+	adapter.ListErrInjector.delay(adapter.clock, key)
+
+	adapter.lock()
 	defer adapter.Unlock()
+	defer adapter.record("ListRecursively", []string{key}, nil, &err)
 
-	if adapter.ListErrInjector != nil && adapter.ListErrInjector.KeyRegexp.MatchString(key) {
-		return storeadapter.StoreNode{}, adapter.ListErrInjector.Error
+	if err := adapter.ListErrInjector.errorFor(key); err != nil {
+		return storeadapter.StoreNode{}, err
 	}
 
 	container, err := adapter.walkToNode(key)
@@ -245,7 +418,9 @@ func (adapter *FakeStoreAdapter) ListRecursively(key string) (storeadapter.Store
 		return storeadapter.StoreNode{}, storeadapter.ErrorNodeIsNotDirectory
 	}
 
-	return adapter.listContainerNode(key, container), nil
+	node = adapter.listContainerNode(key, container)
+	node.Index = adapter.index
+	return node, nil
 }
 
 func (adapter *FakeStoreAdapter) listContainerNode(key string, container *containerNode) storeadapter.StoreNode {
@@ -260,75 +435,124 @@ func (adapter *FakeStoreAdapter) listContainerNode(key string, container *contai
 			}
 			childNodes = append(childNodes, adapter.listContainerNode(nodeKey, node))
 		} else {
-			childNodes = append(childNodes, node.storeNode)
+			childNodes = append(childNodes, node.report(adapter.clock.Now()))
 		}
 	}
 
 	return storeadapter.StoreNode{
 		Key:        key,
 		Dir:        true,
+		Index:      container.storeNode.Index,
+		TTL:        container.report(adapter.clock.Now()).TTL,
 		ChildNodes: childNodes,
 	}
 }
 
-func (adapter *FakeStoreAdapter) Delete(keys ...string) error {
-	adapter.Lock()
+func (adapter *FakeStoreAdapter) Delete(keys ...string) (err error) {
+	adapter.DeleteErrInjector.delay(adapter.clock, keys...)
+
+	adapter.lock()
 	defer adapter.Unlock()
+	defer adapter.record("Delete", keys, nil, &err)
+
+	return adapter.fanOut(keys, func(i int) error {
+		if err := adapter.DeleteErrInjector.errorFor(keys[i]); err != nil {
+			return err
+		}
 
-	return adapter.deleteKeys(keys...)
+		return adapter.deleteKeys(keys[i])
+	})
 }
 
+// deleteKeys deletes each key, with everything under it, at an index of its
+// own.
 func (adapter *FakeStoreAdapter) deleteKeys(keys ...string) error {
 	for _, key := range keys {
-		node, _ := adapter.get(key)
+		adapter.index++
 
-		if adapter.DeleteErrInjector != nil && adapter.DeleteErrInjector.KeyRegexp.MatchString(key) {
-			return adapter.DeleteErrInjector.Error
+		err := adapter.deleteKey(key)
+		if err != nil {
+			adapter.index--
+			return err
 		}
+	}
 
-		components := adapter.keyComponents(key)
-		container := adapter.rootNode
-		parentNode := adapter.rootNode
-		for _, component := range components {
-			var exists bool
-			parentNode = container
-			container, exists = container.nodes[component]
-			if !exists {
-				return storeadapter.ErrorKeyNotFound
-			}
-		}
+	return nil
+}
 
-		leaf := parentNode.nodes[components[len(components)-1]]
-		if leaf.dir {
-			var keysToDelete []string
-			for key, _ := range leaf.nodes {
-				childKey := strings.Join(append(components, key), "/")
-				keysToDelete = append(keysToDelete, childKey)
-			}
-			adapter.deleteKeys(keysToDelete...)
+func (adapter *FakeStoreAdapter) deleteKey(key string) error {
+	components := adapter.keyComponents(key)
+	container := adapter.rootNode
+	parentNode := adapter.rootNode
+	for _, component := range components {
+		var exists bool
+		parentNode = container
+		container, exists = container.nodes[component]
+		if !exists {
+			return storeadapter.ErrorKeyNotFound
 		}
-
-		delete(parentNode.nodes, components[len(components)-1])
-		adapter.sendEvent(&node, nil, storeadapter.DeleteEvent)
 	}
 
+	adapter.removeNode(parentNode, components[len(components)-1], storeadapter.DeleteEvent)
+
 	return nil
 }
 
-func (adapter *FakeStoreAdapter) DeleteLeaves(keys ...string) error {
-	panic("not implemented")
+// removeNode removes the named node from its parent, with everything under
+// it, sending an event of eventType for each node removed.
+func (adapter *FakeStoreAdapter) removeNode(parentNode *containerNode, name string, eventType storeadapter.EventType) {
+	container := parentNode.nodes[name]
+	for childName := range container.nodes {
+		adapter.removeNode(container, childName, eventType)
+	}
+
+	prevNode := container.report(adapter.clock.Now())
+	delete(parentNode.nodes, name)
+	adapter.sendEvent(&prevNode, nil, eventType)
 }
 
-func (adapter *FakeStoreAdapter) CompareAndDelete(nodes ...storeadapter.StoreNode) error {
-	adapter.Lock()
+func (adapter *FakeStoreAdapter) DeleteLeaves(keys ...string) (err error) {
+	adapter.DeleteLeavesErrInjector.delay(adapter.clock, keys...)
+
+	adapter.lock()
 	defer adapter.Unlock()
+	defer adapter.record("DeleteLeaves", keys, nil, &err)
 
-	if len(nodes) != 1 {
-		panic("not implemented for zero/multiple nodes")
-	}
+	return adapter.fanOut(keys, func(i int) error {
+		if err := errorFor(keys[i], adapter.DeleteLeavesErrInjector, adapter.DeleteErrInjector); err != nil {
+			return err
+		}
 
-	node := nodes[0]
+		container, err := adapter.walkToNode(keys[i])
+		if err != nil {
+			return err
+		}
+
+		if container.dir && len(container.nodes) > 0 {
+			return storeadapter.ErrorDirectoryNotEmpty
+		}
+
+		return adapter.deleteKeys(keys[i])
+	})
+}
 
+func (adapter *FakeStoreAdapter) CompareAndDelete(nodes ...storeadapter.StoreNode) (err error) {
+	adapter.CompareAndDeleteErrInjector.delay(adapter.clock, nodeKeys(nodes)...)
+
+	adapter.lock()
+	defer adapter.Unlock()
+	defer adapter.record("CompareAndDelete", nodeKeys(nodes), nodes, &err)
+
+	return adapter.fanOut(nodeKeys(nodes), func(i int) error {
+		if err := errorFor(nodes[i].Key, adapter.CompareAndDeleteErrInjector, adapter.GetErrInjector, adapter.DeleteErrInjector); err != nil {
+			return err
+		}
+
+		return adapter.compareAndDelete(nodes[i])
+	})
+}
+
+func (adapter *FakeStoreAdapter) compareAndDelete(node storeadapter.StoreNode) error {
 	existingNode, err := adapter.get(node.Key)
 
 	if err != nil {
@@ -342,11 +566,151 @@ func (adapter *FakeStoreAdapter) CompareAndDelete(nodes ...storeadapter.StoreNod
 	return adapter.deleteKeys(node.Key)
 }
 
-func (adapter *FakeStoreAdapter) CompareAndDeleteByIndex(node ...storeadapter.StoreNode) error {
-	panic("not implemented")
+func (adapter *FakeStoreAdapter) CompareAndDeleteByIndex(nodes ...storeadapter.StoreNode) (err error) {
+	adapter.CompareAndDeleteErrInjector.delay(adapter.clock, nodeKeys(nodes)...)
+
+	adapter.lock()
+	defer adapter.Unlock()
+	defer adapter.record("CompareAndDeleteByIndex", nodeKeys(nodes), nodes, &err)
+
+	return adapter.fanOut(nodeKeys(nodes), func(i int) error {
+		if err := errorFor(nodes[i].Key, adapter.CompareAndDeleteErrInjector, adapter.GetErrInjector, adapter.DeleteErrInjector); err != nil {
+			return err
+		}
+
+		return adapter.compareAndDeleteByIndex(nodes[i])
+	})
+}
+
+func (adapter *FakeStoreAdapter) compareAndDeleteByIndex(node storeadapter.StoreNode) error {
+	existingNode, err := adapter.get(node.Key)
+
+	if err != nil {
+		return err
+	}
+
+	if node.Index != existingNode.Index {
+		return storeadapter.ErrorKeyComparisonFailed
+	}
+
+	return adapter.deleteKeys(node.Key)
+}
+
+func (adapter *FakeStoreAdapter) Txn(comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) (err error) {
+	adapter.TxnErrInjector.delay(adapter.clock, txnKeys(operations)...)
+
+	adapter.lock()
+	defer adapter.Unlock()
+	defer adapter.record("Txn", txnKeys(operations), txnNodes(operations), &err)
+
+	for _, operation := range operations {
+		fallbacks := []*FakeStoreAdapterErrorInjector{adapter.SetErrInjector}
+		if operation.Type == storeadapter.DeleteOp {
+			fallbacks = []*FakeStoreAdapterErrorInjector{adapter.GetErrInjector, adapter.DeleteErrInjector}
+		}
+
+		if err := errorFor(operation.Node.Key, adapter.TxnErrInjector, fallbacks...); err != nil {
+			return err
+		}
+	}
+
+	for _, comparison := range comparisons {
+		err := comparison.Check(adapter.lookup(comparison.Node.Key))
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshot := adapter.rootNode.clone()
+	snapshotIndex := adapter.index
+	adapter.bufferEvents = true
+	adapter.txnEvents = nil
+
+	err = adapter.applyTxnOps(operations)
+
+	adapter.bufferEvents = false
+	if err != nil {
+		adapter.rootNode = snapshot
+		adapter.index = snapshotIndex
+		adapter.txnEvents = nil
+		return err
+	}
+
+	for _, event := range adapter.txnEvents {
+		adapter.publish(event)
+	}
+	adapter.txnEvents = nil
+
+	return nil
+}
+
+func (adapter *FakeStoreAdapter) applyTxnOps(operations []storeadapter.TxnOp) error {
+	for _, operation := range operations {
+		var err error
+
+		switch operation.Type {
+		case storeadapter.PutOp:
+			eventType := storeadapter.UpdateEvent
+			if adapter.lookup(operation.Node.Key) == nil {
+				eventType = storeadapter.CreateEvent
+			}
+			err = adapter.set(operation.Node, eventType)
+		case storeadapter.DeleteOp:
+			_, err = adapter.get(operation.Node.Key)
+			if err == nil {
+				err = adapter.deleteKeys(operation.Node.Key)
+			}
+		default:
+			err = storeadapter.ErrorInvalidFormat
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (adapter *FakeStoreAdapter) UpdateDirTTL(key string, ttl uint64) error {
+// lookup returns the leaf or directory at key, or nil if there is none.
+func (adapter *FakeStoreAdapter) lookup(key string) *storeadapter.StoreNode {
+	container, err := adapter.walkToNode(key)
+	if err != nil {
+		return nil
+	}
+
+	node := container.report(adapter.clock.Now())
+	return &node
+}
+
+func (node *containerNode) clone() *containerNode {
+	clone := &containerNode{
+		dir:       node.dir,
+		expires:   node.expires,
+		storeNode: node.storeNode,
+	}
+
+	if node.nodes != nil {
+		clone.nodes = make(map[string]*containerNode, len(node.nodes))
+		for name, child := range node.nodes {
+			clone.nodes[name] = child.clone()
+		}
+	}
+
+	return clone
+}
+
+func (adapter *FakeStoreAdapter) UpdateDirTTL(key string, ttl uint64) (err error) {
+	adapter.UpdateDirTTLErrInjector.delay(adapter.clock, key)
+
+	adapter.lock()
+	defer adapter.Unlock()
+	defer adapter.record("UpdateDirTTL", []string{key}, nil, &err)
+
+	if err := adapter.UpdateDirTTLErrInjector.errorFor(key); err != nil {
+		return err
+	}
+
 	container, err := adapter.walkToNode(key)
 	if err != nil {
 		return err
@@ -355,21 +719,106 @@ func (adapter *FakeStoreAdapter) UpdateDirTTL(key string, ttl uint64) error {
 		return storeadapter.ErrorNodeIsNotDirectory
 	}
 
+	adapter.index++
+	container.storeNode.Index = adapter.index
+	container.expires = time.Time{}
+	if ttl > 0 {
+		container.expires = adapter.expireAfter(ttl)
+	}
+
+	return nil
+}
+
+// expireAfter returns when a node with the given TTL expires, and arranges for
+// it to be expired then, even if nothing else touches the store.
+func (adapter *FakeStoreAdapter) expireAfter(ttl uint64) time.Time {
+	duration := time.Duration(ttl) * time.Second
+	timer := adapter.clock.NewTimer(duration)
+	stop := adapter.done
+
 	go func() {
-		time.Sleep(time.Duration(ttl) * time.Second)
-		adapter.Delete(key)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C():
+			adapter.lock()
+			adapter.Unlock()
+		case <-stop:
+		}
 	}()
-	return nil
+
+	return adapter.clock.Now().Add(duration)
+}
+
+// expireNodes removes every node whose TTL has run out, each at an index of
+// its own, sending an ExpireEvent for it and everything under it.
+func (adapter *FakeStoreAdapter) expireNodes() {
+	adapter.expireUnder(adapter.rootNode, adapter.clock.Now())
 }
 
-func (adapter *FakeStoreAdapter) Update(node storeadapter.StoreNode) error {
-	panic("not implemented")
+func (adapter *FakeStoreAdapter) expireUnder(container *containerNode, now time.Time) {
+	for name, node := range container.nodes {
+		if !node.expires.IsZero() && !node.expires.After(now) {
+			adapter.index++
+			adapter.removeNode(container, name, storeadapter.ExpireEvent)
+		} else if node.dir {
+			adapter.expireUnder(node, now)
+		}
+	}
 }
 
-func (adapter *FakeStoreAdapter) CompareAndSwap(oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) error {
+// FastForwardTime takes seconds off the TTL of every node that has one, and
+// expires those that run out, as StoreRunner.FastForwardTime does.
+func (adapter *FakeStoreAdapter) FastForwardTime(seconds int) {
 	adapter.Lock()
 	defer adapter.Unlock()
 
+	adapter.fastForwardTime(adapter.rootNode, time.Duration(seconds)*time.Second)
+	adapter.expireNodes()
+}
+
+func (adapter *FakeStoreAdapter) fastForwardTime(container *containerNode, duration time.Duration) {
+	for _, node := range container.nodes {
+		if !node.expires.IsZero() {
+			node.expires = node.expires.Add(-duration)
+		}
+
+		if node.dir {
+			adapter.fastForwardTime(node, duration)
+		}
+	}
+}
+
+func (adapter *FakeStoreAdapter) Update(node storeadapter.StoreNode) (err error) {
+	adapter.UpdateErrInjector.delay(adapter.clock, node.Key)
+
+	adapter.lock()
+	defer adapter.Unlock()
+	defer adapter.record("Update", []string{node.Key}, []storeadapter.StoreNode{node}, &err)
+
+	if err := errorFor(node.Key, adapter.UpdateErrInjector, adapter.GetErrInjector, adapter.SetErrInjector); err != nil {
+		return err
+	}
+
+	_, err = adapter.get(node.Key)
+	if err != nil {
+		return err
+	}
+
+	return adapter.set(node, storeadapter.UpdateEvent)
+}
+
+func (adapter *FakeStoreAdapter) CompareAndSwap(oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) (err error) {
+	adapter.CompareAndSwapErrInjector.delay(adapter.clock, newNode.Key)
+
+	adapter.lock()
+	defer adapter.Unlock()
+	defer adapter.record("CompareAndSwap", []string{newNode.Key}, []storeadapter.StoreNode{oldNode, newNode}, &err)
+
+	if err := errorFor(newNode.Key, adapter.CompareAndSwapErrInjector, adapter.GetErrInjector, adapter.SetErrInjector); err != nil {
+		return err
+	}
+
 	existingNode, err := adapter.get(newNode.Key)
 
 	if err != nil {
@@ -380,22 +829,31 @@ func (adapter *FakeStoreAdapter) CompareAndSwap(oldNode storeadapter.StoreNode,
 		return storeadapter.ErrorKeyComparisonFailed
 	}
 
-	return adapter.setMulti([]storeadapter.StoreNode{newNode})
+	return adapter.set(newNode, storeadapter.UpdateEvent)
 }
 
-func (adapter *FakeStoreAdapter) CompareAndSwapByIndex(oldNodeIndex uint64, newNode storeadapter.StoreNode) error {
-	panic("not implemented")
-}
+func (adapter *FakeStoreAdapter) CompareAndSwapByIndex(oldNodeIndex uint64, newNode storeadapter.StoreNode) (err error) {
+	adapter.CompareAndSwapErrInjector.delay(adapter.clock, newNode.Key)
 
-func (adapter *FakeStoreAdapter) Watch(key string) (events <-chan storeadapter.WatchEvent, stop chan<- bool, errors <-chan error) {
-	adapter.Lock()
+	adapter.lock()
 	defer adapter.Unlock()
-	adapter.sendEvents = true
-	adapter.WatchErrChannel = make(chan error, 1)
+	defer adapter.record("CompareAndSwapByIndex", []string{newNode.Key}, []storeadapter.StoreNode{newNode}, &err)
 
-	// We haven't implemented stop yet
+	if err := errorFor(newNode.Key, adapter.CompareAndSwapErrInjector, adapter.GetErrInjector, adapter.SetErrInjector); err != nil {
+		return err
+	}
 
-	return adapter.eventChannel, nil, adapter.WatchErrChannel
+	existingNode, err := adapter.get(newNode.Key)
+
+	if err != nil {
+		return err
+	}
+
+	if oldNodeIndex != existingNode.Index {
+		return storeadapter.ErrorKeyComparisonFailed
+	}
+
+	return adapter.set(newNode, storeadapter.UpdateEvent)
 }
 
 func (adapter *FakeStoreAdapter) keyComponents(key string) (components []string) {
@@ -408,16 +866,198 @@ func (adapter *FakeStoreAdapter) keyComponents(key string) (components []string)
 	return components
 }
 
-func (adapter *FakeStoreAdapter) MaintainNode(storeNode storeadapter.StoreNode) (status <-chan bool, releaseNode chan chan bool, err error) {
-	adapter.Lock()
-	defer adapter.Unlock()
+func (adapter *FakeStoreAdapter) ConnectContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return adapter.Connect()
+}
+
+func (adapter *FakeStoreAdapter) CreateContext(ctx context.Context, node storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return adapter.Create(node)
+}
+
+func (adapter *FakeStoreAdapter) UpdateContext(ctx context.Context, node storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return adapter.Update(node)
+}
+
+func (adapter *FakeStoreAdapter) CompareAndSwapContext(ctx context.Context, oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return adapter.CompareAndSwap(oldNode, newNode)
+}
+
+func (adapter *FakeStoreAdapter) CompareAndSwapByIndexContext(ctx context.Context, oldNodeIndex uint64, newNode storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return adapter.CompareAndSwapByIndex(oldNodeIndex, newNode)
+}
+
+func (adapter *FakeStoreAdapter) SetMultiContext(ctx context.Context, nodes []storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return adapter.SetMulti(nodes)
+}
+
+func (adapter *FakeStoreAdapter) GetContext(ctx context.Context, key string) (storeadapter.StoreNode, error) {
+	if err := ctx.Err(); err != nil {
+		return storeadapter.StoreNode{}, err
+	}
+	return adapter.Get(key)
+}
+
+func (adapter *FakeStoreAdapter) ListRecursivelyContext(ctx context.Context, key string) (storeadapter.StoreNode, error) {
+	if err := ctx.Err(); err != nil {
+		return storeadapter.StoreNode{}, err
+	}
+	return adapter.ListRecursively(key)
+}
+
+func (adapter *FakeStoreAdapter) DeleteContext(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return adapter.Delete(keys...)
+}
+
+func (adapter *FakeStoreAdapter) DeleteLeavesContext(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return adapter.DeleteLeaves(keys...)
+}
+
+func (adapter *FakeStoreAdapter) CompareAndDeleteContext(ctx context.Context, nodes ...storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return adapter.CompareAndDelete(nodes...)
+}
+
+func (adapter *FakeStoreAdapter) CompareAndDeleteByIndexContext(ctx context.Context, nodes ...storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return adapter.CompareAndDeleteByIndex(nodes...)
+}
+
+func (adapter *FakeStoreAdapter) TxnContext(ctx context.Context, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return adapter.Txn(comparisons, operations)
+}
 
-	adapter.maintainedNodeName = storeNode.Key
-	adapter.MaintainedNodeValue = storeNode.Value
-	adapter.releaseNodeChannel = make(chan chan bool, 1)
-	if adapter.OnReleaseNodeChannel != nil {
-		go adapter.OnReleaseNodeChannel(adapter.releaseNodeChannel)
+func (adapter *FakeStoreAdapter) UpdateDirTTLContext(ctx context.Context, key string, ttl uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return adapter.UpdateDirTTL(key, ttl)
+}
+
+// WatchContext checks the context before attaching, and stops the watch once
+// the context is done.
+func (adapter *FakeStoreAdapter) WatchContext(ctx context.Context, key string) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	return adapter.watchContext(ctx, func() (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+		return adapter.Watch(key)
+	})
+}
+
+func (adapter *FakeStoreAdapter) watchContext(ctx context.Context, watch func() (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error)) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	if err := ctx.Err(); err != nil {
+		events := make(chan storeadapter.WatchEvent)
+		errors := make(chan error, 1)
+		errors <- err
+		close(events)
+		close(errors)
+		return events, make(chan bool, 1), errors
+	}
+
+	events, stop, errors := watch()
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				select {
+				case stop <- true:
+				default:
+				}
+			case <-adapter.done:
+			}
+		}()
+	}
+
+	return events, stop, errors
+}
+
+func (adapter *FakeStoreAdapter) WatchFromContext(ctx context.Context, key string, afterIndex uint64) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	return adapter.watchContext(ctx, func() (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+		return adapter.WatchFrom(key, afterIndex)
+	})
+}
+
+func (adapter *FakeStoreAdapter) MaintainNodeContext(ctx context.Context, storeNode storeadapter.StoreNode) (<-chan bool, chan chan bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	status, releaseNode, err := adapter.MaintainNode(storeNode)
+	if err == nil && ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			select {
+			case releaseNode <- nil:
+			default:
+			}
+		}()
+	}
+
+	return status, releaseNode, err
+}
+
+func (adapter *FakeStoreAdapter) MaintainNodeWithTokenContext(ctx context.Context, storeNode storeadapter.StoreNode) (<-chan storeadapter.NodeStatus, chan chan bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	status, releaseNode, err := adapter.MaintainNodeWithToken(storeNode)
+	if err == nil && ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			select {
+			case releaseNode <- nil:
+			default:
+			}
+		}()
+	}
+
+	return status, releaseNode, err
+}
+
+func (adapter *FakeStoreAdapter) MaintainNodeWithOptionsContext(ctx context.Context, storeNode storeadapter.StoreNode, options storeadapter.MaintainOptions) (<-chan storeadapter.NodeStatus, chan chan bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	status, releaseNode, err := adapter.MaintainNodeWithOptions(storeNode, options)
+	if err == nil && ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			select {
+			case releaseNode <- nil:
+			default:
+			}
+		}()
 	}
 
-	return adapter.MaintainNodeStatus, adapter.releaseNodeChannel, adapter.MaintainNodeError
+	return status, releaseNode, err
 }
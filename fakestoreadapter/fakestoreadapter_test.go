@@ -1,9 +1,12 @@
 package fakestoreadapter_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
+	"code.cloudfoundry.org/clock/fakeclock"
 	"github.com/cloudfoundry/storeadapter"
 	. "github.com/cloudfoundry/storeadapter/fakestoreadapter"
 	. "github.com/cloudfoundry/storeadapter/storenodematchers"
@@ -61,6 +64,209 @@ var _ = Describe("Fakestoreadapter", func() {
 		Expect(adapterInterface)
 	})
 
+	It("should satisfy the context interface", func() {
+		var adapterInterface storeadapter.ContextStoreAdapter
+		adapterInterface = adapter
+
+		Expect(adapterInterface)
+	})
+
+	Describe("with a context", func() {
+		Context("when the context is not done", func() {
+			It("should behave like the plain operation", func() {
+				value, err := adapter.GetContext(context.Background(), "/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(breakfastNode))
+			})
+		})
+
+		Context("when the context is done", func() {
+			var ctx context.Context
+
+			BeforeEach(func() {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(context.Background())
+				cancel()
+			})
+
+			It("should return the context's error without touching the store", func() {
+				err := adapter.SetMultiContext(ctx, []storeadapter.StoreNode{{Key: "/menu/breakfast", Value: []byte("crepes")}})
+				Expect(err).To(Equal(context.Canceled))
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(breakfastNode))
+			})
+
+			It("should report the context's error on the watch error channel", func() {
+				events, _, errs := adapter.WatchContext(ctx, "/menu")
+				Expect(errs).To(Receive(Equal(context.Canceled)))
+				Expect(events).To(BeClosed())
+			})
+		})
+
+		Context("when the context is done while maintaining a node", func() {
+			It("should release the node", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				_, releaseNode, err := adapter.MaintainNodeContext(ctx, storeadapter.StoreNode{Key: "/lock", TTL: 1})
+				Expect(err).NotTo(HaveOccurred())
+
+				cancel()
+				Eventually(releaseNode).Should(Receive())
+			})
+		})
+	})
+
+	Describe("Maintaining a node with a fencing token", func() {
+		It("should record the node and report the statuses sent on MaintainNodeTokens", func() {
+			status, releaseNode, err := adapter.MaintainNodeWithToken(storeadapter.StoreNode{Key: "/lock", Value: []byte("me"), TTL: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(releaseNode).NotTo(BeNil())
+			Expect(adapter.GetMaintainedNodeName()).To(Equal("/lock"))
+
+			adapter.MaintainNodeTokens <- storeadapter.NodeStatus{Owned: true, Token: 3}
+			Expect(status).To(Receive(Equal(storeadapter.NodeStatus{Owned: true, Token: 3})))
+		})
+	})
+
+	Describe("Maintaining a node with options", func() {
+		It("should record the options and report the statuses sent on MaintainNodeTokens", func() {
+			options := storeadapter.MaintainOptions{RefreshInterval: time.Second, StatusEvery: 1}
+			status, _, err := adapter.MaintainNodeWithOptions(storeadapter.StoreNode{Key: "/lock", TTL: 2}, options)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(adapter.GetMaintainedNodeName()).To(Equal("/lock"))
+			Expect(adapter.MaintainedNodeOptions).To(Equal(options))
+
+			adapter.MaintainNodeTokens <- storeadapter.NodeStatus{Owned: true, Token: 3}
+			Expect(status).To(Receive(Equal(storeadapter.NodeStatus{Owned: true, Token: 3})))
+		})
+	})
+
+	Describe("Simulating MaintainNode", func() {
+		var lockNode storeadapter.StoreNode
+
+		BeforeEach(func() {
+			adapter.SimulateMaintainNode = true
+			lockNode = storeadapter.StoreNode{Key: "/lock", Value: []byte("first"), TTL: 10}
+		})
+
+		It("writes the node for the first contender, with its index as the token", func() {
+			status, _, err := adapter.MaintainNodeWithToken(lockNode)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(status).Should(Receive(Equal(storeadapter.NodeStatus{Owned: true, Token: 6})))
+
+			node, err := adapter.Get("/lock")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node).To(MatchStoreNode(storeadapter.StoreNode{Key: "/lock", Value: []byte("first"), Index: 6}))
+		})
+
+		It("reports ownership on the channel MaintainNode returns", func() {
+			status, _, err := adapter.MaintainNode(lockNode)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(status).Should(Receive(BeTrue()))
+		})
+
+		It("blocks a second contender until the first releases the node", func() {
+			_, releaseFirst, err := adapter.MaintainNodeWithToken(lockNode)
+			Expect(err).NotTo(HaveOccurred())
+
+			second, _, err := adapter.MaintainNodeWithToken(storeadapter.StoreNode{Key: "/lock", Value: []byte("second")})
+			Expect(err).NotTo(HaveOccurred())
+			Consistently(second).ShouldNot(Receive())
+
+			released := make(chan bool)
+			releaseFirst <- released
+			Eventually(released).Should(BeClosed())
+
+			Eventually(second).Should(Receive(Equal(storeadapter.NodeStatus{Owned: true, Token: 8})))
+
+			node, err := adapter.Get("/lock")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Value).To(Equal([]byte("second")))
+		})
+
+		It("tells contenders that give no value apart", func() {
+			lockNode.Value = nil
+
+			first, _, _ := adapter.MaintainNodeWithToken(lockNode)
+			second, _, _ := adapter.MaintainNodeWithToken(lockNode)
+
+			Eventually(first).Should(Receive())
+			Consistently(second).ShouldNot(Receive())
+		})
+
+		It("makes the holder lose the node when it expires, handing it to the next contender", func() {
+			first, _, _ := adapter.MaintainNodeWithToken(lockNode)
+			Eventually(first).Should(Receive())
+
+			second, _, _ := adapter.MaintainNodeWithToken(storeadapter.StoreNode{Key: "/lock", Value: []byte("second")})
+			events, _, _ := adapter.Watch("/lock")
+
+			Expect(adapter.ExpireMaintainedNode("/lock")).To(Succeed())
+
+			Eventually(first).Should(Receive(Equal(storeadapter.NodeStatus{Owned: false})))
+			Eventually(second).Should(Receive(Equal(storeadapter.NodeStatus{Owned: true, Token: 8})))
+
+			var event storeadapter.WatchEvent
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(storeadapter.ExpireEvent))
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(storeadapter.CreateEvent))
+			Expect(event.Node.Value).To(Equal([]byte("second")))
+		})
+
+		It("lets a lone holder acquire the node again once it expires", func() {
+			status, _, _ := adapter.MaintainNodeWithToken(lockNode)
+			Eventually(status).Should(Receive())
+
+			Expect(adapter.ExpireMaintainedNode("/lock")).To(Succeed())
+
+			Eventually(status).Should(Receive(Equal(storeadapter.NodeStatus{Owned: false})))
+			Eventually(status).Should(Receive(Equal(storeadapter.NodeStatus{Owned: true, Token: 8})))
+		})
+
+		It("makes the holder lose the node when it is overwritten", func() {
+			status, _, _ := adapter.MaintainNodeWithToken(lockNode)
+			Eventually(status).Should(Receive())
+
+			err := adapter.SetMulti([]storeadapter.StoreNode{{Key: "/lock", Value: []byte("intruder")}})
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(status).Should(Receive(Equal(storeadapter.NodeStatus{Owned: false})))
+			Consistently(status).ShouldNot(Receive())
+
+			Expect(adapter.Delete("/lock")).To(Succeed())
+			Eventually(status).Should(Receive(Equal(storeadapter.NodeStatus{Owned: true, Token: 9})))
+		})
+
+		It("fails to expire a node nobody holds", func() {
+			Expect(adapter.ExpireMaintainedNode("/lock")).To(Equal(storeadapter.ErrorKeyNotFound))
+		})
+
+		It("reports MaintainNodeError instead of contending", func() {
+			adapter.MaintainNodeError = errors.New("oops")
+
+			status, releaseNode, err := adapter.MaintainNodeWithToken(lockNode)
+			Expect(err).To(Equal(adapter.MaintainNodeError))
+			Expect(status).To(BeNil())
+			Expect(releaseNode).To(BeNil())
+
+			_, err = adapter.Get("/lock")
+			Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+		})
+
+		It("closes the status channels on disconnect", func() {
+			status, _, _ := adapter.MaintainNodeWithToken(lockNode)
+			Eventually(status).Should(Receive())
+
+			adapter.Disconnect()
+			Eventually(status).Should(BeClosed())
+		})
+	})
+
 	Describe("Disconnecting", func() {
 		It("should set DidDisconnect to true", func() {
 			Expect(adapter.DidDisconnect).To(BeFalse())
@@ -76,6 +282,18 @@ var _ = Describe("Fakestoreadapter", func() {
 			adapter.Disconnect()
 			Expect(events).To(BeClosed())
 			Expect(errors).To(BeClosed())
+			Expect(adapter.WatchErrChannel).To(BeClosed())
+		})
+
+		It("should close the channels of every watch, without reporting an error", func() {
+			firstEvents, _, firstErrors := adapter.Watch("/menu")
+			secondEvents, _, secondErrors := adapter.WatchFrom("/foo", 0)
+
+			adapter.Disconnect()
+			Expect(firstEvents).To(BeClosed())
+			Expect(secondEvents).To(BeClosed())
+			Expect(firstErrors).NotTo(Receive(HaveOccurred()))
+			Expect(secondErrors).NotTo(Receive(HaveOccurred()))
 		})
 
 		It("should not panic when called multiple times", func() {
@@ -104,7 +322,7 @@ var _ = Describe("Fakestoreadapter", func() {
 
 				value, err := adapter.Get("/menu/dinner/third")
 				Expect(err).NotTo(HaveOccurred())
-				Expect(value).To(Equal(thirdCourseDinnerNode))
+				Expect(value).To(MatchStoreNode(thirdCourseDinnerNode))
 			})
 		})
 
@@ -129,11 +347,11 @@ var _ = Describe("Fakestoreadapter", func() {
 					Value: []byte("oops"),
 				}
 				err := adapter.SetMulti([]storeadapter.StoreNode{badMenu})
-				Expect(err).To(Equal(storeadapter.ErrorNodeIsDirectory))
+				Expect(err).To(MatchError(storeadapter.ErrorNodeIsDirectory))
 
 				value, err := adapter.Get("/menu/breakfast")
 				Expect(err).NotTo(HaveOccurred())
-				Expect(value).To(Equal(breakfastNode))
+				Expect(value).To(MatchStoreNode(breakfastNode))
 			})
 		})
 
@@ -144,11 +362,11 @@ var _ = Describe("Fakestoreadapter", func() {
 					Value: []byte("oops"),
 				}
 				err := adapter.SetMulti([]storeadapter.StoreNode{badBreakfast})
-				Expect(err).To(Equal(storeadapter.ErrorNodeIsNotDirectory))
+				Expect(err).To(MatchError(storeadapter.ErrorNodeIsNotDirectory))
 
 				value, err := adapter.Get("/menu/breakfast")
 				Expect(err).NotTo(HaveOccurred())
-				Expect(value).To(Equal(breakfastNode))
+				Expect(value).To(MatchStoreNode(breakfastNode))
 			})
 		})
 
@@ -163,7 +381,7 @@ var _ = Describe("Fakestoreadapter", func() {
 
 				value, err := adapter.Get("/menu/breakfast")
 				Expect(err).NotTo(HaveOccurred())
-				Expect(value).To(Equal(discerningBreakfastNode))
+				Expect(value).To(MatchStoreNode(discerningBreakfastNode))
 			})
 		})
 
@@ -175,12 +393,14 @@ var _ = Describe("Fakestoreadapter", func() {
 				}
 
 				err := adapter.SetMulti([]storeadapter.StoreNode{lessRandomNode})
-				Expect(err).To(Equal(errors.New("injected set error")))
+				Expect(err).To(Equal(&storeadapter.MultiError{
+					Results: []storeadapter.KeyResult{{Key: "/random", Err: errors.New("injected set error")}},
+				}))
 
 				adapter.GetErrInjector = nil
 				value, err := adapter.Get("/random")
 				Expect(err).NotTo(HaveOccurred())
-				Expect(value).To(Equal(randomNode))
+				Expect(value).To(MatchStoreNode(randomNode))
 			})
 		})
 	})
@@ -190,7 +410,7 @@ var _ = Describe("Fakestoreadapter", func() {
 			It("should return the node", func() {
 				value, err := adapter.Get("/menu/breakfast")
 				Expect(err).NotTo(HaveOccurred())
-				Expect(value).To(Equal(breakfastNode))
+				Expect(value).To(MatchStoreNode(breakfastNode))
 			})
 		})
 
@@ -227,7 +447,7 @@ var _ = Describe("Fakestoreadapter", func() {
 				Expect(value.Key).To(Equal("/"))
 				Expect(value.Dir).To(BeTrue())
 				Expect(value.ChildNodes).To(HaveLen(2))
-				Expect(value.ChildNodes).To(ContainElement(randomNode))
+				Expect(value.ChildNodes).To(ContainElement(MatchStoreNode(randomNode)))
 
 				var menuNode storeadapter.StoreNode
 				for _, node := range value.ChildNodes {
@@ -238,8 +458,8 @@ var _ = Describe("Fakestoreadapter", func() {
 				Expect(menuNode.Key).To(Equal("/menu"))
 				Expect(menuNode.Dir).To(BeTrue())
 				Expect(menuNode.ChildNodes).To(HaveLen(3))
-				Expect(menuNode.ChildNodes).To(ContainElement(breakfastNode))
-				Expect(menuNode.ChildNodes).To(ContainElement(lunchNode))
+				Expect(menuNode.ChildNodes).To(ContainElement(MatchStoreNode(breakfastNode)))
+				Expect(menuNode.ChildNodes).To(ContainElement(MatchStoreNode(lunchNode)))
 
 				var dinnerNode storeadapter.StoreNode
 				for _, node := range menuNode.ChildNodes {
@@ -250,8 +470,8 @@ var _ = Describe("Fakestoreadapter", func() {
 				Expect(dinnerNode.Key).To(Equal("/menu/dinner"))
 				Expect(dinnerNode.Dir).To(BeTrue())
 				Expect(dinnerNode.ChildNodes).To(HaveLen(2))
-				Expect(dinnerNode.ChildNodes).To(ContainElement(firstCourseDinnerNode))
-				Expect(dinnerNode.ChildNodes).To(ContainElement(secondCourseDinnerNode))
+				Expect(dinnerNode.ChildNodes).To(ContainElement(MatchStoreNode(firstCourseDinnerNode)))
+				Expect(dinnerNode.ChildNodes).To(ContainElement(MatchStoreNode(secondCourseDinnerNode)))
 			})
 		})
 
@@ -262,8 +482,8 @@ var _ = Describe("Fakestoreadapter", func() {
 				Expect(menuNode.Key).To(Equal("/menu"))
 				Expect(menuNode.Dir).To(BeTrue())
 				Expect(menuNode.ChildNodes).To(HaveLen(3))
-				Expect(menuNode.ChildNodes).To(ContainElement(breakfastNode))
-				Expect(menuNode.ChildNodes).To(ContainElement(lunchNode))
+				Expect(menuNode.ChildNodes).To(ContainElement(MatchStoreNode(breakfastNode)))
+				Expect(menuNode.ChildNodes).To(ContainElement(MatchStoreNode(lunchNode)))
 
 				var dinnerNode storeadapter.StoreNode
 				for _, node := range menuNode.ChildNodes {
@@ -274,8 +494,8 @@ var _ = Describe("Fakestoreadapter", func() {
 				Expect(dinnerNode.Key).To(Equal("/menu/dinner"))
 				Expect(dinnerNode.Dir).To(BeTrue())
 				Expect(dinnerNode.ChildNodes).To(HaveLen(2))
-				Expect(dinnerNode.ChildNodes).To(ContainElement(firstCourseDinnerNode))
-				Expect(dinnerNode.ChildNodes).To(ContainElement(secondCourseDinnerNode))
+				Expect(dinnerNode.ChildNodes).To(ContainElement(MatchStoreNode(firstCourseDinnerNode)))
+				Expect(dinnerNode.ChildNodes).To(ContainElement(MatchStoreNode(secondCourseDinnerNode)))
 			})
 		})
 
@@ -322,6 +542,23 @@ var _ = Describe("Fakestoreadapter", func() {
 		Context("when the key is missing", func() {
 			It("should return the key not found error", func() {
 				err := adapter.Delete("/not/a/key")
+				Expect(err).To(MatchError(storeadapter.ErrorKeyNotFound))
+			})
+
+			It("should still delete the keys that are present", func() {
+				err := adapter.Delete("/menu/breakfast", "/not/a/key", "/menu/lunch")
+				Expect(err).To(Equal(&storeadapter.MultiError{
+					Results: []storeadapter.KeyResult{
+						{Key: "/menu/breakfast", Index: 6},
+						{Key: "/not/a/key", Err: storeadapter.ErrorKeyNotFound},
+						{Key: "/menu/lunch", Index: 7},
+					},
+				}))
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+
+				_, err = adapter.Get("/menu/lunch")
 				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
 			})
 		})
@@ -364,11 +601,13 @@ var _ = Describe("Fakestoreadapter", func() {
 		Context("when the key matches the error injector", func() {
 			It("should return the injected error", func() {
 				err := adapter.Delete("/random")
-				Expect(err).To(Equal(errors.New("injected delete error")))
+				Expect(err).To(Equal(&storeadapter.MultiError{
+					Results: []storeadapter.KeyResult{{Key: "/random", Err: errors.New("injected delete error")}},
+				}))
 
 				value, err := adapter.Get("/menu/breakfast")
 				Expect(err).NotTo(HaveOccurred())
-				Expect(value).To(Equal(breakfastNode))
+				Expect(value).To(MatchStoreNode(breakfastNode))
 			})
 		})
 	})
@@ -393,9 +632,32 @@ var _ = Describe("Fakestoreadapter", func() {
 			}
 		})
 
+		Context("when passed no nodes", func() {
+			It("does nothing", func() {
+				Expect(adapter.CompareAndDelete()).To(Succeed())
+			})
+		})
+
 		Context("when passed multiple keys", func() {
-			It("Panics", func() {
-				Expect(func() { adapter.CompareAndDelete(nodeFoo, nodeBar) }).To(Panic())
+			BeforeEach(func() {
+				adapter.Create(nodeFoo)
+			})
+
+			It("deletes the nodes that match, and reports the others in a MultiError", func() {
+				err := adapter.CompareAndDelete(nodeFoo, storeadapter.StoreNode{Key: "/menu/lunch", Value: []byte("salad")})
+				Expect(err).To(Equal(&storeadapter.MultiError{
+					Results: []storeadapter.KeyResult{
+						{Key: "/foo", Index: 7},
+						{Key: "/menu/lunch", Err: storeadapter.ErrorKeyComparisonFailed},
+					},
+				}))
+
+				_, err = adapter.Get("/foo")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+
+				value, err := adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(lunchNode))
 			})
 		})
 
@@ -403,7 +665,7 @@ var _ = Describe("Fakestoreadapter", func() {
 			It("returns a KeyNotFound error", func() {
 
 				err := adapter.CompareAndDelete(nodeFoo)
-				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+				Expect(err).To(MatchError(storeadapter.ErrorKeyNotFound))
 			})
 		})
 
@@ -414,9 +676,9 @@ var _ = Describe("Fakestoreadapter", func() {
 
 			It("does NOT delete the existing node and returns a KeyComparisonFailed error", func() {
 				err := adapter.CompareAndDelete(nodeBar)
-				Expect(err).To(Equal(storeadapter.ErrorKeyComparisonFailed))
+				Expect(err).To(MatchError(storeadapter.ErrorKeyComparisonFailed))
 				node, _ := adapter.Get("/foo")
-				Expect(node).To(Equal(nodeFoo))
+				Expect(node).To(MatchStoreNode(nodeFoo))
 			})
 		})
 
@@ -434,6 +696,110 @@ var _ = Describe("Fakestoreadapter", func() {
 		})
 	})
 
+	Describe("Transactions", func() {
+		Context("when every comparison holds", func() {
+			It("applies all of the operations", func() {
+				err := adapter.Txn(
+					[]storeadapter.TxnCompare{
+						storeadapter.ValueEquals("/menu/breakfast", []byte("waffle")),
+						storeadapter.KeyMissing("/menu/brunch"),
+					},
+					[]storeadapter.TxnOp{
+						storeadapter.Put(storeadapter.StoreNode{Key: "/menu/brunch", Value: []byte("eggs")}),
+						storeadapter.DeleteKey("/menu/breakfast"),
+					},
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu/brunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(value.Value)).To(Equal("eggs"))
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+			})
+
+			It("sends an event per operation", func() {
+				events, _, _ := adapter.Watch("/menu")
+
+				err := adapter.Txn(nil, []storeadapter.TxnOp{
+					storeadapter.Put(storeadapter.StoreNode{Key: "/menu/brunch", Value: []byte("eggs")}),
+					storeadapter.Put(storeadapter.StoreNode{Key: "/menu/breakfast", Value: []byte("crepes")}),
+					storeadapter.DeleteKey("/menu/lunch"),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				var event storeadapter.WatchEvent
+				Eventually(events).Should(Receive(&event))
+				Expect(event.Type).To(Equal(storeadapter.CreateEvent))
+				Expect(event.Node.Key).To(Equal("/menu/brunch"))
+
+				Eventually(events).Should(Receive(&event))
+				Expect(event.Type).To(Equal(storeadapter.UpdateEvent))
+				Expect(event.Node.Key).To(Equal("/menu/breakfast"))
+
+				Eventually(events).Should(Receive(&event))
+				Expect(event.Type).To(Equal(storeadapter.DeleteEvent))
+			})
+		})
+
+		Context("when a comparison fails", func() {
+			It("returns the comparison's error and applies nothing", func() {
+				err := adapter.Txn(
+					[]storeadapter.TxnCompare{storeadapter.ValueEquals("/menu/breakfast", []byte("crepes"))},
+					[]storeadapter.TxnOp{storeadapter.DeleteKey("/menu/lunch")},
+				)
+				Expect(err).To(Equal(storeadapter.ErrorKeyComparisonFailed))
+
+				value, err := adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(lunchNode))
+			})
+		})
+
+		Context("when an operation fails part way through", func() {
+			It("rolls back the operations already applied", func() {
+				events, _, _ := adapter.Watch("/menu")
+
+				err := adapter.Txn(nil, []storeadapter.TxnOp{
+					storeadapter.Put(storeadapter.StoreNode{Key: "/menu/breakfast", Value: []byte("crepes")}),
+					storeadapter.DeleteKey("/menu/lunch"),
+					storeadapter.DeleteKey("/menu/elevenses"),
+				})
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(breakfastNode))
+
+				value, err = adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(lunchNode))
+
+				Consistently(events).ShouldNot(Receive())
+			})
+
+			It("returns injected errors", func() {
+				err := adapter.Txn(nil, []storeadapter.TxnOp{
+					storeadapter.DeleteKey("/menu/lunch"),
+					storeadapter.Put(storeadapter.StoreNode{Key: "/menu/random", Value: []byte("?")}),
+				})
+				Expect(err).To(Equal(errors.New("injected set error")))
+
+				value, err := adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(lunchNode))
+			})
+		})
+
+		Context("when deleting a directory", func() {
+			It("returns the node is directory error", func() {
+				err := adapter.Txn(nil, []storeadapter.TxnOp{storeadapter.DeleteKey("/menu/dinner")})
+				Expect(err).To(Equal(storeadapter.ErrorNodeIsDirectory))
+			})
+		})
+	})
+
 	Describe("Updating Dir TTL", func() {
 		It("should return a NotADirectory error if the key is not a directory", func() {
 			err := adapter.UpdateDirTTL("/menu/breakfast", 1)
@@ -453,84 +819,767 @@ var _ = Describe("Fakestoreadapter", func() {
 		})
 	})
 
-	Describe("Compare-and-Swapping", func() {
-		Context("when the key is missing", func() {
-			It("returns a KeyNotFound error", func() {
-				node := storeadapter.StoreNode{
-					Key:   "not/a/real/key",
-					Value: []byte("value"),
-				}
+	Describe("Expiring", func() {
+		var fakeClock *fakeclock.FakeClock
 
-				err := adapter.CompareAndSwap(node, node)
-				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
-			})
-		})
+		BeforeEach(func() {
+			fakeClock = fakeclock.NewFakeClock(time.Now())
+			adapter = NewWithClock(fakeClock)
 
-		Context("when the key is present", func() {
-			var (
-				nodeFoo storeadapter.StoreNode
-				nodeBar storeadapter.StoreNode
-			)
+			err := adapter.SetMulti([]storeadapter.StoreNode{breakfastNode, firstCourseDinnerNode})
+			Expect(err).NotTo(HaveOccurred())
+		})
 
+		Context("when a leaf has a TTL", func() {
 			BeforeEach(func() {
-				nodeFoo = storeadapter.StoreNode{
-					Key:   "/foo",
-					Value: []byte("foo"),
-					TTL:   1,
-				}
+				lunchNode.TTL = 10
+				err := adapter.SetMulti([]storeadapter.StoreNode{lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+			})
 
-				nodeBar = storeadapter.StoreNode{
-					Key:   "/foo",
-					Value: []byte("bar"),
-					TTL:   2,
-				}
+			It("should report the time it has left", func() {
+				fakeClock.Increment(3500 * time.Millisecond)
 
-				adapter.Create(nodeFoo)
+				value, err := adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value.TTL).To(BeEquivalentTo(7))
+
+				menuNode, err := adapter.ListRecursively("/menu")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(menuNode.ChildNodes).To(ContainElement(MatchStoreNode(storeadapter.StoreNode{
+					Key:   "/menu/lunch",
+					Value: []byte("burger"),
+					TTL:   7,
+				})))
 			})
 
-			Context("and the Value of oldNode is different", func() {
-				It("returns a KeyComparisonFailed error", func() {
-					err := adapter.CompareAndSwap(nodeBar, nodeBar)
-					Expect(err).To(Equal(storeadapter.ErrorKeyComparisonFailed))
-				})
+			It("should remove it once the clock runs past its TTL", func() {
+				fakeClock.Increment(9 * time.Second)
 
-				It("does not update the existing node", func() {
-					adapter.CompareAndSwap(nodeBar, nodeBar)
+				_, err := adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
 
-					retrievedNode, err := adapter.Get("/foo")
-					Expect(err).NotTo(HaveOccurred())
-					Expect(retrievedNode).To(MatchStoreNode(nodeFoo))
-				})
-			})
+				fakeClock.Increment(time.Second)
 
-			Context("and the Value of oldNode is identical", func() {
-				It("updates the node with the new node", func() {
-					err := adapter.CompareAndSwap(nodeFoo, nodeBar)
-					Expect(err).NotTo(HaveOccurred())
+				_, err = adapter.Get("/menu/lunch")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
 
-					retrievedNode, err := adapter.Get("/foo")
-					Expect(err).NotTo(HaveOccurred())
-					Expect(retrievedNode).To(MatchStoreNode(nodeBar))
-				})
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
 			})
-		})
-	})
 
-	Describe("Watching", func() {
-		Context("when a node under the key is created", func() {
-			It("sends an event with CreateEvent type and the node's value", func(done Done) {
-				events, _, _ := adapter.Watch("/foo")
+			It("should send an ExpireEvent without the store being touched", func() {
+				events, _, _ := adapter.Watch("/menu")
 
-				err := adapter.Create(storeadapter.StoreNode{
-					Key:   "/foo/a",
-					Value: []byte("new value"),
-				})
-				Expect(err).ToNot(HaveOccurred())
-				event := <-events
+				fakeClock.WaitForWatcherAndIncrement(10 * time.Second)
 
-				Expect(event.Type).To(Equal(storeadapter.CreateEvent))
-				Expect(event.Node.Key).To(Equal("/foo/a"))
-				Expect(string(event.Node.Value)).To(Equal("new value"))
+				var event storeadapter.WatchEvent
+				Eventually(events).Should(Receive(&event))
+				Expect(event.Type).To(Equal(storeadapter.ExpireEvent))
+				Expect(event.Node).To(BeNil())
+				Expect(event.PrevNode.Key).To(Equal("/menu/lunch"))
+			})
+
+			It("should not expire it once it has been written without a TTL", func() {
+				lunchNode.TTL = 0
+				err := adapter.SetMulti([]storeadapter.StoreNode{lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				fakeClock.Increment(time.Minute)
+
+				value, err := adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value.TTL).To(BeZero())
+			})
+		})
+
+		Context("when a directory has a TTL", func() {
+			BeforeEach(func() {
+				err := adapter.UpdateDirTTL("/menu/dinner", 5)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("should remove it, with everything under it, once the clock runs past its TTL", func() {
+				fakeClock.Increment(4 * time.Second)
+
+				dinnerNode, err := adapter.ListRecursively("/menu/dinner")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dinnerNode.TTL).To(BeEquivalentTo(1))
+
+				fakeClock.Increment(time.Second)
+
+				_, err = adapter.ListRecursively("/menu/dinner")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+
+				_, err = adapter.Get("/menu/dinner/first")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Describe("FastForwardTime", func() {
+			BeforeEach(func() {
+				lunchNode.TTL = 10
+				err := adapter.SetMulti([]storeadapter.StoreNode{lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.UpdateDirTTL("/menu/dinner", 5)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("should take the seconds off every TTL", func() {
+				adapter.FastForwardTime(3)
+
+				value, err := adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value.TTL).To(BeEquivalentTo(7))
+
+				dinnerNode, err := adapter.ListRecursively("/menu/dinner")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dinnerNode.TTL).To(BeEquivalentTo(2))
+			})
+
+			It("should expire the nodes that run out, sending an ExpireEvent for each", func() {
+				events, _, _ := adapter.Watch("/menu")
+
+				adapter.FastForwardTime(5)
+
+				_, err := adapter.ListRecursively("/menu/dinner")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+
+				_, err = adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+
+				// /menu/dinner, /menu/dinner/first
+				for i := 0; i < 2; i++ {
+					var event storeadapter.WatchEvent
+					Eventually(events).Should(Receive(&event))
+					Expect(event.Type).To(Equal(storeadapter.ExpireEvent))
+				}
+			})
+		})
+	})
+
+	Describe("Compare-and-Swapping", func() {
+		Context("when the key is missing", func() {
+			It("returns a KeyNotFound error", func() {
+				node := storeadapter.StoreNode{
+					Key:   "not/a/real/key",
+					Value: []byte("value"),
+				}
+
+				err := adapter.CompareAndSwap(node, node)
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+			})
+		})
+
+		Context("when the key is present", func() {
+			var (
+				nodeFoo storeadapter.StoreNode
+				nodeBar storeadapter.StoreNode
+			)
+
+			BeforeEach(func() {
+				nodeFoo = storeadapter.StoreNode{
+					Key:   "/foo",
+					Value: []byte("foo"),
+					TTL:   1,
+				}
+
+				nodeBar = storeadapter.StoreNode{
+					Key:   "/foo",
+					Value: []byte("bar"),
+					TTL:   2,
+				}
+
+				adapter.Create(nodeFoo)
+			})
+
+			Context("and the Value of oldNode is different", func() {
+				It("returns a KeyComparisonFailed error", func() {
+					err := adapter.CompareAndSwap(nodeBar, nodeBar)
+					Expect(err).To(Equal(storeadapter.ErrorKeyComparisonFailed))
+				})
+
+				It("does not update the existing node", func() {
+					adapter.CompareAndSwap(nodeBar, nodeBar)
+
+					retrievedNode, err := adapter.Get("/foo")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(retrievedNode).To(MatchStoreNode(nodeFoo))
+				})
+			})
+
+			Context("and the Value of oldNode is identical", func() {
+				It("updates the node with the new node", func() {
+					err := adapter.CompareAndSwap(nodeFoo, nodeBar)
+					Expect(err).NotTo(HaveOccurred())
+
+					retrievedNode, err := adapter.Get("/foo")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(retrievedNode).To(MatchStoreNode(nodeBar))
+				})
+			})
+		})
+	})
+
+	Describe("Indices", func() {
+		It("should report the index each node was last modified at", func() {
+			value, err := adapter.Get("/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value.Index).To(BeEquivalentTo(1))
+
+			value, err = adapter.Get("/menu/dinner/second")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value.Index).To(BeEquivalentTo(4))
+
+			err = adapter.SetMulti([]storeadapter.StoreNode{breakfastNode})
+			Expect(err).NotTo(HaveOccurred())
+
+			value, err = adapter.Get("/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value.Index).To(BeEquivalentTo(6))
+		})
+
+		It("should report the index each directory was created at when listing, and the current index for the listed one", func() {
+			menuNode, err := adapter.ListRecursively("/menu")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(menuNode.Index).To(BeEquivalentTo(5))
+
+			for _, node := range menuNode.ChildNodes {
+				switch node.Key {
+				case "/menu/breakfast":
+					Expect(node.Index).To(BeEquivalentTo(1))
+				case "/menu/lunch":
+					Expect(node.Index).To(BeEquivalentTo(2))
+				case "/menu/dinner":
+					Expect(node.Index).To(BeEquivalentTo(3))
+				}
+			}
+		})
+
+		It("should not advance the index when a write fails", func() {
+			err := adapter.Create(breakfastNode)
+			Expect(err).To(Equal(storeadapter.ErrorKeyExists))
+
+			err = adapter.Txn(nil, []storeadapter.TxnOp{
+				storeadapter.Put(storeadapter.StoreNode{Key: "/menu/brunch", Value: []byte("eggs")}),
+				storeadapter.DeleteKey("/menu/elevenses"),
+			})
+			Expect(err).To(HaveOccurred())
+
+			err = adapter.Create(storeadapter.StoreNode{Key: "/menu/brunch", Value: []byte("eggs")})
+			Expect(err).NotTo(HaveOccurred())
+
+			value, err := adapter.Get("/menu/brunch")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value.Index).To(BeEquivalentTo(6))
+		})
+
+		It("should compare indices in transactions", func() {
+			err := adapter.Txn(
+				[]storeadapter.TxnCompare{storeadapter.IndexEquals("/menu/breakfast", 2)},
+				[]storeadapter.TxnOp{storeadapter.DeleteKey("/menu/breakfast")},
+			)
+			Expect(err).To(Equal(storeadapter.ErrorKeyComparisonFailed))
+
+			err = adapter.Txn(
+				[]storeadapter.TxnCompare{storeadapter.IndexEquals("/menu/breakfast", 1)},
+				[]storeadapter.TxnOp{storeadapter.DeleteKey("/menu/breakfast")},
+			)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("Updating", func() {
+		Context("when the key is present", func() {
+			It("should update the node", func() {
+				crepesNode := storeadapter.StoreNode{Key: "/menu/breakfast", Value: []byte("crepes")}
+				err := adapter.Update(crepesNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(crepesNode))
+			})
+		})
+
+		Context("when the key is missing", func() {
+			It("should return the key not found error and create nothing", func() {
+				err := adapter.Update(storeadapter.StoreNode{Key: "/menu/brunch", Value: []byte("eggs")})
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+
+				_, err = adapter.Get("/menu/brunch")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+			})
+		})
+
+		Context("when the key is a directory", func() {
+			It("should return the node is directory error", func() {
+				err := adapter.Update(storeadapter.StoreNode{Key: "/menu", Value: []byte("oops")})
+				Expect(err).To(Equal(storeadapter.ErrorNodeIsDirectory))
+			})
+		})
+
+		Context("when the key matches the error injector", func() {
+			It("should return the injected error", func() {
+				adapter.GetErrInjector = nil
+				err := adapter.Update(storeadapter.StoreNode{Key: "/random", Value: []byte("0")})
+				Expect(err).To(Equal(errors.New("injected set error")))
+			})
+		})
+	})
+
+	Describe("Deleting leaves", func() {
+		Context("when the key is a leaf", func() {
+			It("should delete it", func() {
+				err := adapter.DeleteLeaves("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+			})
+		})
+
+		Context("when the key is an empty directory", func() {
+			It("should delete it", func() {
+				err := adapter.DeleteLeaves("/menu/dinner/first", "/menu/dinner/second")
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.DeleteLeaves("/menu/dinner")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.ListRecursively("/menu/dinner")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+			})
+		})
+
+		Context("when the key is a directory with keys under it", func() {
+			It("should fail, and delete the other keys", func() {
+				err := adapter.DeleteLeaves("/menu/dinner", "/menu/lunch")
+
+				multiErr, ok := err.(*storeadapter.MultiError)
+				Expect(ok).To(BeTrue())
+				Expect(multiErr.Failed()).To(HaveLen(1))
+				Expect(multiErr.Failed()[0].Key).To(Equal("/menu/dinner"))
+				Expect(multiErr.Failed()[0].Err).To(Equal(storeadapter.ErrorDirectoryNotEmpty))
+
+				_, err = adapter.Get("/menu/dinner/first")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.Get("/menu/lunch")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+			})
+		})
+
+		Context("when the key is missing", func() {
+			It("should return the key not found error", func() {
+				err := adapter.DeleteLeaves("/not/a/key")
+				Expect(err).To(MatchError(storeadapter.ErrorKeyNotFound))
+			})
+		})
+	})
+
+	Describe("Compare-and-Swapping by index", func() {
+		var node storeadapter.StoreNode
+
+		BeforeEach(func() {
+			var err error
+			node, err = adapter.Get("/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when the index matches", func() {
+			It("swaps the node", func() {
+				crepesNode := storeadapter.StoreNode{Key: "/menu/breakfast", Value: []byte("crepes")}
+				err := adapter.CompareAndSwapByIndex(node.Index, crepesNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(crepesNode))
+				Expect(value.Index).To(BeNumerically(">", node.Index))
+			})
+		})
+
+		Context("when the node has been written since", func() {
+			It("returns a KeyComparisonFailed error and leaves the node alone", func() {
+				err := adapter.SetMulti([]storeadapter.StoreNode{breakfastNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.CompareAndSwapByIndex(node.Index, storeadapter.StoreNode{Key: "/menu/breakfast", Value: []byte("crepes")})
+				Expect(err).To(Equal(storeadapter.ErrorKeyComparisonFailed))
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(breakfastNode))
+			})
+		})
+
+		Context("when the key is missing", func() {
+			It("returns a KeyNotFound error", func() {
+				err := adapter.CompareAndSwapByIndex(node.Index, storeadapter.StoreNode{Key: "/menu/brunch", Value: []byte("eggs")})
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+			})
+		})
+
+		Context("when the key is a directory", func() {
+			It("returns a NodeIsDirectory error", func() {
+				err := adapter.CompareAndSwapByIndex(1, storeadapter.StoreNode{Key: "/menu", Value: []byte("oops")})
+				Expect(err).To(Equal(storeadapter.ErrorNodeIsDirectory))
+			})
+		})
+	})
+
+	Describe("Compare-and-Deleting by index", func() {
+		var breakfast, lunch storeadapter.StoreNode
+
+		BeforeEach(func() {
+			var err error
+			breakfast, err = adapter.Get("/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+
+			lunch, err = adapter.Get("/menu/lunch")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when the indices match", func() {
+			It("deletes the nodes", func() {
+				err := adapter.CompareAndDeleteByIndex(breakfast, lunch)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+
+				_, err = adapter.Get("/menu/lunch")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+			})
+		})
+
+		Context("when a node has been written since", func() {
+			It("does not delete it, and reports it in a MultiError", func() {
+				err := adapter.SetMulti([]storeadapter.StoreNode{lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.CompareAndDeleteByIndex(breakfast, lunch)
+				Expect(err).To(Equal(&storeadapter.MultiError{
+					Results: []storeadapter.KeyResult{
+						{Key: "/menu/breakfast", Index: 7},
+						{Key: "/menu/lunch", Err: storeadapter.ErrorKeyComparisonFailed},
+					},
+				}))
+
+				value, err := adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(lunchNode))
+			})
+		})
+
+		Context("when the key is missing", func() {
+			It("returns a KeyNotFound error", func() {
+				err := adapter.CompareAndDeleteByIndex(storeadapter.StoreNode{Key: "/not/a/key", Index: 1})
+				Expect(err).To(MatchError(storeadapter.ErrorKeyNotFound))
+			})
+		})
+
+		Context("when the key is a directory", func() {
+			It("returns a NodeIsDirectory error", func() {
+				err := adapter.CompareAndDeleteByIndex(storeadapter.StoreNode{Key: "/menu", Index: 1})
+				Expect(err).To(MatchError(storeadapter.ErrorNodeIsDirectory))
+			})
+		})
+	})
+
+	Describe("Injecting errors", func() {
+		var injectedErr, otherErr error
+
+		BeforeEach(func() {
+			injectedErr = errors.New("injected error")
+			otherErr = errors.New("other error")
+		})
+
+		It("can fail a number of times and then succeed", func() {
+			adapter.GetErrInjector = NewFakeStoreAdapterErrorInjector("breakfast", injectedErr).FailTimes(2)
+
+			_, err := adapter.Get("/menu/breakfast")
+			Expect(err).To(Equal(injectedErr))
+			_, err = adapter.Get("/menu/breakfast")
+			Expect(err).To(Equal(injectedErr))
+
+			node, err := adapter.Get("/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node).To(MatchStoreNode(breakfastNode))
+
+			Expect(adapter.GetErrInjector.Calls()).To(Equal(3))
+		})
+
+		It("only counts the calls whose key matches", func() {
+			adapter.GetErrInjector = NewFakeStoreAdapterErrorInjector("breakfast", injectedErr).FailTimes(1)
+
+			_, err := adapter.Get("/menu/lunch")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = adapter.Get("/menu/breakfast")
+			Expect(err).To(Equal(injectedErr))
+		})
+
+		It("can fail with a sequence of errors", func() {
+			adapter.UpdateErrInjector = NewFakeStoreAdapterErrorSequence("breakfast", injectedErr, nil, otherErr)
+
+			Expect(adapter.Update(breakfastNode)).To(Equal(injectedErr))
+			Expect(adapter.Update(breakfastNode)).To(Succeed())
+			Expect(adapter.Update(breakfastNode)).To(Equal(otherErr))
+			Expect(adapter.Update(breakfastNode)).To(Succeed())
+		})
+
+		It("fails a write without an injector of its own with the injectors of the operations it is made of", func() {
+			adapter.CreateErrInjector = nil
+			adapter.SetErrInjector = NewFakeStoreAdapterErrorInjector("breakfast", injectedErr)
+			adapter.DeleteErrInjector = NewFakeStoreAdapterErrorInjector("lunch", injectedErr)
+
+			Expect(adapter.Create(storeadapter.StoreNode{Key: "/menu/breakfast/new"})).To(Equal(injectedErr))
+			Expect(adapter.Update(breakfastNode)).To(Equal(injectedErr))
+			Expect(adapter.CompareAndSwap(breakfastNode, breakfastNode)).To(Equal(injectedErr))
+			Expect(adapter.CompareAndDelete(lunchNode)).To(MatchError(injectedErr))
+			Expect(adapter.DeleteLeaves("/menu/lunch")).To(MatchError(injectedErr))
+			Expect(adapter.Txn(nil, []storeadapter.TxnOp{storeadapter.DeleteKey("/menu/lunch")})).To(Equal(injectedErr))
+		})
+
+		It("only fails a write with its own injector when it has one", func() {
+			adapter.UpdateErrInjector = NewFakeStoreAdapterErrorInjector("nothing", injectedErr)
+			adapter.CompareAndSwapErrInjector = NewFakeStoreAdapterErrorInjector("nothing", injectedErr)
+			adapter.CreateErrInjector = NewFakeStoreAdapterErrorInjector("nothing", injectedErr)
+			adapter.TxnErrInjector = NewFakeStoreAdapterErrorInjector("nothing", injectedErr)
+			adapter.GetErrInjector = NewFakeStoreAdapterErrorInjector("breakfast", injectedErr).FailTimes(1)
+			adapter.SetErrInjector = NewFakeStoreAdapterErrorInjector("breakfast", injectedErr).FailTimes(1)
+
+			Expect(adapter.Update(breakfastNode)).To(Succeed())
+			Expect(adapter.CompareAndSwap(breakfastNode, breakfastNode)).To(Succeed())
+			Expect(adapter.Create(breakfastNode)).To(Equal(storeadapter.ErrorKeyExists))
+			Expect(adapter.Txn(nil, []storeadapter.TxnOp{storeadapter.Put(breakfastNode)})).To(Succeed())
+			Expect(adapter.GetErrInjector.Calls()).To(BeZero())
+			Expect(adapter.SetErrInjector.Calls()).To(BeZero())
+
+			_, err := adapter.Get("/menu/breakfast")
+			Expect(err).To(Equal(injectedErr))
+			Expect(adapter.SetMulti([]storeadapter.StoreNode{breakfastNode})).To(MatchError(injectedErr))
+		})
+
+		It("fails with a probability, the same way for the same seed", func() {
+			outcomes := func() []bool {
+				adapter.CompareAndSwapErrInjector = NewFakeStoreAdapterErrorInjector("breakfast", injectedErr).FailWithProbability(0.5, 42)
+
+				var failed []bool
+				for i := 0; i < 20; i++ {
+					failed = append(failed, adapter.CompareAndSwap(breakfastNode, breakfastNode) != nil)
+				}
+				return failed
+			}
+
+			first := outcomes()
+			Expect(first).To(ContainElement(true))
+			Expect(first).To(ContainElement(false))
+			Expect(outcomes()).To(Equal(first))
+		})
+
+		It("delays matching calls on the adapter's clock", func() {
+			clock := fakeclock.NewFakeClock(time.Now())
+			adapter = NewWithClock(clock)
+			adapter.SetMulti([]storeadapter.StoreNode{breakfastNode})
+			adapter.GetErrInjector = NewFakeStoreAdapterErrorInjector("breakfast", nil).WithLatency(5 * time.Second)
+
+			got := make(chan storeadapter.StoreNode, 1)
+			go func() {
+				node, _ := adapter.Get("/menu/breakfast")
+				got <- node
+			}()
+
+			clock.WaitForWatcherAndIncrement(4 * time.Second)
+			Consistently(got).ShouldNot(Receive())
+
+			_, err := adapter.ListRecursively("/menu")
+			Expect(err).NotTo(HaveOccurred())
+
+			clock.Increment(time.Second)
+			Eventually(got).Should(Receive(MatchStoreNode(breakfastNode)))
+		})
+
+		It("can fail any method", func() {
+			adapter.ConnectErrInjector = NewFakeStoreAdapterErrorInjector("", injectedErr)
+			adapter.DeleteLeavesErrInjector = NewFakeStoreAdapterErrorInjector("lunch", injectedErr)
+			adapter.CompareAndDeleteErrInjector = NewFakeStoreAdapterErrorInjector("lunch", injectedErr)
+			adapter.TxnErrInjector = NewFakeStoreAdapterErrorInjector("lunch", injectedErr)
+			adapter.UpdateDirTTLErrInjector = NewFakeStoreAdapterErrorInjector("menu", injectedErr)
+
+			Expect(adapter.Connect()).To(Equal(injectedErr))
+
+			err := adapter.DeleteLeaves("/menu/lunch")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, injectedErr)).To(BeTrue())
+
+			err = adapter.CompareAndDelete(lunchNode)
+			Expect(errors.Is(err, injectedErr)).To(BeTrue())
+
+			err = adapter.CompareAndDeleteByIndex(lunchNode)
+			Expect(errors.Is(err, injectedErr)).To(BeTrue())
+
+			err = adapter.Txn(nil, []storeadapter.TxnOp{{Type: storeadapter.PutOp, Node: lunchNode}})
+			Expect(err).To(Equal(injectedErr))
+
+			Expect(adapter.UpdateDirTTL("/menu", 10)).To(Equal(injectedErr))
+
+			_, err = adapter.Get("/menu/lunch")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("can fail a watch", func() {
+			adapter.WatchErrInjector = NewFakeStoreAdapterErrorInjector("menu", injectedErr).FailTimes(1)
+
+			events, _, errs := adapter.Watch("/menu")
+			Eventually(errs).Should(Receive(Equal(injectedErr)))
+			Eventually(events).Should(BeClosed())
+
+			events, _, _ = adapter.Watch("/menu")
+			adapter.SetMulti([]storeadapter.StoreNode{breakfastNode})
+			Eventually(events).Should(Receive())
+		})
+
+		It("can fail maintaining a node", func() {
+			adapter.MaintainNodeErrInjector = NewFakeStoreAdapterErrorInjector("lock", injectedErr).FailTimes(1)
+
+			status, releaseNode, err := adapter.MaintainNodeWithToken(storeadapter.StoreNode{Key: "/lock"})
+			Expect(err).To(Equal(injectedErr))
+			Expect(status).To(BeNil())
+			Expect(releaseNode).To(BeNil())
+			Expect(adapter.GetMaintainedNodeName()).To(BeEmpty())
+
+			_, _, err = adapter.MaintainNodeWithToken(storeadapter.StoreNode{Key: "/lock"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(adapter.GetMaintainedNodeName()).To(Equal("/lock"))
+		})
+	})
+
+	Describe("Recording operations", func() {
+		It("records nothing unless asked to", func() {
+			_, err := adapter.Get("/menu/breakfast")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(adapter.Operations()).To(BeEmpty())
+		})
+
+		Context("when RecordOperations is set", func() {
+			var clock *fakeclock.FakeClock
+
+			BeforeEach(func() {
+				clock = fakeclock.NewFakeClock(time.Unix(1000, 0))
+				adapter = NewWithClock(clock)
+				adapter.RecordOperations = true
+			})
+
+			It("records each call's method, keys, nodes, error and time, in order", func() {
+				err := adapter.SetMulti([]storeadapter.StoreNode{breakfastNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				clock.Increment(time.Second)
+
+				_, err = adapter.Get("/menu/lunch")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+
+				operations := adapter.Operations()
+				Expect(operations).To(HaveLen(2))
+
+				Expect(operations[0].Method).To(Equal("SetMulti"))
+				Expect(operations[0].Keys).To(Equal([]string{"/menu/breakfast"}))
+				Expect(operations[0].Nodes).To(Equal([]storeadapter.StoreNode{breakfastNode}))
+				Expect(operations[0].Err).NotTo(HaveOccurred())
+				Expect(operations[0].Time).To(Equal(time.Unix(1000, 0)))
+
+				Expect(operations[1].Method).To(Equal("Get"))
+				Expect(operations[1].Keys).To(Equal([]string{"/menu/lunch"}))
+				Expect(operations[1].Nodes).To(BeEmpty())
+				Expect(operations[1].Err).To(Equal(storeadapter.ErrorKeyNotFound))
+				Expect(operations[1].Time).To(Equal(time.Unix(1001, 0)))
+			})
+
+			It("records the context variants under the plain method's name", func() {
+				_, err := adapter.GetContext(context.Background(), "/menu/lunch")
+				Expect(err).To(HaveOccurred())
+
+				Expect(adapter).To(HaveRecordedOperations("Get"))
+			})
+
+			It("counts the keys of a partly failed write as written", func() {
+				adapter.SetErrInjector = NewFakeStoreAdapterErrorInjector("dom$", errors.New("injected set error"))
+
+				err := adapter.SetMulti([]storeadapter.StoreNode{breakfastNode, randomNode})
+				Expect(err).To(HaveOccurred())
+
+				Expect(adapter).To(HaveRecordedWrite("/menu/breakfast"))
+				Expect(adapter).NotTo(HaveRecordedWrite("/random"))
+			})
+
+			It("does not count reads as writes", func() {
+				adapter.Get("/menu/breakfast")
+
+				Expect(adapter).NotTo(HaveRecordedWrite("/menu/breakfast"))
+			})
+
+			It("filters the journal by writes and by key", func() {
+				adapter.SetMulti([]storeadapter.StoreNode{breakfastNode, lunchNode})
+				adapter.Get("/menu/breakfast")
+				adapter.Delete("/menu/lunch")
+
+				Expect(adapter.Writes()).To(HaveRecordedOperations("SetMulti", "Delete"))
+				Expect(adapter.OperationsOn("/menu/breakfast")).To(HaveRecordedOperations("SetMulti", "Get"))
+				Expect(adapter.OperationsOn("menu/lunch/")).To(HaveRecordedOperations("SetMulti", "Delete"))
+			})
+
+			It("can be cleared", func() {
+				adapter.SetMulti([]storeadapter.StoreNode{breakfastNode})
+				adapter.ClearOperations()
+
+				Expect(adapter.Operations()).To(BeEmpty())
+			})
+
+			It("matches the recorded sequence by method name or by matcher", func() {
+				adapter.Create(breakfastNode)
+				adapter.Create(breakfastNode)
+
+				Expect(adapter).To(HaveRecordedOperations(
+					"Create",
+					WithTransform(func(operation Operation) error { return operation.Err }, Equal(storeadapter.ErrorKeyExists)),
+				))
+				Expect(adapter).NotTo(HaveRecordedOperations("Create"))
+				Expect(adapter).NotTo(HaveRecordedOperations("Create", "SetMulti"))
+			})
+
+			It("rejects anything other than an adapter or its operations", func() {
+				_, err := HaveRecordedWrite("/menu").Match("not an adapter")
+				Expect(err).To(HaveOccurred())
+
+				_, err = HaveRecordedOperations(17).Match(adapter)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("Watching", func() {
+		Context("when a node under the key is created", func() {
+			It("sends an event with CreateEvent type and the node's value", func(done Done) {
+				events, _, _ := adapter.Watch("/foo")
+
+				err := adapter.Create(storeadapter.StoreNode{
+					Key:   "/foo/a",
+					Value: []byte("new value"),
+				})
+				Expect(err).ToNot(HaveOccurred())
+				event := <-events
+
+				Expect(event.Type).To(Equal(storeadapter.CreateEvent))
+				Expect(event.Node.Key).To(Equal("/foo/a"))
+				Expect(string(event.Node.Value)).To(Equal("new value"))
 
 				close(done)
 			}, 5.0)
@@ -606,5 +1655,204 @@ var _ = Describe("Fakestoreadapter", func() {
 				close(done)
 			})
 		})
+
+		Context("when resuming from an index", func() {
+			var createdAt uint64
+
+			BeforeEach(func() {
+				err := adapter.Create(storeadapter.StoreNode{Key: "/foo/a", Value: []byte("1")})
+				Expect(err).NotTo(HaveOccurred())
+
+				node, err := adapter.Get("/foo/a")
+				Expect(err).NotTo(HaveOccurred())
+				createdAt = node.Index
+
+				err = adapter.Create(storeadapter.StoreNode{Key: "/bar", Value: []byte("2")})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.SetMulti([]storeadapter.StoreNode{{Key: "/foo/a", Value: []byte("3")}})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("first sends the changes to the key after the index", func() {
+				events, _, _ := adapter.WatchFrom("/foo", createdAt)
+
+				var event storeadapter.WatchEvent
+				Eventually(events).Should(Receive(&event))
+				Expect(event.Type).To(Equal(storeadapter.UpdateEvent))
+				Expect(string(event.Node.Value)).To(Equal("3"))
+
+				err := adapter.Delete("/foo/a")
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(events).Should(Receive(&event))
+				Expect(event.Type).To(Equal(storeadapter.DeleteEvent))
+			})
+
+			It("resumes with a context too", func() {
+				events, _, _ := adapter.WatchFromContext(context.Background(), "/foo", createdAt-1)
+
+				var event storeadapter.WatchEvent
+				Eventually(events).Should(Receive(&event))
+				Expect(event.Type).To(Equal(storeadapter.CreateEvent))
+				Eventually(events).Should(Receive(&event))
+				Expect(event.Type).To(Equal(storeadapter.UpdateEvent))
+			})
+
+			Context("when the history has been cleared", func() {
+				It("reports that the index is cleared, with the current index, and ends the watch", func() {
+					adapter.ClearWatchHistory()
+
+					events, _, errs := adapter.WatchFrom("/foo", createdAt)
+
+					var err error
+					Eventually(errs).Should(Receive(&err))
+					Expect(err).To(MatchError(storeadapter.ErrorWatchIndexCleared))
+
+					var storeErr *storeadapter.Error
+					Expect(errors.As(err, &storeErr)).To(BeTrue())
+					Expect(storeErr.Index).To(Equal(createdAt + 2))
+					Eventually(events).Should(BeClosed())
+				})
+
+				It("still resumes from the current index", func() {
+					adapter.ClearWatchHistory()
+
+					events, _, _ := adapter.WatchFrom("/foo", createdAt+2)
+
+					err := adapter.Delete("/foo/a")
+					Expect(err).NotTo(HaveOccurred())
+
+					var event storeadapter.WatchEvent
+					Eventually(events).Should(Receive(&event))
+					Expect(event.Type).To(Equal(storeadapter.DeleteEvent))
+				})
+			})
+		})
+
+		It("reports PrevNode as etcd does", func() {
+			events, _, _ := adapter.Watch("/foo")
+
+			err := adapter.Create(storeadapter.StoreNode{Key: "/foo/a", Value: []byte("1")})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = adapter.SetMulti([]storeadapter.StoreNode{{Key: "/foo/b", Value: []byte("2")}})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = adapter.SetMulti([]storeadapter.StoreNode{{Key: "/foo/a", Value: []byte("3")}})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = adapter.Delete("/foo/b")
+			Expect(err).NotTo(HaveOccurred())
+
+			var event storeadapter.WatchEvent
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(storeadapter.CreateEvent))
+			Expect(event.PrevNode).To(BeNil())
+
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(storeadapter.UpdateEvent))
+			Expect(event.Node.Key).To(Equal("/foo/b"))
+			Expect(event.PrevNode).To(BeNil())
+
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(storeadapter.UpdateEvent))
+			Expect(string(event.Node.Value)).To(Equal("3"))
+			Expect(event.PrevNode.Key).To(Equal("/foo/a"))
+			Expect(string(event.PrevNode.Value)).To(Equal("1"))
+			Expect(event.PrevNode.Index).To(BeNumerically("<", event.Node.Index))
+
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(storeadapter.DeleteEvent))
+			Expect(event.Node).To(BeNil())
+			Expect(event.PrevNode.Key).To(Equal("/foo/b"))
+			Expect(string(event.PrevNode.Value)).To(Equal("2"))
+		})
+
+		It("sends events in the order they happened, at increasing indices", func() {
+			events, _, _ := adapter.Watch("/foo")
+
+			for i := 0; i < 50; i++ {
+				err := adapter.SetMulti([]storeadapter.StoreNode{{Key: "/foo/a", Value: []byte(fmt.Sprintf("%d", i))}})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			lastIndex := uint64(0)
+			for i := 0; i < 50; i++ {
+				var event storeadapter.WatchEvent
+				Eventually(events).Should(Receive(&event))
+				Expect(string(event.Node.Value)).To(Equal(fmt.Sprintf("%d", i)))
+				Expect(event.Index).To(Equal(event.Node.Index))
+				Expect(event.Index).To(BeNumerically(">", lastIndex))
+				lastIndex = event.Index
+			}
+		})
+
+		It("only sends events for the watched key and keys under it", func() {
+			events, _, _ := adapter.Watch("/foo")
+
+			err := adapter.SetMulti([]storeadapter.StoreNode{
+				{Key: "/foobar", Value: []byte("unrelated")},
+				{Key: "/menu/foo", Value: []byte("unrelated")},
+				{Key: "/foo", Value: []byte("watched")},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var event storeadapter.WatchEvent
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Node.Key).To(Equal("/foo"))
+			Consistently(events).ShouldNot(Receive())
+		})
+
+		It("sends each watch its own events", func() {
+			fooEvents, _, _ := adapter.Watch("/foo")
+			menuEvents, _, _ := adapter.Watch("/menu")
+			rootEvents, _, _ := adapter.Watch("/")
+
+			err := adapter.SetMulti([]storeadapter.StoreNode{
+				{Key: "/foo/a", Value: []byte("1")},
+				{Key: "/menu/lunch", Value: []byte("2")},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var event storeadapter.WatchEvent
+			Eventually(fooEvents).Should(Receive(&event))
+			Expect(event.Node.Key).To(Equal("/foo/a"))
+
+			Eventually(menuEvents).Should(Receive(&event))
+			Expect(event.Node.Key).To(Equal("/menu/lunch"))
+
+			Eventually(rootEvents).Should(Receive(&event))
+			Expect(event.Node.Key).To(Equal("/foo/a"))
+			Eventually(rootEvents).Should(Receive(&event))
+			Expect(event.Node.Key).To(Equal("/menu/lunch"))
+
+			Consistently(fooEvents).ShouldNot(Receive())
+			Consistently(menuEvents).ShouldNot(Receive())
+		})
+
+		It("closes a watch's channels when it is told to stop, leaving the others", func() {
+			events, stop, errs := adapter.Watch("/foo")
+			otherEvents, _, _ := adapter.Watch("/foo")
+
+			stop <- true
+			Eventually(events).Should(BeClosed())
+			Eventually(errs).Should(BeClosed())
+
+			err := adapter.SetMulti([]storeadapter.StoreNode{{Key: "/foo/a", Value: []byte("1")}})
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(otherEvents).Should(Receive())
+		})
+
+		It("stops the watch once its context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+
+			events, _, errs := adapter.WatchContext(ctx, "/foo")
+			cancel()
+
+			Eventually(events).Should(BeClosed())
+			Eventually(errs).Should(BeClosed())
+		})
 	})
 })
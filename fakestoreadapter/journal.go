@@ -0,0 +1,144 @@
+package fakestoreadapter
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudfoundry/storeadapter"
+)
+
+// Operation is a call made on a FakeStoreAdapter, as recorded in its journal
+// while RecordOperations is set.
+type Operation struct {
+	// The StoreAdapter method called, such as "SetMulti". Calls made through
+	// the context variants are recorded under the plain method's name.
+	Method string
+
+	// The keys the call was about, in the order they were given.
+	Keys []string
+
+	// The nodes the call was given to write or compare, if any.
+	Nodes []storeadapter.StoreNode
+
+	// The error the call returned.
+	Err error
+
+	// When the call was made, by the adapter's clock.
+	Time time.Time
+}
+
+var writeMethods = map[string]bool{
+	"Create":                  true,
+	"Update":                  true,
+	"CompareAndSwap":          true,
+	"CompareAndSwapByIndex":   true,
+	"SetMulti":                true,
+	"Delete":                  true,
+	"DeleteLeaves":            true,
+	"CompareAndDelete":        true,
+	"CompareAndDeleteByIndex": true,
+	"Txn":                     true,
+	"UpdateDirTTL":            true,
+}
+
+// IsWrite reports whether the operation is one that can change the store.
+func (operation Operation) IsWrite() bool {
+	return writeMethods[operation.Method]
+}
+
+// Wrote reports whether the operation is a write that succeeded for key. A
+// fan-out write that failed for some of its keys still wrote the others.
+func (operation Operation) Wrote(key string) bool {
+	if !operation.IsWrite() || !operation.hasKey(key) {
+		return false
+	}
+
+	if operation.Err == nil {
+		return true
+	}
+
+	var multiErr *storeadapter.MultiError
+	if !errors.As(operation.Err, &multiErr) {
+		return false
+	}
+
+	for _, result := range multiErr.Results {
+		if result.Key == key && result.Err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (operation Operation) hasKey(key string) bool {
+	for _, operationKey := range operation.Keys {
+		if cleanKey(operationKey) == cleanKey(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// record adds a call to the journal, if operations are being recorded. It is
+// called, usually deferred, with the lock held.
+func (adapter *FakeStoreAdapter) record(method string, keys []string, nodes []storeadapter.StoreNode, err *error) {
+	if !adapter.RecordOperations {
+		return
+	}
+
+	operation := Operation{
+		Method: method,
+		Keys:   keys,
+		Nodes:  nodes,
+		Time:   adapter.clock.Now(),
+	}
+
+	if err != nil {
+		operation.Err = *err
+	}
+
+	adapter.journal = append(adapter.journal, operation)
+}
+
+// Operations returns every operation recorded so far, oldest first.
+func (adapter *FakeStoreAdapter) Operations() []Operation {
+	adapter.Lock()
+	defer adapter.Unlock()
+
+	return append([]Operation{}, adapter.journal...)
+}
+
+// Writes returns the recorded operations that can change the store, oldest
+// first, whether or not they succeeded.
+func (adapter *FakeStoreAdapter) Writes() []Operation {
+	var writes []Operation
+	for _, operation := range adapter.Operations() {
+		if operation.IsWrite() {
+			writes = append(writes, operation)
+		}
+	}
+
+	return writes
+}
+
+// OperationsOn returns the recorded operations about key, oldest first.
+func (adapter *FakeStoreAdapter) OperationsOn(key string) []Operation {
+	var operations []Operation
+	for _, operation := range adapter.Operations() {
+		if operation.hasKey(key) {
+			operations = append(operations, operation)
+		}
+	}
+
+	return operations
+}
+
+// ClearOperations empties the journal.
+func (adapter *FakeStoreAdapter) ClearOperations() {
+	adapter.Lock()
+	defer adapter.Unlock()
+
+	adapter.journal = nil
+}
@@ -0,0 +1,295 @@
+package fakestoreadapter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry/storeadapter"
+)
+
+// fakeMaintainer is one caller contending for a maintained node. It queues
+// the statuses it is sent, so that sending them never waits on the caller.
+type fakeMaintainer struct {
+	node storeadapter.StoreNode
+
+	// token is the index the node was written at while this contender holds
+	// it, and 0 otherwise.
+	token uint64
+
+	mutex sync.Mutex
+	queue []storeadapter.NodeStatus
+	wake  chan bool
+}
+
+func (m *fakeMaintainer) push(status storeadapter.NodeStatus) {
+	m.mutex.Lock()
+	m.queue = append(m.queue, status)
+	m.mutex.Unlock()
+
+	select {
+	case m.wake <- true:
+	default:
+	}
+}
+
+func (m *fakeMaintainer) next() (storeadapter.NodeStatus, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.queue) == 0 {
+		return storeadapter.NodeStatus{}, false
+	}
+
+	status := m.queue[0]
+	m.queue = m.queue[1:]
+
+	return status, true
+}
+
+// MaintainNode either reports the statuses sent on MaintainNodeStatus, or,
+// while SimulateMaintainNode is set, contends for the node as a real store
+// does. See SimulateMaintainNode.
+func (adapter *FakeStoreAdapter) MaintainNode(storeNode storeadapter.StoreNode) (status <-chan bool, releaseNode chan chan bool, err error) {
+	simulated, releaseNode, err := adapter.maintainNode("MaintainNode", storeNode)
+	if releaseNode == nil {
+		return nil, nil, err
+	}
+
+	if simulated != nil {
+		return ownership(simulated), releaseNode, nil
+	}
+
+	return adapter.MaintainNodeStatus, releaseNode, err
+}
+
+// ownership reports whether each status sent on statuses is owned.
+func ownership(statuses <-chan storeadapter.NodeStatus) <-chan bool {
+	owned := make(chan bool)
+	go func() {
+		defer close(owned)
+		for status := range statuses {
+			owned <- status.Owned
+		}
+	}()
+
+	return owned
+}
+
+// maintainNode records the call and, if the node is simulated, joins the
+// contenders for it, returning the channel its statuses are sent on.
+func (adapter *FakeStoreAdapter) maintainNode(method string, storeNode storeadapter.StoreNode) (simulated <-chan storeadapter.NodeStatus, releaseNode chan chan bool, err error) {
+	adapter.MaintainNodeErrInjector.delay(adapter.clock, storeNode.Key)
+
+	adapter.lock()
+	defer adapter.Unlock()
+	defer adapter.record(method, []string{storeNode.Key}, []storeadapter.StoreNode{storeNode}, &err)
+
+	if err := adapter.MaintainNodeErrInjector.errorFor(storeNode.Key); err != nil {
+		return nil, nil, err
+	}
+
+	adapter.maintainedNodeName = storeNode.Key
+	adapter.MaintainedNodeValue = storeNode.Value
+
+	if adapter.SimulateMaintainNode {
+		if adapter.MaintainNodeError != nil {
+			return nil, nil, adapter.MaintainNodeError
+		}
+
+		statuses, releaseNode := adapter.contend(storeNode)
+		return statuses, releaseNode, nil
+	}
+
+	adapter.releaseNodeChannel = make(chan chan bool, 1)
+	if adapter.OnReleaseNodeChannel != nil {
+		go adapter.OnReleaseNodeChannel(adapter.releaseNodeChannel)
+	}
+
+	return nil, adapter.releaseNodeChannel, adapter.MaintainNodeError
+}
+
+// MaintainNodeWithToken is MaintainNode, with statuses sent on
+// MaintainNodeTokens instead of MaintainNodeStatus. A simulated node's token
+// is the index it was written at.
+func (adapter *FakeStoreAdapter) MaintainNodeWithToken(storeNode storeadapter.StoreNode) (status <-chan storeadapter.NodeStatus, releaseNode chan chan bool, err error) {
+	return adapter.maintainNodeWithToken("MaintainNodeWithToken", storeNode)
+}
+
+func (adapter *FakeStoreAdapter) maintainNodeWithToken(method string, storeNode storeadapter.StoreNode) (status <-chan storeadapter.NodeStatus, releaseNode chan chan bool, err error) {
+	simulated, releaseNode, err := adapter.maintainNode(method, storeNode)
+	if releaseNode == nil {
+		return nil, nil, err
+	}
+
+	if simulated != nil {
+		return simulated, releaseNode, nil
+	}
+
+	return adapter.MaintainNodeTokens, releaseNode, err
+}
+
+// MaintainNodeWithOptions is MaintainNodeWithToken. The options are recorded in
+// MaintainedNodeOptions, but do not change how the node is maintained.
+func (adapter *FakeStoreAdapter) MaintainNodeWithOptions(storeNode storeadapter.StoreNode, options storeadapter.MaintainOptions) (status <-chan storeadapter.NodeStatus, releaseNode chan chan bool, err error) {
+	adapter.Lock()
+	adapter.MaintainedNodeOptions = options
+	adapter.Unlock()
+
+	return adapter.maintainNodeWithToken("MaintainNodeWithOptions", storeNode)
+}
+
+// contend adds a contender for the node, which acquires it at once if nobody
+// holds it. It is called with the lock held.
+func (adapter *FakeStoreAdapter) contend(storeNode storeadapter.StoreNode) (<-chan storeadapter.NodeStatus, chan chan bool) {
+	// Contenders that give no value are told apart, as a real store does with
+	// a generated one.
+	if len(storeNode.Value) == 0 {
+		adapter.maintainerCount++
+		storeNode.Value = []byte(fmt.Sprintf("fake-maintainer-%d", adapter.maintainerCount))
+	}
+
+	storeNode.Key = cleanKey(storeNode.Key)
+	storeNode.TTL = 0
+
+	m := &fakeMaintainer{
+		node: storeNode,
+		wake: make(chan bool, 1),
+	}
+
+	statuses := make(chan storeadapter.NodeStatus)
+	releaseNode := make(chan chan bool, 1)
+
+	adapter.maintainers[storeNode.Key] = append(adapter.maintainers[storeNode.Key], m)
+	go adapter.dispatchNodeStatuses(m, adapter.done, statuses, releaseNode)
+
+	adapter.settleMaintainedNode(storeNode.Key)
+
+	return statuses, releaseNode
+}
+
+func (adapter *FakeStoreAdapter) dispatchNodeStatuses(m *fakeMaintainer, done <-chan struct{}, statuses chan<- storeadapter.NodeStatus, releaseNode <-chan chan bool) {
+	var released chan bool
+	defer func() {
+		close(statuses)
+		if released != nil {
+			close(released)
+		}
+	}()
+
+	for {
+		status, ok := m.next()
+		if !ok {
+			select {
+			case <-m.wake:
+				continue
+			case released = <-releaseNode:
+				adapter.releaseMaintainedNode(m)
+			case <-done:
+			}
+			return
+		}
+
+		select {
+		case statuses <- status:
+		case released = <-releaseNode:
+			adapter.releaseMaintainedNode(m)
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// releaseMaintainedNode withdraws the contender, deleting the node if it
+// holds it, so that the next contender can acquire it.
+func (adapter *FakeStoreAdapter) releaseMaintainedNode(m *fakeMaintainer) {
+	adapter.lock()
+	defer adapter.Unlock()
+
+	key := m.node.Key
+	contenders := adapter.maintainers[key]
+	for i, contender := range contenders {
+		if contender == m {
+			adapter.maintainers[key] = append(contenders[:i:i], contenders[i+1:]...)
+			break
+		}
+	}
+
+	if current := adapter.lookup(key); m.token != 0 && current != nil && !current.Dir && current.Index == m.token {
+		adapter.deleteKeys(key)
+	}
+
+	adapter.settleMaintainedNode(key)
+}
+
+// settleMaintainedNode keeps the contenders for key in step with the store.
+// The holder loses the node once it is no longer the node it wrote, and goes
+// to the back of the line; the contender at the front then acquires the node
+// if nobody else has written it. It is called with the lock held, whenever
+// the key changes.
+func (adapter *FakeStoreAdapter) settleMaintainedNode(key string) {
+	contenders := adapter.maintainers[key]
+	if len(contenders) == 0 {
+		return
+	}
+
+	holder := contenders[0]
+	if holder.token != 0 {
+		if current := adapter.lookup(key); current != nil && !current.Dir && current.Index == holder.token {
+			return
+		}
+
+		holder.token = 0
+		holder.push(storeadapter.NodeStatus{Owned: false})
+		contenders = append(contenders[1:], holder)
+		adapter.maintainers[key] = contenders
+	}
+
+	next := contenders[0]
+
+	// A node holding the contender's value is one it held before, so it is
+	// taken over.
+	eventType := storeadapter.CreateEvent
+	if current := adapter.lookup(key); current != nil {
+		if current.Dir || !bytes.Equal(current.Value, next.node.Value) {
+			return
+		}
+		eventType = storeadapter.UpdateEvent
+	}
+
+	next.token = adapter.index + 1
+	if err := adapter.set(next.node, eventType); err != nil {
+		next.token = 0
+		return
+	}
+
+	next.push(storeadapter.NodeStatus{Owned: true, Token: next.token})
+}
+
+// ExpireMaintainedNode expires a node maintained while SimulateMaintainNode is
+// set, as if its holder had failed to refresh it in time. The holder is told
+// it has lost the node, and the next contender, possibly the holder itself if
+// there is no other, acquires it.
+func (adapter *FakeStoreAdapter) ExpireMaintainedNode(key string) error {
+	adapter.lock()
+	defer adapter.Unlock()
+
+	key = cleanKey(key)
+	contenders := adapter.maintainers[key]
+	if len(contenders) == 0 || contenders[0].token == 0 {
+		return storeadapter.ErrorKeyNotFound
+	}
+
+	components := adapter.keyComponents(key)
+	parentNode, err := adapter.walkToNode("/" + strings.Join(components[:len(components)-1], "/"))
+	if err != nil {
+		return err
+	}
+
+	adapter.index++
+	adapter.removeNode(parentNode, components[len(components)-1], storeadapter.ExpireEvent)
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package fakestoreadapter
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry/storeadapter"
+)
+
+// At least the latest watchHistorySize events are kept for WatchFrom.
+const watchHistorySize = 1000
+
+// fakeWatcher queues the events for one watch, so that sending them never
+// waits on the watch's reader, and they arrive in the order they happened.
+type fakeWatcher struct {
+	key        string
+	afterIndex uint64
+
+	mutex   sync.Mutex
+	queue   []storeadapter.WatchEvent
+	wake    chan bool
+	stopped chan struct{}
+}
+
+func newFakeWatcher(key string, afterIndex uint64) *fakeWatcher {
+	return &fakeWatcher{
+		key:        key,
+		afterIndex: afterIndex,
+		wake:       make(chan bool, 1),
+		stopped:    make(chan struct{}),
+	}
+}
+
+// covers reports whether the event is about the watched key or a key under
+// it, and happened after the index the watch started from.
+func (w *fakeWatcher) covers(event storeadapter.WatchEvent) bool {
+	if event.Index <= w.afterIndex {
+		return false
+	}
+
+	node := event.Node
+	if node == nil {
+		node = event.PrevNode
+	}
+
+	key := cleanKey(node.Key)
+	return w.key == "/" || key == w.key || strings.HasPrefix(key, w.key+"/")
+}
+
+func (w *fakeWatcher) push(event storeadapter.WatchEvent) {
+	w.mutex.Lock()
+	w.queue = append(w.queue, event)
+	w.mutex.Unlock()
+
+	select {
+	case w.wake <- true:
+	default:
+	}
+}
+
+func (w *fakeWatcher) next() (storeadapter.WatchEvent, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.queue) == 0 {
+		return storeadapter.WatchEvent{}, false
+	}
+
+	event := w.queue[0]
+	w.queue = w.queue[1:]
+
+	return event, true
+}
+
+func cleanKey(key string) string {
+	var components []string
+	for _, component := range strings.Split(key, "/") {
+		if component != "" {
+			components = append(components, component)
+		}
+	}
+
+	return "/" + strings.Join(components, "/")
+}
+
+// sendEvent reports a change at the current index. Within a transaction, the
+// event is held back until the transaction commits.
+func (adapter *FakeStoreAdapter) sendEvent(prevNode *storeadapter.StoreNode, node *storeadapter.StoreNode, eventType storeadapter.EventType) {
+	event := storeadapter.WatchEvent{
+		Type:     eventType,
+		Node:     node,
+		PrevNode: prevNode,
+		Index:    adapter.index,
+	}
+
+	if adapter.bufferEvents {
+		adapter.txnEvents = append(adapter.txnEvents, event)
+		return
+	}
+
+	adapter.publish(event)
+}
+
+// publish records the event in the history, queues it for every watch it
+// concerns, and settles any simulated maintained node it changed. It is
+// called with the lock held.
+func (adapter *FakeStoreAdapter) publish(event storeadapter.WatchEvent) {
+	adapter.history = append(adapter.history, event)
+
+	// The history is trimmed back to watchHistorySize once it is twice as
+	// long.
+	if dropped := len(adapter.history) - watchHistorySize; dropped >= watchHistorySize {
+		adapter.historyStart = adapter.history[dropped-1].Index
+		adapter.history = append([]storeadapter.WatchEvent{}, adapter.history[dropped:]...)
+	}
+
+	for w := range adapter.watchers {
+		if w.covers(event) {
+			w.push(event)
+		}
+	}
+
+	node := event.Node
+	if node == nil {
+		node = event.PrevNode
+	}
+	adapter.settleMaintainedNode(cleanKey(node.Key))
+}
+
+// Watch sends every change to key, or to a key under it, in the order the
+// changes happened, until stop is sent to or the adapter is disconnected. An
+// error sent on WatchErrChannel is reported by one of the watches, which then
+// ends. A watch failed by WatchErrInjector reports the error and ends at once.
+func (adapter *FakeStoreAdapter) Watch(key string) (events <-chan storeadapter.WatchEvent, stop chan<- bool, errors <-chan error) {
+	return adapter.watch("Watch", key, 0, false)
+}
+
+// WatchFrom is Watch, first sending the changes after afterIndex from the
+// history of the latest events. If those are no longer all in the history, or
+// ClearWatchHistory has been called since, the watch reports an error wrapping
+// ErrorWatchIndexCleared, with the current index, and ends at once.
+func (adapter *FakeStoreAdapter) WatchFrom(key string, afterIndex uint64) (events <-chan storeadapter.WatchEvent, stop chan<- bool, errors <-chan error) {
+	return adapter.watch("WatchFrom", key, afterIndex, true)
+}
+
+// ClearWatchHistory forgets the events so far, as a store does once they are
+// old, so that watches can only resume from the current index on.
+func (adapter *FakeStoreAdapter) ClearWatchHistory() {
+	adapter.Lock()
+	defer adapter.Unlock()
+
+	adapter.history = nil
+	adapter.historyStart = adapter.index
+}
+
+func (adapter *FakeStoreAdapter) watch(method string, key string, afterIndex uint64, resume bool) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	adapter.WatchErrInjector.delay(adapter.clock, key)
+
+	adapter.Lock()
+	defer adapter.Unlock()
+
+	eventChannel := make(chan storeadapter.WatchEvent)
+	stopChannel := make(chan bool, 1)
+
+	err := adapter.WatchErrInjector.errorFor(key)
+	if err == nil && resume && afterIndex < adapter.historyStart {
+		err = &storeadapter.Error{Op: method, Key: key, Index: adapter.index, Err: storeadapter.ErrorWatchIndexCleared}
+	}
+
+	if err != nil {
+		adapter.record(method, []string{key}, nil, &err)
+
+		errorChannel := make(chan error, 1)
+		errorChannel <- err
+		close(eventChannel)
+		close(errorChannel)
+		return eventChannel, stopChannel, errorChannel
+	}
+
+	adapter.record(method, []string{key}, nil, nil)
+
+	errorChannel := make(chan error)
+
+	w := newFakeWatcher(cleanKey(key), afterIndex)
+	if resume {
+		for _, event := range adapter.history {
+			if w.covers(event) {
+				w.push(event)
+			}
+		}
+	}
+	adapter.watchers[w] = true
+
+	go adapter.dispatchWatchEvents(w, adapter.WatchErrChannel, adapter.done, eventChannel, stopChannel, errorChannel)
+
+	return eventChannel, stopChannel, errorChannel
+}
+
+func (adapter *FakeStoreAdapter) dispatchWatchEvents(w *fakeWatcher, injectedErrors <-chan error, done <-chan struct{}, events chan<- storeadapter.WatchEvent, stop <-chan bool, errors chan<- error) {
+	defer close(w.stopped)
+	defer close(events)
+	defer close(errors)
+
+	defer func() {
+		adapter.Lock()
+		delete(adapter.watchers, w)
+		adapter.Unlock()
+	}()
+
+	for {
+		event, ok := w.next()
+		if !ok {
+			select {
+			case <-w.wake:
+				continue
+			case err, ok := <-injectedErrors:
+				if !ok {
+					return
+				}
+				select {
+				case errors <- err:
+				case <-stop:
+				case <-done:
+				}
+			case <-stop:
+			case <-done:
+			}
+			return
+		}
+
+		select {
+		case events <- event:
+		case err, ok := <-injectedErrors:
+			if !ok {
+				return
+			}
+			select {
+			case errors <- err:
+			case <-stop:
+			case <-done:
+			}
+			return
+		case <-stop:
+			return
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package storeadapter
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+var ErrorStaleFencingToken = errors.New("a newer fencing token has already been used")
+
+// FencedWriter guards writes with a fencing token, such as the Token of a
+// NodeStatus. The largest token that has written so far is kept at a fence
+// key; a write succeeds only if the writer's token is at least as large, and
+// records the writer's token in the same transaction. A holder that has lost
+// ownership therefore cannot overwrite anything its successor has written.
+//
+// Every writer guarding the same data must use the same fence key.
+type FencedWriter struct {
+	adapter  StoreAdapter
+	fenceKey string
+	token    uint64
+}
+
+func NewFencedWriter(adapter StoreAdapter, fenceKey string, token uint64) *FencedWriter {
+	return &FencedWriter{
+		adapter:  adapter,
+		fenceKey: fenceKey,
+		token:    token,
+	}
+}
+
+func (writer *FencedWriter) Token() uint64 {
+	return writer.token
+}
+
+// Set writes the nodes if the token is not stale, returning
+// ErrorStaleFencingToken if it is.
+func (writer *FencedWriter) Set(nodes ...StoreNode) error {
+	operations := make([]TxnOp, len(nodes))
+	for i, node := range nodes {
+		operations[i] = Put(node)
+	}
+
+	return writer.Txn(nil, operations)
+}
+
+// Delete deletes the keys if the token is not stale, returning
+// ErrorStaleFencingToken if it is.
+func (writer *FencedWriter) Delete(keys ...string) error {
+	operations := make([]TxnOp, len(keys))
+	for i, key := range keys {
+		operations[i] = DeleteKey(key)
+	}
+
+	return writer.Txn(nil, operations)
+}
+
+// Txn is StoreAdapter.Txn, guarded by the token. The transaction is retried
+// if another writer moves the fence in the meantime.
+func (writer *FencedWriter) Txn(comparisons []TxnCompare, operations []TxnOp) error {
+	fence := StoreNode{Key: writer.fenceKey, Value: []byte(strconv.FormatUint(writer.token, 10))}
+
+	for {
+		current, err := writer.readFence()
+		if err != nil {
+			return err
+		}
+
+		guard := KeyMissing(writer.fenceKey)
+		if current != nil {
+			guard = IndexEquals(writer.fenceKey, current.Index)
+		}
+
+		err = writer.adapter.Txn(
+			append([]TxnCompare{guard}, comparisons...),
+			append([]TxnOp{Put(fence)}, operations...),
+		)
+		if err == nil {
+			return nil
+		}
+
+		latest, readErr := writer.readFence()
+		if readErr != nil {
+			return readErr
+		}
+		if sameFence(current, latest) {
+			return err
+		}
+	}
+}
+
+// readFence returns the fence, or nil if there is none yet. It returns
+// ErrorStaleFencingToken if the fence holds a larger token than the writer's.
+func (writer *FencedWriter) readFence() (*StoreNode, error) {
+	fence, err := writer.adapter.Get(writer.fenceKey)
+	if errors.Is(err, ErrorKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := strconv.ParseUint(string(fence.Value), 10, 64)
+	if err != nil {
+		return nil, ErrorInvalidFormat
+	}
+
+	if token > writer.token {
+		return nil, ErrorStaleFencingToken
+	}
+
+	return &fence, nil
+}
+
+func sameFence(a, b *StoreNode) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Index == b.Index && bytes.Equal(a.Value, b.Value)
+}
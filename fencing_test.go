@@ -0,0 +1,148 @@
+package storeadapter_test
+
+import (
+	"errors"
+
+	. "github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/fakes"
+	"github.com/cloudfoundry/storeadapter/fakestoreadapter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FencedWriter", func() {
+	var (
+		adapter *fakestoreadapter.FakeStoreAdapter
+		writer  *FencedWriter
+		node    StoreNode
+	)
+
+	BeforeEach(func() {
+		adapter = fakestoreadapter.New()
+		writer = NewFencedWriter(adapter, "/fence", 10)
+		node = StoreNode{Key: "/data", Value: []byte("mine")}
+	})
+
+	Context("when nothing has been written yet", func() {
+		It("writes the nodes and records the token", func() {
+			Expect(writer.Set(node)).To(Succeed())
+
+			value, err := adapter.Get("/data")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value.Value).To(Equal([]byte("mine")))
+
+			fence, err := adapter.Get("/fence")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fence.Value).To(Equal([]byte("10")))
+		})
+	})
+
+	Context("when a writer with the same or an older token wrote last", func() {
+		BeforeEach(func() {
+			Expect(NewFencedWriter(adapter, "/fence", 7).Set(StoreNode{Key: "/data", Value: []byte("old")})).To(Succeed())
+			Expect(writer.Set(StoreNode{Key: "/data", Value: []byte("earlier")})).To(Succeed())
+		})
+
+		It("writes", func() {
+			Expect(writer.Set(node)).To(Succeed())
+
+			value, err := adapter.Get("/data")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value.Value).To(Equal([]byte("mine")))
+		})
+
+		It("deletes", func() {
+			Expect(writer.Delete("/data")).To(Succeed())
+
+			_, err := adapter.Get("/data")
+			Expect(err).To(Equal(ErrorKeyNotFound))
+		})
+	})
+
+	Context("when a writer with a newer token has written", func() {
+		BeforeEach(func() {
+			Expect(NewFencedWriter(adapter, "/fence", 11).Set(StoreNode{Key: "/data", Value: []byte("newer")})).To(Succeed())
+		})
+
+		It("refuses to write", func() {
+			Expect(writer.Set(node)).To(Equal(ErrorStaleFencingToken))
+			Expect(writer.Delete("/data")).To(Equal(ErrorStaleFencingToken))
+
+			value, err := adapter.Get("/data")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value.Value).To(Equal([]byte("newer")))
+		})
+	})
+
+	Context("when the fence does not hold a token", func() {
+		BeforeEach(func() {
+			Expect(adapter.Create(StoreNode{Key: "/fence", Value: []byte("garbage")})).To(Succeed())
+		})
+
+		It("returns ErrorInvalidFormat", func() {
+			Expect(writer.Set(node)).To(Equal(ErrorInvalidFormat))
+		})
+	})
+
+	Context("when one of the caller's comparisons fails", func() {
+		It("returns the error without retrying", func() {
+			err := writer.Txn([]TxnCompare{KeyExists("/missing")}, []TxnOp{Put(node)})
+			Expect(err).To(Equal(ErrorKeyNotFound))
+
+			_, err = adapter.Get("/data")
+			Expect(err).To(Equal(ErrorKeyNotFound))
+		})
+	})
+
+	Context("when the fence moves between reading it and writing", func() {
+		var fakeAdapter *fakes.FakeStoreAdapter
+
+		BeforeEach(func() {
+			fakeAdapter = new(fakes.FakeStoreAdapter)
+			writer = NewFencedWriter(fakeAdapter, "/fence", 10)
+
+			fences := []StoreNode{
+				{Key: "/fence", Value: []byte("9"), Index: 1},
+				{Key: "/fence", Value: []byte("9"), Index: 2},
+				{Key: "/fence", Value: []byte("9"), Index: 2},
+			}
+			fakeAdapter.GetStub = func(key string) (StoreNode, error) {
+				fence := fences[0]
+				if len(fences) > 1 {
+					fences = fences[1:]
+				}
+				return fence, nil
+			}
+
+			fakeAdapter.TxnStub = func(comparisons []TxnCompare, operations []TxnOp) error {
+				if comparisons[0].Node.Index == 1 {
+					return ErrorKeyComparisonFailed
+				}
+				return nil
+			}
+		})
+
+		It("tries again against the new fence", func() {
+			Expect(writer.Set(node)).To(Succeed())
+
+			Expect(fakeAdapter.TxnCallCount()).To(Equal(2))
+			comparisons, operations := fakeAdapter.TxnArgsForCall(1)
+			Expect(comparisons).To(Equal([]TxnCompare{IndexEquals("/fence", 2)}))
+			Expect(operations).To(Equal([]TxnOp{
+				Put(StoreNode{Key: "/fence", Value: []byte("10")}),
+				Put(node),
+			}))
+		})
+	})
+
+	Context("when reading the fence fails", func() {
+		BeforeEach(func() {
+			adapter.GetErrInjector = fakestoreadapter.NewFakeStoreAdapterErrorInjector("fence", errors.New("oops"))
+		})
+
+		It("returns the error", func() {
+			Expect(writer.Set(node)).To(MatchError("oops"))
+		})
+	})
+})
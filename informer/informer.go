@@ -0,0 +1,373 @@
+package informer
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/cloudfoundry/storeadapter"
+)
+
+// Handlers are called, one at a time, as the leaves under the informer's key
+// change. Any of them may be nil.
+type Handlers struct {
+	Added   func(node storeadapter.StoreNode)
+	Updated func(oldNode, newNode storeadapter.StoreNode)
+	Deleted func(node storeadapter.StoreNode)
+}
+
+// Informer keeps an in-memory copy of the leaves under a key. It lists the
+// key, then watches it from the index the store reports with the listing so
+// that no change made in between is missed. Changes are applied by index: an event that is no
+// newer than what the cache already holds for its key is ignored.
+//
+// When the watch fails, including because the store has discarded the events
+// it needed, the informer lists the key again, reports the differences to
+// its handlers and watches from the new listing.
+type Informer struct {
+	// How long to wait before listing again after the watch fails or a
+	// listing fails. Defaults to one second.
+	RetryInterval time.Duration
+
+	// Drives the wait before listing again. Defaults to the real clock.
+	Clock clock.Clock
+
+	adapter  storeadapter.StoreAdapter
+	key      string
+	handlers Handlers
+
+	lock  sync.RWMutex
+	nodes map[string]storeadapter.StoreNode
+	index uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func New(adapter storeadapter.StoreAdapter, key string, handlers Handlers) *Informer {
+	return &Informer{
+		RetryInterval: time.Second,
+		Clock:         clock.NewClock(),
+
+		adapter:  adapter,
+		key:      key,
+		handlers: handlers,
+
+		nodes: map[string]storeadapter.StoreNode{},
+	}
+}
+
+// Start lists the key, calling Added for every leaf under it, and starts
+// watching it. The cache is then kept up to date in the background until Stop
+// is called. Start returns an error, and does not start, if the first listing
+// fails. A key that does not exist yet is treated as empty.
+func (informer *Informer) Start() error {
+	err := informer.resync()
+	if err != nil {
+		return err
+	}
+
+	informer.stop = make(chan struct{})
+	informer.done = make(chan struct{})
+	go informer.run(informer.startWatch())
+
+	return nil
+}
+
+// Stop stops watching and waits for any handler that is running to return.
+func (informer *Informer) Stop() {
+	if informer.stop == nil {
+		return
+	}
+
+	close(informer.stop)
+	<-informer.done
+}
+
+func (informer *Informer) Get(key string) (storeadapter.StoreNode, bool) {
+	informer.lock.RLock()
+	defer informer.lock.RUnlock()
+
+	node, ok := informer.nodes[key]
+	return node, ok
+}
+
+// List returns every cached leaf, sorted by key.
+func (informer *Informer) List() []storeadapter.StoreNode {
+	informer.lock.RLock()
+	defer informer.lock.RUnlock()
+
+	nodes := make([]storeadapter.StoreNode, 0, len(informer.nodes))
+	for _, node := range informer.nodes {
+		nodes = append(nodes, node)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Key < nodes[j].Key
+	})
+
+	return nodes
+}
+
+// Index returns the newest store index the cache is known to reflect.
+func (informer *Informer) Index() uint64 {
+	informer.lock.RLock()
+	defer informer.lock.RUnlock()
+
+	return informer.index
+}
+
+type watch struct {
+	events <-chan storeadapter.WatchEvent
+	stop   chan<- bool
+	errs   <-chan error
+}
+
+func (informer *Informer) run(current watch) {
+	defer close(informer.done)
+
+	for {
+		if !informer.consume(current) {
+			return
+		}
+
+		for {
+			if !informer.wait() {
+				return
+			}
+			if informer.resync() == nil {
+				break
+			}
+		}
+
+		current = informer.startWatch()
+	}
+}
+
+// startWatch watches from the cache's index, which is never older than the
+// last listing. A plain Watch would start from whenever the store got the
+// request, missing any change made since the listing.
+func (informer *Informer) startWatch() watch {
+	var current watch
+	current.events, current.stop, current.errs = informer.adapter.WatchFrom(informer.key, informer.Index())
+	return current
+}
+
+// consume applies events until the watch fails, returning false if it was
+// stopped instead.
+func (informer *Informer) consume(current watch) bool {
+	events, stop, errs := current.events, current.stop, current.errs
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return true
+			}
+			informer.apply(event)
+
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			informer.noteClearedIndex(err)
+			return true
+
+		case <-informer.stop:
+			if stop != nil {
+				select {
+				case stop <- true:
+				default:
+				}
+			}
+			return false
+		}
+	}
+}
+
+func (informer *Informer) wait() bool {
+	timer := informer.Clock.NewTimer(informer.RetryInterval)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return true
+	case <-informer.stop:
+		return false
+	}
+}
+
+// noteClearedIndex moves the cache's index up to the store's current index
+// when the watch failed because the events it needed are gone, so that the
+// next watch does not ask for them again.
+func (informer *Informer) noteClearedIndex(err error) {
+	var storeErr *storeadapter.Error
+	if errors.Is(err, storeadapter.ErrorWatchIndexCleared) && errors.As(err, &storeErr) {
+		informer.lock.Lock()
+		if storeErr.Index > informer.index {
+			informer.index = storeErr.Index
+		}
+		informer.lock.Unlock()
+	}
+}
+
+// resync lists the key and reports how the listing differs from the cache.
+func (informer *Informer) resync() error {
+	listing, err := informer.adapter.ListRecursively(informer.key)
+	index := listing.Index
+
+	if errors.Is(err, storeadapter.ErrorKeyNotFound) {
+		var storeErr *storeadapter.Error
+		if errors.As(err, &storeErr) {
+			index = storeErr.Index
+		}
+		listing = storeadapter.StoreNode{Key: informer.key, Dir: true}
+	} else if err != nil {
+		return err
+	}
+
+	nodes := map[string]storeadapter.StoreNode{}
+	index = collectLeaves(listing, nodes, index)
+
+	informer.lock.Lock()
+	previous := informer.nodes
+	informer.nodes = nodes
+	if index > informer.index {
+		informer.index = index
+	}
+	informer.lock.Unlock()
+
+	for key, node := range nodes {
+		oldNode, existed := previous[key]
+		if !existed {
+			informer.added(node)
+		} else if oldNode.Index != node.Index || string(oldNode.Value) != string(node.Value) {
+			informer.updated(oldNode, node)
+		}
+	}
+
+	for key, oldNode := range previous {
+		if _, exists := nodes[key]; !exists {
+			informer.deleted(oldNode)
+		}
+	}
+
+	return nil
+}
+
+// collectLeaves adds every leaf under node to leaves, returning the largest
+// of index and the indices of node and everything under it.
+func collectLeaves(node storeadapter.StoreNode, leaves map[string]storeadapter.StoreNode, index uint64) uint64 {
+	if node.Index > index {
+		index = node.Index
+	}
+
+	if !node.Dir {
+		leaves[node.Key] = node
+		return index
+	}
+
+	for _, child := range node.ChildNodes {
+		index = collectLeaves(child, leaves, index)
+	}
+
+	return index
+}
+
+func (informer *Informer) apply(event storeadapter.WatchEvent) {
+	switch event.Type {
+	case storeadapter.CreateEvent, storeadapter.UpdateEvent:
+		if event.Node != nil && !event.Node.Dir {
+			informer.put(*event.Node, event.Index)
+		}
+	case storeadapter.DeleteEvent, storeadapter.ExpireEvent:
+		if event.PrevNode != nil {
+			informer.remove(*event.PrevNode, event.Index)
+		}
+	}
+}
+
+func (informer *Informer) put(node storeadapter.StoreNode, index uint64) {
+	if !informer.covers(node.Key) {
+		return
+	}
+
+	informer.lock.Lock()
+	oldNode, existed := informer.nodes[node.Key]
+	if existed && stale(oldNode.Index, index) {
+		informer.lock.Unlock()
+		return
+	}
+	informer.nodes[node.Key] = node
+	informer.advance(index)
+	informer.lock.Unlock()
+
+	if existed {
+		informer.updated(oldNode, node)
+	} else {
+		informer.added(node)
+	}
+}
+
+func (informer *Informer) remove(prevNode storeadapter.StoreNode, index uint64) {
+	if prevNode.Key == "" || !informer.covers(prevNode.Key) {
+		return
+	}
+
+	removed := []storeadapter.StoreNode{}
+
+	informer.lock.Lock()
+	for key, node := range informer.nodes {
+		if key == prevNode.Key || (prevNode.Dir && strings.HasPrefix(key, prevNode.Key+"/")) {
+			if !stale(node.Index, index) {
+				delete(informer.nodes, key)
+				removed = append(removed, node)
+			}
+		}
+	}
+	informer.advance(index)
+	informer.lock.Unlock()
+
+	for _, node := range removed {
+		informer.deleted(node)
+	}
+}
+
+func (informer *Informer) advance(index uint64) {
+	if index > informer.index {
+		informer.index = index
+	}
+}
+
+func (informer *Informer) covers(key string) bool {
+	root := strings.TrimSuffix(informer.key, "/")
+	return key == root || strings.HasPrefix(key, root+"/")
+}
+
+// stale reports whether an event at index is no newer than a cached node at
+// cachedIndex. Stores that do not report indices are never stale.
+func stale(cachedIndex, index uint64) bool {
+	return cachedIndex != 0 && index != 0 && index <= cachedIndex
+}
+
+func (informer *Informer) added(node storeadapter.StoreNode) {
+	if informer.handlers.Added != nil {
+		informer.handlers.Added(node)
+	}
+}
+
+func (informer *Informer) updated(oldNode, newNode storeadapter.StoreNode) {
+	if informer.handlers.Updated != nil {
+		informer.handlers.Updated(oldNode, newNode)
+	}
+}
+
+func (informer *Informer) deleted(node storeadapter.StoreNode) {
+	if informer.handlers.Deleted != nil {
+		informer.handlers.Deleted(node)
+	}
+}
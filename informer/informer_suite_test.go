@@ -0,0 +1,13 @@
+package informer_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestInformer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Informer Suite")
+}
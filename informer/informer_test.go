@@ -0,0 +1,389 @@
+package informer_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/fakes"
+	"github.com/cloudfoundry/storeadapter/fakestoreadapter"
+	. "github.com/cloudfoundry/storeadapter/informer"
+	. "github.com/cloudfoundry/storeadapter/storenodematchers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type notification struct {
+	kind    string
+	node    storeadapter.StoreNode
+	oldNode storeadapter.StoreNode
+}
+
+type recorder struct {
+	lock          sync.Mutex
+	notifications []notification
+}
+
+func (r *recorder) handlers() Handlers {
+	return Handlers{
+		Added: func(node storeadapter.StoreNode) {
+			r.record(notification{kind: "added", node: node})
+		},
+		Updated: func(oldNode, newNode storeadapter.StoreNode) {
+			r.record(notification{kind: "updated", node: newNode, oldNode: oldNode})
+		},
+		Deleted: func(node storeadapter.StoreNode) {
+			r.record(notification{kind: "deleted", node: node})
+		},
+	}
+}
+
+func (r *recorder) record(n notification) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.notifications = append(r.notifications, n)
+}
+
+func (r *recorder) Kinds() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	kinds := []string{}
+	for _, n := range r.notifications {
+		kinds = append(kinds, n.kind+" "+n.node.Key)
+	}
+	return kinds
+}
+
+func (r *recorder) Last() notification {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.notifications[len(r.notifications)-1]
+}
+
+var _ = Describe("Informer", func() {
+	var (
+		breakfastNode, lunchNode, randomNode storeadapter.StoreNode
+
+		notifications *recorder
+		informer      *Informer
+	)
+
+	BeforeEach(func() {
+		breakfastNode = storeadapter.StoreNode{Key: "/menu/breakfast", Value: []byte("waffle")}
+		lunchNode = storeadapter.StoreNode{Key: "/menu/lunch", Value: []byte("burger")}
+		randomNode = storeadapter.StoreNode{Key: "/random", Value: []byte("17")}
+
+		notifications = &recorder{}
+	})
+
+	AfterEach(func() {
+		if informer != nil {
+			informer.Stop()
+		}
+	})
+
+	Context("against the fake store adapter", func() {
+		var adapter *fakestoreadapter.FakeStoreAdapter
+
+		BeforeEach(func() {
+			adapter = fakestoreadapter.New()
+
+			err := adapter.SetMulti([]storeadapter.StoreNode{breakfastNode, randomNode})
+			Expect(err).NotTo(HaveOccurred())
+
+			informer = New(adapter, "/menu", notifications.handlers())
+		})
+
+		Describe("Start", func() {
+			It("adds every leaf under the key", func() {
+				err := informer.Start()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(notifications.Kinds()).To(Equal([]string{"added /menu/breakfast"}))
+				Expect(informer.List()).To(HaveLen(1))
+				Expect(informer.List()[0]).To(MatchStoreNode(breakfastNode))
+
+				node, ok := informer.Get("/menu/breakfast")
+				Expect(ok).To(BeTrue())
+				Expect(node).To(MatchStoreNode(breakfastNode))
+
+				_, ok = informer.Get("/random")
+				Expect(ok).To(BeFalse())
+			})
+
+			It("starts from the store's index, however long the key has been unchanged", func() {
+				err := adapter.SetMulti([]storeadapter.StoreNode{randomNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = informer.Start()
+				Expect(err).NotTo(HaveOccurred())
+
+				random, err := adapter.Get("/random")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(informer.Index()).To(Equal(random.Index))
+			})
+
+			Context("when the key does not exist", func() {
+				BeforeEach(func() {
+					informer = New(adapter, "/nothing", notifications.handlers())
+				})
+
+				It("starts empty", func() {
+					err := informer.Start()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(informer.List()).To(BeEmpty())
+				})
+			})
+
+			Context("when listing fails", func() {
+				BeforeEach(func() {
+					adapter.ListErrInjector = fakestoreadapter.NewFakeStoreAdapterErrorInjector("menu", errors.New("oops"))
+				})
+
+				It("returns the error", func() {
+					err := informer.Start()
+					Expect(err).To(MatchError("oops"))
+					Expect(notifications.Kinds()).To(BeEmpty())
+				})
+			})
+		})
+
+		Context("once started", func() {
+			BeforeEach(func() {
+				err := informer.Start()
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("adds created leaves", func() {
+				err := adapter.Create(lunchNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(notifications.Kinds).Should(ContainElement("added /menu/lunch"))
+				node, _ := informer.Get("/menu/lunch")
+				Expect(node).To(MatchStoreNode(lunchNode))
+			})
+
+			It("updates changed leaves", func() {
+				updatedNode := storeadapter.StoreNode{Key: "/menu/breakfast", Value: []byte("pancakes")}
+				err := adapter.SetMulti([]storeadapter.StoreNode{updatedNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(notifications.Kinds).Should(ContainElement("updated /menu/breakfast"))
+				Expect(notifications.Last().oldNode).To(MatchStoreNode(breakfastNode))
+				Expect(notifications.Last().node).To(MatchStoreNode(updatedNode))
+				node, _ := informer.Get("/menu/breakfast")
+				Expect(node).To(MatchStoreNode(updatedNode))
+			})
+
+			It("deletes removed leaves", func() {
+				err := adapter.Delete("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(notifications.Kinds).Should(ContainElement("deleted /menu/breakfast"))
+				Expect(informer.List()).To(BeEmpty())
+			})
+
+			It("ignores leaves outside the key", func() {
+				err := adapter.SetMulti([]storeadapter.StoreNode{randomNode, lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(notifications.Kinds).Should(ContainElement("added /menu/lunch"))
+				Expect(notifications.Kinds()).NotTo(ContainElement("added /random"))
+				Expect(notifications.Kinds()).NotTo(ContainElement("updated /random"))
+			})
+		})
+	})
+
+	Context("against a store that reports indices", func() {
+		var (
+			adapter *fakes.FakeStoreAdapter
+			events  chan storeadapter.WatchEvent
+			errs    chan error
+			stop    chan bool
+
+			listingLock sync.Mutex
+			listing     storeadapter.StoreNode
+			listingErr  error
+		)
+
+		listReturns := func(node storeadapter.StoreNode, err error) {
+			listingLock.Lock()
+			defer listingLock.Unlock()
+			listing, listingErr = node, err
+		}
+
+		BeforeEach(func() {
+			adapter = new(fakes.FakeStoreAdapter)
+			adapter.ListRecursivelyStub = func(key string) (storeadapter.StoreNode, error) {
+				listingLock.Lock()
+				defer listingLock.Unlock()
+				return listing, listingErr
+			}
+
+			breakfastNode.Index = 10
+			listReturns(storeadapter.StoreNode{
+				Key:        "/menu",
+				Dir:        true,
+				Index:      15,
+				ChildNodes: []storeadapter.StoreNode{breakfastNode},
+			}, nil)
+
+			events = make(chan storeadapter.WatchEvent)
+			errs = make(chan error, 1)
+			stop = make(chan bool, 1)
+			adapter.WatchFromReturns(events, stop, errs)
+
+			informer = New(adapter, "/menu", notifications.handlers())
+			informer.RetryInterval = 10 * time.Millisecond
+
+			err := informer.Start()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("watches from the index reported with the listing", func() {
+			Eventually(adapter.WatchFromCallCount).Should(Equal(1))
+			key, afterIndex := adapter.WatchFromArgsForCall(0)
+			Expect(key).To(Equal("/menu"))
+			Expect(afterIndex).To(BeEquivalentTo(15))
+			Expect(adapter.WatchCallCount()).To(BeZero())
+		})
+
+		It("ignores events that are no newer than the cache", func() {
+			Eventually(adapter.WatchFromCallCount).Should(Equal(1))
+
+			staleNode := storeadapter.StoreNode{Key: "/menu/breakfast", Value: []byte("stale"), Index: 9}
+			events <- storeadapter.WatchEvent{Type: storeadapter.UpdateEvent, Node: &staleNode, Index: 9}
+			events <- storeadapter.WatchEvent{Type: storeadapter.DeleteEvent, PrevNode: &breakfastNode, Index: 10}
+
+			newNode := storeadapter.StoreNode{Key: "/menu/breakfast", Value: []byte("new"), Index: 16}
+			events <- storeadapter.WatchEvent{Type: storeadapter.UpdateEvent, Node: &newNode, Index: 16}
+
+			Eventually(notifications.Kinds).Should(Equal([]string{"added /menu/breakfast", "updated /menu/breakfast"}))
+			node, _ := informer.Get("/menu/breakfast")
+			Expect(node).To(Equal(newNode))
+			Expect(informer.Index()).To(BeEquivalentTo(16))
+		})
+
+		It("deletes every leaf under a deleted directory", func() {
+			Eventually(adapter.WatchFromCallCount).Should(Equal(1))
+
+			dir := storeadapter.StoreNode{Key: "/menu", Dir: true}
+			events <- storeadapter.WatchEvent{Type: storeadapter.ExpireEvent, PrevNode: &dir, Index: 16}
+
+			Eventually(notifications.Kinds).Should(ContainElement("deleted /menu/breakfast"))
+			Expect(informer.List()).To(BeEmpty())
+		})
+
+		Context("when the watch fails", func() {
+			var lunchNode storeadapter.StoreNode
+
+			BeforeEach(func() {
+				Eventually(adapter.WatchFromCallCount).Should(Equal(1))
+
+				lunchNode = storeadapter.StoreNode{Key: "/menu/lunch", Value: []byte("burger"), Index: 20}
+				listReturns(storeadapter.StoreNode{
+					Key:        "/menu",
+					Dir:        true,
+					Index:      25,
+					ChildNodes: []storeadapter.StoreNode{lunchNode},
+				}, nil)
+
+				errs <- errors.New("lost connection")
+			})
+
+			It("lists again and reports the differences", func() {
+				Eventually(notifications.Kinds).Should(ConsistOf(
+					"added /menu/breakfast",
+					"added /menu/lunch",
+					"deleted /menu/breakfast",
+				))
+				Expect(informer.List()).To(Equal([]storeadapter.StoreNode{lunchNode}))
+			})
+
+			It("watches again from the new listing", func() {
+				Eventually(adapter.WatchFromCallCount).Should(Equal(2))
+				_, afterIndex := adapter.WatchFromArgsForCall(1)
+				Expect(afterIndex).To(BeEquivalentTo(25))
+			})
+		})
+
+		Context("when the store has cleared the events being watched for", func() {
+			BeforeEach(func() {
+				Eventually(adapter.WatchFromCallCount).Should(Equal(1))
+
+				errs <- &storeadapter.Error{Op: "Watch", Key: "/menu", Code: 401, Index: 5000, Err: storeadapter.ErrorWatchIndexCleared}
+			})
+
+			It("watches again from the store's current index", func() {
+				Eventually(adapter.WatchFromCallCount).Should(Equal(2))
+				_, afterIndex := adapter.WatchFromArgsForCall(1)
+				Expect(afterIndex).To(BeEquivalentTo(5000))
+			})
+		})
+
+		Context("with a clock", func() {
+			var clock *fakeclock.FakeClock
+
+			BeforeEach(func() {
+				informer.Stop()
+
+				clock = fakeclock.NewFakeClock(time.Now())
+				informer = New(adapter, "/menu", notifications.handlers())
+				informer.RetryInterval = time.Minute
+				informer.Clock = clock
+
+				err := informer.Start()
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(adapter.WatchFromCallCount).Should(Equal(2))
+
+				listReturns(storeadapter.StoreNode{}, errors.New("still down"))
+				errs <- errors.New("lost connection")
+			})
+
+			It("waits the retry interval on it before each listing", func() {
+				listings := adapter.ListRecursivelyCallCount()
+
+				clock.WaitForWatcherAndIncrement(time.Minute - time.Second)
+				Consistently(adapter.ListRecursivelyCallCount).Should(Equal(listings))
+
+				clock.Increment(time.Second)
+				Eventually(adapter.ListRecursivelyCallCount).Should(Equal(listings + 1))
+
+				listReturns(storeadapter.StoreNode{Key: "/menu", Dir: true}, nil)
+				clock.WaitForWatcherAndIncrement(time.Minute)
+				Eventually(adapter.WatchFromCallCount).Should(Equal(3))
+			})
+		})
+
+		Context("when listing again fails", func() {
+			BeforeEach(func() {
+				Eventually(adapter.WatchFromCallCount).Should(Equal(1))
+
+				listReturns(storeadapter.StoreNode{}, errors.New("still down"))
+				errs <- errors.New("lost connection")
+				Eventually(adapter.ListRecursivelyCallCount).Should(BeNumerically(">=", 3))
+			})
+
+			It("keeps trying until it succeeds", func() {
+				Expect(adapter.WatchFromCallCount()).To(Equal(1))
+
+				listReturns(storeadapter.StoreNode{Key: "/menu", Dir: true}, nil)
+
+				Eventually(adapter.WatchFromCallCount).Should(Equal(2))
+				Eventually(notifications.Kinds).Should(ContainElement("deleted /menu/breakfast"))
+			})
+		})
+
+		It("stops the watch when stopped", func() {
+			Eventually(adapter.WatchFromCallCount).Should(Equal(1))
+
+			informer.Stop()
+			informer = nil
+
+			Expect(stop).To(Receive(BeTrue()))
+		})
+	})
+})
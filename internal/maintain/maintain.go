@@ -0,0 +1,180 @@
+// Package maintain runs the loop behind MaintainNode for the adapters that
+// hold a node by writing it and refreshing it themselves.
+package maintain
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/cloudfoundry/storeadapter"
+)
+
+// ErrNodeLost is returned by Backend.Refresh once the node is no longer the
+// one written with the token, so that it is acquired again straight away.
+var ErrNodeLost = errors.New("the maintained node is no longer held")
+
+// Backend writes, refreshes and deletes one maintained node. Its functions
+// are only called from the loop, one at a time.
+type Backend struct {
+	// Acquire writes the node if nobody else holds it, returning its
+	// fencing token, or an error wrapping ErrorKeyExists if someone does.
+	Acquire func(ctx context.Context) (uint64, error)
+
+	// Refresh keeps the node written with token held.
+	Refresh func(ctx context.Context, token uint64) error
+
+	// Release deletes the node if it is still the one written with token,
+	// the token it was last acquired with. The token is 0 if it never was.
+	Release func(token uint64)
+
+	// Disconnected is closed once the adapter disconnects, which stops the
+	// loop without releasing the node.
+	Disconnected <-chan struct{}
+}
+
+// Node maintains a node with backend until it is released or ctx is done,
+// as MaintainNodeWithOptionsContext does. The options must have their
+// defaults filled in.
+func Node(ctx context.Context, options storeadapter.MaintainOptions, backend Backend) (<-chan storeadapter.NodeStatus, chan (chan bool)) {
+	releaseNode := make(chan chan bool)
+	nodeStatus := make(chan storeadapter.NodeStatus)
+
+	go run(ctx, options, backend, nodeStatus, releaseNode)
+
+	return nodeStatus, releaseNode
+}
+
+func run(ctx context.Context, options storeadapter.MaintainOptions, backend Backend, nodeStatus chan storeadapter.NodeStatus, releaseNode chan (chan bool)) {
+	timer := options.Clock.NewTimer(options.RefreshInterval)
+	timer.Stop()
+
+	// The first attempt is made straight away, and the rest on the timer.
+	firstAttempt := make(chan time.Time, 1)
+	firstAttempt <- options.Clock.Now()
+	timerC := (<-chan time.Time)(firstAttempt)
+
+	owned := false
+	token := uint64(0)
+	refreshes := 0
+	failures := uint(0)
+
+	for {
+		select {
+		case <-timerC:
+			timerC = timer.C()
+
+			// An attempt must not outlast the refresh interval, or the node
+			// could expire while waiting on a store that is down.
+			attemptCtx, cancel := context.WithTimeout(ctx, options.RefreshInterval)
+
+			var err error
+			if owned {
+				err = backend.Refresh(attemptCtx, token)
+				if err == nil {
+					cancel()
+					failures = 0
+					refreshes++
+
+					elapsed := time.Duration(0)
+					if refreshes >= options.StatusEvery {
+						elapsed = elapsedChannelSend(options.Clock, nodeStatus, storeadapter.NodeStatus{Owned: true, Token: token})
+						refreshes = 0
+					}
+					timer.Reset(options.RefreshInterval - elapsed)
+					continue
+				}
+
+				owned = false
+				refreshes = 0
+				nodeStatus <- storeadapter.NodeStatus{Owned: false}
+			}
+
+			if err == nil || errors.Is(err, ErrNodeLost) {
+				var acquired uint64
+				acquired, err = backend.Acquire(attemptCtx)
+				if err == nil {
+					cancel()
+					token = acquired
+					failures = 0
+					owned = true
+
+					elapsed := elapsedChannelSend(options.Clock, nodeStatus, storeadapter.NodeStatus{Owned: true, Token: token})
+					timer.Reset(options.RefreshInterval - elapsed)
+					continue
+				}
+			}
+
+			cancel()
+
+			// Someone else holding the node is an answer from the store, not a
+			// failure: the node is contended for at every refresh interval,
+			// however long that takes.
+			if errors.Is(err, storeadapter.ErrorKeyExists) {
+				failures = 0
+				timer.Reset(options.RefreshInterval)
+				continue
+			}
+
+			failures++
+			retryInterval, ok := options.RetryPolicy.DelayFor(failures)
+			if !ok {
+				// Giving up: the node is deleted if it is still ours, so that it
+				// is not left to expire, and reporting stops, but a release is
+				// still acknowledged.
+				backend.Release(token)
+				token = 0
+				close(nodeStatus)
+				nodeStatus = nil
+				timerC = nil
+				continue
+			}
+
+			timer.Reset(retryInterval)
+
+		case released := <-releaseNode:
+			backend.Release(token)
+			timer.Stop()
+			if nodeStatus != nil {
+				close(nodeStatus)
+			}
+			if released != nil {
+				close(released)
+			}
+			return
+
+		case <-ctx.Done():
+			backend.Release(token)
+			timer.Stop()
+			if nodeStatus != nil {
+				close(nodeStatus)
+			}
+			AcknowledgeReleases(releaseNode)
+
+		case <-backend.Disconnected:
+			timer.Stop()
+			if nodeStatus != nil {
+				close(nodeStatus)
+			}
+			AcknowledgeReleases(releaseNode)
+		}
+	}
+}
+
+// AcknowledgeReleases closes every channel sent on releaseNode, for a node
+// that has already been let go of, so that a caller releasing it later does
+// not wait forever. It returns only if releaseNode is closed.
+func AcknowledgeReleases(releaseNode <-chan chan bool) {
+	for released := range releaseNode {
+		if released != nil {
+			close(released)
+		}
+	}
+}
+
+func elapsedChannelSend(clk clock.Clock, channel chan storeadapter.NodeStatus, val storeadapter.NodeStatus) time.Duration {
+	start := clk.Now()
+	channel <- val
+	return clk.Since(start)
+}
@@ -0,0 +1,191 @@
+package maintain_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/fakes"
+	"github.com/cloudfoundry/storeadapter/internal/maintain"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// recordingBackend fails acquires with acquireErr and refreshes with
+// refreshErr, and records how often it is acquired and the tokens it is
+// released with.
+type recordingBackend struct {
+	mutex      sync.Mutex
+	acquireErr error
+	refreshErr error
+	acquires   int
+	released   []uint64
+}
+
+func (backend *recordingBackend) backend(disconnected <-chan struct{}) maintain.Backend {
+	return maintain.Backend{
+		Acquire: func(context.Context) (uint64, error) {
+			backend.mutex.Lock()
+			defer backend.mutex.Unlock()
+			backend.acquires++
+			if backend.acquireErr != nil {
+				return 0, backend.acquireErr
+			}
+			return 7, nil
+		},
+		Refresh: func(context.Context, uint64) error {
+			backend.mutex.Lock()
+			defer backend.mutex.Unlock()
+			return backend.refreshErr
+		},
+		Release: func(token uint64) {
+			backend.mutex.Lock()
+			defer backend.mutex.Unlock()
+			backend.released = append(backend.released, token)
+		},
+		Disconnected: disconnected,
+	}
+}
+
+func (backend *recordingBackend) setAcquireErr(err error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	backend.acquireErr = err
+}
+
+func (backend *recordingBackend) acquireCount() int {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	return backend.acquires
+}
+
+func (backend *recordingBackend) releasedTokens() []uint64 {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	return append([]uint64{}, backend.released...)
+}
+
+var _ = Describe("Node", func() {
+	var (
+		ctx          context.Context
+		cancel       context.CancelFunc
+		disconnected chan struct{}
+		clock        *fakeclock.FakeClock
+		retryPolicy  *fakes.FakeRetryPolicy
+
+		recorder *recordingBackend
+
+		nodeStatus  <-chan storeadapter.NodeStatus
+		releaseNode chan chan bool
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		disconnected = make(chan struct{})
+		clock = fakeclock.NewFakeClock(time.Now())
+		retryPolicy = new(fakes.FakeRetryPolicy)
+		retryPolicy.DelayForReturns(time.Second, true)
+
+		recorder = new(recordingBackend)
+	})
+
+	JustBeforeEach(func() {
+		nodeStatus, releaseNode = maintain.Node(ctx, storeadapter.MaintainOptions{
+			RefreshInterval: time.Second,
+			StatusEvery:     1,
+			RetryPolicy:     retryPolicy,
+			Clock:           clock,
+		}, recorder.backend(disconnected))
+
+		if recorder.acquireErr == nil {
+			Eventually(nodeStatus).Should(Receive(Equal(storeadapter.NodeStatus{Owned: true, Token: 7})))
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("releases the node with its token when told to", func() {
+		released := make(chan bool)
+		releaseNode <- released
+
+		Eventually(released).Should(BeClosed())
+		Expect(nodeStatus).To(BeClosed())
+		Expect(recorder.releasedTokens()).To(Equal([]uint64{7}))
+	})
+
+	Context("when the retry policy gives up", func() {
+		BeforeEach(func() {
+			retryPolicy.DelayForReturns(0, false)
+			recorder.refreshErr = errors.New("oh no!")
+		})
+
+		It("reports the node lost, releases it and closes the status channel", func() {
+			clock.WaitForWatcherAndIncrement(time.Second)
+
+			Eventually(nodeStatus).Should(Receive(Equal(storeadapter.NodeStatus{Owned: false})))
+			Eventually(nodeStatus).Should(BeClosed())
+			Expect(recorder.releasedTokens()).To(Equal([]uint64{7}))
+		})
+
+		It("still acknowledges a release", func() {
+			clock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(nodeStatus).Should(BeClosed())
+
+			released := make(chan bool)
+			releaseNode <- released
+			Eventually(released).Should(BeClosed())
+		})
+	})
+
+	Context("when someone else holds the node", func() {
+		BeforeEach(func() {
+			retryPolicy.DelayForReturns(0, false)
+			recorder.acquireErr = &storeadapter.Error{Op: "MaintainNode", Err: storeadapter.ErrorKeyExists}
+		})
+
+		It("keeps trying for it every refresh interval, without counting a failure", func() {
+			Eventually(recorder.acquireCount).Should(Equal(1))
+
+			for attempt := 2; attempt <= 4; attempt++ {
+				clock.WaitForWatcherAndIncrement(time.Second)
+				Eventually(recorder.acquireCount).Should(Equal(attempt))
+			}
+			Consistently(nodeStatus).ShouldNot(Receive())
+			Expect(retryPolicy.DelayForCallCount()).To(BeZero())
+
+			recorder.setAcquireErr(nil)
+			clock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(nodeStatus).Should(Receive(Equal(storeadapter.NodeStatus{Owned: true, Token: 7})))
+		})
+	})
+
+	Context("when the context is done", func() {
+		It("releases the node and acknowledges a later release", func() {
+			cancel()
+			Eventually(nodeStatus).Should(BeClosed())
+			Expect(recorder.releasedTokens()).To(Equal([]uint64{7}))
+
+			released := make(chan bool)
+			Eventually(releaseNode).Should(BeSent(released))
+			Eventually(released).Should(BeClosed())
+		})
+	})
+
+	Context("when the adapter disconnects", func() {
+		It("stops without releasing the node, and acknowledges a later release", func() {
+			close(disconnected)
+			Eventually(nodeStatus).Should(BeClosed())
+			Expect(recorder.releasedTokens()).To(BeEmpty())
+
+			released := make(chan bool)
+			Eventually(releaseNode).Should(BeSent(released))
+			Eventually(released).Should(BeClosed())
+		})
+	})
+})
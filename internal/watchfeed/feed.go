@@ -0,0 +1,194 @@
+// Package watchfeed hands the events of an in-process store's writes to its
+// watches, keeping the latest of them for watches that resume from an index.
+package watchfeed
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry/storeadapter"
+)
+
+// Feed is guarded by the store's write lock: Publish, Register, Unregister
+// and Reset are called with it held, so that watchers see events in index
+// order, and a watcher misses no event published after it is registered, and
+// sees none twice.
+type Feed struct {
+	historySize int
+
+	watchers map[*Watcher]bool
+	history  []storeadapter.WatchEvent
+
+	// Watches can resume from any index from start on.
+	start uint64
+}
+
+// New returns a feed keeping at least the latest historySize events.
+func New(historySize int) *Feed {
+	return &Feed{
+		historySize: historySize,
+		watchers:    map[*Watcher]bool{},
+	}
+}
+
+// Reset forgets the history, so that watches can only resume from index on.
+func (feed *Feed) Reset(index uint64) {
+	feed.history = nil
+	feed.start = index
+}
+
+// Publish records the events of a committed write and queues them for the
+// watches they concern.
+func (feed *Feed) Publish(events []storeadapter.WatchEvent) {
+	for _, event := range events {
+		feed.history = append(feed.history, event)
+
+		for w := range feed.watchers {
+			if w.covers(event) {
+				w.push(event)
+			}
+		}
+	}
+
+	// The history is trimmed back to historySize once it is twice as long.
+	if dropped := len(feed.history) - feed.historySize; dropped >= feed.historySize {
+		feed.start = feed.history[dropped-1].Index
+		feed.history = append([]storeadapter.WatchEvent{}, feed.history[dropped:]...)
+	}
+}
+
+// Register adds a watcher for key. A resumed watcher is first given the
+// events after afterIndex from the history; if those have been trimmed, it
+// is not registered, and ok is false.
+func (feed *Feed) Register(key string, afterIndex uint64, resume bool) (w *Watcher, ok bool) {
+	if resume && afterIndex < feed.start {
+		return nil, false
+	}
+
+	w = newWatcher(key, afterIndex)
+
+	if resume {
+		for _, event := range feed.history {
+			if w.covers(event) {
+				w.push(event)
+			}
+		}
+	}
+
+	feed.watchers[w] = true
+
+	return w, true
+}
+
+func (feed *Feed) Unregister(w *Watcher) {
+	delete(feed.watchers, w)
+}
+
+// Watcher queues the events for one watch, so that publishing them never
+// waits on the watch's reader.
+type Watcher struct {
+	key        string
+	afterIndex uint64
+
+	mutex sync.Mutex
+	queue []storeadapter.WatchEvent
+	wake  chan bool
+}
+
+func newWatcher(key string, afterIndex uint64) *Watcher {
+	return &Watcher{
+		key:        key,
+		afterIndex: afterIndex,
+		wake:       make(chan bool, 1),
+	}
+}
+
+// covers reports whether the event is about the watched key or a key under
+// it, or removes a directory the watched key is under.
+func (w *Watcher) covers(event storeadapter.WatchEvent) bool {
+	if event.Index <= w.afterIndex {
+		return false
+	}
+
+	if event.Node != nil {
+		return isUnder(event.Node.Key, w.key)
+	}
+
+	return isUnder(event.PrevNode.Key, w.key) || event.PrevNode.Dir && isUnder(w.key, event.PrevNode.Key)
+}
+
+func (w *Watcher) push(event storeadapter.WatchEvent) {
+	w.mutex.Lock()
+	w.queue = append(w.queue, event)
+	w.mutex.Unlock()
+
+	select {
+	case w.wake <- true:
+	default:
+	}
+}
+
+func (w *Watcher) next() (storeadapter.WatchEvent, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.queue) == 0 {
+		return storeadapter.WatchEvent{}, false
+	}
+
+	event := w.queue[0]
+	w.queue = w.queue[1:]
+
+	return event, true
+}
+
+// Dispatch sends the watcher's events until the watch is stopped, ctx is
+// done or disconnected is closed. It then calls unregister and closes events
+// and errs.
+func (w *Watcher) Dispatch(ctx context.Context, disconnected <-chan struct{}, events chan<- storeadapter.WatchEvent, stop chan bool, errs chan<- error, unregister func()) {
+	defer close(events)
+	defer close(errs)
+	defer unregister()
+
+	for {
+		event, ok := w.next()
+		if !ok {
+			select {
+			case <-w.wake:
+				continue
+			case <-stop:
+			case <-ctx.Done():
+			case <-disconnected:
+			}
+			return
+		}
+
+		select {
+		case events <- event:
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-disconnected:
+			return
+		}
+	}
+}
+
+// Refuse reports err on errs, unless ctx is done or disconnected is closed
+// first, then closes events and errs.
+func Refuse(ctx context.Context, disconnected <-chan struct{}, err error, events chan<- storeadapter.WatchEvent, errs chan<- error) {
+	defer close(events)
+	defer close(errs)
+
+	select {
+	case errs <- err:
+	case <-ctx.Done():
+	case <-disconnected:
+	}
+}
+
+func isUnder(key, dir string) bool {
+	return key == dir || strings.HasPrefix(key, dir+"/")
+}
@@ -0,0 +1,114 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/cloudfoundry/storeadapter"
+)
+
+var ErrorNoLeader = errors.New("there is no leader")
+
+// ElectionCallbacks are called from LeaderElector.Run as the candidate gains
+// and loses leadership. Either may be nil.
+type ElectionCallbacks struct {
+	OnElected func()
+	OnDemoted func()
+}
+
+// LeaderElector campaigns for leadership by holding a lock on a key. The
+// leader is whichever candidate holds the lock, and the lock's value is that
+// candidate's identity.
+type LeaderElector struct {
+	lock      *Lock
+	adapter   storeadapter.StoreAdapter
+	callbacks ElectionCallbacks
+
+	mutex   sync.RWMutex
+	leading bool
+}
+
+// NewLeaderElector returns an elector for the election held at key. If
+// candidate is empty, a unique one is generated.
+func NewLeaderElector(adapter storeadapter.StoreAdapter, key string, candidate []byte, ttl uint64, callbacks ElectionCallbacks) (*LeaderElector, error) {
+	lock, err := New(adapter, key, candidate, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LeaderElector{
+		lock:      lock,
+		adapter:   adapter,
+		callbacks: callbacks,
+	}, nil
+}
+
+func (elector *LeaderElector) Candidate() []byte {
+	return elector.lock.Owner()
+}
+
+// Run campaigns until the context is done, calling OnElected each time the
+// candidate becomes the leader and OnDemoted each time it stops being the
+// leader. Leadership is given up when the context is done, and Run then
+// returns the context's error. Run returns early if campaigning fails.
+func (elector *LeaderElector) Run(ctx context.Context) error {
+	for {
+		lost, err := elector.lock.Lock(ctx)
+		if err != nil {
+			return err
+		}
+
+		elector.setLeading(true)
+		if elector.callbacks.OnElected != nil {
+			elector.callbacks.OnElected()
+		}
+
+		select {
+		case <-lost:
+		case <-ctx.Done():
+			elector.lock.Unlock()
+		}
+
+		elector.setLeading(false)
+		if elector.callbacks.OnDemoted != nil {
+			elector.callbacks.OnDemoted()
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+func (elector *LeaderElector) IsLeader() bool {
+	elector.mutex.RLock()
+	defer elector.mutex.RUnlock()
+
+	return elector.leading
+}
+
+// Leader returns the identity of the current leader, or ErrorNoLeader if
+// there is none.
+func (elector *LeaderElector) Leader() ([]byte, error) {
+	if elector.IsLeader() {
+		return elector.Candidate(), nil
+	}
+
+	node, err := elector.adapter.Get(elector.lock.Key())
+	if errors.Is(err, storeadapter.ErrorKeyNotFound) {
+		return nil, ErrorNoLeader
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return node.Value, nil
+}
+
+func (elector *LeaderElector) setLeading(leading bool) {
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+
+	elector.leading = leading
+}
@@ -0,0 +1,124 @@
+package lock_test
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/fakestoreadapter"
+	. "github.com/cloudfoundry/storeadapter/lock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LeaderElector", func() {
+	var (
+		adapter *fakestoreadapter.FakeStoreAdapter
+		elector *LeaderElector
+
+		transitionsLock sync.Mutex
+		transitions     []string
+
+		ctx    context.Context
+		cancel context.CancelFunc
+		result chan error
+		done   chan struct{}
+	)
+
+	getTransitions := func() []string {
+		transitionsLock.Lock()
+		defer transitionsLock.Unlock()
+		return append([]string{}, transitions...)
+	}
+
+	record := func(transition string) func() {
+		return func() {
+			transitionsLock.Lock()
+			defer transitionsLock.Unlock()
+			transitions = append(transitions, transition)
+		}
+	}
+
+	BeforeEach(func() {
+		adapter = fakestoreadapter.New()
+		adapter.OnReleaseNodeChannel = func(releaseNode chan chan bool) {
+			if released := <-releaseNode; released != nil {
+				close(released)
+			}
+		}
+
+		transitions = nil
+
+		var err error
+		elector, err = NewLeaderElector(adapter, "/leader", []byte("me"), 10, ElectionCallbacks{
+			OnElected: record("elected"),
+			OnDemoted: record("demoted"),
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx, cancel = context.WithCancel(context.Background())
+		result = make(chan error, 1)
+		done = make(chan struct{})
+		go func() {
+			result <- elector.Run(ctx)
+			close(done)
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("is not the leader until it holds the lock", func() {
+		Consistently(elector.IsLeader).Should(BeFalse())
+		Expect(getTransitions()).To(BeEmpty())
+	})
+
+	Context("when elected", func() {
+		BeforeEach(func() {
+			adapter.MaintainNodeStatus <- true
+			Eventually(getTransitions).Should(Equal([]string{"elected"}))
+		})
+
+		It("is the leader", func() {
+			Expect(elector.IsLeader()).To(BeTrue())
+
+			leader, err := elector.Leader()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leader).To(Equal([]byte("me")))
+		})
+
+		It("is demoted when the lock is lost, and campaigns again", func() {
+			adapter.MaintainNodeStatus <- false
+			Eventually(getTransitions).Should(Equal([]string{"elected", "demoted"}))
+			Expect(elector.IsLeader()).To(BeFalse())
+
+			adapter.MaintainNodeStatus <- true
+			Eventually(getTransitions).Should(Equal([]string{"elected", "demoted", "elected"}))
+		})
+
+		It("steps down when the context is done", func() {
+			cancel()
+			Eventually(result).Should(Receive(Equal(context.Canceled)))
+			Expect(getTransitions()).To(Equal([]string{"elected", "demoted"}))
+			Expect(elector.IsLeader()).To(BeFalse())
+		})
+	})
+
+	Describe("Leader", func() {
+		It("returns ErrorNoLeader when nobody holds the lock", func() {
+			_, err := elector.Leader()
+			Expect(err).To(Equal(ErrorNoLeader))
+		})
+
+		It("returns the value of the lock held by another candidate", func() {
+			err := adapter.Create(storeadapter.StoreNode{Key: "/leader", Value: []byte("someone else")})
+			Expect(err).NotTo(HaveOccurred())
+
+			leader, err := elector.Leader()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leader).To(Equal([]byte("someone else")))
+		})
+	})
+})
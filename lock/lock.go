@@ -0,0 +1,259 @@
+package lock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/nu7hatch/gouuid"
+)
+
+var (
+	ErrorLockHeld  = errors.New("the lock is held by another owner")
+	ErrorNotLocked = errors.New("the lock is not held")
+)
+
+// Lock is a lock on a key, held by maintaining a node at the key with
+// StoreAdapter.MaintainNode. The node's value identifies the owner.
+type Lock struct {
+	adapter storeadapter.StoreAdapter
+	node    storeadapter.StoreNode
+
+	// locking admits one caller at a time to take the lock, so that mutex is
+	// not held while waiting for it.
+	locking chan struct{}
+
+	mutex sync.Mutex
+	held  *hold
+}
+
+type hold struct {
+	status  <-chan bool
+	release chan chan bool
+	unlock  chan chan struct{}
+	lost    chan struct{}
+}
+
+// New returns a lock on key that is lost if it is not refreshed within ttl
+// seconds. If owner is empty, a unique one is generated.
+func New(adapter storeadapter.StoreAdapter, key string, owner []byte, ttl uint64) (*Lock, error) {
+	if len(owner) == 0 {
+		guid, err := uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+		owner = []byte(guid.String())
+	}
+
+	return &Lock{
+		adapter: adapter,
+		node: storeadapter.StoreNode{
+			Key:   key,
+			Value: owner,
+			TTL:   ttl,
+		},
+		locking: make(chan struct{}, 1),
+	}, nil
+}
+
+func (l *Lock) Key() string {
+	return l.node.Key
+}
+
+func (l *Lock) Owner() []byte {
+	return l.node.Value
+}
+
+// Lock blocks until the lock is held or the context is done. The returned
+// channel is closed once the lock is no longer held, either because it was
+// lost or because it was unlocked. Locking a lock that is already held
+// returns immediately.
+func (l *Lock) Lock(ctx context.Context) (<-chan struct{}, error) {
+	return l.lock(ctx, false)
+}
+
+// TryLock is like Lock, but makes a single attempt, returning ErrorLockHeld
+// straight away if another owner holds the lock.
+func (l *Lock) TryLock(ctx context.Context) (<-chan struct{}, error) {
+	return l.lock(ctx, true)
+}
+
+func (l *Lock) lock(ctx context.Context, try bool) (<-chan struct{}, error) {
+	select {
+	case l.locking <- struct{}{}:
+		defer func() { <-l.locking }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if lost, ok := l.lostIfHeld(); ok {
+		return lost, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var created bool
+	if try {
+		var err error
+		created, err = l.claim()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	status, release, err := l.adapter.MaintainNode(l.node)
+	if err != nil {
+		// Nothing will refresh or release the node just created, so it is
+		// deleted rather than left to lock others out until it expires.
+		if created {
+			l.adapter.CompareAndDelete(l.node)
+		}
+		return nil, err
+	}
+
+	for {
+		select {
+		case owned, ok := <-status:
+			if ok && owned {
+				held := &hold{
+					status:  status,
+					release: release,
+					unlock:  make(chan chan struct{}),
+					lost:    make(chan struct{}),
+				}
+				go held.monitor()
+
+				l.mutex.Lock()
+				l.held = held
+				l.mutex.Unlock()
+
+				return held.lost, nil
+			}
+
+			if !ok {
+				return nil, ErrorNotLocked
+			}
+
+			if try {
+				releaseNode(status, release)
+				return nil, ErrorLockHeld
+			}
+
+		case <-ctx.Done():
+			releaseNode(status, release)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// lostIfHeld returns the lost channel of the hold on the lock, if it is
+// still held.
+func (l *Lock) lostIfHeld() (<-chan struct{}, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.held == nil {
+		return nil, false
+	}
+
+	select {
+	case <-l.held.lost:
+		l.held = nil
+		return nil, false
+	default:
+		return l.held.lost, true
+	}
+}
+
+// claim makes a single attempt at the lock by creating its node, returning
+// ErrorLockHeld if another owner's node is there, even one that has yet to
+// expire. A node of this owner's is left to MaintainNode to take over. It
+// reports whether it created the node.
+func (l *Lock) claim() (bool, error) {
+	for {
+		err := l.adapter.Create(l.node)
+		if !errors.Is(err, storeadapter.ErrorKeyExists) {
+			return err == nil, err
+		}
+
+		node, err := l.adapter.Get(l.node.Key)
+		if errors.Is(err, storeadapter.ErrorKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if !bytes.Equal(node.Value, l.node.Value) {
+			return false, ErrorLockHeld
+		}
+
+		return false, nil
+	}
+}
+
+// Unlock releases the lock, waiting until its node has been removed. It
+// returns ErrorNotLocked if the lock is not held.
+func (l *Lock) Unlock() error {
+	l.mutex.Lock()
+	held := l.held
+	l.held = nil
+	l.mutex.Unlock()
+
+	if held == nil {
+		return ErrorNotLocked
+	}
+
+	unlocked := make(chan struct{})
+	select {
+	case held.unlock <- unlocked:
+		<-unlocked
+		return nil
+	case <-held.lost:
+		return nil
+	}
+}
+
+func (h *hold) monitor() {
+	for {
+		select {
+		case owned, ok := <-h.status:
+			if !ok || !owned {
+				releaseNode(h.status, h.release)
+				close(h.lost)
+				return
+			}
+
+		case unlocked := <-h.unlock:
+			releaseNode(h.status, h.release)
+			close(h.lost)
+			close(unlocked)
+			return
+		}
+	}
+}
+
+// releaseNode stops maintaining a node and waits for it to be released,
+// draining status so the maintainer is never blocked reporting to us.
+func releaseNode(status <-chan bool, release chan chan bool) {
+	released := make(chan bool)
+	var waitForRelease chan bool
+
+	for {
+		select {
+		case release <- released:
+			release = nil
+			waitForRelease = released
+		case _, ok := <-status:
+			if !ok {
+				status = nil
+			}
+		case <-waitForRelease:
+			return
+		}
+	}
+}
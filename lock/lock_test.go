@@ -0,0 +1,246 @@
+package lock_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/fakestoreadapter"
+	. "github.com/cloudfoundry/storeadapter/lock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lock", func() {
+	var (
+		adapter  *fakestoreadapter.FakeStoreAdapter
+		releases chan chan bool
+		lock     *Lock
+	)
+
+	BeforeEach(func() {
+		adapter = fakestoreadapter.New()
+
+		releases = make(chan chan bool, 10)
+		adapter.OnReleaseNodeChannel = func(releaseNode chan chan bool) {
+			released := <-releaseNode
+			releases <- released
+			if released != nil {
+				close(released)
+			}
+		}
+
+		var err error
+		lock, err = New(adapter, "/lock", []byte("me"), 10)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("generates an owner if none is given", func() {
+		lock, err := New(adapter, "/lock", nil, 10)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Owner()).NotTo(BeEmpty())
+	})
+
+	Describe("Lock", func() {
+		It("maintains a node at the key, owned by the lock's owner", func() {
+			adapter.MaintainNodeStatus <- true
+
+			_, err := lock.Lock(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(adapter.GetMaintainedNodeName()).To(Equal("/lock"))
+			Expect(adapter.MaintainedNodeValue).To(Equal([]byte("me")))
+		})
+
+		It("blocks until the node is held", func() {
+			locked := make(chan error)
+			go func() {
+				_, err := lock.Lock(context.Background())
+				locked <- err
+			}()
+
+			Consistently(locked).ShouldNot(Receive())
+
+			adapter.MaintainNodeStatus <- true
+			Eventually(locked).Should(Receive(BeNil()))
+		})
+
+		It("returns immediately if the lock is already held", func() {
+			adapter.MaintainNodeStatus <- true
+			lost, err := lock.Lock(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			lostAgain, err := lock.Lock(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lostAgain).To(Equal(lost))
+		})
+
+		Context("when the context is done before the node is held", func() {
+			It("stops maintaining the node and returns the context's error", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				locked := make(chan error)
+				go func() {
+					_, err := lock.Lock(ctx)
+					locked <- err
+				}()
+
+				Eventually(adapter.GetMaintainedNodeName).Should(Equal("/lock"))
+
+				cancel()
+				Eventually(locked).Should(Receive(Equal(context.Canceled)))
+				Expect(releases).To(Receive(Not(BeNil())))
+			})
+		})
+
+		Context("when maintaining the node fails", func() {
+			BeforeEach(func() {
+				adapter.MaintainNodeError = storeadapter.ErrorInvalidTTL
+			})
+
+			It("returns the error", func() {
+				_, err := lock.Lock(context.Background())
+				Expect(err).To(Equal(storeadapter.ErrorInvalidTTL))
+			})
+		})
+
+		Context("while waiting for the node", func() {
+			It("does not hold up Unlock, or Lock with a context that is done", func() {
+				go lock.Lock(context.Background())
+				Eventually(adapter.GetMaintainedNodeName).Should(Equal("/lock"))
+
+				unlocked := make(chan error, 1)
+				go func() {
+					unlocked <- lock.Unlock()
+				}()
+				Eventually(unlocked).Should(Receive(Equal(ErrorNotLocked)))
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err := lock.Lock(ctx)
+				Expect(err).To(Equal(context.Canceled))
+
+				adapter.MaintainNodeStatus <- true
+			})
+		})
+
+		Context("when the lock is lost", func() {
+			It("stops maintaining the node and closes the lost channel", func() {
+				adapter.MaintainNodeStatus <- true
+				lost, err := lock.Lock(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+
+				Consistently(lost).ShouldNot(BeClosed())
+
+				adapter.MaintainNodeStatus <- false
+				Eventually(lost).Should(BeClosed())
+				Expect(releases).To(Receive(Not(BeNil())))
+
+				Expect(lock.Unlock()).To(Succeed())
+			})
+		})
+	})
+
+	Describe("TryLock", func() {
+		Context("when another owner holds the lock", func() {
+			BeforeEach(func() {
+				err := adapter.Create(storeadapter.StoreNode{Key: "/lock", Value: []byte("someone else")})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns ErrorLockHeld without maintaining the node", func() {
+				_, err := lock.TryLock(context.Background())
+				Expect(err).To(Equal(ErrorLockHeld))
+				Expect(adapter.GetMaintainedNodeName()).To(BeEmpty())
+			})
+		})
+
+		Context("when nobody holds the lock", func() {
+			It("locks it", func() {
+				adapter.MaintainNodeStatus <- true
+				_, err := lock.TryLock(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(adapter.GetMaintainedNodeName()).To(Equal("/lock"))
+
+				node, err := adapter.Get("/lock")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(node.Value).To(Equal([]byte("me")))
+			})
+		})
+
+		Context("when the lock's node is this owner's from before", func() {
+			BeforeEach(func() {
+				err := adapter.Create(storeadapter.StoreNode{Key: "/lock", Value: []byte("me")})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("takes it over", func() {
+				adapter.MaintainNodeStatus <- true
+				_, err := lock.TryLock(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(adapter.GetMaintainedNodeName()).To(Equal("/lock"))
+			})
+		})
+
+		Context("when the node is not held once maintained", func() {
+			It("stops maintaining it and returns ErrorLockHeld", func() {
+				adapter.MaintainNodeStatus <- false
+				_, err := lock.TryLock(context.Background())
+				Expect(err).To(Equal(ErrorLockHeld))
+				Expect(releases).To(Receive(Not(BeNil())))
+			})
+		})
+
+		Context("when maintaining the node it created fails", func() {
+			BeforeEach(func() {
+				adapter.MaintainNodeError = storeadapter.ErrorInvalidTTL
+			})
+
+			It("deletes the node and returns the error", func() {
+				_, err := lock.TryLock(context.Background())
+				Expect(err).To(Equal(storeadapter.ErrorInvalidTTL))
+
+				_, err = adapter.Get("/lock")
+				Expect(err).To(Equal(storeadapter.ErrorKeyNotFound))
+			})
+
+			It("leaves a node of this owner's from before", func() {
+				err := adapter.Create(storeadapter.StoreNode{Key: "/lock", Value: []byte("me")})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = lock.TryLock(context.Background())
+				Expect(err).To(Equal(storeadapter.ErrorInvalidTTL))
+
+				_, err = adapter.Get("/lock")
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when checking the lock fails", func() {
+			BeforeEach(func() {
+				adapter.CreateErrInjector = fakestoreadapter.NewFakeStoreAdapterErrorInjector("lock", errors.New("oops"))
+			})
+
+			It("returns the error", func() {
+				_, err := lock.TryLock(context.Background())
+				Expect(err).To(MatchError("oops"))
+			})
+		})
+	})
+
+	Describe("Unlock", func() {
+		It("releases the node and closes the lost channel", func() {
+			adapter.MaintainNodeStatus <- true
+			lost, err := lock.Lock(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(lock.Unlock()).To(Succeed())
+			Expect(lost).To(BeClosed())
+			Expect(releases).To(Receive(Not(BeNil())))
+		})
+
+		It("returns ErrorNotLocked when the lock is not held", func() {
+			Expect(lock.Unlock()).To(Equal(ErrorNotLocked))
+		})
+	})
+})
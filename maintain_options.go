@@ -0,0 +1,84 @@
+package storeadapter
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+const (
+	defaultMaintainStatusEvery = 2
+	defaultMaintainRetryDelay  = 2 * time.Second
+)
+
+// MaintainOptions configures how a maintained node is kept alive. The zero
+// value of each field selects its default.
+type MaintainOptions struct {
+	// How often the node's TTL is refreshed. It must be shorter than the TTL,
+	// and defaults to half of it.
+	RefreshInterval time.Duration
+
+	// While the node is owned, a status is sent after every StatusEvery
+	// refreshes, as well as whenever ownership changes. Defaults to 2.
+	StatusEvery int
+
+	// How long to wait after failing to create or refresh the node, by number
+	// of consecutive failures. Finding the node held by someone else is not a
+	// failure: it is tried for again after RefreshInterval. If the policy
+	// gives up, the node is reported lost, deleted if it is still held, and
+	// the status channel is closed; maintaining stops, though a release is
+	// still acknowledged. Defaults to 2 seconds, doubling up to the refresh
+	// interval, without giving up.
+	RetryPolicy RetryPolicy
+
+	// Drives the refresh and retry timers. Defaults to the real clock.
+	Clock clock.Clock
+}
+
+// WithDefaults fills in the defaults for a node with the given TTL, returning
+// ErrorInvalidTTL if the TTL is not longer than the refresh interval.
+func (options MaintainOptions) WithDefaults(ttl uint64) (MaintainOptions, error) {
+	if ttl == 0 {
+		return options, ErrorInvalidTTL
+	}
+
+	if options.RefreshInterval == 0 {
+		options.RefreshInterval = time.Duration(ttl) * time.Second / 2
+	}
+
+	if options.RefreshInterval < 0 || options.RefreshInterval >= time.Duration(ttl)*time.Second {
+		return options, ErrorInvalidTTL
+	}
+
+	if options.StatusEvery <= 0 {
+		options.StatusEvery = defaultMaintainStatusEvery
+	}
+
+	if options.RetryPolicy == nil {
+		options.RetryPolicy = maintainRetryPolicy{max: options.RefreshInterval}
+	}
+	options.RetryPolicy = NewRetrySequence(options.RetryPolicy)
+
+	if options.Clock == nil {
+		options.Clock = clock.NewClock()
+	}
+
+	return options, nil
+}
+
+type maintainRetryPolicy struct {
+	max time.Duration
+}
+
+func (policy maintainRetryPolicy) DelayFor(attempts uint) (time.Duration, bool) {
+	delay := defaultMaintainRetryDelay
+	for i := uint(1); i < attempts && delay < policy.max; i++ {
+		delay *= 2
+	}
+
+	if delay > policy.max {
+		delay = policy.max
+	}
+
+	return delay, true
+}
@@ -0,0 +1,58 @@
+package storeadapter_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	. "github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MaintainOptions", func() {
+	Describe("WithDefaults", func() {
+		It("refreshes at half the TTL, reporting every other refresh", func() {
+			options, err := MaintainOptions{}.WithDefaults(10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(options.RefreshInterval).To(Equal(5 * time.Second))
+			Expect(options.StatusEvery).To(Equal(2))
+			Expect(options.Clock).NotTo(BeNil())
+		})
+
+		It("retries after 2 seconds, doubling up to the refresh interval", func() {
+			options, err := MaintainOptions{}.WithDefaults(10)
+			Expect(err).NotTo(HaveOccurred())
+
+			for attempts, expected := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+				delay, keepRetrying := options.RetryPolicy.DelayFor(uint(attempts + 1))
+				Expect(delay).To(Equal(expected))
+				Expect(keepRetrying).To(BeTrue())
+			}
+		})
+
+		It("keeps the options that are given", func() {
+			given := MaintainOptions{
+				RefreshInterval: 100 * time.Millisecond,
+				StatusEvery:     5,
+				RetryPolicy:     new(fakes.FakeRetryPolicy),
+				Clock:           fakeclock.NewFakeClock(time.Now()),
+			}
+
+			options, err := given.WithDefaults(1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(options).To(Equal(given))
+		})
+
+		It("returns ErrorInvalidTTL for a TTL of 0", func() {
+			_, err := MaintainOptions{}.WithDefaults(0)
+			Expect(err).To(Equal(ErrorInvalidTTL))
+		})
+
+		It("returns ErrorInvalidTTL if the refresh interval is not shorter than the TTL", func() {
+			_, err := MaintainOptions{RefreshInterval: time.Second}.WithDefaults(1)
+			Expect(err).To(Equal(ErrorInvalidTTL))
+		})
+	})
+})
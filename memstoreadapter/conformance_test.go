@@ -0,0 +1,14 @@
+package memstoreadapter_test
+
+import (
+	"github.com/cloudfoundry/storeadapter"
+	. "github.com/cloudfoundry/storeadapter/memstoreadapter"
+	"github.com/cloudfoundry/storeadapter/storeadaptertest"
+)
+
+var _ = storeadaptertest.DescribeConformance("Mem Store Adapter conformance", storeadaptertest.Backend{
+	NewStoreAdapter: func() storeadapter.StoreAdapter {
+		return New(nil)
+	},
+	EmptyDirectoriesRemain: true,
+})
@@ -0,0 +1,133 @@
+package memstoreadapter
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/internal/maintain"
+	"github.com/nu7hatch/gouuid"
+)
+
+func (adapter *MemStoreAdapter) MaintainNode(storeNode storeadapter.StoreNode) (<-chan bool, chan (chan bool), error) {
+	return adapter.MaintainNodeContext(context.Background(), storeNode)
+}
+
+func (adapter *MemStoreAdapter) MaintainNodeContext(ctx context.Context, storeNode storeadapter.StoreNode) (<-chan bool, chan (chan bool), error) {
+	status, releaseNode, err := adapter.MaintainNodeWithTokenContext(ctx, storeNode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owned := make(chan bool)
+	go func() {
+		defer close(owned)
+		for nodeStatus := range status {
+			owned <- nodeStatus.Owned
+		}
+	}()
+
+	return owned, releaseNode, nil
+}
+
+func (adapter *MemStoreAdapter) MaintainNodeWithToken(storeNode storeadapter.StoreNode) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	return adapter.MaintainNodeWithTokenContext(context.Background(), storeNode)
+}
+
+func (adapter *MemStoreAdapter) MaintainNodeWithTokenContext(ctx context.Context, storeNode storeadapter.StoreNode) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	return adapter.MaintainNodeWithOptionsContext(ctx, storeNode, storeadapter.MaintainOptions{})
+}
+
+func (adapter *MemStoreAdapter) MaintainNodeWithOptions(storeNode storeadapter.StoreNode, options storeadapter.MaintainOptions) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	return adapter.MaintainNodeWithOptionsContext(context.Background(), storeNode, options)
+}
+
+// The node is written with its TTL, and each refresh pushes back when it
+// expires without modifying it, so watchers only see it acquired and
+// released. The fencing token is the index the node was written at.
+func (adapter *MemStoreAdapter) MaintainNodeWithOptionsContext(ctx context.Context, storeNode storeadapter.StoreNode, options storeadapter.MaintainOptions) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	options, err := options.WithDefaults(storeNode.TTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(storeNode.Value) == 0 {
+		guid, err := uuid.NewV4()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		storeNode.Value = []byte(guid.String())
+	}
+
+	storeNode.Key = cleanKey(storeNode.Key)
+
+	nodeStatus, releaseNode := maintain.Node(ctx, options, maintain.Backend{
+		Acquire: func(context.Context) (uint64, error) {
+			return adapter.acquireNode(storeNode)
+		},
+		Refresh: func(_ context.Context, token uint64) error {
+			return adapter.refreshNode(storeNode, token)
+		},
+		Release: func(token uint64) {
+			adapter.releaseNode(storeNode, token)
+		},
+		Disconnected: adapter.ctx.Done(),
+	})
+
+	return nodeStatus, releaseNode, nil
+}
+
+// acquireNode writes the node if nobody else holds it, returning the index it
+// was written at.
+func (adapter *MemStoreAdapter) acquireNode(storeNode storeadapter.StoreNode) (uint64, error) {
+	return adapter.update("MaintainNode", storeNode.Key, func(txn *memTxn) error {
+		current := txn.record(storeNode.Key)
+
+		// A node holding our value is one we held before, so it is taken over.
+		if current != nil && (current.dir || !bytes.Equal(current.value, storeNode.Value)) {
+			return keyError("MaintainNode", storeNode.Key, txn.index, storeadapter.ErrorKeyExists)
+		}
+
+		return txn.putLeaf("MaintainNode", storeNode)
+	})
+}
+
+// refreshNode gives the node its full TTL again, returning maintain.ErrNodeLost if it
+// is no longer the one written with token.
+func (adapter *MemStoreAdapter) refreshNode(storeNode storeadapter.StoreNode, token uint64) error {
+	_, err := adapter.update("MaintainNode", storeNode.Key, func(txn *memTxn) error {
+		current := txn.record(storeNode.Key)
+		if current == nil || current.dir || current.index != token || !bytes.Equal(current.value, storeNode.Value) {
+			return maintain.ErrNodeLost
+		}
+
+		refreshed := *current
+		refreshed.expires = txn.expiresAt(storeNode.TTL)
+
+		txn.put(storeNode.Key, refreshed)
+		return nil
+	})
+
+	return err
+}
+
+// releaseNode deletes the node if it is still the one written with token.
+func (adapter *MemStoreAdapter) releaseNode(storeNode storeadapter.StoreNode, token uint64) {
+	if token == 0 {
+		return
+	}
+
+	adapter.update("MaintainNode", storeNode.Key, func(txn *memTxn) error {
+		current := txn.record(storeNode.Key)
+		if current != nil && !current.dir && current.index == token {
+			txn.deleteTree(storeNode.Key, *current, storeadapter.DeleteEvent)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,535 @@
+package memstoreadapter
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/internal/watchfeed"
+)
+
+var (
+	errRootReadOnly = errors.New("the root directory is read only")
+	errNotConnected = errors.New("the store is not connected")
+)
+
+const (
+	// How often nodes whose TTL has run out are looked for.
+	expiryInterval = 500 * time.Millisecond
+
+	// How many of the latest events are kept for WatchFrom, at least.
+	historySize = 1000
+)
+
+type MemOptions struct {
+	// Drives TTL expiry. Defaults to the real clock.
+	Clock clock.Clock
+}
+
+// MemStoreAdapter is a StoreAdapter that keeps its nodes in memory, for a
+// single process that needs a store but no server. Unlike the fake, it
+// implements every method as etcd does.
+//
+// As in etcd v2, every node, leaf or directory, is kept under its key, and
+// directories are created as keys are put under them. Every write is made at
+// the next index, and a node's Index is the index it was last modified at. A
+// node is removed once its TTL runs out, along with everything under it.
+//
+// WatchFrom can resume from any of the latest 1000 events, and reports
+// ErrorWatchIndexCleared for an earlier index.
+type MemStoreAdapter struct {
+	options MemOptions
+
+	// lock guards everything below it. Writes hold it while they publish
+	// their events, so that watchers see events in index order.
+	lock      sync.RWMutex
+	connected bool
+	nodes     map[string]record
+	children  map[string]map[string]bool
+	expiries  expiryQueue
+	index     uint64
+	feed      *watchfeed.Feed
+
+	// ctx is cancelled by Disconnect, stopping watches, maintained nodes and
+	// expiry.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New returns an empty store. options may be nil, for the defaults.
+func New(options *MemOptions) *MemStoreAdapter {
+	adapterOptions := MemOptions{}
+	if options != nil {
+		adapterOptions = *options
+	}
+	if adapterOptions.Clock == nil {
+		adapterOptions.Clock = clock.NewClock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &MemStoreAdapter{
+		options:  adapterOptions,
+		nodes:    map[string]record{},
+		children: map[string]map[string]bool{},
+		feed:     watchfeed.New(historySize),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (adapter *MemStoreAdapter) Connect() error {
+	return adapter.ConnectContext(context.Background())
+}
+
+func (adapter *MemStoreAdapter) ConnectContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	adapter.lock.Lock()
+	defer adapter.lock.Unlock()
+
+	if adapter.ctx.Err() != nil {
+		return adapter.convertError("Connect", "", errNotConnected)
+	}
+
+	if !adapter.connected {
+		adapter.connected = true
+		go adapter.expireNodes()
+	}
+
+	return nil
+}
+
+// Disconnect stops the adapter for good. The nodes can no longer be read.
+func (adapter *MemStoreAdapter) Disconnect() error {
+	adapter.cancel()
+
+	adapter.lock.Lock()
+	defer adapter.lock.Unlock()
+
+	adapter.connected = false
+
+	return nil
+}
+
+// cleanKey returns key as it is stored: rooted, without a trailing slash, and
+// empty for the root itself.
+func cleanKey(key string) string {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" {
+		return ""
+	}
+
+	return cleaned
+}
+
+// ancestors returns the directories above key, outermost first.
+func ancestors(key string) []string {
+	dirs := []string{}
+	for i := 1; i < len(key); i++ {
+		if key[i] == '/' {
+			dirs = append(dirs, key[:i])
+		}
+	}
+
+	return dirs
+}
+
+// parentKey returns the directory key is in.
+func parentKey(key string) string {
+	return key[:strings.LastIndex(key, "/")]
+}
+
+// convertError wraps err in a *storeadapter.Error for the given operation and
+// key. Context errors are returned as is.
+func (adapter *MemStoreAdapter) convertError(op string, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch err.(type) {
+	case *storeadapter.Error, *storeadapter.MultiError:
+		return err
+	}
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+
+	return &storeadapter.Error{Op: op, Key: key, Err: err, Cause: err}
+}
+
+// keyError returns a *storeadapter.Error for one of the storeadapter
+// sentinels, reported at the given index.
+func keyError(op string, key string, index uint64, err error) error {
+	return &storeadapter.Error{Op: op, Key: key, Index: index, Err: err, Cause: err}
+}
+
+// view runs read against the nodes as they are now.
+func (adapter *MemStoreAdapter) view(op string, key string, read func(txn *memTxn) error) error {
+	adapter.lock.RLock()
+	defer adapter.lock.RUnlock()
+
+	if !adapter.connected {
+		return adapter.convertError(op, key, errNotConnected)
+	}
+
+	return adapter.convertError(op, key, read(adapter.newTxn()))
+}
+
+// update runs write, once the nodes whose TTL has run out have been removed,
+// and publishes the events of its writes. It returns the index they were made
+// at. If write fails, everything it wrote is undone.
+func (adapter *MemStoreAdapter) update(op string, key string, write func(txn *memTxn) error) (uint64, error) {
+	adapter.lock.Lock()
+	defer adapter.lock.Unlock()
+
+	if !adapter.connected {
+		return 0, adapter.convertError(op, key, errNotConnected)
+	}
+
+	txn := adapter.newTxn()
+	txn.expire()
+
+	if err := write(txn); err != nil {
+		txn.rollback()
+		return 0, adapter.convertError(op, key, err)
+	}
+
+	adapter.publish(txn.committedIndex(), txn.events)
+
+	return txn.committedIndex(), nil
+}
+
+// expireNodes removes the nodes whose TTL has run out, on every tick, until
+// the adapter is disconnected.
+func (adapter *MemStoreAdapter) expireNodes() {
+	ticker := adapter.options.Clock.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			due := false
+			adapter.view("Expire", "", func(txn *memTxn) error {
+				due = txn.expiryDue()
+				return nil
+			})
+
+			if due {
+				adapter.update("Expire", "", func(txn *memTxn) error {
+					return nil
+				})
+			}
+
+		case <-adapter.ctx.Done():
+			return
+		}
+	}
+}
+
+// fanOut makes one write per key, all at one index. A write that fails
+// changes nothing, and the others still take effect. If any of them fail, it
+// returns a *storeadapter.MultiError holding the outcome for every key.
+func (adapter *MemStoreAdapter) fanOut(op string, keys []string, write func(txn *memTxn, i int) error) error {
+	results := make([]storeadapter.KeyResult, len(keys))
+
+	index, err := adapter.update(op, "", func(txn *memTxn) error {
+		for i, key := range keys {
+			results[i] = storeadapter.KeyResult{Key: key}
+
+			savepoint := txn.savepoint()
+			if err := write(txn, i); err != nil {
+				txn.rollbackTo(savepoint)
+				results[i].Err = err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Index = index
+		}
+	}
+
+	return storeadapter.NewMultiError(results)
+}
+
+func nodeKeys(nodes []storeadapter.StoreNode) []string {
+	keys := make([]string, len(nodes))
+	for i, node := range nodes {
+		keys[i] = node.Key
+	}
+
+	return keys
+}
+
+func (adapter *MemStoreAdapter) Get(key string) (storeadapter.StoreNode, error) {
+	return adapter.GetContext(context.Background(), key)
+}
+
+func (adapter *MemStoreAdapter) GetContext(ctx context.Context, key string) (storeadapter.StoreNode, error) {
+	if err := ctx.Err(); err != nil {
+		return storeadapter.StoreNode{}, err
+	}
+
+	key = cleanKey(key)
+
+	var node storeadapter.StoreNode
+	err := adapter.view("Get", key, func(txn *memTxn) error {
+		current := txn.lookup(key)
+
+		if key == "" || current != nil && current.dir {
+			return keyError("Get", key, txn.index, storeadapter.ErrorNodeIsDirectory)
+		}
+
+		if current == nil {
+			return keyError("Get", key, txn.index, storeadapter.ErrorKeyNotFound)
+		}
+
+		node = txn.storeNode(key, *current)
+		return nil
+	})
+
+	return node, err
+}
+
+func (adapter *MemStoreAdapter) ListRecursively(key string) (storeadapter.StoreNode, error) {
+	return adapter.ListRecursivelyContext(context.Background(), key)
+}
+
+func (adapter *MemStoreAdapter) ListRecursivelyContext(ctx context.Context, key string) (storeadapter.StoreNode, error) {
+	if err := ctx.Err(); err != nil {
+		return storeadapter.StoreNode{}, err
+	}
+
+	key = cleanKey(key)
+
+	var node storeadapter.StoreNode
+	err := adapter.view("ListRecursively", key, func(txn *memTxn) error {
+		dir := &record{dir: true, index: txn.index}
+		if key != "" {
+			dir = txn.lookup(key)
+		}
+
+		if dir == nil {
+			return keyError("ListRecursively", key, txn.index, storeadapter.ErrorKeyNotFound)
+		}
+
+		if !dir.dir {
+			return keyError("ListRecursively", key, txn.index, storeadapter.ErrorNodeIsNotDirectory)
+		}
+
+		node = txn.list(key, *dir)
+		node.Index = txn.index
+		return nil
+	})
+
+	return node, err
+}
+
+func (adapter *MemStoreAdapter) Create(node storeadapter.StoreNode) error {
+	return adapter.CreateContext(context.Background(), node)
+}
+
+func (adapter *MemStoreAdapter) CreateContext(ctx context.Context, node storeadapter.StoreNode) error {
+	return adapter.txn(ctx, "Create", node.Key,
+		[]storeadapter.TxnCompare{storeadapter.KeyMissing(node.Key)},
+		[]storeadapter.TxnOp{storeadapter.Put(node)},
+	)
+}
+
+func (adapter *MemStoreAdapter) Update(node storeadapter.StoreNode) error {
+	return adapter.UpdateContext(context.Background(), node)
+}
+
+func (adapter *MemStoreAdapter) UpdateContext(ctx context.Context, node storeadapter.StoreNode) error {
+	return adapter.txn(ctx, "Update", node.Key,
+		[]storeadapter.TxnCompare{storeadapter.KeyExists(node.Key)},
+		[]storeadapter.TxnOp{storeadapter.Put(node)},
+	)
+}
+
+func (adapter *MemStoreAdapter) CompareAndSwap(oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) error {
+	return adapter.CompareAndSwapContext(context.Background(), oldNode, newNode)
+}
+
+func (adapter *MemStoreAdapter) CompareAndSwapContext(ctx context.Context, oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) error {
+	return adapter.txn(ctx, "CompareAndSwap", newNode.Key,
+		[]storeadapter.TxnCompare{storeadapter.ValueEquals(newNode.Key, oldNode.Value)},
+		[]storeadapter.TxnOp{storeadapter.Put(newNode)},
+	)
+}
+
+func (adapter *MemStoreAdapter) CompareAndSwapByIndex(oldNodeIndex uint64, newNode storeadapter.StoreNode) error {
+	return adapter.CompareAndSwapByIndexContext(context.Background(), oldNodeIndex, newNode)
+}
+
+func (adapter *MemStoreAdapter) CompareAndSwapByIndexContext(ctx context.Context, oldNodeIndex uint64, newNode storeadapter.StoreNode) error {
+	return adapter.txn(ctx, "CompareAndSwapByIndex", newNode.Key,
+		[]storeadapter.TxnCompare{storeadapter.IndexEquals(newNode.Key, oldNodeIndex)},
+		[]storeadapter.TxnOp{storeadapter.Put(newNode)},
+	)
+}
+
+func (adapter *MemStoreAdapter) SetMulti(nodes []storeadapter.StoreNode) error {
+	return adapter.SetMultiContext(context.Background(), nodes)
+}
+
+func (adapter *MemStoreAdapter) SetMultiContext(ctx context.Context, nodes []storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return adapter.fanOut("SetMulti", nodeKeys(nodes), func(txn *memTxn, i int) error {
+		return txn.putLeaf("SetMulti", nodes[i])
+	})
+}
+
+func (adapter *MemStoreAdapter) Delete(keys ...string) error {
+	return adapter.DeleteContext(context.Background(), keys...)
+}
+
+func (adapter *MemStoreAdapter) DeleteContext(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return adapter.fanOut("Delete", keys, func(txn *memTxn, i int) error {
+		return txn.deleteNode("Delete", cleanKey(keys[i]))
+	})
+}
+
+func (adapter *MemStoreAdapter) DeleteLeaves(keys ...string) error {
+	return adapter.DeleteLeavesContext(context.Background(), keys...)
+}
+
+func (adapter *MemStoreAdapter) DeleteLeavesContext(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return adapter.fanOut("DeleteLeaves", keys, func(txn *memTxn, i int) error {
+		key := cleanKey(keys[i])
+		if key == "" {
+			return keyError("DeleteLeaves", key, txn.index, errRootReadOnly)
+		}
+
+		current := txn.record(key)
+		if current == nil {
+			return keyError("DeleteLeaves", key, txn.index, storeadapter.ErrorKeyNotFound)
+		}
+
+		if current.dir && txn.hasChildren(key) {
+			return keyError("DeleteLeaves", key, txn.index, storeadapter.ErrorDirectoryNotEmpty)
+		}
+
+		txn.deleteTree(key, *current, storeadapter.DeleteEvent)
+		return nil
+	})
+}
+
+func (adapter *MemStoreAdapter) CompareAndDelete(nodes ...storeadapter.StoreNode) error {
+	return adapter.CompareAndDeleteContext(context.Background(), nodes...)
+}
+
+func (adapter *MemStoreAdapter) CompareAndDeleteContext(ctx context.Context, nodes ...storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return adapter.fanOut("CompareAndDelete", nodeKeys(nodes), func(txn *memTxn, i int) error {
+		return txn.apply("CompareAndDelete",
+			[]storeadapter.TxnCompare{storeadapter.ValueEquals(nodes[i].Key, nodes[i].Value)},
+			[]storeadapter.TxnOp{storeadapter.DeleteKey(nodes[i].Key)},
+		)
+	})
+}
+
+func (adapter *MemStoreAdapter) CompareAndDeleteByIndex(nodes ...storeadapter.StoreNode) error {
+	return adapter.CompareAndDeleteByIndexContext(context.Background(), nodes...)
+}
+
+func (adapter *MemStoreAdapter) CompareAndDeleteByIndexContext(ctx context.Context, nodes ...storeadapter.StoreNode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return adapter.fanOut("CompareAndDeleteByIndex", nodeKeys(nodes), func(txn *memTxn, i int) error {
+		return txn.apply("CompareAndDeleteByIndex",
+			[]storeadapter.TxnCompare{storeadapter.IndexEquals(nodes[i].Key, nodes[i].Index)},
+			[]storeadapter.TxnOp{storeadapter.DeleteKey(nodes[i].Key)},
+		)
+	})
+}
+
+func (adapter *MemStoreAdapter) Txn(comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	return adapter.TxnContext(context.Background(), comparisons, operations)
+}
+
+func (adapter *MemStoreAdapter) TxnContext(ctx context.Context, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	return adapter.txn(ctx, "Txn", "", comparisons, operations)
+}
+
+func (adapter *MemStoreAdapter) txn(ctx context.Context, op string, key string, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, err := adapter.update(op, cleanKey(key), func(txn *memTxn) error {
+		return txn.apply(op, comparisons, operations)
+	})
+
+	return err
+}
+
+func (adapter *MemStoreAdapter) UpdateDirTTL(key string, ttl uint64) error {
+	return adapter.UpdateDirTTLContext(context.Background(), key, ttl)
+}
+
+// As in etcd v2, the directory itself is given the TTL, and once it runs out
+// the directory is removed with everything under it. A TTL of 0 removes it.
+func (adapter *MemStoreAdapter) UpdateDirTTLContext(ctx context.Context, key string, ttl uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	key = cleanKey(key)
+
+	_, err := adapter.update("UpdateDirTTL", key, func(txn *memTxn) error {
+		if key == "" {
+			return keyError("UpdateDirTTL", key, txn.index, errRootReadOnly)
+		}
+
+		current := txn.record(key)
+		if current == nil {
+			return keyError("UpdateDirTTL", key, txn.index, storeadapter.ErrorKeyNotFound)
+		}
+
+		if !current.dir {
+			return keyError("UpdateDirTTL", key, txn.index, storeadapter.ErrorNodeIsNotDirectory)
+		}
+
+		updated := *current
+		updated.index = txn.writeIndex()
+		updated.expires = txn.expiresAt(ttl)
+
+		txn.put(key, updated)
+		txn.emit(storeadapter.UpdateEvent, key, &updated, current)
+		return nil
+	})
+
+	return err
+}
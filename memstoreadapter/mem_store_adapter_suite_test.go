@@ -0,0 +1,18 @@
+package memstoreadapter_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStoreAdapter(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	SetDefaultEventuallyTimeout(5 * time.Second)
+
+	RunSpecs(t, "Mem Store Adapter Suite")
+}
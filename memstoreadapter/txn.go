@@ -0,0 +1,464 @@
+package memstoreadapter
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+
+	"github.com/cloudfoundry/storeadapter"
+)
+
+// record is a node as it is kept: whether it is a directory, the index it was
+// last modified at, when it expires in Unix nanoseconds or 0 for never, and
+// the value of a leaf.
+type record struct {
+	dir     bool
+	index   uint64
+	expires int64
+	value   []byte
+}
+
+// expiry is when the node at key expires. The queue is not updated as nodes
+// change, so an expiry only holds if the node still expires at that time.
+type expiry struct {
+	expires int64
+	key     string
+}
+
+// expiryQueue is a heap of expiries, the earliest first.
+type expiryQueue []expiry
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].expires < q[j].expires }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(expiry)) }
+
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return last
+}
+
+// change is what was at key before a transaction first wrote to it, or nil if
+// there was nothing.
+type change struct {
+	key  string
+	prev *record
+}
+
+// memTxn reads and writes the adapter's nodes as of one moment, keeping what
+// it needs to undo its writes, and the events they add up to. Every write in
+// it is made at the index after the one it read.
+type memTxn struct {
+	adapter *MemStoreAdapter
+
+	now    time.Time
+	index  uint64
+	events []storeadapter.WatchEvent
+
+	changes []change
+	popped  []expiry
+}
+
+// savepoint is how far a transaction had got, for rolling back to.
+type savepoint struct {
+	changes int
+	popped  int
+	events  int
+}
+
+func (adapter *MemStoreAdapter) newTxn() *memTxn {
+	return &memTxn{
+		adapter: adapter,
+		now:     adapter.options.Clock.Now(),
+		index:   adapter.index,
+	}
+}
+
+// writeIndex returns the index the transaction's writes are made at.
+func (txn *memTxn) writeIndex() uint64 {
+	return txn.index + 1
+}
+
+// committedIndex returns the index of the latest write once the transaction
+// is done.
+func (txn *memTxn) committedIndex() uint64 {
+	if len(txn.events) == 0 {
+		return txn.index
+	}
+
+	return txn.writeIndex()
+}
+
+func (txn *memTxn) savepoint() savepoint {
+	return savepoint{
+		changes: len(txn.changes),
+		popped:  len(txn.popped),
+		events:  len(txn.events),
+	}
+}
+
+// rollbackTo undoes everything written since the savepoint.
+func (txn *memTxn) rollbackTo(sp savepoint) {
+	for i := len(txn.changes) - 1; i >= sp.changes; i-- {
+		c := txn.changes[i]
+		if c.prev == nil {
+			txn.adapter.removeRecord(c.key)
+		} else {
+			txn.adapter.setRecord(c.key, *c.prev)
+		}
+	}
+
+	for _, e := range txn.popped[sp.popped:] {
+		heap.Push(&txn.adapter.expiries, e)
+	}
+
+	txn.changes = txn.changes[:sp.changes]
+	txn.popped = txn.popped[:sp.popped]
+	txn.events = txn.events[:sp.events]
+}
+
+func (txn *memTxn) rollback() {
+	txn.rollbackTo(savepoint{})
+}
+
+// setRecord keeps r at key, with key among its parent's children.
+func (adapter *MemStoreAdapter) setRecord(key string, r record) {
+	adapter.nodes[key] = r
+
+	parent := parentKey(key)
+	if adapter.children[parent] == nil {
+		adapter.children[parent] = map[string]bool{}
+	}
+	adapter.children[parent][key] = true
+}
+
+func (adapter *MemStoreAdapter) removeRecord(key string) {
+	delete(adapter.nodes, key)
+
+	parent := parentKey(key)
+	delete(adapter.children[parent], key)
+	if len(adapter.children[parent]) == 0 {
+		delete(adapter.children, parent)
+	}
+}
+
+// expiresAt returns when a node given ttl now expires, or 0 for never.
+func (txn *memTxn) expiresAt(ttl uint64) int64 {
+	if ttl == 0 {
+		return 0
+	}
+
+	return txn.now.Add(time.Duration(ttl) * time.Second).UnixNano()
+}
+
+func (txn *memTxn) expired(r record) bool {
+	return r.expires != 0 && r.expires <= txn.now.UnixNano()
+}
+
+// ttl returns the whole seconds left before r expires, rounded up.
+func (txn *memTxn) ttl(r record) uint64 {
+	if r.expires == 0 {
+		return 0
+	}
+
+	left := time.Duration(r.expires - txn.now.UnixNano())
+	if left < time.Second {
+		return 1
+	}
+
+	return uint64((left + time.Second - 1) / time.Second)
+}
+
+// record returns the node kept at key, or nil if there is none. It does not
+// check whether the node has expired; an update has no expired nodes left.
+func (txn *memTxn) record(key string) *record {
+	if key == "" {
+		return nil
+	}
+
+	r, ok := txn.adapter.nodes[key]
+	if !ok {
+		return nil
+	}
+
+	return &r
+}
+
+// lookup returns the node at key, or nil if there is none, or if it or a
+// directory above it has expired.
+func (txn *memTxn) lookup(key string) *record {
+	for _, dir := range ancestors(key) {
+		r := txn.record(dir)
+		if r == nil || txn.expired(*r) {
+			return nil
+		}
+	}
+
+	r := txn.record(key)
+	if r == nil || txn.expired(*r) {
+		return nil
+	}
+
+	return r
+}
+
+// storeNode returns r as a StoreNode with its own copy of the value.
+func (txn *memTxn) storeNode(key string, r record) storeadapter.StoreNode {
+	node := storeadapter.StoreNode{
+		Key:   key,
+		Value: append([]byte{}, r.value...),
+		Dir:   r.dir,
+		TTL:   txn.ttl(r),
+		Index: r.index,
+	}
+
+	if r.dir {
+		node.ChildNodes = []storeadapter.StoreNode{}
+	}
+
+	return node
+}
+
+// node returns the node at key, or nil if there is none, for a comparison.
+func (txn *memTxn) node(key string) *storeadapter.StoreNode {
+	if key == "" {
+		return &storeadapter.StoreNode{Key: key, Dir: true}
+	}
+
+	r := txn.lookup(key)
+	if r == nil {
+		return nil
+	}
+
+	node := txn.storeNode(key, *r)
+	return &node
+}
+
+// childKeys returns the keys directly under the directory at key, in key
+// order.
+func (txn *memTxn) childKeys(key string) []string {
+	keys := make([]string, 0, len(txn.adapter.children[key]))
+	for childKey := range txn.adapter.children[key] {
+		keys = append(keys, childKey)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// subtree returns the keys under the directory at key, each directory before
+// the keys under it.
+func (txn *memTxn) subtree(key string) []string {
+	keys := []string{}
+	for _, childKey := range txn.childKeys(key) {
+		keys = append(keys, childKey)
+		keys = append(keys, txn.subtree(childKey)...)
+	}
+
+	return keys
+}
+
+func (txn *memTxn) hasChildren(key string) bool {
+	return len(txn.adapter.children[key]) > 0
+}
+
+// list returns the directory dir at key with everything under it that has
+// not expired.
+func (txn *memTxn) list(key string, dir record) storeadapter.StoreNode {
+	node := txn.storeNode(key, dir)
+	for _, childKey := range txn.childKeys(key) {
+		child := txn.adapter.nodes[childKey]
+		if txn.expired(child) {
+			continue
+		}
+
+		if child.dir {
+			node.ChildNodes = append(node.ChildNodes, txn.list(childKey, child))
+		} else {
+			node.ChildNodes = append(node.ChildNodes, txn.storeNode(childKey, child))
+		}
+	}
+
+	return node
+}
+
+// put keeps r at key, noting what was there to undo it.
+func (txn *memTxn) put(key string, r record) {
+	txn.changes = append(txn.changes, change{key: key, prev: txn.record(key)})
+	txn.adapter.setRecord(key, r)
+
+	if r.expires != 0 {
+		heap.Push(&txn.adapter.expiries, expiry{expires: r.expires, key: key})
+	}
+}
+
+// remove deletes the node at key, noting what was there to undo it.
+func (txn *memTxn) remove(key string) {
+	txn.changes = append(txn.changes, change{key: key, prev: txn.record(key)})
+	txn.adapter.removeRecord(key)
+}
+
+func (txn *memTxn) emit(eventType storeadapter.EventType, key string, r *record, prev *record) {
+	event := storeadapter.WatchEvent{Type: eventType, Index: txn.writeIndex()}
+
+	if r != nil {
+		node := txn.storeNode(key, *r)
+		event.Node = &node
+	}
+
+	if prev != nil {
+		prevNode := txn.storeNode(key, *prev)
+		event.PrevNode = &prevNode
+	}
+
+	txn.events = append(txn.events, event)
+}
+
+// putLeaf writes node as a leaf, creating the directories above it. It checks
+// that it can before writing anything.
+func (txn *memTxn) putLeaf(op string, node storeadapter.StoreNode) error {
+	key := cleanKey(node.Key)
+	if key == "" {
+		return keyError(op, key, txn.index, storeadapter.ErrorNodeIsDirectory)
+	}
+
+	current := txn.record(key)
+	if current != nil && current.dir {
+		return keyError(op, key, txn.index, storeadapter.ErrorNodeIsDirectory)
+	}
+
+	missing := []string{}
+	for _, dir := range ancestors(key) {
+		r := txn.record(dir)
+		if r == nil {
+			missing = append(missing, dir)
+		} else if !r.dir {
+			return keyError(op, key, txn.index, storeadapter.ErrorNodeIsNotDirectory)
+		}
+	}
+
+	for _, dir := range missing {
+		txn.put(dir, record{dir: true, index: txn.writeIndex()})
+	}
+
+	leaf := record{
+		index:   txn.writeIndex(),
+		expires: txn.expiresAt(node.TTL),
+		value:   append([]byte{}, node.Value...),
+	}
+
+	txn.put(key, leaf)
+
+	if current == nil {
+		txn.emit(storeadapter.CreateEvent, key, &leaf, nil)
+	} else {
+		txn.emit(storeadapter.UpdateEvent, key, &leaf, current)
+	}
+
+	return nil
+}
+
+// deleteNode deletes the node at key with everything under it. Deleting the
+// root deletes everything in the store.
+func (txn *memTxn) deleteNode(op string, key string) error {
+	if key != "" {
+		current := txn.record(key)
+		if current == nil {
+			return keyError(op, key, txn.index, storeadapter.ErrorKeyNotFound)
+		}
+
+		txn.deleteTree(key, *current, storeadapter.DeleteEvent)
+		return nil
+	}
+
+	childKeys := txn.childKeys(key)
+	if len(childKeys) == 0 {
+		return keyError(op, key, txn.index, storeadapter.ErrorKeyNotFound)
+	}
+
+	for _, childKey := range childKeys {
+		txn.deleteTree(childKey, *txn.record(childKey), storeadapter.DeleteEvent)
+	}
+
+	return nil
+}
+
+// deleteTree deletes r from key, with everything under it, reporting it as
+// one event.
+func (txn *memTxn) deleteTree(key string, r record, eventType storeadapter.EventType) {
+	if r.dir {
+		for _, childKey := range txn.subtree(key) {
+			txn.remove(childKey)
+		}
+	}
+
+	txn.remove(key)
+	txn.emit(eventType, key, nil, &r)
+}
+
+// expiryDue reports whether some node's TTL may have run out.
+func (txn *memTxn) expiryDue() bool {
+	queue := txn.adapter.expiries
+	return len(queue) > 0 && queue[0].expires <= txn.now.UnixNano()
+}
+
+// expire removes every node whose TTL has run out, with everything under it.
+func (txn *memTxn) expire() {
+	for txn.expiryDue() {
+		due := heap.Pop(&txn.adapter.expiries).(expiry)
+		txn.popped = append(txn.popped, due)
+
+		// It may have been given a new TTL, or have gone with an expired
+		// directory above it.
+		current := txn.record(due.key)
+		if current == nil || current.expires != due.expires {
+			continue
+		}
+
+		txn.deleteTree(due.key, *current, storeadapter.ExpireEvent)
+	}
+}
+
+// apply checks the comparisons, then plays the operations in order. If one
+// fails, it returns why, and the transaction must be rolled back, as the
+// operations before it have been written.
+func (txn *memTxn) apply(op string, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	for _, comparison := range comparisons {
+		key := cleanKey(comparison.Node.Key)
+
+		if err := comparison.Check(txn.node(key)); err != nil {
+			return keyError(op, key, txn.index, err)
+		}
+	}
+
+	for _, operation := range operations {
+		key := cleanKey(operation.Node.Key)
+
+		switch operation.Type {
+		case storeadapter.PutOp:
+			if err := txn.putLeaf(op, operation.Node); err != nil {
+				return err
+			}
+
+		case storeadapter.DeleteOp:
+			current := txn.record(key)
+			if current == nil {
+				return keyError(op, key, txn.index, storeadapter.ErrorKeyNotFound)
+			}
+
+			if current.dir {
+				return keyError(op, key, txn.index, storeadapter.ErrorNodeIsDirectory)
+			}
+
+			txn.deleteTree(key, *current, storeadapter.DeleteEvent)
+
+		default:
+			return keyError(op, key, txn.index, storeadapter.ErrorInvalidFormat)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package storeadapter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyResult is the outcome of an operation on a single key. Index is the
+// store index the key was left at, when the store reports one.
+type KeyResult struct {
+	Key   string
+	Err   error
+	Index uint64
+}
+
+// MultiError is returned by operations that act on several keys independently
+// when at least one of the keys fails. Results holds the outcome of every key,
+// in the order the keys were given, including the ones that succeeded.
+//
+// errors.Is reports whether any of the keys failed with the given error.
+type MultiError struct {
+	Results []KeyResult
+}
+
+// NewMultiError returns a *MultiError for the given results if any of them
+// failed, and nil otherwise.
+func NewMultiError(results []KeyResult) error {
+	for _, result := range results {
+		if result.Err != nil {
+			return &MultiError{Results: results}
+		}
+	}
+
+	return nil
+}
+
+func (e *MultiError) Error() string {
+	failed := e.Failed()
+
+	messages := make([]string, len(failed))
+	for i, result := range failed {
+		messages[i] = fmt.Sprintf("%s: %s", result.Key, result.Err)
+	}
+
+	if len(e.Results) == 1 {
+		return messages[0]
+	}
+
+	return fmt.Sprintf("%d of %d keys failed: %s", len(failed), len(e.Results), strings.Join(messages, "; "))
+}
+
+func (e *MultiError) Unwrap() []error {
+	errs := []error{}
+	for _, result := range e.Results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+
+	return errs
+}
+
+func (e *MultiError) Failed() []KeyResult {
+	failed := []KeyResult{}
+	for _, result := range e.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+
+	return failed
+}
+
+func (e *MultiError) Succeeded() []KeyResult {
+	succeeded := []KeyResult{}
+	for _, result := range e.Results {
+		if result.Err == nil {
+			succeeded = append(succeeded, result)
+		}
+	}
+
+	return succeeded
+}
@@ -0,0 +1,72 @@
+package storeadapter_test
+
+import (
+	"errors"
+
+	. "github.com/cloudfoundry/storeadapter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MultiError", func() {
+	Describe("NewMultiError", func() {
+		It("returns nil when every key succeeded", func() {
+			err := NewMultiError([]KeyResult{{Key: "/a", Index: 1}, {Key: "/b", Index: 2}})
+			Expect(err).To(BeNil())
+		})
+
+		It("returns nil when there are no keys", func() {
+			Expect(NewMultiError(nil)).To(BeNil())
+		})
+
+		It("returns every result when a key failed", func() {
+			results := []KeyResult{
+				{Key: "/a", Index: 1},
+				{Key: "/b", Err: ErrorKeyNotFound},
+			}
+
+			err := NewMultiError(results)
+			Expect(err).To(Equal(&MultiError{Results: results}))
+		})
+	})
+
+	Context("with several failures", func() {
+		var multiErr *MultiError
+
+		BeforeEach(func() {
+			multiErr = &MultiError{Results: []KeyResult{
+				{Key: "/a", Err: ErrorKeyNotFound},
+				{Key: "/b", Index: 7},
+				{Key: "/c", Err: ErrorKeyComparisonFailed},
+			}}
+		})
+
+		It("describes each failed key", func() {
+			Expect(multiErr.Error()).To(Equal(
+				"2 of 3 keys failed: /a: the requested key could not be found; /c: node comparison failed",
+			))
+		})
+
+		It("matches any of the errors it holds", func() {
+			Expect(errors.Is(multiErr, ErrorKeyNotFound)).To(BeTrue())
+			Expect(errors.Is(multiErr, ErrorKeyComparisonFailed)).To(BeTrue())
+			Expect(errors.Is(multiErr, ErrorTimeout)).To(BeFalse())
+		})
+
+		It("splits the results into failures and successes", func() {
+			Expect(multiErr.Failed()).To(Equal([]KeyResult{
+				{Key: "/a", Err: ErrorKeyNotFound},
+				{Key: "/c", Err: ErrorKeyComparisonFailed},
+			}))
+			Expect(multiErr.Succeeded()).To(Equal([]KeyResult{{Key: "/b", Index: 7}}))
+		})
+	})
+
+	Context("with a single key", func() {
+		It("describes just that key", func() {
+			multiErr := &MultiError{Results: []KeyResult{{Key: "/a", Err: ErrorKeyNotFound}}}
+			Expect(multiErr.Error()).To(Equal("/a: the requested key could not be found"))
+		})
+	})
+})
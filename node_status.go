@@ -0,0 +1,12 @@
+package storeadapter
+
+// NodeStatus reports whether a maintained node is currently owned.
+//
+// Token is a fencing token for the current ownership: the node's index when it
+// was last acquired. It stays the same while the node is held and increases
+// every time the node is acquired again, so a write guarded by a token is
+// rejected once a newer owner has written with a larger one. See FencedWriter.
+type NodeStatus struct {
+	Owned bool
+	Token uint64
+}
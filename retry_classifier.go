@@ -0,0 +1,49 @@
+package storeadapter
+
+import "errors"
+
+//go:generate counterfeiter . RetryClassifier
+
+// RetryClassifier decides which failed requests the retryable adapter makes
+// again. It is only asked about errors, never about a nil one.
+type RetryClassifier interface {
+	ShouldRetry(error) bool
+}
+
+// RetryClassifierFunc lets an ordinary function be used as a RetryClassifier.
+type RetryClassifierFunc func(error) bool
+
+func (f RetryClassifierFunc) ShouldRetry(err error) bool {
+	return f(err)
+}
+
+// TimeoutRetryClassifier retries requests that time out. It is what
+// NewRetryable retries on.
+type TimeoutRetryClassifier struct{}
+
+func (TimeoutRetryClassifier) ShouldRetry(err error) bool {
+	return errors.Is(err, ErrorTimeout)
+}
+
+// TemporaryRetryClassifier retries requests that failed for reasons that may
+// go away, as reported by IsTemporary: timeouts, network errors, leader
+// elections and the store's internal errors.
+type TemporaryRetryClassifier struct{}
+
+func (TemporaryRetryClassifier) ShouldRetry(err error) bool {
+	return IsTemporary(err)
+}
+
+// AnyRetryClassifier retries requests that any of the classifiers would
+// retry.
+func AnyRetryClassifier(classifiers ...RetryClassifier) RetryClassifier {
+	return RetryClassifierFunc(func(err error) bool {
+		for _, classifier := range classifiers {
+			if classifier.ShouldRetry(err) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
@@ -0,0 +1,68 @@
+package storeadapter_test
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	. "github.com/cloudfoundry/storeadapter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryClassifiers", func() {
+	var connectionRefused error
+
+	BeforeEach(func() {
+		connectionRefused = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	})
+
+	Describe("TimeoutRetryClassifier", func() {
+		It("retries timeouts, wrapped or not", func() {
+			classifier := TimeoutRetryClassifier{}
+
+			Expect(classifier.ShouldRetry(ErrorTimeout)).To(BeTrue())
+			Expect(classifier.ShouldRetry(&Error{Op: "Get", Key: "/a", Err: ErrorTimeout})).To(BeTrue())
+		})
+
+		It("does not retry anything else", func() {
+			classifier := TimeoutRetryClassifier{}
+
+			Expect(classifier.ShouldRetry(ErrorKeyNotFound)).To(BeFalse())
+			Expect(classifier.ShouldRetry(connectionRefused)).To(BeFalse())
+			Expect(classifier.ShouldRetry(&Error{Op: "Get", Key: "/a", Code: 301, Err: errors.New("leader election"), Temporary: true})).To(BeFalse())
+		})
+	})
+
+	Describe("TemporaryRetryClassifier", func() {
+		It("retries timeouts, temporary store errors and network errors", func() {
+			classifier := TemporaryRetryClassifier{}
+
+			Expect(classifier.ShouldRetry(ErrorTimeout)).To(BeTrue())
+			Expect(classifier.ShouldRetry(&Error{Op: "Get", Key: "/a", Code: 301, Err: errors.New("leader election"), Temporary: true})).To(BeTrue())
+			Expect(classifier.ShouldRetry(&Error{Op: "Get", Key: "/a", Err: connectionRefused, Cause: connectionRefused})).To(BeTrue())
+		})
+
+		It("does not retry errors about the request, or a done context", func() {
+			classifier := TemporaryRetryClassifier{}
+
+			Expect(classifier.ShouldRetry(&Error{Op: "Get", Key: "/a", Code: 100, Err: ErrorKeyNotFound})).To(BeFalse())
+			Expect(classifier.ShouldRetry(context.DeadlineExceeded)).To(BeFalse())
+			Expect(classifier.ShouldRetry(context.Canceled)).To(BeFalse())
+		})
+	})
+
+	Describe("AnyRetryClassifier", func() {
+		It("retries what any of its classifiers would retry", func() {
+			isKeyExists := RetryClassifierFunc(func(err error) bool {
+				return errors.Is(err, ErrorKeyExists)
+			})
+			classifier := AnyRetryClassifier(TimeoutRetryClassifier{}, isKeyExists)
+
+			Expect(classifier.ShouldRetry(ErrorTimeout)).To(BeTrue())
+			Expect(classifier.ShouldRetry(ErrorKeyExists)).To(BeTrue())
+			Expect(classifier.ShouldRetry(ErrorKeyNotFound)).To(BeFalse())
+		})
+	})
+})
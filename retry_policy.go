@@ -1,21 +1,275 @@
 package storeadapter
 
-import "time"
+import (
+	"math/rand"
+	"sync"
+	"time"
 
-type ExponentialRetryPolicy struct{}
+	"code.cloudfoundry.org/clock"
+)
 
-const maxRetryDelay = 16 * time.Second
+const (
+	defaultRetryBaseDelay  = time.Second
+	defaultRetryMaxDelay   = 16 * time.Second
+	defaultRetryMaxRetries = 20
+)
 
-func (ExponentialRetryPolicy) DelayFor(attempts uint) (time.Duration, bool) {
-	// 20 attempts = around 5 minutes
-	if attempts > 20 {
+// StatefulRetryPolicy is a RetryPolicy that remembers the attempts it has
+// been asked about, such as when the first of them failed. It starts over
+// when asked about a first failed attempt, and NewSequence gives each request
+// a copy of its own, so that requests retried at the same time do not share
+// their state.
+type StatefulRetryPolicy interface {
+	RetryPolicy
+	NewSequence() RetryPolicy
+}
+
+// NewRetrySequence returns the policy to retry one request with: a fresh copy
+// of a StatefulRetryPolicy, or else the policy itself.
+func NewRetrySequence(policy RetryPolicy) RetryPolicy {
+	if stateful, ok := policy.(StatefulRetryPolicy); ok {
+		return stateful.NewSequence()
+	}
+
+	return policy
+}
+
+// RandomDuration returns a random duration from 0 up to and including max.
+// The policies with jitter use math/rand when theirs is nil.
+type RandomDuration func(max time.Duration) time.Duration
+
+func (random RandomDuration) upTo(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	if random == nil {
+		return time.Duration(rand.Int63n(int64(max) + 1))
+	}
+
+	return random(max)
+}
+
+// ExponentialRetryPolicy doubles the delay after each failed attempt, from
+// BaseDelay up to MaxDelay, and gives up after MaxRetries retries. The zero
+// value waits 1 second, doubling up to 16 seconds, for 20 retries: around 5
+// minutes.
+type ExponentialRetryPolicy struct {
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	MaxRetries uint
+}
+
+func (policy ExponentialRetryPolicy) DelayFor(attempts uint) (time.Duration, bool) {
+	maxRetries := policy.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultRetryMaxRetries
+	}
+
+	if attempts > maxRetries {
+		return 0, false
+	}
+
+	return exponentialDelay(policy.BaseDelay, policy.MaxDelay, attempts), true
+}
+
+// exponentialDelay is base doubled for each failed attempt after the first, up
+// to max, with the defaults for a zero base or max.
+func exponentialDelay(base time.Duration, max time.Duration, attempts uint) time.Duration {
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	if max <= 0 {
+		max = defaultRetryMaxDelay
+	}
+
+	if attempts == 0 {
+		return 0
+	}
+
+	delay := base
+	for i := uint(1); i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	return delay
+}
+
+// FullJitterRetryPolicy waits a random delay of up to what
+// ExponentialRetryPolicy would wait, so that clients which failed together do
+// not retry together. It never gives up; see MaxAttempts and MaxElapsed.
+type FullJitterRetryPolicy struct {
+	// Defaulting to 1 and 16 seconds.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	Random RandomDuration
+}
+
+func (policy FullJitterRetryPolicy) DelayFor(attempts uint) (time.Duration, bool) {
+	return policy.Random.upTo(exponentialDelay(policy.BaseDelay, policy.MaxDelay, attempts)), true
+}
+
+// DecorrelatedJitterRetryPolicy waits a random delay of between BaseDelay and
+// three times its previous delay, up to MaxDelay. It never gives up; see
+// MaxAttempts and MaxElapsed.
+type DecorrelatedJitterRetryPolicy struct {
+	// Defaulting to 1 and 16 seconds.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	Random RandomDuration
+
+	mutex    sync.Mutex
+	previous time.Duration
+}
+
+func (policy *DecorrelatedJitterRetryPolicy) DelayFor(attempts uint) (time.Duration, bool) {
+	base := policy.BaseDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	max := policy.MaxDelay
+	if max <= 0 {
+		max = defaultRetryMaxDelay
+	}
+
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+
+	if attempts <= 1 || policy.previous < base {
+		policy.previous = base
+	}
+
+	delay := base + policy.Random.upTo(3*policy.previous-base)
+	if delay > max {
+		delay = max
+	}
+
+	policy.previous = delay
+	return delay, true
+}
+
+func (policy *DecorrelatedJitterRetryPolicy) NewSequence() RetryPolicy {
+	return &DecorrelatedJitterRetryPolicy{
+		BaseDelay: policy.BaseDelay,
+		MaxDelay:  policy.MaxDelay,
+		Random:    policy.Random,
+	}
+}
+
+// ConstantRetryPolicy always waits Delay. It never gives up; see MaxAttempts
+// and MaxElapsed.
+type ConstantRetryPolicy struct {
+	Delay time.Duration
+}
+
+func (policy ConstantRetryPolicy) DelayFor(attempts uint) (time.Duration, bool) {
+	return policy.Delay, true
+}
+
+// LinearRetryPolicy waits BaseDelay after the first failed attempt, and
+// Increment longer after each one after that, up to MaxDelay if it is set.
+// Increment defaults to BaseDelay. It never gives up; see MaxAttempts and
+// MaxElapsed.
+type LinearRetryPolicy struct {
+	BaseDelay time.Duration
+	Increment time.Duration
+	MaxDelay  time.Duration
+}
+
+func (policy LinearRetryPolicy) DelayFor(attempts uint) (time.Duration, bool) {
+	if attempts == 0 {
+		return 0, true
+	}
+
+	increment := policy.Increment
+	if increment == 0 {
+		increment = policy.BaseDelay
+	}
+
+	steps := time.Duration(attempts - 1)
+	if policy.MaxDelay > 0 && increment > 0 && steps > (policy.MaxDelay-policy.BaseDelay)/increment {
+		return policy.MaxDelay, true
+	}
+
+	delay := policy.BaseDelay + steps*increment
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+
+	return delay, true
+}
+
+// LimitedRetryPolicy retries as Policy does, but gives up once MaxAttempts
+// attempts have failed, or once MaxElapsed has passed since the first of
+// them failed, whichever comes first. It never waits past MaxElapsed. A zero
+// limit does not apply, and Clock defaults to the real clock.
+type LimitedRetryPolicy struct {
+	Policy      RetryPolicy
+	MaxAttempts uint
+	MaxElapsed  time.Duration
+	Clock       clock.Clock
+
+	mutex     sync.Mutex
+	firstFail time.Time
+}
+
+// MaxAttempts limits policy to the given number of attempts, retries
+// included.
+func MaxAttempts(policy RetryPolicy, attempts uint) *LimitedRetryPolicy {
+	return &LimitedRetryPolicy{Policy: policy, MaxAttempts: attempts}
+}
+
+// MaxElapsed limits policy to retrying for the given time after the first
+// failed attempt.
+func MaxElapsed(policy RetryPolicy, elapsed time.Duration) *LimitedRetryPolicy {
+	return &LimitedRetryPolicy{Policy: policy, MaxElapsed: elapsed}
+}
+
+func (policy *LimitedRetryPolicy) DelayFor(attempts uint) (time.Duration, bool) {
+	if policy.MaxAttempts > 0 && attempts >= policy.MaxAttempts {
+		return 0, false
+	}
+
+	delay, keepRetrying := policy.Policy.DelayFor(attempts)
+	if !keepRetrying || policy.MaxElapsed <= 0 {
+		return delay, keepRetrying
+	}
+
+	clk := policy.Clock
+	if clk == nil {
+		clk = clock.NewClock()
+	}
+	now := clk.Now()
+
+	policy.mutex.Lock()
+	if attempts <= 1 || policy.firstFail.IsZero() {
+		policy.firstFail = now
+	}
+	remaining := policy.MaxElapsed - now.Sub(policy.firstFail)
+	policy.mutex.Unlock()
+
+	if remaining <= 0 {
 		return 0, false
 	}
 
-	exponentialDelay := (1 << (attempts - 1)) * time.Second
-	if exponentialDelay > maxRetryDelay {
-		return maxRetryDelay, true
+	if delay > remaining {
+		delay = remaining
 	}
 
-	return exponentialDelay, true
+	return delay, true
+}
+
+func (policy *LimitedRetryPolicy) NewSequence() RetryPolicy {
+	return &LimitedRetryPolicy{
+		Policy:      NewRetrySequence(policy.Policy),
+		MaxAttempts: policy.MaxAttempts,
+		MaxElapsed:  policy.MaxElapsed,
+		Clock:       policy.Clock,
+	}
 }
@@ -0,0 +1,185 @@
+package storeadapter_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	. "github.com/cloudfoundry/storeadapter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryPolicies", func() {
+	// upToHalf makes the jittered policies predictable, by always waiting
+	// half of the most they could.
+	upToHalf := RandomDuration(func(max time.Duration) time.Duration {
+		return max / 2
+	})
+
+	delaysFor := func(policy RetryPolicy, attempts uint) []time.Duration {
+		var delays []time.Duration
+		for attempt := uint(1); attempt <= attempts; attempt++ {
+			delay, keepRetrying := policy.DelayFor(attempt)
+			Expect(keepRetrying).To(BeTrue())
+			delays = append(delays, delay)
+		}
+		return delays
+	}
+
+	Describe("ExponentialRetryPolicy", func() {
+		It("can be configured", func() {
+			policy := ExponentialRetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, MaxRetries: 5}
+
+			Expect(delaysFor(policy, 5)).To(Equal([]time.Duration{
+				100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second,
+			}))
+
+			_, keepRetrying := policy.DelayFor(6)
+			Expect(keepRetrying).To(BeFalse())
+		})
+	})
+
+	Describe("FullJitterRetryPolicy", func() {
+		It("waits a random delay of up to the exponential one, without giving up", func() {
+			policy := FullJitterRetryPolicy{Random: upToHalf}
+
+			Expect(delaysFor(policy, 6)).To(Equal([]time.Duration{
+				500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second,
+			}))
+			Expect(delaysFor(policy, 100)).To(HaveLen(100))
+		})
+
+		It("stays within the exponential delay when it uses math/rand", func() {
+			policy := FullJitterRetryPolicy{BaseDelay: time.Second, MaxDelay: 4 * time.Second}
+
+			for i := 0; i < 100; i++ {
+				delay, _ := policy.DelayFor(3)
+				Expect(delay).To(BeNumerically(">=", 0))
+				Expect(delay).To(BeNumerically("<=", 4*time.Second))
+			}
+		})
+	})
+
+	Describe("DecorrelatedJitterRetryPolicy", func() {
+		It("waits between the base delay and three times its previous delay, up to the max", func() {
+			policy := &DecorrelatedJitterRetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second, Random: upToHalf}
+
+			// 1 + (3*1-1)/2 = 2, 1 + (3*2-1)/2 = 3.5, 1 + (3*3.5-1)/2 = 5.75, ...
+			Expect(delaysFor(policy, 5)).To(Equal([]time.Duration{
+				2 * time.Second, 3500 * time.Millisecond, 5750 * time.Millisecond, 9125 * time.Millisecond, 10 * time.Second,
+			}))
+		})
+
+		It("starts over when asked about a first failed attempt", func() {
+			policy := &DecorrelatedJitterRetryPolicy{Random: upToHalf}
+			delaysFor(policy, 3)
+
+			delay, _ := policy.DelayFor(1)
+			Expect(delay).To(Equal(2 * time.Second))
+		})
+
+		It("gives each sequence its own state", func() {
+			policy := &DecorrelatedJitterRetryPolicy{Random: upToHalf}
+			delaysFor(policy, 3)
+
+			delay, _ := NewRetrySequence(policy).DelayFor(2)
+			Expect(delay).To(Equal(2 * time.Second))
+		})
+	})
+
+	Describe("ConstantRetryPolicy", func() {
+		It("always waits the same delay", func() {
+			policy := ConstantRetryPolicy{Delay: 3 * time.Second}
+
+			Expect(delaysFor(policy, 3)).To(Equal([]time.Duration{3 * time.Second, 3 * time.Second, 3 * time.Second}))
+		})
+	})
+
+	Describe("LinearRetryPolicy", func() {
+		It("waits longer by the increment after each failed attempt, up to the max", func() {
+			policy := LinearRetryPolicy{BaseDelay: time.Second, Increment: 2 * time.Second, MaxDelay: 6 * time.Second}
+
+			Expect(delaysFor(policy, 5)).To(Equal([]time.Duration{
+				time.Second, 3 * time.Second, 5 * time.Second, 6 * time.Second, 6 * time.Second,
+			}))
+
+			delay, _ := policy.DelayFor(1 << 40)
+			Expect(delay).To(Equal(6 * time.Second))
+		})
+
+		It("increments by the base delay by default", func() {
+			policy := LinearRetryPolicy{BaseDelay: time.Second}
+
+			Expect(delaysFor(policy, 3)).To(Equal([]time.Duration{time.Second, 2 * time.Second, 3 * time.Second}))
+		})
+	})
+
+	Describe("MaxAttempts", func() {
+		It("gives up once the given number of attempts have failed", func() {
+			policy := MaxAttempts(ConstantRetryPolicy{Delay: time.Second}, 3)
+
+			Expect(delaysFor(policy, 2)).To(Equal([]time.Duration{time.Second, time.Second}))
+
+			_, keepRetrying := policy.DelayFor(3)
+			Expect(keepRetrying).To(BeFalse())
+		})
+
+		It("gives up when the policy it limits does", func() {
+			policy := MaxAttempts(ExponentialRetryPolicy{MaxRetries: 1}, 10)
+
+			_, keepRetrying := policy.DelayFor(2)
+			Expect(keepRetrying).To(BeFalse())
+		})
+	})
+
+	Describe("MaxElapsed", func() {
+		var clock *fakeclock.FakeClock
+		var policy *LimitedRetryPolicy
+
+		BeforeEach(func() {
+			clock = fakeclock.NewFakeClock(time.Now())
+			policy = MaxElapsed(ConstantRetryPolicy{Delay: 4 * time.Second}, 10*time.Second)
+			policy.Clock = clock
+		})
+
+		It("retries until the time has passed since the first failed attempt, never waiting past it", func() {
+			delay, keepRetrying := policy.DelayFor(1)
+			Expect(delay).To(Equal(4 * time.Second))
+			Expect(keepRetrying).To(BeTrue())
+
+			clock.Increment(4 * time.Second)
+			delay, _ = policy.DelayFor(2)
+			Expect(delay).To(Equal(4 * time.Second))
+
+			clock.Increment(4 * time.Second)
+			delay, keepRetrying = policy.DelayFor(3)
+			Expect(delay).To(Equal(2 * time.Second))
+			Expect(keepRetrying).To(BeTrue())
+
+			clock.Increment(2 * time.Second)
+			_, keepRetrying = policy.DelayFor(4)
+			Expect(keepRetrying).To(BeFalse())
+		})
+
+		It("starts timing again for a new sequence", func() {
+			policy.DelayFor(1)
+			clock.Increment(20 * time.Second)
+
+			_, keepRetrying := NewRetrySequence(policy).DelayFor(2)
+			Expect(keepRetrying).To(BeTrue())
+
+			_, keepRetrying = policy.DelayFor(1)
+			Expect(keepRetrying).To(BeTrue())
+		})
+
+		It("composes with MaxAttempts", func() {
+			limited := MaxAttempts(policy, 2)
+
+			_, keepRetrying := limited.DelayFor(1)
+			Expect(keepRetrying).To(BeTrue())
+			_, keepRetrying = limited.DelayFor(2)
+			Expect(keepRetrying).To(BeFalse())
+		})
+	})
+})
@@ -1,6 +1,9 @@
 package storeadapter
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 //go:generate counterfeiter . Sleeper
 
@@ -16,18 +19,45 @@ type RetryPolicy interface {
 
 type retryable struct {
 	StoreAdapter
-	sleeper     Sleeper
-	retryPolicy RetryPolicy
+	contextAdapter  ContextStoreAdapter
+	sleeper         Sleeper
+	retryPolicy     RetryPolicy
+	retryClassifier RetryClassifier
 }
 
+// NewRetryable wraps storeAdapter so that requests which time out are retried
+// according to retryPolicy. Connecting and maintaining a node are retried
+// like any other request, a maintained node is maintained again if the
+// adapter stops maintaining it before it is released, and a watch that fails
+// is resumed from the last event it delivered. The returned adapter also implements
+// ContextStoreAdapter; pass it to NewContextStoreAdapter to use it.
 func NewRetryable(storeAdapter StoreAdapter, sleeper Sleeper, retryPolicy RetryPolicy) StoreAdapter {
+	return NewRetryableWithClassifier(storeAdapter, sleeper, retryPolicy, TimeoutRetryClassifier{})
+}
+
+// NewRetryableWithClassifier is NewRetryable, retrying the requests whose
+// errors retryClassifier picks out instead of only those that time out. A nil
+// retryClassifier retries on timeouts.
+func NewRetryableWithClassifier(storeAdapter StoreAdapter, sleeper Sleeper, retryPolicy RetryPolicy, retryClassifier RetryClassifier) StoreAdapter {
+	if retryClassifier == nil {
+		retryClassifier = TimeoutRetryClassifier{}
+	}
+
 	return &retryable{
-		StoreAdapter: storeAdapter,
-		sleeper:      sleeper,
-		retryPolicy:  retryPolicy,
+		StoreAdapter:    storeAdapter,
+		contextAdapter:  NewContextStoreAdapter(storeAdapter),
+		sleeper:         sleeper,
+		retryPolicy:     retryPolicy,
+		retryClassifier: retryClassifier,
 	}
 }
 
+func (adapter *retryable) Connect() error {
+	return adapter.retry(func() error {
+		return adapter.StoreAdapter.Connect()
+	})
+}
+
 func (adapter *retryable) Create(node StoreNode) error {
 	return adapter.retry(func() error {
 		return adapter.StoreAdapter.Create(node)
@@ -104,25 +134,181 @@ func (adapter *retryable) CompareAndDeleteByIndex(nodes ...StoreNode) error {
 	})
 }
 
+func (adapter *retryable) Txn(comparisons []TxnCompare, operations []TxnOp) error {
+	return adapter.retry(func() error {
+		return adapter.StoreAdapter.Txn(comparisons, operations)
+	})
+}
+
 func (adapter *retryable) UpdateDirTTL(dir string, ttl uint64) error {
 	return adapter.retry(func() error {
 		return adapter.StoreAdapter.UpdateDirTTL(dir, ttl)
 	})
 }
 
+// Watch resumes the watch whenever it fails with an error the classifier
+// retries. See watch.
+func (adapter *retryable) Watch(key string) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	return adapter.watch(context.Background(), key, 0, false)
+}
+
+func (adapter *retryable) WatchFrom(key string, afterIndex uint64) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	return adapter.watch(context.Background(), key, afterIndex, true)
+}
+
+func (adapter *retryable) MaintainNode(storeNode StoreNode) (<-chan bool, chan chan bool, error) {
+	return adapter.maintainOwnership(context.Background(), func() (<-chan bool, chan chan bool, error) {
+		return adapter.StoreAdapter.MaintainNode(storeNode)
+	})
+}
+
+func (adapter *retryable) MaintainNodeWithToken(storeNode StoreNode) (<-chan NodeStatus, chan chan bool, error) {
+	return adapter.maintain(context.Background(), func() (<-chan NodeStatus, chan chan bool, error) {
+		return adapter.StoreAdapter.MaintainNodeWithToken(storeNode)
+	})
+}
+
+func (adapter *retryable) MaintainNodeWithOptions(storeNode StoreNode, options MaintainOptions) (<-chan NodeStatus, chan chan bool, error) {
+	return adapter.maintain(context.Background(), func() (<-chan NodeStatus, chan chan bool, error) {
+		return adapter.StoreAdapter.MaintainNodeWithOptions(storeNode, options)
+	})
+}
+
+func (adapter *retryable) ConnectContext(ctx context.Context) error {
+	return adapter.retryContext(ctx, func() error {
+		return adapter.contextAdapter.ConnectContext(ctx)
+	})
+}
+
+func (adapter *retryable) CreateContext(ctx context.Context, node StoreNode) error {
+	return adapter.retryContext(ctx, func() error {
+		return adapter.contextAdapter.CreateContext(ctx, node)
+	})
+}
+
+func (adapter *retryable) UpdateContext(ctx context.Context, node StoreNode) error {
+	return adapter.retryContext(ctx, func() error {
+		return adapter.contextAdapter.UpdateContext(ctx, node)
+	})
+}
+
+func (adapter *retryable) CompareAndSwapContext(ctx context.Context, nodeA StoreNode, nodeB StoreNode) error {
+	return adapter.retryContext(ctx, func() error {
+		return adapter.contextAdapter.CompareAndSwapContext(ctx, nodeA, nodeB)
+	})
+}
+
+func (adapter *retryable) CompareAndSwapByIndexContext(ctx context.Context, index uint64, node StoreNode) error {
+	return adapter.retryContext(ctx, func() error {
+		return adapter.contextAdapter.CompareAndSwapByIndexContext(ctx, index, node)
+	})
+}
+
+func (adapter *retryable) SetMultiContext(ctx context.Context, nodes []StoreNode) error {
+	return adapter.retryContext(ctx, func() error {
+		return adapter.contextAdapter.SetMultiContext(ctx, nodes)
+	})
+}
+
+func (adapter *retryable) GetContext(ctx context.Context, key string) (StoreNode, error) {
+	var node StoreNode
+	err := adapter.retryContext(ctx, func() error {
+		var err error
+		node, err = adapter.contextAdapter.GetContext(ctx, key)
+		return err
+	})
+
+	return node, err
+}
+
+func (adapter *retryable) DeleteContext(ctx context.Context, keys ...string) error {
+	return adapter.retryContext(ctx, func() error {
+		return adapter.contextAdapter.DeleteContext(ctx, keys...)
+	})
+}
+
+func (adapter *retryable) DeleteLeavesContext(ctx context.Context, keys ...string) error {
+	return adapter.retryContext(ctx, func() error {
+		return adapter.contextAdapter.DeleteLeavesContext(ctx, keys...)
+	})
+}
+
+func (adapter *retryable) ListRecursivelyContext(ctx context.Context, key string) (StoreNode, error) {
+	var node StoreNode
+	err := adapter.retryContext(ctx, func() error {
+		var err error
+		node, err = adapter.contextAdapter.ListRecursivelyContext(ctx, key)
+		return err
+	})
+
+	return node, err
+}
+
+func (adapter *retryable) CompareAndDeleteContext(ctx context.Context, nodes ...StoreNode) error {
+	return adapter.retryContext(ctx, func() error {
+		return adapter.contextAdapter.CompareAndDeleteContext(ctx, nodes...)
+	})
+}
+
+func (adapter *retryable) CompareAndDeleteByIndexContext(ctx context.Context, nodes ...StoreNode) error {
+	return adapter.retryContext(ctx, func() error {
+		return adapter.contextAdapter.CompareAndDeleteByIndexContext(ctx, nodes...)
+	})
+}
+
+func (adapter *retryable) TxnContext(ctx context.Context, comparisons []TxnCompare, operations []TxnOp) error {
+	return adapter.retryContext(ctx, func() error {
+		return adapter.contextAdapter.TxnContext(ctx, comparisons, operations)
+	})
+}
+
+func (adapter *retryable) UpdateDirTTLContext(ctx context.Context, dir string, ttl uint64) error {
+	return adapter.retryContext(ctx, func() error {
+		return adapter.contextAdapter.UpdateDirTTLContext(ctx, dir, ttl)
+	})
+}
+
+func (adapter *retryable) WatchContext(ctx context.Context, key string) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	return adapter.watch(ctx, key, 0, false)
+}
+
+func (adapter *retryable) WatchFromContext(ctx context.Context, key string, afterIndex uint64) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	return adapter.watch(ctx, key, afterIndex, true)
+}
+
+func (adapter *retryable) MaintainNodeContext(ctx context.Context, storeNode StoreNode) (<-chan bool, chan chan bool, error) {
+	return adapter.maintainOwnership(ctx, func() (<-chan bool, chan chan bool, error) {
+		return adapter.contextAdapter.MaintainNodeContext(ctx, storeNode)
+	})
+}
+
+func (adapter *retryable) MaintainNodeWithTokenContext(ctx context.Context, storeNode StoreNode) (<-chan NodeStatus, chan chan bool, error) {
+	return adapter.maintain(ctx, func() (<-chan NodeStatus, chan chan bool, error) {
+		return adapter.contextAdapter.MaintainNodeWithTokenContext(ctx, storeNode)
+	})
+}
+
+func (adapter *retryable) MaintainNodeWithOptionsContext(ctx context.Context, storeNode StoreNode, options MaintainOptions) (<-chan NodeStatus, chan chan bool, error) {
+	return adapter.maintain(ctx, func() (<-chan NodeStatus, chan chan bool, error) {
+		return adapter.contextAdapter.MaintainNodeWithOptionsContext(ctx, storeNode, options)
+	})
+}
+
 func (adapter *retryable) retry(action func() error) error {
 	var err error
 
+	retryPolicy := NewRetrySequence(adapter.retryPolicy)
+
 	var failedAttempts uint
 	for {
 		err = action()
-		if err != ErrorTimeout {
+		if !adapter.shouldRetry(err) {
 			break
 		}
 
 		failedAttempts++
 
-		delay, keepRetrying := adapter.retryPolicy.DelayFor(failedAttempts)
+		delay, keepRetrying := retryPolicy.DelayFor(failedAttempts)
 		if !keepRetrying {
 			break
 		}
@@ -132,3 +318,44 @@ func (adapter *retryable) retry(action func() error) error {
 
 	return err
 }
+
+func (adapter *retryable) shouldRetry(err error) bool {
+	return err != nil && adapter.retryClassifier.ShouldRetry(err)
+}
+
+// retryContext behaves like retry, but gives up as soon as the context is done,
+// including while sleeping between attempts.
+func (adapter *retryable) retryContext(ctx context.Context, action func() error) error {
+	var err error
+
+	retryPolicy := NewRetrySequence(adapter.retryPolicy)
+
+	var failedAttempts uint
+	for {
+		err = action()
+		if !adapter.shouldRetry(err) {
+			break
+		}
+
+		failedAttempts++
+
+		delay, keepRetrying := retryPolicy.DelayFor(failedAttempts)
+		if !keepRetrying {
+			break
+		}
+
+		slept := make(chan struct{})
+		go func() {
+			adapter.sleeper.Sleep(delay)
+			close(slept)
+		}()
+
+		select {
+		case <-slept:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,148 @@
+package storeadapter
+
+import "context"
+
+// maintain maintains a node with start, which calls one of the inner
+// adapter's MaintainNode methods, retrying it as any other request. The inner
+// adapter reports most failures only by closing its status channel, so when
+// it does so before the node is released, maintaining is started again once
+// the retry policy's delay has passed, and its statuses are forwarded on the
+// same channel. Failed attempts are counted afresh after each status.
+// Maintaining ends, closing the status channel, when the node is released,
+// ctx is done, or the policy gives up; a release is still acknowledged after
+// that.
+func (adapter *retryable) maintain(ctx context.Context, start func() (<-chan NodeStatus, chan chan bool, error)) (<-chan NodeStatus, chan chan bool, error) {
+	var innerStatus <-chan NodeStatus
+	var innerRelease chan chan bool
+	err := adapter.retryContext(ctx, func() error {
+		var err error
+		innerStatus, innerRelease, err = start()
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nodeStatus := make(chan NodeStatus)
+	releaseNode := make(chan chan bool)
+
+	go func() {
+		defer acknowledgeReleases(releaseNode)
+		defer close(nodeStatus)
+
+		retryPolicy := NewRetrySequence(adapter.retryPolicy)
+
+		var failedAttempts uint
+		for {
+			select {
+			case status, ok := <-innerStatus:
+				if ok {
+					nodeStatus <- status
+					failedAttempts = 0
+					continue
+				}
+
+				if ctx.Err() != nil {
+					return
+				}
+
+				failedAttempts++
+
+				delay, keepRetrying := retryPolicy.DelayFor(failedAttempts)
+				if !keepRetrying {
+					return
+				}
+
+				slept := make(chan struct{})
+				go func() {
+					adapter.sleeper.Sleep(delay)
+					close(slept)
+				}()
+
+				select {
+				case <-slept:
+				case released := <-releaseNode:
+					if released != nil {
+						close(released)
+					}
+					return
+				case <-ctx.Done():
+					return
+				}
+
+				if adapter.retryContext(ctx, func() error {
+					var err error
+					innerStatus, innerRelease, err = start()
+					return err
+				}) != nil {
+					return
+				}
+
+			case released := <-releaseNode:
+				// The inner adapter acknowledges the release once it has let go
+				// of the node, and only then is the status channel closed.
+				acknowledged := make(chan bool)
+				if released == nil {
+					acknowledged = nil
+				}
+				innerRelease <- acknowledged
+				if acknowledged != nil {
+					<-acknowledged
+					close(released)
+				}
+				return
+			}
+		}
+	}()
+
+	return nodeStatus, releaseNode, nil
+}
+
+// acknowledgeReleases closes every channel sent on releaseNode, for a node
+// that is no longer maintained, so that a caller releasing it later does not
+// wait forever.
+func acknowledgeReleases(releaseNode chan chan bool) {
+	for released := range releaseNode {
+		if released != nil {
+			close(released)
+		}
+	}
+}
+
+// maintainOwnership is maintain for the MaintainNode methods that report only
+// whether the node is owned.
+func (adapter *retryable) maintainOwnership(ctx context.Context, start func() (<-chan bool, chan chan bool, error)) (<-chan bool, chan chan bool, error) {
+	nodeStatus, releaseNode, err := adapter.maintain(ctx, func() (<-chan NodeStatus, chan chan bool, error) {
+		lostNode, releaseNode, err := start()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return statusesOf(lostNode), releaseNode, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owned := make(chan bool)
+	go func() {
+		defer close(owned)
+		for status := range nodeStatus {
+			owned <- status.Owned
+		}
+	}()
+
+	return owned, releaseNode, nil
+}
+
+func statusesOf(lostNode <-chan bool) <-chan NodeStatus {
+	nodeStatus := make(chan NodeStatus)
+	go func() {
+		defer close(nodeStatus)
+		for owned := range lostNode {
+			nodeStatus <- NodeStatus{Owned: owned}
+		}
+	}()
+
+	return nodeStatus
+}
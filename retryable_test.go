@@ -1,11 +1,14 @@
 package storeadapter_test
 
 import (
+	"context"
 	"errors"
 	"time"
 
+	"code.cloudfoundry.org/clock/fakeclock"
 	. "github.com/cloudfoundry/storeadapter"
 	"github.com/cloudfoundry/storeadapter/fakes"
+	"github.com/cloudfoundry/storeadapter/fakestoreadapter"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -76,6 +79,22 @@ var _ = Describe("Retryable", func() {
 			})
 		})
 
+		Context("when the store adapter returns an error wrapping a timeout", func() {
+			BeforeEach(func() {
+				resultIn(&MultiError{Results: []KeyResult{
+					{Key: "key-a"},
+					{Key: "key-b", Err: ErrorTimeout},
+				}})
+
+				retryPolicy.DelayForReturns(0, false)
+			})
+
+			It("retries", func() {
+				Expect(retryPolicy.DelayForCallCount()).To(Equal(1))
+				Expect(errResult).To(MatchError(ErrorTimeout))
+			})
+		})
+
 		Context("when the store adapter returns a non-timeout error", func() {
 			var adapterErr error
 
@@ -335,6 +354,25 @@ var _ = Describe("Retryable", func() {
 		})
 	})
 
+	Describe("Txn", func() {
+		comparisons := []TxnCompare{ValueEquals("key-a", []byte("value-a"))}
+		operations := []TxnOp{Put(StoreNode{Key: "key-b", Value: []byte("value-b")})}
+
+		itRetries(func() error {
+			return adapter.Txn(comparisons, operations)
+		}, func(err error) {
+			innerStoreAdapter.TxnReturns(err)
+		}, func() int {
+			return innerStoreAdapter.TxnCallCount()
+		}, func() {
+			It("passes the comparisons and operations through", func() {
+				c, o := innerStoreAdapter.TxnArgsForCall(0)
+				Expect(c).To(Equal(comparisons))
+				Expect(o).To(Equal(operations))
+			})
+		})
+	})
+
 	Describe("UpdateDirTTL", func() {
 		dirKey := "dir-key"
 		var ttlToSet uint64 = 42
@@ -353,4 +391,505 @@ var _ = Describe("Retryable", func() {
 			})
 		})
 	})
+
+	Describe("Connect", func() {
+		itRetries(func() error {
+			return adapter.Connect()
+		}, func(err error) {
+			innerStoreAdapter.ConnectReturns(err)
+		}, func() int {
+			return innerStoreAdapter.ConnectCallCount()
+		}, func() {})
+	})
+
+	Describe("MaintainNode", func() {
+		maintainedNode := StoreNode{
+			Key:   "maintained-key",
+			Value: []byte("maintained-value"),
+		}
+
+		itRetries(func() error {
+			_, _, err := adapter.MaintainNode(maintainedNode)
+			return err
+		}, func(err error) {
+			innerStoreAdapter.MaintainNodeReturns(nil, nil, err)
+		}, func() int {
+			return innerStoreAdapter.MaintainNodeCallCount()
+		}, func() {
+			It("passes the node through", func() {
+				Expect(innerStoreAdapter.MaintainNodeArgsForCall(0)).To(Equal(maintainedNode))
+			})
+		})
+	})
+
+	Describe("MaintainNodeWithToken", func() {
+		maintainedNode := StoreNode{
+			Key:   "maintained-key",
+			Value: []byte("maintained-value"),
+		}
+
+		itRetries(func() error {
+			_, _, err := adapter.MaintainNodeWithToken(maintainedNode)
+			return err
+		}, func(err error) {
+			innerStoreAdapter.MaintainNodeWithTokenReturns(nil, nil, err)
+		}, func() int {
+			return innerStoreAdapter.MaintainNodeWithTokenCallCount()
+		}, func() {
+			It("passes the node through", func() {
+				Expect(innerStoreAdapter.MaintainNodeWithTokenArgsForCall(0)).To(Equal(maintainedNode))
+			})
+		})
+	})
+
+	Describe("MaintainNodeWithOptions", func() {
+		maintainedNode := StoreNode{
+			Key:   "maintained-key",
+			Value: []byte("maintained-value"),
+		}
+		options := MaintainOptions{RefreshInterval: time.Second}
+
+		itRetries(func() error {
+			_, _, err := adapter.MaintainNodeWithOptions(maintainedNode, options)
+			return err
+		}, func(err error) {
+			innerStoreAdapter.MaintainNodeWithOptionsReturns(nil, nil, err)
+		}, func() int {
+			return innerStoreAdapter.MaintainNodeWithOptionsCallCount()
+		}, func() {
+			It("passes the node and options through", func() {
+				node, passedOptions := innerStoreAdapter.MaintainNodeWithOptionsArgsForCall(0)
+				Expect(node).To(Equal(maintainedNode))
+				Expect(passedOptions).To(Equal(options))
+			})
+		})
+	})
+
+	Describe("maintaining a node the store adapter stops maintaining", func() {
+		var (
+			fakeStore   *fakestoreadapter.FakeStoreAdapter
+			firstStatus chan NodeStatus
+
+			nodeStatus  <-chan NodeStatus
+			releaseNode chan chan bool
+		)
+
+		BeforeEach(func() {
+			fakeStore = fakestoreadapter.New()
+			firstStatus = make(chan NodeStatus, 1)
+			fakeStore.MaintainNodeTokens = firstStatus
+			retryPolicy.DelayForReturns(time.Second, true)
+
+			adapter = NewRetryable(fakeStore, sleeper, retryPolicy)
+
+			var err error
+			nodeStatus, releaseNode, err = adapter.MaintainNodeWithToken(StoreNode{Key: "maintained-key", TTL: 2})
+			Expect(err).NotTo(HaveOccurred())
+
+			firstStatus <- NodeStatus{Owned: true, Token: 1}
+			Eventually(nodeStatus).Should(Receive(Equal(NodeStatus{Owned: true, Token: 1})))
+		})
+
+		It("maintains it again, retrying as the policy allows, and forwards its statuses", func() {
+			secondStatus := make(chan NodeStatus, 1)
+			fakeStore.MaintainNodeTokens = secondStatus
+			fakeStore.MaintainNodeErrInjector = fakestoreadapter.NewFakeStoreAdapterErrorInjector("maintained-key", ErrorTimeout).FailTimes(2)
+			firstStatus <- NodeStatus{Owned: false}
+			close(firstStatus)
+
+			Eventually(nodeStatus).Should(Receive(Equal(NodeStatus{Owned: false})))
+			secondStatus <- NodeStatus{Owned: true, Token: 2}
+			Eventually(nodeStatus).Should(Receive(Equal(NodeStatus{Owned: true, Token: 2})))
+
+			Expect(fakeStore.MaintainNodeErrInjector.Calls()).To(Equal(3))
+			Expect(sleeper.SleepCallCount()).To(Equal(3))
+		})
+
+		It("releases the node it maintains now", func() {
+			secondStatus := make(chan NodeStatus, 1)
+			fakeStore.MaintainNodeTokens = secondStatus
+			innerReleases := make(chan chan chan bool, 1)
+			fakeStore.OnReleaseNodeChannel = func(releaseNode chan chan bool) {
+				innerReleases <- releaseNode
+			}
+			close(firstStatus)
+
+			secondStatus <- NodeStatus{Owned: true, Token: 2}
+			Eventually(nodeStatus).Should(Receive(Equal(NodeStatus{Owned: true, Token: 2})))
+
+			var innerRelease chan chan bool
+			Eventually(innerReleases).Should(Receive(&innerRelease))
+			released := make(chan bool)
+			releaseNode <- released
+
+			var acknowledged chan bool
+			Eventually(innerRelease).Should(Receive(&acknowledged))
+			Consistently(released).ShouldNot(BeClosed())
+
+			close(acknowledged)
+			Eventually(released).Should(BeClosed())
+			Expect(nodeStatus).To(BeClosed())
+		})
+
+		Context("when the retry policy gives up", func() {
+			It("closes the status channel, and still acknowledges a release", func() {
+				retryPolicy.DelayForReturns(0, false)
+				close(firstStatus)
+
+				Eventually(nodeStatus).Should(BeClosed())
+
+				released := make(chan bool)
+				releaseNode <- released
+				Eventually(released).Should(BeClosed())
+			})
+		})
+	})
+
+	Describe("Watch", func() {
+		type innerWatch struct {
+			events chan WatchEvent
+			stop   chan bool
+			errs   chan error
+		}
+
+		var watches chan innerWatch
+
+		newInnerWatch := func() (<-chan WatchEvent, chan<- bool, <-chan error) {
+			watch := innerWatch{
+				events: make(chan WatchEvent),
+				stop:   make(chan bool, 1),
+				errs:   make(chan error, 1),
+			}
+			watches <- watch
+			return watch.events, watch.stop, watch.errs
+		}
+
+		BeforeEach(func() {
+			watches = make(chan innerWatch, 10)
+			innerStoreAdapter.WatchStub = func(string) (<-chan WatchEvent, chan<- bool, <-chan error) {
+				return newInnerWatch()
+			}
+			innerStoreAdapter.WatchFromStub = func(string, uint64) (<-chan WatchEvent, chan<- bool, <-chan error) {
+				return newInnerWatch()
+			}
+			retryPolicy.DelayForReturns(time.Second, true)
+		})
+
+		It("forwards events", func() {
+			events, _, _ := adapter.Watch("some-key")
+
+			watch := <-watches
+			watch.events <- WatchEvent{Type: CreateEvent, Node: &StoreNode{Key: "some-key"}, Index: 3}
+
+			Eventually(events).Should(Receive(Equal(WatchEvent{Type: CreateEvent, Node: &StoreNode{Key: "some-key"}, Index: 3})))
+			Expect(innerStoreAdapter.WatchArgsForCall(0)).To(Equal("some-key"))
+		})
+
+		Context("when the watch fails with a timeout", func() {
+			It("resumes watching after the last event it forwarded", func() {
+				events, _, errs := adapter.Watch("some-key")
+
+				watch := <-watches
+				watch.events <- WatchEvent{Type: UpdateEvent, Node: &StoreNode{Key: "some-key"}, Index: 7}
+				Eventually(events).Should(Receive())
+				watch.errs <- ErrorTimeout
+
+				watch = <-watches
+				Expect(innerStoreAdapter.WatchFromCallCount()).To(Equal(1))
+				key, afterIndex := innerStoreAdapter.WatchFromArgsForCall(0)
+				Expect(key).To(Equal("some-key"))
+				Expect(afterIndex).To(BeEquivalentTo(7))
+				Expect(sleeper.SleepCallCount()).To(Equal(1))
+				Expect(sleeper.SleepArgsForCall(0)).To(Equal(time.Second))
+
+				watch.events <- WatchEvent{Type: DeleteEvent, Index: 8}
+				Eventually(events).Should(Receive(Equal(WatchEvent{Type: DeleteEvent, Index: 8})))
+				Consistently(errs).ShouldNot(Receive())
+			})
+
+			It("resumes watching from where it started if no event was forwarded", func() {
+				adapter.WatchFrom("some-key", 4)
+
+				watch := <-watches
+				watch.errs <- ErrorTimeout
+
+				<-watches
+				Expect(innerStoreAdapter.WatchFromCallCount()).To(Equal(2))
+				_, afterIndex := innerStoreAdapter.WatchFromArgsForCall(1)
+				Expect(afterIndex).To(BeEquivalentTo(4))
+			})
+
+			It("counts failed attempts afresh after each event", func() {
+				events, _, _ := adapter.Watch("some-key")
+
+				watch := <-watches
+				watch.errs <- ErrorTimeout
+				watch = <-watches
+				watch.errs <- ErrorTimeout
+				watch = <-watches
+				watch.events <- WatchEvent{Type: CreateEvent, Index: 1}
+				Eventually(events).Should(Receive())
+				watch.errs <- ErrorTimeout
+				<-watches
+
+				Expect(retryPolicy.DelayForCallCount()).To(Equal(3))
+				Expect(retryPolicy.DelayForArgsForCall(0)).To(BeEquivalentTo(1))
+				Expect(retryPolicy.DelayForArgsForCall(1)).To(BeEquivalentTo(2))
+				Expect(retryPolicy.DelayForArgsForCall(2)).To(BeEquivalentTo(1))
+			})
+
+			Context("once the retry policy gives up", func() {
+				BeforeEach(func() {
+					retryPolicy.DelayForReturns(0, false)
+				})
+
+				It("sends the error and closes its channels", func() {
+					events, _, errs := adapter.Watch("some-key")
+
+					watch := <-watches
+					watch.errs <- ErrorTimeout
+
+					Eventually(errs).Should(Receive(Equal(ErrorTimeout)))
+					Eventually(events).Should(BeClosed())
+					Expect(innerStoreAdapter.WatchFromCallCount()).To(Equal(0))
+				})
+			})
+		})
+
+		Context("when the index a watch resumes from has been cleared", func() {
+			var indexCleared error
+
+			BeforeEach(func() {
+				indexCleared = &Error{Op: "WatchFrom", Key: "some-key", Index: 20, Err: ErrorWatchIndexCleared}
+			})
+
+			It("watches afresh if it was not asked to start from an index", func() {
+				events, _, errs := adapter.Watch("some-key")
+
+				watch := <-watches
+				watch.events <- WatchEvent{Type: UpdateEvent, Index: 7}
+				Eventually(events).Should(Receive())
+				watch.errs <- ErrorTimeout
+
+				watch = <-watches
+				watch.errs <- indexCleared
+
+				watch = <-watches
+				Expect(innerStoreAdapter.WatchCallCount()).To(Equal(2))
+				Expect(innerStoreAdapter.WatchFromCallCount()).To(Equal(1))
+				Expect(sleeper.SleepCallCount()).To(Equal(1))
+
+				watch.events <- WatchEvent{Type: DeleteEvent, Index: 21}
+				Eventually(events).Should(Receive(Equal(WatchEvent{Type: DeleteEvent, Index: 21})))
+				Consistently(errs).ShouldNot(Receive())
+			})
+
+			It("sends the error if it was asked to start from an index", func() {
+				events, _, errs := adapter.WatchFrom("some-key", 4)
+
+				watch := <-watches
+				watch.errs <- indexCleared
+
+				Eventually(errs).Should(Receive(Equal(indexCleared)))
+				Eventually(events).Should(BeClosed())
+				Expect(innerStoreAdapter.WatchCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the watch fails with an error that is not retried", func() {
+			It("sends the error and closes its channels", func() {
+				watchErr := errors.New("oops")
+				events, _, errs := adapter.Watch("some-key")
+
+				watch := <-watches
+				watch.errs <- watchErr
+
+				Eventually(errs).Should(Receive(Equal(watchErr)))
+				Eventually(events).Should(BeClosed())
+				Expect(sleeper.SleepCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the watch ends without an error", func() {
+			It("closes its channels", func() {
+				events, _, errs := adapter.Watch("some-key")
+
+				watch := <-watches
+				close(watch.events)
+				close(watch.errs)
+
+				Eventually(events).Should(BeClosed())
+				Eventually(errs).Should(BeClosed())
+				Expect(innerStoreAdapter.WatchFromCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when stopped", func() {
+			It("stops the inner watch and closes its channels", func() {
+				events, stop, _ := adapter.Watch("some-key")
+
+				watch := <-watches
+				stop <- true
+
+				Eventually(watch.stop).Should(Receive())
+				Eventually(events).Should(BeClosed())
+			})
+		})
+
+		Context("when the context is done while sleeping before resuming", func() {
+			It("closes its channels without resuming", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				unblock := make(chan struct{})
+				defer close(unblock)
+
+				sleeper.SleepStub = func(time.Duration) {
+					cancel()
+					<-unblock
+				}
+
+				events, _, _ := NewContextStoreAdapter(adapter).WatchContext(ctx, "some-key")
+
+				watch := <-watches
+				watch.errs <- ErrorTimeout
+
+				Eventually(events).Should(BeClosed())
+				Expect(innerStoreAdapter.WatchFromCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("with a context", func() {
+		var contextAdapter ContextStoreAdapter
+
+		BeforeEach(func() {
+			contextAdapter = NewContextStoreAdapter(adapter)
+			innerStoreAdapter.GetReturns(StoreNode{}, ErrorTimeout)
+			retryPolicy.DelayForReturns(time.Second, true)
+		})
+
+		Context("when the context is done while sleeping between attempts", func() {
+			It("gives up with the context's error", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				unblock := make(chan struct{})
+				defer close(unblock)
+
+				sleeper.SleepStub = func(time.Duration) {
+					cancel()
+					<-unblock
+				}
+
+				_, err := contextAdapter.GetContext(ctx, "some-key")
+				Expect(err).To(Equal(context.Canceled))
+				Expect(innerStoreAdapter.GetCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when the inner adapter recovers", func() {
+			BeforeEach(func() {
+				innerStoreAdapter.GetStub = func(string) (StoreNode, error) {
+					if innerStoreAdapter.GetCallCount() < 3 {
+						return StoreNode{}, ErrorTimeout
+					}
+					return StoreNode{Key: "some-key"}, nil
+				}
+			})
+
+			It("retries until it succeeds", func() {
+				node, err := contextAdapter.GetContext(context.Background(), "some-key")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(node.Key).To(Equal("some-key"))
+				Expect(innerStoreAdapter.GetCallCount()).To(Equal(3))
+				Expect(sleeper.SleepCallCount()).To(Equal(2))
+			})
+		})
+	})
+
+	Describe("with a retry classifier", func() {
+		var classifier *fakes.FakeRetryClassifier
+		var leaderElection error
+
+		BeforeEach(func() {
+			classifier = new(fakes.FakeRetryClassifier)
+			leaderElection = &Error{Op: "Get", Key: "some-key", Code: 301, Err: errors.New("leader election"), Temporary: true}
+
+			adapter = NewRetryableWithClassifier(innerStoreAdapter, sleeper, retryPolicy, classifier)
+			retryPolicy.DelayForReturns(time.Second, true)
+
+			innerStoreAdapter.GetStub = func(string) (StoreNode, error) {
+				if innerStoreAdapter.GetCallCount() < 3 {
+					return StoreNode{}, leaderElection
+				}
+				return StoreNode{Key: "some-key"}, nil
+			}
+		})
+
+		It("retries the errors the classifier picks out", func() {
+			classifier.ShouldRetryReturns(true)
+
+			node, err := adapter.Get("some-key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Key).To(Equal("some-key"))
+
+			Expect(classifier.ShouldRetryCallCount()).To(Equal(2))
+			Expect(classifier.ShouldRetryArgsForCall(0)).To(Equal(leaderElection))
+			Expect(sleeper.SleepCallCount()).To(Equal(2))
+		})
+
+		It("returns the errors it does not pick out", func() {
+			classifier.ShouldRetryReturns(false)
+
+			_, err := adapter.Get("some-key")
+			Expect(err).To(Equal(leaderElection))
+			Expect(innerStoreAdapter.GetCallCount()).To(Equal(1))
+		})
+
+		It("classifies errors for the context variants too", func() {
+			classifier.ShouldRetryReturns(true)
+
+			_, err := NewContextStoreAdapter(adapter).GetContext(context.Background(), "some-key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(innerStoreAdapter.GetCallCount()).To(Equal(3))
+		})
+
+		Context("when the classifier is nil", func() {
+			BeforeEach(func() {
+				adapter = NewRetryableWithClassifier(innerStoreAdapter, sleeper, retryPolicy, nil)
+			})
+
+			It("retries timeouts only", func() {
+				_, err := adapter.Get("some-key")
+				Expect(err).To(Equal(leaderElection))
+
+				innerStoreAdapter.GetReturns(StoreNode{}, ErrorTimeout)
+				retryPolicy.DelayForReturns(0, false)
+
+				_, err = adapter.Get("some-key")
+				Expect(err).To(Equal(ErrorTimeout))
+				Expect(retryPolicy.DelayForCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("with a stateful retry policy", func() {
+		It("gives each request a sequence of its own", func() {
+			clock := fakeclock.NewFakeClock(time.Now())
+			policy := MaxElapsed(ConstantRetryPolicy{Delay: time.Second}, 10*time.Second)
+			policy.Clock = clock
+
+			adapter = NewRetryable(innerStoreAdapter, sleeper, policy)
+			sleeper.SleepStub = func(delay time.Duration) {
+				clock.Increment(delay)
+			}
+			innerStoreAdapter.GetReturns(StoreNode{}, ErrorTimeout)
+
+			policy.DelayFor(1)
+			clock.Increment(time.Minute)
+
+			_, err := adapter.Get("some-key")
+			Expect(err).To(Equal(ErrorTimeout))
+			Expect(innerStoreAdapter.GetCallCount()).To(Equal(11))
+		})
+	})
 })
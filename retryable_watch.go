@@ -0,0 +1,129 @@
+package storeadapter
+
+import (
+	"context"
+	"errors"
+)
+
+// watch forwards the events of a watch on key. When the watch fails with an
+// error the classifier retries, it is resumed after the last event forwarded,
+// or from where it started if there was none, once the retry policy's delay
+// has passed. Failed attempts are counted afresh after each event. Any other
+// error, or one the policy gives up on, is sent on the error channel and ends
+// the watch.
+//
+// A watch that was not asked to start from an index never reports
+// ErrorWatchIndexCleared: if the index it resumed from has been cleared, it
+// starts watching afresh at once, and the events in between are missed as
+// they would be by a new Watch.
+func (adapter *retryable) watch(ctx context.Context, key string, afterIndex uint64, fromIndex bool) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	anchored := fromIndex
+
+	events := make(chan WatchEvent)
+	stop := make(chan bool, 1)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(events)
+		defer close(errs)
+
+		retryPolicy := NewRetrySequence(adapter.retryPolicy)
+
+		var failedAttempts uint
+		for {
+			var innerEvents <-chan WatchEvent
+			var innerStop chan<- bool
+			var innerErrs <-chan error
+			if fromIndex {
+				innerEvents, innerStop, innerErrs = adapter.contextAdapter.WatchFromContext(ctx, key, afterIndex)
+			} else {
+				innerEvents, innerStop, innerErrs = adapter.contextAdapter.WatchContext(ctx, key)
+			}
+
+			var err error
+			for err == nil && (innerEvents != nil || innerErrs != nil) {
+				select {
+				case event, ok := <-innerEvents:
+					if !ok {
+						innerEvents = nil
+						continue
+					}
+
+					select {
+					case events <- event:
+					case <-stop:
+						stopWatch(innerStop)
+						return
+					case <-ctx.Done():
+						stopWatch(innerStop)
+						return
+					}
+
+					if event.Index != 0 {
+						afterIndex, fromIndex = event.Index, true
+					}
+					failedAttempts = 0
+
+				case e, ok := <-innerErrs:
+					if !ok {
+						innerErrs = nil
+						continue
+					}
+					err = e
+
+				case <-stop:
+					stopWatch(innerStop)
+					return
+				}
+			}
+
+			// The watch ended without an error: it was stopped by its context
+			// or by disconnecting.
+			if err == nil {
+				return
+			}
+			stopWatch(innerStop)
+
+			if !anchored && fromIndex && ctx.Err() == nil && errors.Is(err, ErrorWatchIndexCleared) {
+				fromIndex = false
+				continue
+			}
+
+			if ctx.Err() != nil || !adapter.shouldRetry(err) {
+				errs <- err
+				return
+			}
+
+			failedAttempts++
+
+			delay, keepRetrying := retryPolicy.DelayFor(failedAttempts)
+			if !keepRetrying {
+				errs <- err
+				return
+			}
+
+			slept := make(chan struct{})
+			go func() {
+				adapter.sleeper.Sleep(delay)
+				close(slept)
+			}()
+
+			select {
+			case <-slept:
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, stop, errs
+}
+
+func stopWatch(stop chan<- bool) {
+	select {
+	case stop <- true:
+	default:
+	}
+}
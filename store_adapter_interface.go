@@ -18,7 +18,7 @@ type StoreAdapter interface {
 	CompareAndSwapByIndex(prevIndex uint64, newNode StoreNode) error
 
 	// Set multiple nodes at once. If any of them fail,
-	// it will return the first error.
+	// it will return a *MultiError with the outcome for each node.
 	SetMulti(nodes []StoreNode) error
 
 	// Retrieve a node from the store at the given key.
@@ -26,23 +26,34 @@ type StoreAdapter interface {
 	Get(key string) (StoreNode, error)
 
 	// Recursively get the contents of a key.
+	//
+	// The listed directory's Index is one WatchFrom can be given to see
+	// every change made after the listing, such as the store's index when
+	// it was read.
 	ListRecursively(key string) (StoreNode, error)
 
 	// Delete a set of keys from the store. If any fail to be
-	// deleted or don't actually exist, an error is returned.
+	// deleted or don't actually exist, a *MultiError is returned.
 	Delete(keys ...string) error
 
 	// DeleteLeaves removes a set of empty directories and key-value pairs
 	// from the store. If any fail to be deleted or don't actually exist,
-	// an error is returned.
+	// a *MultiError is returned.
 	DeleteLeaves(keys ...string) error
 
 	// CompareAndDelete and don't delete if the compare fails.
+	// If any node fails, a *MultiError is returned.
 	CompareAndDelete(...StoreNode) error
 
 	// CompareAndDelete by index and don't delete if the compare fails.
+	// If any node fails, a *MultiError is returned.
 	CompareAndDeleteByIndex(...StoreNode) error
 
+	// Apply all of the operations if and only if every comparison holds. If a
+	// comparison fails or an operation cannot be applied, none of the
+	// operations take effect and the corresponding error is returned.
+	Txn(comparisons []TxnCompare, operations []TxnOp) error
+
 	// Set the ttl on a directory
 	UpdateDirTTL(key string, ttl uint64) error
 
@@ -55,6 +66,14 @@ type StoreAdapter interface {
 	// Otherwise, the caller can assume that the watcher will continue attempting to stream events.
 	Watch(key string) (events <-chan WatchEvent, stop chan<- bool, errors <-chan error)
 
+	// Like Watch, but starting with the first event after afterIndex, and
+	// without skipping any events.
+	//
+	// If the store has already discarded some of those events, an error
+	// wrapping ErrorWatchIndexCleared is sent and watching stops. The caller
+	// should then re-read the key and watch again from the index it read at.
+	WatchFrom(key string, afterIndex uint64) (events <-chan WatchEvent, stop chan<- bool, errors <-chan error)
+
 	// Close any live persistent connection, and cleans up any running state.
 	Disconnect() error
 
@@ -64,4 +83,12 @@ type StoreAdapter interface {
 	//
 	// If the store times out, returns an error.
 	MaintainNode(storeNode StoreNode) (lostNode <-chan bool, releaseNode chan chan bool, err error)
+
+	// Like MaintainNode, but each status also carries the fencing token of the
+	// current ownership of the node. See NodeStatus.
+	MaintainNodeWithToken(storeNode StoreNode) (status <-chan NodeStatus, releaseNode chan chan bool, err error)
+
+	// Like MaintainNodeWithToken, but with the timing of refreshes, statuses
+	// and retries set by options. See MaintainOptions.
+	MaintainNodeWithOptions(storeNode StoreNode, options MaintainOptions) (status <-chan NodeStatus, releaseNode chan chan bool, err error)
 }
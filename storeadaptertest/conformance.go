@@ -0,0 +1,1166 @@
+// Package storeadaptertest is a conformance suite for StoreAdapter
+// implementations. Every adapter in this repository runs it, and any other
+// implementation can run it to check that it behaves the same way.
+package storeadaptertest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	. "github.com/cloudfoundry/storeadapter"
+	. "github.com/cloudfoundry/storeadapter/storenodematchers"
+	"github.com/cloudfoundry/storeadapter/test_helpers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Backend describes the StoreAdapter implementation under test.
+type Backend struct {
+	// NewStoreAdapter returns an adapter that is not yet connected, on an
+	// empty store. It is called before every spec, and the adapter is
+	// disconnected after it.
+	NewStoreAdapter func() StoreAdapter
+
+	// EmptyDirectoriesRemain is true if a directory remains once the last
+	// key under it is deleted, as in etcd v2, and false if it goes, as in
+	// etcd v3.
+	EmptyDirectoriesRemain bool
+}
+
+var counter = 0
+
+// RunConformance runs the conformance suite as the only specs of a go test.
+// A package with a Ginkgo suite of its own should register the conformance
+// specs in it with DescribeConformance instead, as Ginkgo can only run one
+// suite per test binary.
+func RunConformance(t *testing.T, description string, backend Backend) {
+	DescribeConformance(description, backend)
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, description)
+}
+
+// DescribeConformance registers the conformance specs in the current Ginkgo
+// suite, as a top-level Describe:
+//
+//	var _ = storeadaptertest.DescribeConformance("Mem Store Adapter conformance", backend)
+func DescribeConformance(description string, backend Backend) bool {
+	return Describe(description, func() {
+		var (
+			adapter       StoreAdapter
+			breakfastNode StoreNode
+			lunchNode     StoreNode
+		)
+
+		BeforeEach(func() {
+			breakfastNode = StoreNode{
+				Key:   "/menu/breakfast",
+				Value: []byte("waffles"),
+			}
+
+			lunchNode = StoreNode{
+				Key:   "/menu/lunch",
+				Value: []byte("burgers"),
+			}
+
+			adapter = backend.NewStoreAdapter()
+			err := adapter.Connect()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			adapter.Disconnect()
+		})
+
+		Describe("Get", func() {
+			BeforeEach(func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns the node, with the index it was last modified at", func() {
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(breakfastNode))
+				Expect(value.Index).NotTo(BeZero())
+			})
+
+			It("returns ErrorKeyNotFound for a missing key, reporting the operation and key", func() {
+				value, err := adapter.Get("/not_a_key")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+				Expect(value).To(BeZero())
+
+				storeErr, ok := err.(*Error)
+				Expect(ok).To(BeTrue())
+				Expect(storeErr.Op).To(Equal("Get"))
+				Expect(storeErr.Key).To(Equal("/not_a_key"))
+			})
+
+			It("returns ErrorKeyNotFound for a key that only shares a prefix with others", func() {
+				_, err := adapter.Get("/men")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("returns ErrorNodeIsDirectory for a directory", func() {
+				value, err := adapter.Get("/menu")
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
+				Expect(value).To(BeZero())
+			})
+		})
+
+		Describe("SetMulti", func() {
+			It("sets every node", func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				menu, err := adapter.ListRecursively("/menu")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(menu.ChildNodes).To(HaveLen(2))
+				Expect(menu.ChildNodes).To(ContainElement(MatchStoreNode(breakfastNode)))
+				Expect(menu.ChildNodes).To(ContainElement(MatchStoreNode(lunchNode)))
+			})
+
+			Context("when the nodes exist", func() {
+				BeforeEach(func() {
+					err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("updates them, at a later index", func() {
+					before, err := adapter.Get("/menu/lunch")
+					Expect(err).NotTo(HaveOccurred())
+
+					lunchNode.Value = []byte("steak")
+					err = adapter.SetMulti([]StoreNode{lunchNode})
+					Expect(err).NotTo(HaveOccurred())
+
+					after, err := adapter.Get("/menu/lunch")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(after).To(MatchStoreNode(lunchNode))
+					Expect(after.Index).To(BeNumerically(">", before.Index))
+				})
+			})
+
+			It("returns ErrorNodeIsDirectory for a directory", func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.SetMulti([]StoreNode{{Key: "/menu", Value: []byte("oops!")}})
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
+			})
+
+			It("returns ErrorNodeIsNotDirectory under a leaf, and writes nothing", func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.SetMulti([]StoreNode{{Key: "/menu/breakfast/eggs", Value: []byte("oops!")}})
+				Expect(err).To(MatchError(ErrorNodeIsNotDirectory))
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(breakfastNode))
+			})
+
+			It("reports the outcome for each node in a MultiError, and writes the others", func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.SetMulti([]StoreNode{{Key: "/menu", Value: []byte("oops!")}, {Key: "/dinner", Value: []byte("steak")}})
+
+				multiErr, ok := err.(*MultiError)
+				Expect(ok).To(BeTrue())
+				Expect(multiErr.Failed()).To(HaveLen(1))
+				Expect(multiErr.Failed()[0].Key).To(Equal("/menu"))
+				Expect(multiErr.Succeeded()).To(HaveLen(1))
+				Expect(multiErr.Succeeded()[0].Key).To(Equal("/dinner"))
+
+				dinner, err := adapter.Get("/dinner")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(multiErr.Succeeded()[0].Index).To(Equal(dinner.Index))
+			})
+		})
+
+		Describe("ListRecursively", func() {
+			var (
+				firstCourseNode  StoreNode
+				secondCourseNode StoreNode
+			)
+
+			BeforeEach(func() {
+				firstCourseNode = StoreNode{Key: "/menu/dinner/first_course", Value: []byte("Salad")}
+				secondCourseNode = StoreNode{Key: "/menu/dinner/second_course", Value: []byte("Brisket")}
+
+				err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode, firstCourseNode, secondCourseNode})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("lists a directory with everything under it", func() {
+				menu, err := adapter.ListRecursively("/menu")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(menu.Key).To(Equal("/menu"))
+				Expect(menu.Dir).To(BeTrue())
+				Expect(menu.Value).To(BeEmpty())
+				Expect(menu.ChildNodes).To(HaveLen(3))
+				Expect(menu.ChildNodes).To(ContainElement(MatchStoreNode(breakfastNode)))
+				Expect(menu.ChildNodes).To(ContainElement(MatchStoreNode(lunchNode)))
+
+				var dinner StoreNode
+				for _, node := range menu.ChildNodes {
+					if node.Key == "/menu/dinner" {
+						dinner = node
+					} else {
+						Expect(node.Index).NotTo(BeZero())
+					}
+				}
+
+				Expect(dinner.Dir).To(BeTrue())
+				Expect(dinner.ChildNodes).To(HaveLen(2))
+				Expect(dinner.ChildNodes).To(ContainElement(MatchStoreNode(firstCourseNode)))
+				Expect(dinner.ChildNodes).To(ContainElement(MatchStoreNode(secondCourseNode)))
+			})
+
+			It("lists the root directory", func() {
+				root, err := adapter.ListRecursively("/")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(root.Key).To(Equal(""))
+				Expect(root.Dir).To(BeTrue())
+				Expect(root.ChildNodes).To(HaveLen(1))
+				Expect(root.ChildNodes[0].Key).To(Equal("/menu"))
+				Expect(root.ChildNodes[0].ChildNodes).To(HaveLen(3))
+			})
+
+			It("reports an index to watch from that misses nothing written after the listing", func() {
+				menu, err := adapter.ListRecursively("/menu")
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.SetMulti([]StoreNode{{Key: "/elsewhere", Value: []byte("busy")}})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.SetMulti([]StoreNode{{Key: "/menu/lunch", Value: []byte("salad")}})
+				Expect(err).NotTo(HaveOccurred())
+
+				events, stop, errs := adapter.WatchFrom("/menu", menu.Index)
+				defer func() { stop <- true }()
+
+				// A store that keeps no history reports the write as having
+				// been cleared rather than missing it.
+				select {
+				case event := <-events:
+					Expect(event.Node.Key).To(Equal("/menu/lunch"))
+				case err := <-errs:
+					Expect(err).To(MatchError(ErrorWatchIndexCleared))
+				case <-time.After(5 * time.Second):
+					Fail("the write was missed")
+				}
+			})
+
+			It("returns ErrorKeyNotFound for a missing key", func() {
+				value, err := adapter.ListRecursively("/nothing-here")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+				Expect(value).To(BeZero())
+			})
+
+			It("returns ErrorNodeIsNotDirectory for a leaf", func() {
+				value, err := adapter.ListRecursively("/menu/breakfast")
+				Expect(err).To(MatchError(ErrorNodeIsNotDirectory))
+				Expect(value).To(BeZero())
+			})
+
+			Context("when the last key in a directory is deleted", func() {
+				BeforeEach(func() {
+					err := adapter.Delete("/menu/dinner/first_course", "/menu/dinner/second_course")
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				if backend.EmptyDirectoriesRemain {
+					It("leaves the directory empty", func() {
+						dinner, err := adapter.ListRecursively("/menu/dinner")
+						Expect(err).NotTo(HaveOccurred())
+						Expect(dinner.Dir).To(BeTrue())
+						Expect(dinner.ChildNodes).To(BeEmpty())
+					})
+				} else {
+					It("removes the directory", func() {
+						Eventually(func() error {
+							_, err := adapter.ListRecursively("/menu/dinner")
+							return err
+						}, 4, 0.05).Should(MatchError(ErrorKeyNotFound))
+					})
+				}
+			})
+		})
+
+		Describe("Delete", func() {
+			BeforeEach(func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("deletes the keys", func() {
+				err := adapter.Delete("/menu/breakfast", "/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				_, err = adapter.Get("/menu/lunch")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("deletes a directory with everything under it, and nothing that only shares its prefix", func() {
+				err := adapter.SetMulti([]StoreNode{{Key: "/menus", Value: []byte("unrelated")}})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.Delete("/menu")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				_, err = adapter.ListRecursively("/menu")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				_, err = adapter.Get("/menus")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns ErrorKeyNotFound for a missing key, and deletes the others", func() {
+				err := adapter.Delete("/not-a-key", "/menu/lunch")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				multiErr, ok := err.(*MultiError)
+				Expect(ok).To(BeTrue())
+				Expect(multiErr.Failed()).To(HaveLen(1))
+				Expect(multiErr.Failed()[0].Key).To(Equal("/not-a-key"))
+
+				_, err = adapter.Get("/menu/lunch")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+
+		Describe("DeleteLeaves", func() {
+			BeforeEach(func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("deletes leaves", func() {
+				err := adapter.DeleteLeaves("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("refuses to delete a directory with keys under it", func() {
+				err := adapter.DeleteLeaves("/menu")
+				Expect(err).To(MatchError(ErrorDirectoryNotEmpty))
+
+				_, err = adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns ErrorKeyNotFound for a missing key", func() {
+				err := adapter.DeleteLeaves("/not-a-key")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			if backend.EmptyDirectoriesRemain {
+				It("deletes empty directories", func() {
+					err := adapter.DeleteLeaves("/menu/breakfast", "/menu/lunch")
+					Expect(err).NotTo(HaveOccurred())
+
+					err = adapter.DeleteLeaves("/menu")
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = adapter.ListRecursively("/menu")
+					Expect(err).To(MatchError(ErrorKeyNotFound))
+				})
+			}
+		})
+
+		Describe("Create", func() {
+			var node StoreNode
+
+			BeforeEach(func() {
+				node = StoreNode{Key: "/foo", Value: []byte("some value")}
+				err := adapter.Create(node)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("creates the node", func() {
+				retrievedNode, err := adapter.Get("/foo")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(retrievedNode).To(MatchStoreNode(node))
+			})
+
+			It("returns ErrorKeyExists for an existing node", func() {
+				err := adapter.Create(node)
+				Expect(err).To(MatchError(ErrorKeyExists))
+			})
+
+			It("returns ErrorKeyExists for a directory", func() {
+				err := adapter.Create(StoreNode{Key: "/dir/foo", Value: []byte("some value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.Create(StoreNode{Key: "/dir", Value: []byte("some value")})
+				Expect(err).To(MatchError(ErrorKeyExists))
+			})
+		})
+
+		Describe("Update", func() {
+			It("updates an existing node", func() {
+				err := adapter.Create(StoreNode{Key: "/foo", Value: []byte("some value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				updatedNode := StoreNode{Key: "/foo", Value: []byte("some new value")}
+				err = adapter.Update(updatedNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				retrievedNode, err := adapter.Get("/foo")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(retrievedNode).To(MatchStoreNode(updatedNode))
+			})
+
+			It("returns ErrorKeyNotFound for a missing node, and writes nothing", func() {
+				err := adapter.Update(StoreNode{Key: "/foo", Value: []byte("some value")})
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				_, err = adapter.Get("/foo")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("returns ErrorNodeIsDirectory for a directory", func() {
+				err := adapter.Create(StoreNode{Key: "/dir/foo", Value: []byte("some value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.Update(StoreNode{Key: "/dir", Value: []byte("some value")})
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
+			})
+		})
+
+		Describe("CompareAndSwap", func() {
+			var node StoreNode
+
+			BeforeEach(func() {
+				node = StoreNode{Key: "/foo", Value: []byte("some value")}
+				err := adapter.Create(node)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("swaps the node if its value matches", func() {
+				newNode := StoreNode{Key: "/foo", Value: []byte("some new value")}
+				err := adapter.CompareAndSwap(node, newNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				retrievedNode, err := adapter.Get("/foo")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(retrievedNode).To(MatchStoreNode(newNode))
+			})
+
+			It("returns ErrorKeyComparisonFailed if the value does not match, and swaps nothing", func() {
+				err := adapter.CompareAndSwap(
+					StoreNode{Key: "/foo", Value: []byte("some other value")},
+					StoreNode{Key: "/foo", Value: []byte("some new value")},
+				)
+				Expect(err).To(MatchError(ErrorKeyComparisonFailed))
+
+				retrievedNode, err := adapter.Get("/foo")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(retrievedNode).To(MatchStoreNode(node))
+			})
+
+			It("returns ErrorKeyNotFound for a missing node", func() {
+				err := adapter.CompareAndSwap(
+					StoreNode{Key: "/bar", Value: []byte("some value")},
+					StoreNode{Key: "/bar", Value: []byte("some new value")},
+				)
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("returns ErrorNodeIsDirectory for a directory", func() {
+				err := adapter.Create(StoreNode{Key: "/dir/foo", Value: []byte("some value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.CompareAndSwap(
+					StoreNode{Key: "/dir", Value: []byte("some value")},
+					StoreNode{Key: "/dir", Value: []byte("some new value")},
+				)
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
+			})
+		})
+
+		Describe("CompareAndSwapByIndex", func() {
+			var node StoreNode
+
+			BeforeEach(func() {
+				err := adapter.Create(StoreNode{Key: "/foo", Value: []byte("some value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				node, err = adapter.Get("/foo")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("swaps the node if its index matches", func() {
+				newNode := StoreNode{Key: "/foo", Value: []byte("some new value")}
+				err := adapter.CompareAndSwapByIndex(node.Index, newNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				retrievedNode, err := adapter.Get("/foo")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(retrievedNode).To(MatchStoreNode(newNode))
+				Expect(retrievedNode.Index).To(BeNumerically(">", node.Index))
+			})
+
+			It("returns ErrorKeyComparisonFailed if the index does not match", func() {
+				err := adapter.CompareAndSwapByIndex(node.Index+100, StoreNode{Key: "/foo", Value: []byte("some new value")})
+				Expect(err).To(MatchError(ErrorKeyComparisonFailed))
+
+				retrievedNode, err := adapter.Get("/foo")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(retrievedNode).To(Equal(node))
+			})
+
+			It("returns ErrorKeyNotFound for a missing node", func() {
+				err := adapter.CompareAndSwapByIndex(node.Index, StoreNode{Key: "/bar", Value: []byte("some new value")})
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+		})
+
+		Describe("CompareAndDelete", func() {
+			var (
+				nodeFoo StoreNode
+				nodeBar StoreNode
+			)
+
+			BeforeEach(func() {
+				nodeFoo = StoreNode{Key: "/foo", Value: []byte("some foo value")}
+				nodeBar = StoreNode{Key: "/bar", Value: []byte("some bar value")}
+
+				err := adapter.SetMulti([]StoreNode{nodeFoo, nodeBar})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("deletes the nodes whose values match", func() {
+				err := adapter.CompareAndDelete(nodeFoo, nodeBar)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.Get("/foo")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				_, err = adapter.Get("/bar")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("reports ErrorKeyComparisonFailed for a node whose value does not match, and deletes the others", func() {
+				nodeFoo.Value = []byte("some mismatched foo value")
+
+				err := adapter.CompareAndDelete(nodeFoo, nodeBar)
+				Expect(err).To(MatchError(ErrorKeyComparisonFailed))
+
+				multiErr, ok := err.(*MultiError)
+				Expect(ok).To(BeTrue())
+				Expect(multiErr.Failed()).To(HaveLen(1))
+				Expect(multiErr.Failed()[0].Key).To(Equal("/foo"))
+				Expect(multiErr.Succeeded()).To(HaveLen(1))
+				Expect(multiErr.Succeeded()[0].Key).To(Equal("/bar"))
+				Expect(multiErr.Succeeded()[0].Index).NotTo(BeZero())
+
+				_, err = adapter.Get("/foo")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.Get("/bar")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("returns ErrorKeyNotFound for a missing node", func() {
+				err := adapter.CompareAndDelete(StoreNode{Key: "/baz", Value: []byte("some value")})
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("returns ErrorNodeIsDirectory for a directory", func() {
+				err := adapter.Create(StoreNode{Key: "/dir/foo", Value: []byte("some value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.CompareAndDelete(StoreNode{Key: "/dir", Value: []byte("some value")})
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
+			})
+		})
+
+		Describe("CompareAndDeleteByIndex", func() {
+			var nodeFoo StoreNode
+
+			BeforeEach(func() {
+				err := adapter.Create(StoreNode{Key: "/foo", Value: []byte("some foo value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				nodeFoo, err = adapter.Get("/foo")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("deletes the node if its index matches", func() {
+				err := adapter.CompareAndDeleteByIndex(nodeFoo)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = adapter.Get("/foo")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("returns ErrorKeyComparisonFailed if the node has been written since", func() {
+				err := adapter.CompareAndSwap(nodeFoo, nodeFoo)
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.CompareAndDeleteByIndex(nodeFoo)
+				Expect(err).To(MatchError(ErrorKeyComparisonFailed))
+
+				_, err = adapter.Get("/foo")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns ErrorKeyNotFound for a missing node", func() {
+				err := adapter.CompareAndDeleteByIndex(StoreNode{Key: "/bar", Index: nodeFoo.Index})
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("returns ErrorNodeIsDirectory for a directory", func() {
+				err := adapter.Create(StoreNode{Key: "/dir/foo", Value: []byte("some value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				dir, err := adapter.ListRecursively("/dir")
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.CompareAndDeleteByIndex(dir)
+				Expect(err).To(MatchError(ErrorNodeIsDirectory))
+			})
+		})
+
+		Describe("Txn", func() {
+			BeforeEach(func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode, lunchNode})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("applies every operation when every comparison holds", func() {
+				breakfast, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.Txn(
+					[]TxnCompare{
+						IndexEquals("/menu/breakfast", breakfast.Index),
+						ValueEquals("/menu/lunch", []byte("burgers")),
+						KeyExists("/menu/lunch"),
+						KeyMissing("/menu/dinner"),
+					},
+					[]TxnOp{
+						Put(StoreNode{Key: "/menu/breakfast", Value: []byte("crepes")}),
+						Put(StoreNode{Key: "/menu/dinner", Value: []byte("steak")}),
+						DeleteKey("/menu/lunch"),
+					},
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(value.Value)).To(Equal("crepes"))
+
+				value, err = adapter.Get("/menu/dinner")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(value.Value)).To(Equal("steak"))
+
+				_, err = adapter.Get("/menu/lunch")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("returns the error of a comparison that fails, and applies nothing", func() {
+				err := adapter.Txn(
+					[]TxnCompare{ValueEquals("/menu/breakfast", []byte("crepes"))},
+					[]TxnOp{DeleteKey("/menu/lunch")},
+				)
+				Expect(err).To(MatchError(ErrorKeyComparisonFailed))
+
+				err = adapter.Txn(
+					[]TxnCompare{KeyMissing("/menu/breakfast")},
+					[]TxnOp{DeleteKey("/menu/lunch")},
+				)
+				Expect(err).To(MatchError(ErrorKeyExists))
+
+				value, err := adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(lunchNode))
+			})
+
+			It("returns the error of an operation that fails, and applies none of them", func() {
+				err := adapter.Txn(nil, []TxnOp{
+					Put(StoreNode{Key: "/menu/breakfast", Value: []byte("crepes")}),
+					Put(StoreNode{Key: "/menu/dinner", Value: []byte("steak")}),
+					DeleteKey("/menu/lunch"),
+					DeleteKey("/menu/elevenses"),
+				})
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(breakfastNode))
+
+				value, err = adapter.Get("/menu/lunch")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(MatchStoreNode(lunchNode))
+
+				_, err = adapter.Get("/menu/dinner")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("applies each operation after the ones before it", func() {
+				err := adapter.Txn(nil, []TxnOp{
+					DeleteKey("/menu/breakfast"),
+					Put(StoreNode{Key: "/menu/breakfast/eggs", Value: []byte("scrambled")}),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu/breakfast/eggs")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(value.Value)).To(Equal("scrambled"))
+			})
+		})
+
+		Describe("TTLs", func() {
+			It("reports the time a node has left, and removes it once that runs out", func() {
+				breakfastNode.TTL = 1
+				err := adapter.SetMulti([]StoreNode{breakfastNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value.TTL).NotTo(BeZero())
+
+				Eventually(func() error {
+					_, err := adapter.Get("/menu/breakfast")
+					return err
+				}, 4, 0.05).Should(MatchError(ErrorKeyNotFound))
+			})
+
+			It("reports no TTL for a node written without one", func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode})
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value.TTL).To(BeZero())
+			})
+		})
+
+		Describe("UpdateDirTTL", func() {
+			It("removes the directory, with everything under it, once the TTL runs out", func() {
+				err := adapter.SetMulti([]StoreNode{breakfastNode, {Key: "/menu/dinner/first_course", Value: []byte("Salad")}})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.UpdateDirTTL("/menu", 1)
+				Expect(err).NotTo(HaveOccurred())
+
+				value, err := adapter.Get("/menu/breakfast")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(value.Value)).To(Equal("waffles"))
+
+				Eventually(func() error {
+					_, err := adapter.ListRecursively("/menu")
+					return err
+				}, 4, 0.05).Should(MatchError(ErrorKeyNotFound))
+
+				_, err = adapter.Get("/menu/dinner/first_course")
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("returns ErrorKeyNotFound for a missing directory", func() {
+				err := adapter.UpdateDirTTL("/non-existent-key", 1)
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+			})
+
+			It("returns ErrorNodeIsNotDirectory for a leaf", func() {
+				err := adapter.Create(breakfastNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				err = adapter.UpdateDirTTL("/menu/breakfast", 1)
+				Expect(err).To(MatchError(ErrorNodeIsNotDirectory))
+			})
+		})
+
+		Describe("Watch", func() {
+			var (
+				events <-chan WatchEvent
+				stop   chan<- bool
+				errs   <-chan error
+			)
+
+			BeforeEach(func() {
+				err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte("some value")}})
+				Expect(err).NotTo(HaveOccurred())
+
+				events, stop, errs = adapter.Watch("/foo")
+			})
+
+			receive := func() WatchEvent {
+				var event WatchEvent
+				Eventually(events, 5).Should(Receive(&event))
+				return event
+			}
+
+			It("sends a CreateEvent with the node, and no previous node", func() {
+				err := adapter.Create(StoreNode{Key: "/foo/b", Value: []byte("new value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				event := receive()
+				Expect(event.Type).To(Equal(CreateEvent))
+				Expect(event.Node.Key).To(Equal("/foo/b"))
+				Expect(string(event.Node.Value)).To(Equal("new value"))
+				Expect(event.PrevNode).To(BeNil())
+				Expect(event.Index).To(Equal(event.Node.Index))
+			})
+
+			It("sends an UpdateEvent with the node and the previous node", func() {
+				err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte("new value")}})
+				Expect(err).NotTo(HaveOccurred())
+
+				event := receive()
+				Expect(event.Type).To(Equal(UpdateEvent))
+				Expect(string(event.Node.Value)).To(Equal("new value"))
+				Expect(event.PrevNode.Key).To(Equal("/foo/a"))
+				Expect(string(event.PrevNode.Value)).To(Equal("some value"))
+			})
+
+			It("sends an UpdateEvent for a compare-and-swap", func() {
+				err := adapter.CompareAndSwap(StoreNode{Key: "/foo/a", Value: []byte("some value")}, StoreNode{Key: "/foo/a", Value: []byte("new value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				event := receive()
+				Expect(event.Type).To(Equal(UpdateEvent))
+				Expect(string(event.Node.Value)).To(Equal("new value"))
+			})
+
+			It("sends a DeleteEvent with the previous node, and no node", func() {
+				err := adapter.Delete("/foo/a")
+				Expect(err).NotTo(HaveOccurred())
+
+				event := receive()
+				Expect(event.Type).To(Equal(DeleteEvent))
+				Expect(event.Node).To(BeNil())
+				Expect(event.PrevNode.Key).To(Equal("/foo/a"))
+				Expect(string(event.PrevNode.Value)).To(Equal("some value"))
+			})
+
+			It("sends a DeleteEvent for a compare-and-delete", func() {
+				err := adapter.CompareAndDelete(StoreNode{Key: "/foo/a", Value: []byte("some value")})
+				Expect(err).NotTo(HaveOccurred())
+
+				event := receive()
+				Expect(event.Type).To(Equal(DeleteEvent))
+				Expect(event.PrevNode.Key).To(Equal("/foo/a"))
+			})
+
+			It("sends an ExpireEvent with the previous node, and no node, once a TTL runs out", func() {
+				err := adapter.SetMulti([]StoreNode{{Key: "/foo/b", Value: []byte("some value"), TTL: 1}})
+				Expect(err).NotTo(HaveOccurred())
+
+				event := receive()
+				Expect(event.Node.Key).To(Equal("/foo/b"))
+
+				event = receive()
+				Expect(event.Type).To(Equal(ExpireEvent))
+				Expect(event.Node).To(BeNil())
+				Expect(event.PrevNode.Key).To(Equal("/foo/b"))
+			})
+
+			It("sends events in the order they were written, and nothing for keys outside the watched key", func() {
+				err := adapter.SetMulti([]StoreNode{{Key: "/foobar", Value: []byte("unrelated")}})
+				Expect(err).NotTo(HaveOccurred())
+
+				for i := 0; i < 5; i++ {
+					err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte(fmt.Sprintf("%d", i))}})
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				lastIndex := uint64(0)
+				for i := 0; i < 5; i++ {
+					event := receive()
+					Expect(event.Node.Key).To(Equal("/foo/a"))
+					Expect(string(event.Node.Value)).To(Equal(fmt.Sprintf("%d", i)))
+					Expect(event.Index).To(BeNumerically(">", lastIndex))
+					lastIndex = event.Index
+				}
+			})
+
+			It("closes the event and error channels when told to stop", func() {
+				stop <- true
+
+				Eventually(events, 5).Should(BeClosed())
+				Eventually(errs, 5).Should(BeClosed())
+			})
+
+			It("closes the event and error channels on disconnect", func() {
+				adapter.Disconnect()
+
+				Eventually(events, 5).Should(BeClosed())
+				Eventually(errs, 5).Should(BeClosed())
+			})
+		})
+
+		Describe("WatchFrom", func() {
+			It("sends every event after the index, in order, and then the events that follow", func() {
+				var firstIndex uint64
+				for _, value := range []string{"1", "2", "3"} {
+					err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte(value)}})
+					Expect(err).NotTo(HaveOccurred())
+
+					if value == "1" {
+						node, err := adapter.Get("/foo/a")
+						Expect(err).NotTo(HaveOccurred())
+						firstIndex = node.Index
+					}
+				}
+
+				events, stop, _ := adapter.WatchFrom("/foo", firstIndex)
+				defer func() { stop <- true }()
+
+				var event WatchEvent
+				Eventually(events, 5).Should(Receive(&event))
+				Expect(event.Type).To(Equal(UpdateEvent))
+				Expect(string(event.Node.Value)).To(Equal("2"))
+				Expect(string(event.PrevNode.Value)).To(Equal("1"))
+				Expect(event.Index).To(BeNumerically(">", firstIndex))
+
+				Eventually(events, 5).Should(Receive(&event))
+				Expect(string(event.Node.Value)).To(Equal("3"))
+
+				err := adapter.SetMulti([]StoreNode{{Key: "/foo/a", Value: []byte("4")}})
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(events, 5).Should(Receive(&event))
+				Expect(string(event.Node.Value)).To(Equal("4"))
+			})
+		})
+
+		Describe("MaintainNode", func() {
+			var storeNode StoreNode
+
+			release := func(releaseNode chan chan bool) {
+				released := make(chan bool)
+				releaseNode <- released
+				Eventually(released, 5).Should(BeClosed())
+			}
+
+			maintain := func(storeNode StoreNode) chan chan bool {
+				status, releaseNode, err := adapter.MaintainNode(storeNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				reporter := test_helpers.NewStatusReporter(status)
+				Eventually(reporter.Locked, 5).Should(BeTrue())
+
+				return releaseNode
+			}
+
+			BeforeEach(func() {
+				storeNode = StoreNode{Key: fmt.Sprintf("/maintained-%d", counter), TTL: 2}
+				counter++
+			})
+
+			It("returns ErrorInvalidTTL for a TTL of 0", func() {
+				storeNode.TTL = 0
+
+				status, releaseNode, err := adapter.MaintainNode(storeNode)
+				Expect(err).To(MatchError(ErrorInvalidTTL))
+				Expect(status).To(BeNil())
+				Expect(releaseNode).To(BeNil())
+			})
+
+			It("writes the node with the given value, and keeps it past its TTL", func() {
+				storeNode.Value = []byte("some value")
+
+				releaseNode := maintain(storeNode)
+				defer release(releaseNode)
+
+				value, err := adapter.Get(storeNode.Key)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(value.Value)).To(Equal("some value"))
+				Expect(value.TTL).NotTo(BeZero())
+
+				time.Sleep(3 * time.Second)
+
+				_, err = adapter.Get(storeNode.Key)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("writes a unique value if none is given", func() {
+				releaseNode := maintain(storeNode)
+				defer release(releaseNode)
+
+				value, err := adapter.Get(storeNode.Key)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value.Value).NotTo(BeEmpty())
+			})
+
+			It("reports ownership about every TTL", func() {
+				status, releaseNode, err := adapter.MaintainNode(storeNode)
+				Expect(err).NotTo(HaveOccurred())
+				defer release(releaseNode)
+
+				Eventually(status, 2).Should(Receive(BeTrue()))
+
+				start := time.Now()
+				Eventually(status, 4).Should(Receive(BeTrue()))
+				Expect(time.Since(start)).To(BeNumerically("~", 2*time.Second, 500*time.Millisecond))
+			})
+
+			It("keeps others from acquiring the node until it is released", func() {
+				releaseNode := maintain(storeNode)
+
+				otherStoreNode := storeNode
+				otherStoreNode.Value = []byte("other")
+
+				otherStatus, otherReleaseNode, err := adapter.MaintainNode(otherStoreNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				reporter := test_helpers.NewStatusReporter(otherStatus)
+				Consistently(reporter.Reporting, 2).Should(BeFalse())
+
+				release(releaseNode)
+				Eventually(reporter.Locked, 5).Should(BeTrue())
+
+				release(otherReleaseNode)
+			})
+
+			It("reports the node lost once it disappears, and then acquires it again", func() {
+				status, releaseNode, err := adapter.MaintainNode(storeNode)
+				Expect(err).NotTo(HaveOccurred())
+				defer release(releaseNode)
+
+				Eventually(status, 2).Should(Receive(BeTrue()))
+
+				err = adapter.Delete(storeNode.Key)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(status, 3).Should(Receive(BeFalse()))
+				Eventually(status, 3).Should(Receive(BeTrue()))
+			})
+
+			It("deletes the node, and closes the status channel, once released", func() {
+				status, releaseNode, err := adapter.MaintainNode(storeNode)
+				Expect(err).NotTo(HaveOccurred())
+
+				reporter := test_helpers.NewStatusReporter(status)
+				Eventually(reporter.Locked, 5).Should(BeTrue())
+
+				release(releaseNode)
+
+				_, err = adapter.Get(storeNode.Key)
+				Expect(err).To(MatchError(ErrorKeyNotFound))
+
+				Eventually(reporter.Reporting, 5).Should(BeFalse())
+			})
+
+			Context("with a fencing token", func() {
+				It("reports the index the node was written at", func() {
+					status, releaseNode, err := adapter.MaintainNodeWithToken(storeNode)
+					Expect(err).NotTo(HaveOccurred())
+					defer release(releaseNode)
+
+					var acquired NodeStatus
+					Eventually(status, 2).Should(Receive(&acquired))
+					Expect(acquired.Owned).To(BeTrue())
+
+					node, err := adapter.Get(storeNode.Key)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(acquired.Token).To(Equal(node.Index))
+
+					var refreshed NodeStatus
+					Eventually(status, 4).Should(Receive(&refreshed))
+					Expect(refreshed).To(Equal(acquired))
+				})
+
+				It("reports a larger token each time the node is acquired", func() {
+					status, releaseNode, err := adapter.MaintainNodeWithToken(storeNode)
+					Expect(err).NotTo(HaveOccurred())
+
+					var first NodeStatus
+					Eventually(status, 2).Should(Receive(&first))
+					release(releaseNode)
+
+					status, releaseNode, err = adapter.MaintainNodeWithToken(storeNode)
+					Expect(err).NotTo(HaveOccurred())
+					defer release(releaseNode)
+
+					var second NodeStatus
+					Eventually(status, 2).Should(Receive(&second))
+					Expect(second.Owned).To(BeTrue())
+					Expect(second.Token).To(BeNumerically(">", first.Token))
+				})
+			})
+
+			Context("with options", func() {
+				It("refreshes the node on the clock, reporting every StatusEvery refreshes", func() {
+					fakeClock := fakeclock.NewFakeClock(time.Now())
+
+					status, releaseNode, err := adapter.MaintainNodeWithOptions(storeNode, MaintainOptions{
+						RefreshInterval: 500 * time.Millisecond,
+						StatusEvery:     2,
+						Clock:           fakeClock,
+					})
+					Expect(err).NotTo(HaveOccurred())
+					defer release(releaseNode)
+
+					var acquired NodeStatus
+					Eventually(status, 2).Should(Receive(&acquired))
+					Expect(acquired.Owned).To(BeTrue())
+
+					fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+					Consistently(status).ShouldNot(Receive())
+
+					fakeClock.WaitForWatcherAndIncrement(500 * time.Millisecond)
+					Eventually(status, 5).Should(Receive(Equal(acquired)))
+				})
+
+				It("returns ErrorInvalidTTL if the refresh interval is not shorter than the TTL", func() {
+					_, _, err := adapter.MaintainNodeWithOptions(storeNode, MaintainOptions{RefreshInterval: 2 * time.Second})
+					Expect(err).To(MatchError(ErrorInvalidTTL))
+				})
+			})
+		})
+
+		Describe("with a context", func() {
+			var contextAdapter ContextStoreAdapter
+
+			BeforeEach(func() {
+				contextAdapter = NewContextStoreAdapter(adapter)
+			})
+
+			Context("when the context is already done", func() {
+				var ctx context.Context
+
+				BeforeEach(func() {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithCancel(context.Background())
+					cancel()
+				})
+
+				It("returns the context's error without writing", func() {
+					err := contextAdapter.CreateContext(ctx, breakfastNode)
+					Expect(err).To(MatchError(context.Canceled))
+
+					_, err = adapter.Get("/menu/breakfast")
+					Expect(err).To(MatchError(ErrorKeyNotFound))
+				})
+			})
+
+			It("stops watching once the context is done", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				events, _, errs := contextAdapter.WatchContext(ctx, "/foo")
+				cancel()
+
+				Eventually(events, 5).Should(BeClosed())
+				Eventually(errs, 5).Should(BeClosed())
+			})
+		})
+	})
+}
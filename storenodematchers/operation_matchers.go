@@ -0,0 +1,112 @@
+package storenodematchers
+
+import (
+	"fmt"
+
+	"github.com/cloudfoundry/storeadapter/fakestoreadapter"
+	"github.com/onsi/gomega/format"
+	"github.com/onsi/gomega/types"
+)
+
+// HaveRecordedWrite succeeds if a FakeStoreAdapter (or a slice of its
+// Operations) has recorded a write that succeeded for key.
+func HaveRecordedWrite(key string) *recordedWriteMatcher {
+	return &recordedWriteMatcher{key: key}
+}
+
+type recordedWriteMatcher struct {
+	key string
+}
+
+func (matcher *recordedWriteMatcher) Match(actual interface{}) (success bool, err error) {
+	operations, err := recordedOperations(actual)
+	if err != nil {
+		return false, err
+	}
+
+	for _, operation := range operations {
+		if operation.Wrote(matcher.key) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (matcher *recordedWriteMatcher) FailureMessage(actual interface{}) (message string) {
+	operations, _ := recordedOperations(actual)
+	return format.Message(operations, "to have recorded a write to", matcher.key)
+}
+
+func (matcher *recordedWriteMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	operations, _ := recordedOperations(actual)
+	return format.Message(operations, "not to have recorded a write to", matcher.key)
+}
+
+// HaveRecordedOperations succeeds if a FakeStoreAdapter (or a slice of its
+// Operations) has recorded exactly the given operations, in order. Each
+// expected operation is either a method name, such as "SetMulti", or a matcher
+// for the fakestoreadapter.Operation.
+func HaveRecordedOperations(expected ...interface{}) *recordedOperationsMatcher {
+	return &recordedOperationsMatcher{expected: expected}
+}
+
+type recordedOperationsMatcher struct {
+	expected []interface{}
+}
+
+func (matcher *recordedOperationsMatcher) Match(actual interface{}) (success bool, err error) {
+	operations, err := recordedOperations(actual)
+	if err != nil {
+		return false, err
+	}
+
+	for _, expected := range matcher.expected {
+		switch expected.(type) {
+		case string, types.GomegaMatcher:
+		default:
+			return false, fmt.Errorf("HaveRecordedOperations expects method names or matchers.  Got:\n%s", format.Object(expected, 1))
+		}
+	}
+
+	if len(operations) != len(matcher.expected) {
+		return false, nil
+	}
+
+	for i, expected := range matcher.expected {
+		switch expected := expected.(type) {
+		case string:
+			if operations[i].Method != expected {
+				return false, nil
+			}
+		case types.GomegaMatcher:
+			success, err := expected.Match(operations[i])
+			if err != nil || !success {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+func (matcher *recordedOperationsMatcher) FailureMessage(actual interface{}) (message string) {
+	operations, _ := recordedOperations(actual)
+	return format.Message(operations, "to have recorded the operations", matcher.expected)
+}
+
+func (matcher *recordedOperationsMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	operations, _ := recordedOperations(actual)
+	return format.Message(operations, "not to have recorded the operations", matcher.expected)
+}
+
+func recordedOperations(actual interface{}) ([]fakestoreadapter.Operation, error) {
+	switch actual := actual.(type) {
+	case *fakestoreadapter.FakeStoreAdapter:
+		return actual.Operations(), nil
+	case []fakestoreadapter.Operation:
+		return actual, nil
+	default:
+		return nil, fmt.Errorf("Expected a FakeStoreAdapter or its Operations.  Got:\n%s", format.Object(actual, 1))
+	}
+}
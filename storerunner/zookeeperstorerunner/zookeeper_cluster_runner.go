@@ -0,0 +1,338 @@
+package zookeeperstorerunner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/workpool"
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/zkstoreadapter"
+	"github.com/go-zookeeper/zk"
+	. "github.com/onsi/gomega"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/ginkgomon"
+)
+
+// ZookeeperClusterRunner runs a local ZooKeeper ensemble with zkServer.sh,
+// which must be on the PATH. The servers run with TTL and container znodes
+// enabled, and remove expired ones every 100ms.
+type ZookeeperClusterRunner struct {
+	startingPort int
+	numNodes     int
+	zkProcesses  []ifrit.Process
+	running      bool
+	adapter      *zkstoreadapter.ZKStoreAdapter
+
+	mutex *sync.RWMutex
+}
+
+func NewZookeeperClusterRunner(startingPort int, numNodes int) *ZookeeperClusterRunner {
+	return &ZookeeperClusterRunner{
+		startingPort: startingPort,
+		numNodes:     numNodes,
+
+		mutex: &sync.RWMutex{},
+	}
+}
+
+func (zookeeper *ZookeeperClusterRunner) Start() {
+	zookeeper.start(true)
+}
+
+func (zookeeper *ZookeeperClusterRunner) Stop() {
+	zookeeper.stop(true)
+}
+
+func (zookeeper *ZookeeperClusterRunner) KillWithFire() {
+	zookeeper.kill()
+}
+
+func (zookeeper *ZookeeperClusterRunner) GoAway() {
+	zookeeper.stop(false)
+}
+
+func (zookeeper *ZookeeperClusterRunner) ComeBack() {
+	zookeeper.start(false)
+}
+
+func (zookeeper *ZookeeperClusterRunner) NodeURLS() []string {
+	urls := make([]string, zookeeper.numNodes)
+	for i := 0; i < zookeeper.numNodes; i++ {
+		urls[i] = zookeeper.clientAddress(i)
+	}
+	return urls
+}
+
+func (zookeeper *ZookeeperClusterRunner) DiskUsage() (bytes int64, err error) {
+	err = filepath.Walk(zookeeper.tmpPathTo("data", 0), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		bytes += info.Size()
+		return nil
+	})
+	return bytes, err
+}
+
+func (zookeeper *ZookeeperClusterRunner) Reset() {
+	zookeeper.ResetAllBut()
+}
+
+func (zookeeper *ZookeeperClusterRunner) ResetAllBut(roots ...string) {
+	zookeeper.mutex.RLock()
+	running := zookeeper.running
+	zookeeper.mutex.RUnlock()
+
+	rootMap := map[string]*struct{}{}
+	for _, root := range roots {
+		rootMap[root] = &struct{}{}
+	}
+
+	if running {
+		root, err := zookeeper.adapter.ListRecursively("/")
+		if err == nil {
+			for _, doomed := range root.ChildNodes {
+				if rootMap[doomed.Key] == nil {
+					zookeeper.adapter.Delete(doomed.Key)
+				}
+			}
+		}
+	}
+}
+
+// FastForwardTime takes seconds off the TTL of every leaf that has one, and
+// deletes those that would have expired.
+func (zookeeper *ZookeeperClusterRunner) FastForwardTime(seconds int) {
+	zookeeper.mutex.RLock()
+	running := zookeeper.running
+	zookeeper.mutex.RUnlock()
+
+	if running {
+		root, err := zookeeper.adapter.ListRecursively("/")
+		Expect(err).NotTo(HaveOccurred())
+		zookeeper.fastForwardTime(root, seconds)
+	}
+}
+
+func (zookeeper *ZookeeperClusterRunner) newAdapter() *zkstoreadapter.ZKStoreAdapter {
+	pool, err := workpool.NewWorkPool(10)
+	Expect(err).NotTo(HaveOccurred())
+
+	adapter, err := zkstoreadapter.New(&zkstoreadapter.ZKOptions{
+		Servers:        zookeeper.NodeURLS(),
+		SessionTimeout: 2 * time.Second,
+	}, pool)
+	Expect(err).NotTo(HaveOccurred())
+	return adapter
+}
+
+func (zookeeper *ZookeeperClusterRunner) Adapter() storeadapter.StoreAdapter {
+	adapter := zookeeper.newAdapter()
+	adapter.Connect()
+	return adapter
+}
+
+func (zookeeper *ZookeeperClusterRunner) RetryableAdapter(workPoolSize int) storeadapter.StoreAdapter {
+	adapter := storeadapter.NewRetryable(
+		zookeeper.newAdapter(),
+		clock.NewClock(),
+		storeadapter.ExponentialRetryPolicy{},
+	)
+
+	adapter.Connect()
+
+	return adapter
+}
+
+func (zookeeper *ZookeeperClusterRunner) start(nuke bool) {
+	zookeeper.mutex.RLock()
+	running := zookeeper.running
+	zookeeper.mutex.RUnlock()
+
+	if running {
+		return
+	}
+
+	zookeeper.mutex.Lock()
+	defer zookeeper.mutex.Unlock()
+
+	zookeeper.zkProcesses = make([]ifrit.Process, zookeeper.numNodes)
+
+	for i := 0; i < zookeeper.numNodes; i++ {
+		if nuke {
+			zookeeper.nukeArtifacts(i)
+		}
+
+		if zookeeper.detectRunningZookeeper(i) {
+			log.Fatalf("Detected a ZooKeeper already running on %s", zookeeper.clientAddress(i))
+		}
+
+		zookeeper.writeConfig(i)
+
+		command := exec.Command("zkServer.sh", "start-foreground", zookeeper.tmpPathTo("zoo.cfg", i))
+		command.Env = append(os.Environ(),
+			"ZOO_LOG_DIR="+zookeeper.tmpPathTo("log", i),
+			"SERVER_JVMFLAGS=-Dznode.container.checkIntervalMs=100",
+		)
+
+		process := ginkgomon.Invoke(ginkgomon.New(ginkgomon.Config{
+			Name:              "zookeeper_cluster",
+			AnsiColorCode:     "35m",
+			StartCheck:        "binding to port",
+			StartCheckTimeout: 20 * time.Second,
+			Command:           command,
+		}))
+
+		zookeeper.zkProcesses[i] = process
+	}
+
+	for i := 0; i < zookeeper.numNodes; i++ {
+		Eventually(func() bool {
+			return zookeeper.detectRunningZookeeper(i)
+		}, 20, 0.1).Should(BeTrue(), "Expected ZooKeeper to be up and running")
+	}
+
+	zookeeper.adapter = zookeeper.newAdapter()
+	Expect(zookeeper.adapter.Connect()).To(Succeed())
+
+	zookeeper.running = true
+}
+
+func (zookeeper *ZookeeperClusterRunner) stop(nuke bool) {
+	zookeeper.mutex.Lock()
+	defer zookeeper.mutex.Unlock()
+
+	if zookeeper.running {
+		zookeeper.adapter.Disconnect()
+		for i := 0; i < zookeeper.numNodes; i++ {
+			ginkgomon.Interrupt(zookeeper.zkProcesses[i], 5*time.Second)
+			if nuke {
+				zookeeper.nukeArtifacts(i)
+			}
+		}
+		zookeeper.markAsStopped()
+	}
+}
+
+func (zookeeper *ZookeeperClusterRunner) kill() {
+	zookeeper.mutex.Lock()
+	defer zookeeper.mutex.Unlock()
+
+	if zookeeper.running {
+		zookeeper.adapter.Disconnect()
+		for i := 0; i < zookeeper.numNodes; i++ {
+			ginkgomon.Kill(zookeeper.zkProcesses[i], 5*time.Second)
+			zookeeper.nukeArtifacts(i)
+		}
+		zookeeper.markAsStopped()
+	}
+}
+
+func (zookeeper *ZookeeperClusterRunner) markAsStopped() {
+	zookeeper.zkProcesses = nil
+	zookeeper.running = false
+	zookeeper.adapter = nil
+}
+
+// detectRunningZookeeper reports whether a session can be established with
+// the server.
+func (zookeeper *ZookeeperClusterRunner) detectRunningZookeeper(index int) bool {
+	conn, events, err := zk.Connect([]string{zookeeper.clientAddress(index)}, time.Second, zk.WithLogInfo(false), zk.WithLogger(quietLogger{}))
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.State == zk.StateHasSession {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+type quietLogger struct{}
+
+func (quietLogger) Printf(string, ...interface{}) {}
+
+func (zookeeper *ZookeeperClusterRunner) writeConfig(index int) {
+	dataDir := zookeeper.tmpPathTo("data", index)
+	Expect(os.MkdirAll(dataDir, 0700)).To(Succeed())
+	Expect(os.MkdirAll(zookeeper.tmpPathTo("log", index), 0700)).To(Succeed())
+
+	config := []string{
+		"tickTime=200",
+		"initLimit=50",
+		"syncLimit=25",
+		"dataDir=" + dataDir,
+		"clientPortAddress=127.0.0.1",
+		fmt.Sprintf("clientPort=%d", zookeeper.port(index)),
+		"admin.enableServer=false",
+		"extendedTypesEnabled=true",
+	}
+
+	if zookeeper.numNodes > 1 {
+		for i := 0; i < zookeeper.numNodes; i++ {
+			config = append(config, fmt.Sprintf("server.%d=127.0.0.1:%d:%d", i+1, zookeeper.port(i)+3000, zookeeper.port(i)+4000))
+		}
+
+		err := ioutil.WriteFile(filepath.Join(dataDir, "myid"), []byte(fmt.Sprintf("%d\n", index+1)), 0600)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	err := ioutil.WriteFile(zookeeper.tmpPathTo("zoo.cfg", index), []byte(strings.Join(config, "\n")+"\n"), 0600)
+	Expect(err).NotTo(HaveOccurred())
+}
+
+func (zookeeper *ZookeeperClusterRunner) fastForwardTime(node storeadapter.StoreNode, seconds int) {
+	if node.Dir == true {
+		for _, child := range node.ChildNodes {
+			zookeeper.fastForwardTime(child, seconds)
+		}
+	} else {
+		if node.TTL == 0 {
+			return
+		}
+		if node.TTL <= uint64(seconds) {
+			err := zookeeper.adapter.Delete(node.Key)
+			Expect(err).NotTo(HaveOccurred())
+		} else {
+			node.TTL -= uint64(seconds)
+			err := zookeeper.adapter.SetMulti([]storeadapter.StoreNode{node})
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+}
+
+func (zookeeper *ZookeeperClusterRunner) clientAddress(index int) string {
+	return fmt.Sprintf("127.0.0.1:%d", zookeeper.port(index))
+}
+
+func (zookeeper *ZookeeperClusterRunner) port(index int) int {
+	return zookeeper.startingPort + index
+}
+
+func (zookeeper *ZookeeperClusterRunner) tmpPath(index int) string {
+	return fmt.Sprintf("/tmp/ZOOKEEPER_%d", zookeeper.port(index))
+}
+
+func (zookeeper *ZookeeperClusterRunner) tmpPathTo(subdir string, index int) string {
+	return fmt.Sprintf("%s/%s", zookeeper.tmpPath(index), subdir)
+}
+
+func (zookeeper *ZookeeperClusterRunner) nukeArtifacts(index int) {
+	os.RemoveAll(zookeeper.tmpPath(index))
+}
@@ -0,0 +1,193 @@
+package storeadapter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+const throttleTokenSlack = 1e-6
+
+// throttleLimiter admits requests within a ThrottleLimit, making the rest
+// wait in order of priority, and of arrival within a priority.
+type throttleLimiter struct {
+	limit ThrottleLimit
+	clock clock.Clock
+
+	mutex        sync.Mutex
+	tokens       float64
+	refilledAt   time.Time
+	inFlight     int
+	waiters      []*throttleWaiter
+	timerPending bool
+}
+
+type throttleWaiter struct {
+	priority Priority
+	cost     float64
+	admitted chan struct{}
+}
+
+func newThrottleLimiter(limit ThrottleLimit, clk clock.Clock) *throttleLimiter {
+	if limit.Rate > 0 && limit.Burst <= 0 {
+		limit.Burst = int(math.Ceil(limit.Rate))
+	}
+
+	return &throttleLimiter{
+		limit:      limit,
+		clock:      clk,
+		tokens:     float64(limit.Burst),
+		refilledAt: clk.Now(),
+	}
+}
+
+// acquire waits until a request costing cost tokens is admitted, and returns
+// the function that ends it. It gives up with the context's error if the
+// context is done first.
+func (limiter *throttleLimiter) acquire(ctx context.Context, priority Priority, cost int) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	limiter.mutex.Lock()
+
+	waiter := &throttleWaiter{
+		priority: priority,
+		cost:     limiter.costOf(cost),
+		admitted: make(chan struct{}),
+	}
+	limiter.enqueue(waiter)
+	limiter.dispatch()
+
+	limiter.mutex.Unlock()
+
+	select {
+	case <-waiter.admitted:
+		return limiter.release, nil
+	case <-ctx.Done():
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	select {
+	case <-waiter.admitted:
+		// Admitted just as the context was done: hand the slot back, but not
+		// the tokens, which have been spent.
+		limiter.inFlight--
+	default:
+		limiter.remove(waiter)
+	}
+	limiter.dispatch()
+
+	return nil, ctx.Err()
+}
+
+func (limiter *throttleLimiter) release() {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.inFlight--
+	limiter.dispatch()
+}
+
+// costOf is the tokens a request takes: at least one.
+func (limiter *throttleLimiter) costOf(cost int) float64 {
+	if cost < 1 {
+		cost = 1
+	}
+
+	return float64(cost)
+}
+
+// enqueue keeps the waiters ordered by priority, then by arrival. It is
+// called with the lock held.
+func (limiter *throttleLimiter) enqueue(waiter *throttleWaiter) {
+	i := len(limiter.waiters)
+	for i > 0 && limiter.waiters[i-1].priority < waiter.priority {
+		i--
+	}
+
+	limiter.waiters = append(limiter.waiters, nil)
+	copy(limiter.waiters[i+1:], limiter.waiters[i:])
+	limiter.waiters[i] = waiter
+}
+
+func (limiter *throttleLimiter) remove(waiter *throttleWaiter) {
+	for i, w := range limiter.waiters {
+		if w == waiter {
+			limiter.waiters = append(limiter.waiters[:i], limiter.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatch admits waiters from the front of the line for as long as the
+// limit allows. When the first waiter needs more tokens than there are, a
+// timer dispatches again once they have been refilled. A waiter costing more
+// than the bucket holds is admitted once it is full, leaving it in debt, so
+// that the requests after it wait until the whole cost has been refilled. It
+// is called with the lock held.
+func (limiter *throttleLimiter) dispatch() {
+	limiter.refill()
+
+	for len(limiter.waiters) > 0 {
+		waiter := limiter.waiters[0]
+
+		if limiter.limit.Concurrency > 0 && limiter.inFlight >= limiter.limit.Concurrency {
+			return
+		}
+
+		if limiter.limit.Rate > 0 {
+			needed := math.Min(waiter.cost, float64(limiter.limit.Burst))
+
+			// Allowing for the rounding of refills timed to the nanosecond.
+			if limiter.tokens+throttleTokenSlack < needed {
+				limiter.refillLater(needed - limiter.tokens)
+				return
+			}
+			limiter.tokens -= waiter.cost
+		}
+
+		limiter.inFlight++
+		limiter.waiters = limiter.waiters[1:]
+		close(waiter.admitted)
+	}
+}
+
+func (limiter *throttleLimiter) refill() {
+	if limiter.limit.Rate <= 0 {
+		return
+	}
+
+	now := limiter.clock.Now()
+	limiter.tokens += now.Sub(limiter.refilledAt).Seconds() * limiter.limit.Rate
+	limiter.refilledAt = now
+
+	if limiter.tokens > float64(limiter.limit.Burst) {
+		limiter.tokens = float64(limiter.limit.Burst)
+	}
+}
+
+func (limiter *throttleLimiter) refillLater(missing float64) {
+	if limiter.timerPending {
+		return
+	}
+	limiter.timerPending = true
+
+	delay := time.Duration(math.Ceil(missing / limiter.limit.Rate * float64(time.Second)))
+	timer := limiter.clock.NewTimer(delay)
+
+	go func() {
+		<-timer.C()
+
+		limiter.mutex.Lock()
+		defer limiter.mutex.Unlock()
+
+		limiter.timerPending = false
+		limiter.dispatch()
+	}()
+}
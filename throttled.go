@@ -0,0 +1,469 @@
+package storeadapter
+
+import (
+	"context"
+	"strings"
+
+	"code.cloudfoundry.org/clock"
+)
+
+// Priority orders requests waiting for the same limit. Waiting requests of a
+// higher priority are admitted first.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose requests through a throttled adapter
+// have the given priority, whatever ThrottleOptions.Priorities says.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// ThrottleLimit limits one kind of request. The zero value does not limit
+// anything.
+type ThrottleLimit struct {
+	// How many requests are admitted per second, on average, and how many at
+	// once after a lull. A request that writes several nodes or keys counts
+	// once for each of them. Burst defaults to Rate, rounded up. A zero Rate
+	// does not limit the rate.
+	Rate  float64
+	Burst int
+
+	// How many requests may be in flight at once. A watch is in flight until
+	// it is stopped or ends. Zero does not limit concurrency.
+	Concurrency int
+}
+
+// ThrottleOptions configures a throttled adapter.
+type ThrottleOptions struct {
+	// Get and ListRecursively are reads, Watch and WatchFrom are watches, and
+	// every other request, but Connect and Disconnect, is a write. Each kind
+	// waits on its own limit, so that a bulk write does not hold up reads.
+	Reads   ThrottleLimit
+	Writes  ThrottleLimit
+	Watches ThrottleLimit
+
+	// Limits reads and writes together, once each is within its own limit,
+	// for a store whose capacity they share. Reads then wait for a bulk
+	// write in proportion to the nodes it writes.
+	Combined ThrottleLimit
+
+	// The priority of each request by method name, such as "SetMulti", with
+	// or without the Context suffix. Requests not listed are PriorityNormal.
+	Priorities map[string]Priority
+
+	// Refills the rate limits. Defaults to the real clock.
+	Clock clock.Clock
+}
+
+type throttled struct {
+	StoreAdapter
+	contextAdapter ContextStoreAdapter
+	priorities     map[string]Priority
+
+	reads    *throttleLimiter
+	writes   *throttleLimiter
+	watches  *throttleLimiter
+	combined *throttleLimiter
+}
+
+// NewThrottled wraps storeAdapter so that its requests are admitted within
+// the rate and concurrency limits set by options, waiting until they are.
+// Requests made with a context give up waiting with the context's error once
+// it is done. The returned adapter also implements ContextStoreAdapter; pass
+// it to NewContextStoreAdapter to use it.
+func NewThrottled(storeAdapter StoreAdapter, options ThrottleOptions) StoreAdapter {
+	clk := options.Clock
+	if clk == nil {
+		clk = clock.NewClock()
+	}
+
+	return &throttled{
+		StoreAdapter:   storeAdapter,
+		contextAdapter: NewContextStoreAdapter(storeAdapter),
+		priorities:     options.Priorities,
+
+		reads:    newThrottleLimiter(options.Reads, clk),
+		writes:   newThrottleLimiter(options.Writes, clk),
+		watches:  newThrottleLimiter(options.Watches, clk),
+		combined: newThrottleLimiter(options.Combined, clk),
+	}
+}
+
+func (adapter *throttled) Create(node StoreNode) error {
+	return adapter.write(context.Background(), "Create", 1, func() error {
+		return adapter.StoreAdapter.Create(node)
+	})
+}
+
+func (adapter *throttled) Update(node StoreNode) error {
+	return adapter.write(context.Background(), "Update", 1, func() error {
+		return adapter.StoreAdapter.Update(node)
+	})
+}
+
+func (adapter *throttled) CompareAndSwap(nodeA StoreNode, nodeB StoreNode) error {
+	return adapter.write(context.Background(), "CompareAndSwap", 1, func() error {
+		return adapter.StoreAdapter.CompareAndSwap(nodeA, nodeB)
+	})
+}
+
+func (adapter *throttled) CompareAndSwapByIndex(index uint64, node StoreNode) error {
+	return adapter.write(context.Background(), "CompareAndSwapByIndex", 1, func() error {
+		return adapter.StoreAdapter.CompareAndSwapByIndex(index, node)
+	})
+}
+
+func (adapter *throttled) SetMulti(nodes []StoreNode) error {
+	return adapter.write(context.Background(), "SetMulti", len(nodes), func() error {
+		return adapter.StoreAdapter.SetMulti(nodes)
+	})
+}
+
+func (adapter *throttled) Get(key string) (StoreNode, error) {
+	var node StoreNode
+	err := adapter.read(context.Background(), "Get", func() error {
+		var err error
+		node, err = adapter.StoreAdapter.Get(key)
+		return err
+	})
+
+	return node, err
+}
+
+func (adapter *throttled) Delete(keys ...string) error {
+	return adapter.write(context.Background(), "Delete", len(keys), func() error {
+		return adapter.StoreAdapter.Delete(keys...)
+	})
+}
+
+func (adapter *throttled) DeleteLeaves(keys ...string) error {
+	return adapter.write(context.Background(), "DeleteLeaves", len(keys), func() error {
+		return adapter.StoreAdapter.DeleteLeaves(keys...)
+	})
+}
+
+func (adapter *throttled) ListRecursively(key string) (StoreNode, error) {
+	var node StoreNode
+	err := adapter.read(context.Background(), "ListRecursively", func() error {
+		var err error
+		node, err = adapter.StoreAdapter.ListRecursively(key)
+		return err
+	})
+
+	return node, err
+}
+
+func (adapter *throttled) CompareAndDelete(nodes ...StoreNode) error {
+	return adapter.write(context.Background(), "CompareAndDelete", len(nodes), func() error {
+		return adapter.StoreAdapter.CompareAndDelete(nodes...)
+	})
+}
+
+func (adapter *throttled) CompareAndDeleteByIndex(nodes ...StoreNode) error {
+	return adapter.write(context.Background(), "CompareAndDeleteByIndex", len(nodes), func() error {
+		return adapter.StoreAdapter.CompareAndDeleteByIndex(nodes...)
+	})
+}
+
+func (adapter *throttled) Txn(comparisons []TxnCompare, operations []TxnOp) error {
+	return adapter.write(context.Background(), "Txn", len(operations), func() error {
+		return adapter.StoreAdapter.Txn(comparisons, operations)
+	})
+}
+
+func (adapter *throttled) UpdateDirTTL(dir string, ttl uint64) error {
+	return adapter.write(context.Background(), "UpdateDirTTL", 1, func() error {
+		return adapter.StoreAdapter.UpdateDirTTL(dir, ttl)
+	})
+}
+
+func (adapter *throttled) Watch(key string) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	return adapter.watch(context.Background(), "Watch", func() (<-chan WatchEvent, chan<- bool, <-chan error) {
+		return adapter.StoreAdapter.Watch(key)
+	})
+}
+
+func (adapter *throttled) WatchFrom(key string, afterIndex uint64) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	return adapter.watch(context.Background(), "WatchFrom", func() (<-chan WatchEvent, chan<- bool, <-chan error) {
+		return adapter.StoreAdapter.WatchFrom(key, afterIndex)
+	})
+}
+
+func (adapter *throttled) MaintainNode(storeNode StoreNode) (<-chan bool, chan chan bool, error) {
+	var lostNode <-chan bool
+	var releaseNode chan chan bool
+	err := adapter.write(context.Background(), "MaintainNode", 1, func() error {
+		var err error
+		lostNode, releaseNode, err = adapter.StoreAdapter.MaintainNode(storeNode)
+		return err
+	})
+
+	return lostNode, releaseNode, err
+}
+
+func (adapter *throttled) MaintainNodeWithToken(storeNode StoreNode) (<-chan NodeStatus, chan chan bool, error) {
+	var status <-chan NodeStatus
+	var releaseNode chan chan bool
+	err := adapter.write(context.Background(), "MaintainNodeWithToken", 1, func() error {
+		var err error
+		status, releaseNode, err = adapter.StoreAdapter.MaintainNodeWithToken(storeNode)
+		return err
+	})
+
+	return status, releaseNode, err
+}
+
+func (adapter *throttled) MaintainNodeWithOptions(storeNode StoreNode, options MaintainOptions) (<-chan NodeStatus, chan chan bool, error) {
+	var status <-chan NodeStatus
+	var releaseNode chan chan bool
+	err := adapter.write(context.Background(), "MaintainNodeWithOptions", 1, func() error {
+		var err error
+		status, releaseNode, err = adapter.StoreAdapter.MaintainNodeWithOptions(storeNode, options)
+		return err
+	})
+
+	return status, releaseNode, err
+}
+
+func (adapter *throttled) ConnectContext(ctx context.Context) error {
+	return adapter.contextAdapter.ConnectContext(ctx)
+}
+
+func (adapter *throttled) CreateContext(ctx context.Context, node StoreNode) error {
+	return adapter.write(ctx, "CreateContext", 1, func() error {
+		return adapter.contextAdapter.CreateContext(ctx, node)
+	})
+}
+
+func (adapter *throttled) UpdateContext(ctx context.Context, node StoreNode) error {
+	return adapter.write(ctx, "UpdateContext", 1, func() error {
+		return adapter.contextAdapter.UpdateContext(ctx, node)
+	})
+}
+
+func (adapter *throttled) CompareAndSwapContext(ctx context.Context, nodeA StoreNode, nodeB StoreNode) error {
+	return adapter.write(ctx, "CompareAndSwapContext", 1, func() error {
+		return adapter.contextAdapter.CompareAndSwapContext(ctx, nodeA, nodeB)
+	})
+}
+
+func (adapter *throttled) CompareAndSwapByIndexContext(ctx context.Context, index uint64, node StoreNode) error {
+	return adapter.write(ctx, "CompareAndSwapByIndexContext", 1, func() error {
+		return adapter.contextAdapter.CompareAndSwapByIndexContext(ctx, index, node)
+	})
+}
+
+func (adapter *throttled) SetMultiContext(ctx context.Context, nodes []StoreNode) error {
+	return adapter.write(ctx, "SetMultiContext", len(nodes), func() error {
+		return adapter.contextAdapter.SetMultiContext(ctx, nodes)
+	})
+}
+
+func (adapter *throttled) GetContext(ctx context.Context, key string) (StoreNode, error) {
+	var node StoreNode
+	err := adapter.read(ctx, "GetContext", func() error {
+		var err error
+		node, err = adapter.contextAdapter.GetContext(ctx, key)
+		return err
+	})
+
+	return node, err
+}
+
+func (adapter *throttled) DeleteContext(ctx context.Context, keys ...string) error {
+	return adapter.write(ctx, "DeleteContext", len(keys), func() error {
+		return adapter.contextAdapter.DeleteContext(ctx, keys...)
+	})
+}
+
+func (adapter *throttled) DeleteLeavesContext(ctx context.Context, keys ...string) error {
+	return adapter.write(ctx, "DeleteLeavesContext", len(keys), func() error {
+		return adapter.contextAdapter.DeleteLeavesContext(ctx, keys...)
+	})
+}
+
+func (adapter *throttled) ListRecursivelyContext(ctx context.Context, key string) (StoreNode, error) {
+	var node StoreNode
+	err := adapter.read(ctx, "ListRecursivelyContext", func() error {
+		var err error
+		node, err = adapter.contextAdapter.ListRecursivelyContext(ctx, key)
+		return err
+	})
+
+	return node, err
+}
+
+func (adapter *throttled) CompareAndDeleteContext(ctx context.Context, nodes ...StoreNode) error {
+	return adapter.write(ctx, "CompareAndDeleteContext", len(nodes), func() error {
+		return adapter.contextAdapter.CompareAndDeleteContext(ctx, nodes...)
+	})
+}
+
+func (adapter *throttled) CompareAndDeleteByIndexContext(ctx context.Context, nodes ...StoreNode) error {
+	return adapter.write(ctx, "CompareAndDeleteByIndexContext", len(nodes), func() error {
+		return adapter.contextAdapter.CompareAndDeleteByIndexContext(ctx, nodes...)
+	})
+}
+
+func (adapter *throttled) TxnContext(ctx context.Context, comparisons []TxnCompare, operations []TxnOp) error {
+	return adapter.write(ctx, "TxnContext", len(operations), func() error {
+		return adapter.contextAdapter.TxnContext(ctx, comparisons, operations)
+	})
+}
+
+func (adapter *throttled) UpdateDirTTLContext(ctx context.Context, dir string, ttl uint64) error {
+	return adapter.write(ctx, "UpdateDirTTLContext", 1, func() error {
+		return adapter.contextAdapter.UpdateDirTTLContext(ctx, dir, ttl)
+	})
+}
+
+func (adapter *throttled) WatchContext(ctx context.Context, key string) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	return adapter.watch(ctx, "WatchContext", func() (<-chan WatchEvent, chan<- bool, <-chan error) {
+		return adapter.contextAdapter.WatchContext(ctx, key)
+	})
+}
+
+func (adapter *throttled) WatchFromContext(ctx context.Context, key string, afterIndex uint64) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	return adapter.watch(ctx, "WatchFromContext", func() (<-chan WatchEvent, chan<- bool, <-chan error) {
+		return adapter.contextAdapter.WatchFromContext(ctx, key, afterIndex)
+	})
+}
+
+func (adapter *throttled) MaintainNodeContext(ctx context.Context, storeNode StoreNode) (<-chan bool, chan chan bool, error) {
+	var lostNode <-chan bool
+	var releaseNode chan chan bool
+	err := adapter.write(ctx, "MaintainNodeContext", 1, func() error {
+		var err error
+		lostNode, releaseNode, err = adapter.contextAdapter.MaintainNodeContext(ctx, storeNode)
+		return err
+	})
+
+	return lostNode, releaseNode, err
+}
+
+func (adapter *throttled) MaintainNodeWithTokenContext(ctx context.Context, storeNode StoreNode) (<-chan NodeStatus, chan chan bool, error) {
+	var status <-chan NodeStatus
+	var releaseNode chan chan bool
+	err := adapter.write(ctx, "MaintainNodeWithTokenContext", 1, func() error {
+		var err error
+		status, releaseNode, err = adapter.contextAdapter.MaintainNodeWithTokenContext(ctx, storeNode)
+		return err
+	})
+
+	return status, releaseNode, err
+}
+
+func (adapter *throttled) MaintainNodeWithOptionsContext(ctx context.Context, storeNode StoreNode, options MaintainOptions) (<-chan NodeStatus, chan chan bool, error) {
+	var status <-chan NodeStatus
+	var releaseNode chan chan bool
+	err := adapter.write(ctx, "MaintainNodeWithOptionsContext", 1, func() error {
+		var err error
+		status, releaseNode, err = adapter.contextAdapter.MaintainNodeWithOptionsContext(ctx, storeNode, options)
+		return err
+	})
+
+	return status, releaseNode, err
+}
+
+func (adapter *throttled) read(ctx context.Context, method string, action func() error) error {
+	return adapter.admit(ctx, adapter.reads, method, 1, action)
+}
+
+func (adapter *throttled) write(ctx context.Context, method string, cost int, action func() error) error {
+	return adapter.admit(ctx, adapter.writes, method, cost, action)
+}
+
+func (adapter *throttled) admit(ctx context.Context, limiter *throttleLimiter, method string, cost int, action func() error) error {
+	priority := adapter.priority(ctx, method)
+
+	release, err := limiter.acquire(ctx, priority, cost)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	releaseCombined, err := adapter.combined.acquire(ctx, priority, cost)
+	if err != nil {
+		return err
+	}
+	defer releaseCombined()
+
+	return action()
+}
+
+// watch starts a watch once it is admitted, and keeps it in flight until it
+// is stopped or ends.
+func (adapter *throttled) watch(ctx context.Context, method string, start func() (<-chan WatchEvent, chan<- bool, <-chan error)) (<-chan WatchEvent, chan<- bool, <-chan error) {
+	release, err := adapter.watches.acquire(ctx, adapter.priority(ctx, method), 1)
+	if err != nil {
+		return refusedWatch(err)
+	}
+
+	innerEvents, innerStop, innerErrs := start()
+
+	events := make(chan WatchEvent)
+	stop := make(chan bool, 1)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer release()
+		defer close(events)
+		defer close(errs)
+
+		for innerEvents != nil || innerErrs != nil {
+			select {
+			case event, ok := <-innerEvents:
+				if !ok {
+					innerEvents = nil
+					continue
+				}
+
+				select {
+				case events <- event:
+				case <-stop:
+					stopWatch(innerStop)
+					return
+				}
+
+			case err, ok := <-innerErrs:
+				if !ok {
+					innerErrs = nil
+					continue
+				}
+
+				select {
+				case errs <- err:
+				case <-stop:
+					stopWatch(innerStop)
+					return
+				}
+
+			case <-stop:
+				stopWatch(innerStop)
+				return
+			}
+		}
+	}()
+
+	return events, stop, errs
+}
+
+// priority is the priority set on the context, or else the method's.
+func (adapter *throttled) priority(ctx context.Context, method string) Priority {
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return priority
+	}
+
+	if priority, ok := adapter.priorities[method]; ok {
+		return priority
+	}
+
+	return adapter.priorities[strings.TrimSuffix(method, "Context")]
+}
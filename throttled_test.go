@@ -0,0 +1,266 @@
+package storeadapter_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	. "github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Throttled", func() {
+	var (
+		innerStoreAdapter *fakes.FakeStoreAdapter
+		clock             *fakeclock.FakeClock
+		options           ThrottleOptions
+
+		adapter StoreAdapter
+	)
+
+	BeforeEach(func() {
+		innerStoreAdapter = new(fakes.FakeStoreAdapter)
+		clock = fakeclock.NewFakeClock(time.Now())
+		options = ThrottleOptions{Clock: clock}
+	})
+
+	JustBeforeEach(func() {
+		adapter = NewThrottled(innerStoreAdapter, options)
+	})
+
+	// blockGets makes the inner Get wait until the returned function is
+	// called, recording the keys it was called with in order.
+	blockGets := func() (keys func() []string, unblock func()) {
+		var mutex sync.Mutex
+		var got []string
+
+		blocked := make(chan struct{})
+		innerStoreAdapter.GetStub = func(key string) (StoreNode, error) {
+			mutex.Lock()
+			got = append(got, key)
+			mutex.Unlock()
+
+			<-blocked
+			return StoreNode{Key: key}, nil
+		}
+
+		return func() []string {
+				mutex.Lock()
+				defer mutex.Unlock()
+				return append([]string{}, got...)
+			}, func() {
+				close(blocked)
+			}
+	}
+
+	getInBackground := func(ctx context.Context, key string) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := NewContextStoreAdapter(adapter).GetContext(ctx, key)
+			done <- err
+		}()
+		return done
+	}
+
+	It("passes requests through", func() {
+		innerStoreAdapter.GetReturns(StoreNode{Key: "some-key"}, nil)
+
+		node, err := adapter.Get("some-key")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(node).To(Equal(StoreNode{Key: "some-key"}))
+		Expect(innerStoreAdapter.GetArgsForCall(0)).To(Equal("some-key"))
+	})
+
+	Context("with a concurrency limit", func() {
+		BeforeEach(func() {
+			options.Reads.Concurrency = 1
+		})
+
+		It("makes requests over the limit wait", func() {
+			keys, unblock := blockGets()
+
+			first := getInBackground(context.Background(), "first")
+			Eventually(keys).Should(Equal([]string{"first"}))
+
+			second := getInBackground(context.Background(), "second")
+			Consistently(keys).Should(Equal([]string{"first"}))
+
+			unblock()
+			Eventually(first).Should(Receive(BeNil()))
+			Eventually(second).Should(Receive(BeNil()))
+			Expect(keys()).To(Equal([]string{"first", "second"}))
+		})
+
+		It("does not make other kinds of request wait", func() {
+			keys, unblock := blockGets()
+			defer unblock()
+
+			getInBackground(context.Background(), "first")
+			Eventually(keys).Should(HaveLen(1))
+
+			Expect(adapter.SetMulti([]StoreNode{{Key: "some-key"}})).To(Succeed())
+			Expect(innerStoreAdapter.SetMultiCallCount()).To(Equal(1))
+		})
+
+		It("admits waiting requests of a higher priority first", func() {
+			options.Priorities = map[string]Priority{"Get": PriorityLow}
+			adapter = NewThrottled(innerStoreAdapter, options)
+
+			keys, unblock := blockGets()
+
+			getInBackground(context.Background(), "first")
+			Eventually(keys).Should(HaveLen(1))
+
+			low := getInBackground(context.Background(), "low")
+			high := getInBackground(WithPriority(context.Background(), PriorityHigh), "high")
+			Consistently(keys).Should(HaveLen(1))
+
+			unblock()
+			Eventually(low).Should(Receive())
+			Eventually(high).Should(Receive())
+			Expect(keys()).To(Equal([]string{"first", "high", "low"}))
+		})
+
+		Context("when the context is done while waiting", func() {
+			It("gives up with the context's error", func() {
+				keys, unblock := blockGets()
+
+				getInBackground(context.Background(), "first")
+				Eventually(keys).Should(HaveLen(1))
+
+				ctx, cancel := context.WithCancel(context.Background())
+				waiting := getInBackground(ctx, "second")
+				cancel()
+				Eventually(waiting).Should(Receive(Equal(context.Canceled)))
+
+				unblock()
+				Expect(adapter.Get("third")).To(Equal(StoreNode{Key: "third"}))
+				Expect(keys()).To(Equal([]string{"first", "third"}))
+			})
+		})
+	})
+
+	Context("with a rate limit", func() {
+		BeforeEach(func() {
+			options.Writes = ThrottleLimit{Rate: 1, Burst: 2}
+		})
+
+		It("admits a burst, then one request per interval", func() {
+			Expect(adapter.Create(StoreNode{Key: "a"})).To(Succeed())
+			Expect(adapter.Create(StoreNode{Key: "b"})).To(Succeed())
+
+			done := make(chan error, 1)
+			go func() {
+				done <- adapter.Create(StoreNode{Key: "c"})
+			}()
+			Consistently(done).ShouldNot(Receive())
+
+			clock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(innerStoreAdapter.CreateCallCount()).To(Equal(3))
+		})
+
+		It("counts a request once for each node it writes", func() {
+			Expect(adapter.SetMulti([]StoreNode{{Key: "a"}, {Key: "b"}})).To(Succeed())
+
+			done := make(chan error, 1)
+			go func() {
+				done <- adapter.Delete("a")
+			}()
+			Consistently(done).ShouldNot(Receive())
+
+			clock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("admits a request that writes more nodes than the burst, and makes the next wait for all of them", func() {
+			Expect(adapter.SetMulti([]StoreNode{{Key: "a"}, {Key: "b"}, {Key: "c"}, {Key: "d"}, {Key: "e"}})).To(Succeed())
+			Expect(innerStoreAdapter.SetMultiCallCount()).To(Equal(1))
+
+			done := make(chan error, 1)
+			go func() {
+				done <- adapter.Delete("a")
+			}()
+
+			clock.WaitForWatcherAndIncrement(3 * time.Second)
+			Consistently(done).ShouldNot(Receive())
+
+			clock.Increment(time.Second)
+			Eventually(done).Should(Receive(BeNil()))
+		})
+	})
+
+	Context("with a combined rate limit", func() {
+		BeforeEach(func() {
+			options.Combined = ThrottleLimit{Rate: 1, Burst: 2}
+		})
+
+		It("delays reads in proportion to the nodes written before them", func() {
+			nodes := make([]StoreNode, 10)
+			for i := range nodes {
+				nodes[i] = StoreNode{Key: fmt.Sprintf("node-%d", i)}
+			}
+			Expect(adapter.SetMulti(nodes)).To(Succeed())
+
+			done := getInBackground(context.Background(), "some-key")
+
+			clock.WaitForWatcherAndIncrement(8 * time.Second)
+			Consistently(done).ShouldNot(Receive())
+
+			clock.Increment(time.Second)
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("does not delay reads after small writes", func() {
+			Expect(adapter.Create(StoreNode{Key: "some-key"})).To(Succeed())
+
+			_, err := adapter.Get("some-key")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("with a limit on watches", func() {
+		BeforeEach(func() {
+			options.Watches.Concurrency = 1
+
+			innerStoreAdapter.WatchStub = func(string) (<-chan WatchEvent, chan<- bool, <-chan error) {
+				events := make(chan WatchEvent, 1)
+				events <- WatchEvent{Type: CreateEvent, Index: 1}
+				return events, make(chan bool, 1), make(chan error)
+			}
+		})
+
+		It("forwards events", func() {
+			events, _, _ := adapter.Watch("some-key")
+			Eventually(events).Should(Receive(Equal(WatchEvent{Type: CreateEvent, Index: 1})))
+		})
+
+		It("keeps a watch in flight until it is stopped", func() {
+			_, stop, _ := adapter.Watch("some-key")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			errs := make(chan (<-chan error), 1)
+			go func() {
+				_, _, watchErrs := NewContextStoreAdapter(adapter).WatchContext(ctx, "other-key")
+				errs <- watchErrs
+			}()
+			Consistently(innerStoreAdapter.WatchCallCount).Should(Equal(1))
+			cancel()
+
+			var watchErrs <-chan error
+			Eventually(errs).Should(Receive(&watchErrs))
+			Expect(watchErrs).To(Receive(Equal(context.Canceled)))
+
+			stop <- true
+			adapter.Watch("other-key")
+			Expect(innerStoreAdapter.WatchCallCount()).To(Equal(2))
+		})
+	})
+})
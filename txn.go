@@ -0,0 +1,95 @@
+package storeadapter
+
+import "bytes"
+
+type TxnCompareType int
+
+const (
+	InvalidCompare = TxnCompareType(iota)
+	// The node at Node.Key must be a leaf whose value is Node.Value.
+	CompareValue
+	// The node at Node.Key must be a leaf whose index is Node.Index.
+	CompareIndex
+	// Some node, leaf or directory, must exist at Node.Key.
+	CompareExists
+	// No node may exist at Node.Key.
+	CompareMissing
+)
+
+type TxnCompare struct {
+	Type TxnCompareType
+	Node StoreNode
+}
+
+func ValueEquals(key string, value []byte) TxnCompare {
+	return TxnCompare{Type: CompareValue, Node: StoreNode{Key: key, Value: value}}
+}
+
+func IndexEquals(key string, index uint64) TxnCompare {
+	return TxnCompare{Type: CompareIndex, Node: StoreNode{Key: key, Index: index}}
+}
+
+func KeyExists(key string) TxnCompare {
+	return TxnCompare{Type: CompareExists, Node: StoreNode{Key: key}}
+}
+
+func KeyMissing(key string) TxnCompare {
+	return TxnCompare{Type: CompareMissing, Node: StoreNode{Key: key}}
+}
+
+// Check reports whether the comparison holds for the node currently stored at
+// its key; current is nil when there is no such node. A failing comparison
+// returns the error a transaction reports for it.
+func (compare TxnCompare) Check(current *StoreNode) error {
+	switch compare.Type {
+	case CompareExists:
+		if current == nil {
+			return ErrorKeyNotFound
+		}
+		return nil
+	case CompareMissing:
+		if current != nil {
+			return ErrorKeyExists
+		}
+		return nil
+	case CompareValue, CompareIndex:
+		if current == nil {
+			return ErrorKeyNotFound
+		}
+		if current.Dir {
+			return ErrorNodeIsDirectory
+		}
+		if compare.Type == CompareValue && !bytes.Equal(compare.Node.Value, current.Value) {
+			return ErrorKeyComparisonFailed
+		}
+		if compare.Type == CompareIndex && compare.Node.Index != current.Index {
+			return ErrorKeyComparisonFailed
+		}
+		return nil
+	}
+
+	return ErrorInvalidFormat
+}
+
+type TxnOpType int
+
+const (
+	InvalidOp = TxnOpType(iota)
+	// Set the leaf Node, creating or overwriting it.
+	PutOp
+	// Delete the leaf at Node.Key, which must exist.
+	DeleteOp
+)
+
+type TxnOp struct {
+	Type TxnOpType
+	Node StoreNode
+}
+
+func Put(node StoreNode) TxnOp {
+	return TxnOp{Type: PutOp, Node: node}
+}
+
+func DeleteKey(key string) TxnOp {
+	return TxnOp{Type: DeleteOp, Node: StoreNode{Key: key}}
+}
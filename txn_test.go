@@ -0,0 +1,68 @@
+package storeadapter_test
+
+import (
+	. "github.com/cloudfoundry/storeadapter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TxnCompare", func() {
+	var (
+		leaf = &StoreNode{Key: "/menu/breakfast", Value: []byte("waffles"), Index: 7}
+		dir  = &StoreNode{Key: "/menu", Dir: true}
+	)
+
+	Describe("CompareValue", func() {
+		It("holds when the value matches", func() {
+			Expect(ValueEquals("/menu/breakfast", []byte("waffles")).Check(leaf)).To(Succeed())
+		})
+
+		It("fails the comparison when the value differs", func() {
+			Expect(ValueEquals("/menu/breakfast", []byte("crepes")).Check(leaf)).To(Equal(ErrorKeyComparisonFailed))
+		})
+
+		It("reports a missing key", func() {
+			Expect(ValueEquals("/menu/breakfast", []byte("waffles")).Check(nil)).To(Equal(ErrorKeyNotFound))
+		})
+
+		It("refuses to compare a directory", func() {
+			Expect(ValueEquals("/menu", nil).Check(dir)).To(Equal(ErrorNodeIsDirectory))
+		})
+	})
+
+	Describe("CompareIndex", func() {
+		It("holds when the index matches", func() {
+			Expect(IndexEquals("/menu/breakfast", 7).Check(leaf)).To(Succeed())
+		})
+
+		It("fails the comparison when the index differs", func() {
+			Expect(IndexEquals("/menu/breakfast", 8).Check(leaf)).To(Equal(ErrorKeyComparisonFailed))
+		})
+	})
+
+	Describe("CompareExists", func() {
+		It("holds for leaves and directories", func() {
+			Expect(KeyExists("/menu/breakfast").Check(leaf)).To(Succeed())
+			Expect(KeyExists("/menu").Check(dir)).To(Succeed())
+		})
+
+		It("reports a missing key", func() {
+			Expect(KeyExists("/menu/breakfast").Check(nil)).To(Equal(ErrorKeyNotFound))
+		})
+	})
+
+	Describe("CompareMissing", func() {
+		It("holds when there is no node", func() {
+			Expect(KeyMissing("/menu/breakfast").Check(nil)).To(Succeed())
+		})
+
+		It("reports an existing key", func() {
+			Expect(KeyMissing("/menu/breakfast").Check(leaf)).To(Equal(ErrorKeyExists))
+		})
+	})
+
+	It("rejects an unknown comparison", func() {
+		Expect(TxnCompare{Node: *leaf}.Check(leaf)).To(Equal(ErrorInvalidFormat))
+	})
+})
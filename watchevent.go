@@ -4,6 +4,10 @@ type WatchEvent struct {
 	Type     EventType
 	Node     *StoreNode
 	PrevNode *StoreNode
+
+	// The store index at which the event happened. Pass it to WatchFrom to
+	// resume watching after this event.
+	Index uint64
 }
 
 type EventType int
@@ -0,0 +1,23 @@
+package zkstoreadapter_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/workpool"
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/storeadaptertest"
+	. "github.com/cloudfoundry/storeadapter/zkstoreadapter"
+	. "github.com/onsi/gomega"
+)
+
+var _ = storeadaptertest.DescribeConformance("ZooKeeper Store Adapter conformance", storeadaptertest.Backend{
+	NewStoreAdapter: func() storeadapter.StoreAdapter {
+		workPool, err := workpool.NewWorkPool(10)
+		Expect(err).NotTo(HaveOccurred())
+
+		adapter, err := New(&ZKOptions{Servers: zkRunner.NodeURLS(), SessionTimeout: 2 * time.Second}, workPool)
+		Expect(err).NotTo(HaveOccurred())
+		return adapter
+	},
+	EmptyDirectoriesRemain: false,
+})
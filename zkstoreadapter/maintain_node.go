@@ -0,0 +1,206 @@
+package zkstoreadapter
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/cloudfoundry/storeadapter/internal/maintain"
+	"github.com/go-zookeeper/zk"
+	"github.com/nu7hatch/gouuid"
+)
+
+func (adapter *ZKStoreAdapter) MaintainNode(storeNode storeadapter.StoreNode) (<-chan bool, chan (chan bool), error) {
+	return adapter.MaintainNodeContext(context.Background(), storeNode)
+}
+
+func (adapter *ZKStoreAdapter) MaintainNodeContext(ctx context.Context, storeNode storeadapter.StoreNode) (<-chan bool, chan (chan bool), error) {
+	status, releaseNode, err := adapter.MaintainNodeWithTokenContext(ctx, storeNode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owned := make(chan bool)
+	go func() {
+		defer close(owned)
+		for nodeStatus := range status {
+			owned <- nodeStatus.Owned
+		}
+	}()
+
+	return owned, releaseNode, nil
+}
+
+func (adapter *ZKStoreAdapter) MaintainNodeWithToken(storeNode storeadapter.StoreNode) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	return adapter.MaintainNodeWithTokenContext(context.Background(), storeNode)
+}
+
+func (adapter *ZKStoreAdapter) MaintainNodeWithTokenContext(ctx context.Context, storeNode storeadapter.StoreNode) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	return adapter.MaintainNodeWithOptionsContext(ctx, storeNode, storeadapter.MaintainOptions{})
+}
+
+func (adapter *ZKStoreAdapter) MaintainNodeWithOptions(storeNode storeadapter.StoreNode, options storeadapter.MaintainOptions) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	return adapter.MaintainNodeWithOptionsContext(context.Background(), storeNode, options)
+}
+
+// The node is an ephemeral znode, which the server removes when the session
+// ends, so it outlives a lost connection by the session timeout rather than
+// by its TTL; the TTL only sets the default refresh interval. Each refresh
+// checks that the node is still held by this session. The fencing token is
+// the zxid the node was created at.
+func (adapter *ZKStoreAdapter) MaintainNodeWithOptionsContext(ctx context.Context, storeNode storeadapter.StoreNode, options storeadapter.MaintainOptions) (<-chan storeadapter.NodeStatus, chan (chan bool), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	options, err := options.WithDefaults(storeNode.TTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(storeNode.Value) == 0 {
+		guid, err := uuid.NewV4()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		storeNode.Value = []byte(guid.String())
+	}
+
+	storeNode.Key = cleanKey(storeNode.Key)
+
+	nodeStatus, releaseNode := maintain.Node(ctx, options, maintain.Backend{
+		Acquire: func(ctx context.Context) (uint64, error) {
+			var token int64
+			err := call(ctx, func() error {
+				var err error
+				token, err = adapter.acquireNode(storeNode)
+				return err
+			})
+			return uint64(token), err
+		},
+		Refresh: func(ctx context.Context, token uint64) error {
+			return call(ctx, func() error {
+				return adapter.refreshNode(storeNode, int64(token))
+			})
+		},
+		Release: func(token uint64) {
+			adapter.releaseNode(storeNode, int64(token))
+		},
+		Disconnected: adapter.ctx.Done(),
+	})
+
+	return nodeStatus, releaseNode, nil
+}
+
+// acquireNode creates the node as an ephemeral znode if nobody else holds it,
+// returning the zxid it was created at.
+func (adapter *ZKStoreAdapter) acquireNode(storeNode storeadapter.StoreNode) (int64, error) {
+	key := storeNode.Key
+	data := encodeLeaf(storeNode.Value, 0)
+
+	for {
+		if err := adapter.ensureParents("MaintainNode", key); err != nil {
+			return 0, err
+		}
+
+		_, err := adapter.conn.Create(key, data, zk.FlagEphemeral, acl)
+		if err == nil {
+			return adapter.ownedToken(storeNode)
+		}
+
+		if err != zk.ErrNodeExists {
+			return 0, err
+		}
+
+		current, err := adapter.readNode(key)
+		if err != nil {
+			return 0, err
+		}
+
+		if current == nil {
+			continue
+		}
+
+		if current.isDir() {
+			return 0, storeadapter.ErrorNodeIsDirectory
+		}
+
+		value, _, err := current.leaf()
+		if err != nil || !bytes.Equal(value, storeNode.Value) {
+			return 0, storeadapter.ErrorKeyExists
+		}
+
+		if current.stat.EphemeralOwner == adapter.conn.SessionID() {
+			return current.stat.Czxid, nil
+		}
+
+		// A node holding our value is one we held before, so it is taken over.
+		_, err = adapter.conn.Multi(
+			&zk.DeleteRequest{Path: key, Version: current.stat.Version},
+			&zk.CreateRequest{Path: key, Data: data, Acl: acl, Flags: zk.FlagEphemeral},
+		)
+		if isRace(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		return adapter.ownedToken(storeNode)
+	}
+}
+
+// ownedToken returns the zxid the node was created at, as long as it is held
+// by this session.
+func (adapter *ZKStoreAdapter) ownedToken(storeNode storeadapter.StoreNode) (int64, error) {
+	current, err := adapter.readNode(storeNode.Key)
+	if err != nil {
+		return 0, err
+	}
+
+	if current == nil || current.stat.EphemeralOwner != adapter.conn.SessionID() {
+		return 0, maintain.ErrNodeLost
+	}
+
+	return current.stat.Czxid, nil
+}
+
+// refreshNode returns maintain.ErrNodeLost if the node is no longer the one this
+// session created with the given token.
+func (adapter *ZKStoreAdapter) refreshNode(storeNode storeadapter.StoreNode, token int64) error {
+	current, err := adapter.ownedToken(storeNode)
+	if err != nil {
+		return err
+	}
+
+	if current != token {
+		return maintain.ErrNodeLost
+	}
+
+	return nil
+}
+
+// releaseNode deletes the node if it is still held with token.
+func (adapter *ZKStoreAdapter) releaseNode(storeNode storeadapter.StoreNode, token int64) {
+	if token == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(adapter.ctx, time.Duration(storeNode.TTL)*time.Second)
+	defer cancel()
+
+	call(ctx, func() error {
+		current, err := adapter.readNode(storeNode.Key)
+		if err != nil || current == nil {
+			return err
+		}
+
+		if current.stat.EphemeralOwner != adapter.conn.SessionID() || current.stat.Czxid != token {
+			return nil
+		}
+
+		return adapter.conn.Delete(storeNode.Key, current.stat.Version)
+	})
+}
@@ -0,0 +1,262 @@
+package zkstoreadapter
+
+import (
+	"context"
+
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/go-zookeeper/zk"
+)
+
+// txn reads every znode involved, checks the comparisons and plays the
+// operations against what it read, then makes the resulting writes in one
+// multi request, on condition that none of the leaves read has changed
+// since. If one has, it starts over.
+//
+// A multi request can neither create TTL znodes nor check that a znode is
+// still missing, so a put that needs a TTL znode fails with ErrorInvalidTTL,
+// and a KeyMissing comparison only holds at the moment it is read, unless the
+// transaction creates that key itself.
+func (adapter *ZKStoreAdapter) txn(ctx context.Context, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		state, err := adapter.readTxnState(comparisons, operations)
+		if err != nil {
+			return adapter.convertError("Txn", "", err)
+		}
+
+		for _, comparison := range comparisons {
+			key := cleanKey(comparison.Node.Key)
+
+			if err := comparison.Check(state.node(key)); err != nil {
+				return keyError("Txn", key, 0, err)
+			}
+		}
+
+		requests, failedKey, err := state.apply(operations)
+		if err != nil {
+			return keyError("Txn", failedKey, 0, err)
+		}
+
+		if len(requests) == 0 {
+			return nil
+		}
+
+		for _, key := range state.created {
+			if err = adapter.ensureParents("Txn", key); err != nil {
+				break
+			}
+		}
+
+		if err == nil {
+			_, err = adapter.conn.Multi(append(state.guards(), requests...)...)
+		}
+
+		if isRace(err) {
+			continue
+		}
+
+		return adapter.convertError("Txn", "", err)
+	}
+}
+
+// txnState is what a transaction has read of the znodes involved in it, as
+// changed by the operations it has played so far.
+type txnState struct {
+	// The znode at each key as read, or nil. This covers the keys compared and
+	// operated on, and the directories above the keys put.
+	read map[string]*znode
+
+	// The leaf at each key as it stands, or nil if there is none.
+	leaves map[string]*txnLeaf
+
+	// How many znodes there are under each directory read.
+	children map[string]int32
+
+	// The directories read that have been replaced by leaves.
+	replaced map[string]bool
+
+	// The keys put that did not exist when read.
+	created []string
+}
+
+type txnLeaf struct {
+	value   []byte
+	ttl     uint64
+	version int32
+	index   uint64
+}
+
+func (adapter *ZKStoreAdapter) readTxnState(comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) (*txnState, error) {
+	state := &txnState{
+		read:     map[string]*znode{},
+		leaves:   map[string]*txnLeaf{},
+		children: map[string]int32{},
+		replaced: map[string]bool{},
+	}
+
+	keys := []string{}
+	for _, comparison := range comparisons {
+		keys = append(keys, cleanKey(comparison.Node.Key))
+	}
+	for _, operation := range operations {
+		key := cleanKey(operation.Node.Key)
+		keys = append(keys, key)
+		if operation.Type == storeadapter.PutOp {
+			keys = append(keys, ancestors(key)...)
+		}
+	}
+
+	for _, key := range keys {
+		if _, seen := state.read[key]; seen {
+			continue
+		}
+
+		current, err := adapter.readNode(key)
+		if err != nil {
+			return nil, err
+		}
+
+		state.read[key] = current
+
+		if current != nil && current.isDir() {
+			state.children[key] = current.stat.NumChildren
+		}
+
+		if current != nil && !current.isDir() {
+			value, ttl, err := current.leaf()
+			if err != nil {
+				return nil, keyError("Txn", key, current.stat.Mzxid, err)
+			}
+
+			state.leaves[key] = &txnLeaf{
+				value:   value,
+				ttl:     ttl,
+				version: current.stat.Version,
+				index:   uint64(current.stat.Mzxid),
+			}
+		}
+	}
+
+	return state, nil
+}
+
+func (state *txnState) isDir(key string) bool {
+	current := state.read[key]
+	return state.leaves[key] == nil && !state.replaced[key] && current != nil && current.isDir()
+}
+
+// countChild adds delta to the count of znodes under the directory above key,
+// if it was read.
+func (state *txnState) countChild(key string, delta int32) {
+	if key == "" {
+		return
+	}
+
+	if _, ok := state.children[parentKey(key)]; ok {
+		state.children[parentKey(key)] += delta
+	}
+}
+
+// node returns the node at key as it stands, or nil if there is none.
+func (state *txnState) node(key string) *storeadapter.StoreNode {
+	if leaf := state.leaves[key]; leaf != nil {
+		return &storeadapter.StoreNode{Key: key, Value: leaf.value, Index: leaf.index}
+	}
+
+	if state.isDir(key) {
+		return &storeadapter.StoreNode{Key: key, Dir: true}
+	}
+
+	return nil
+}
+
+// apply plays the operations in order, returning the requests they add up to.
+// If an operation cannot be applied, it returns its key and why.
+func (state *txnState) apply(operations []storeadapter.TxnOp) ([]interface{}, string, error) {
+	requests := []interface{}{}
+
+	for _, operation := range operations {
+		key := cleanKey(operation.Node.Key)
+		leaf := state.leaves[key]
+
+		switch operation.Type {
+		case storeadapter.PutOp:
+			if key == "" || state.isDir(key) && state.children[key] > 0 {
+				return nil, key, storeadapter.ErrorNodeIsDirectory
+			}
+
+			for _, parent := range ancestors(key) {
+				if state.leaves[parent] != nil {
+					return nil, key, storeadapter.ErrorNodeIsNotDirectory
+				}
+			}
+
+			node := operation.Node
+			data := encodeLeaf(node.Value, node.TTL)
+
+			switch {
+			case leaf != nil && (leaf.ttl == 0) == (node.TTL == 0):
+				requests = append(requests, &zk.SetDataRequest{Path: key, Data: data, Version: leaf.version})
+				leaf.version++
+
+			case node.TTL > 0:
+				return nil, key, storeadapter.ErrorInvalidTTL
+
+			default:
+				switch {
+				case leaf != nil:
+					requests = append(requests, &zk.DeleteRequest{Path: key, Version: leaf.version})
+				case state.isDir(key):
+					requests = append(requests, &zk.DeleteRequest{Path: key, Version: state.read[key].stat.Version})
+					state.replaced[key] = true
+				default:
+					state.countChild(key, 1)
+					if state.read[key] == nil {
+						state.created = append(state.created, key)
+					}
+				}
+
+				requests = append(requests, &zk.CreateRequest{Path: key, Data: data, Acl: acl, Flags: zk.FlagPersistent})
+				leaf = &txnLeaf{}
+			}
+
+			leaf.value = node.Value
+			leaf.ttl = node.TTL
+			state.leaves[key] = leaf
+
+		case storeadapter.DeleteOp:
+			if leaf == nil {
+				if state.isDir(key) {
+					return nil, key, storeadapter.ErrorNodeIsDirectory
+				}
+				return nil, key, storeadapter.ErrorKeyNotFound
+			}
+
+			requests = append(requests, &zk.DeleteRequest{Path: key, Version: leaf.version})
+			state.leaves[key] = nil
+			state.countChild(key, -1)
+
+		default:
+			return nil, key, storeadapter.ErrorInvalidFormat
+		}
+	}
+
+	return requests, "", nil
+}
+
+// guards returns the checks that hold as long as none of the leaves the
+// transaction read has changed.
+func (state *txnState) guards() []interface{} {
+	guards := []interface{}{}
+
+	for key, current := range state.read {
+		if current != nil && !current.isDir() {
+			guards = append(guards, &zk.CheckVersionRequest{Path: key, Version: current.stat.Version})
+		}
+	}
+
+	return guards
+}
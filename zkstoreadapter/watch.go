@@ -0,0 +1,406 @@
+package zkstoreadapter
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/go-zookeeper/zk"
+)
+
+var errWatchStopped = errors.New("the watch was stopped")
+
+// How long a watch waits to read the subtree again after failing to.
+const watchRetryInterval = time.Second
+
+func (adapter *ZKStoreAdapter) Watch(key string) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	return adapter.WatchContext(context.Background(), key)
+}
+
+// A ZooKeeper watch fires once, and only says that a znode has changed, so
+// the subtree is kept in memory and read again wherever a watch fires, and
+// events are worked out from what has changed. Changes to a leaf made before
+// it is read again are reported as one.
+//
+// Watching starts from the subtree as it is when Watch returns, so no later
+// change is missed. Failures to read it, such as while the connection is
+// down, are retried rather than reported.
+func (adapter *ZKStoreAdapter) WatchContext(ctx context.Context, key string) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	watcher, events, stop, errs := adapter.newTreeWatcher(ctx, key)
+
+	err := watcher.sync(watcher.key, true)
+	go watcher.run(err, errs)
+
+	return events, stop, errs
+}
+
+func (adapter *ZKStoreAdapter) WatchFrom(key string, afterIndex uint64) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	return adapter.WatchFromContext(context.Background(), key, afterIndex)
+}
+
+// ZooKeeper keeps no history, so watching can only resume from afterIndex if
+// nothing in the subtree has changed since. Otherwise ErrorWatchIndexCleared
+// is reported, at the latest zxid within the subtree.
+func (adapter *ZKStoreAdapter) WatchFromContext(ctx context.Context, key string, afterIndex uint64) (<-chan storeadapter.WatchEvent, chan<- bool, <-chan error) {
+	watcher, events, stop, errs := adapter.newTreeWatcher(ctx, key)
+
+	go func() {
+		err := watcher.sync(watcher.key, true)
+		if err == nil {
+			if latest := watcher.latestZxid(); latest > afterIndex {
+				err = keyError("Watch", watcher.key, int64(latest), storeadapter.ErrorWatchIndexCleared)
+			}
+		}
+
+		watcher.run(err, errs)
+	}()
+
+	return events, stop, errs
+}
+
+// treeWatcher keeps what it last read of a subtree, with a watch on each
+// znode in it.
+type treeWatcher struct {
+	adapter *ZKStoreAdapter
+	ctx     context.Context
+	key     string
+
+	events   chan storeadapter.WatchEvent
+	stop     chan bool
+	emitting bool
+
+	seen         map[string]*znode
+	dataWatches  map[string]bool
+	childWatches map[string]bool
+
+	// Bumped when the session's watches are lost, so that any fired before
+	// then are ignored.
+	generation int
+
+	fired chan firedWatch
+	done  chan struct{}
+}
+
+type firedWatch struct {
+	key        string
+	children   bool
+	generation int
+	event      zk.Event
+}
+
+func (adapter *ZKStoreAdapter) newTreeWatcher(ctx context.Context, key string) (*treeWatcher, chan storeadapter.WatchEvent, chan bool, chan error) {
+	watcher := &treeWatcher{
+		adapter:      adapter,
+		ctx:          ctx,
+		key:          cleanKey(key),
+		events:       make(chan storeadapter.WatchEvent),
+		stop:         make(chan bool, 1),
+		seen:         map[string]*znode{},
+		dataWatches:  map[string]bool{},
+		childWatches: map[string]bool{},
+		fired:        make(chan firedWatch),
+		done:         make(chan struct{}),
+	}
+
+	return watcher, watcher.events, watcher.stop, make(chan error)
+}
+
+// run reports err if reading the subtree failed to begin with, and otherwise
+// sends events as watches fire, until the watch is stopped.
+func (watcher *treeWatcher) run(err error, errs chan<- error) {
+	defer close(watcher.done)
+	defer close(watcher.events)
+	defer close(errs)
+
+	if err != nil {
+		select {
+		case errs <- watcher.adapter.convertError("Watch", watcher.key, err):
+		case <-watcher.stop:
+		case <-watcher.ctx.Done():
+		case <-watcher.adapter.ctx.Done():
+		}
+		return
+	}
+
+	watcher.emitting = true
+
+	var retry <-chan time.Time
+	for {
+		select {
+		case fired := <-watcher.fired:
+			if fired.generation != watcher.generation {
+				continue
+			}
+
+			if fired.event.Type == zk.EventNotWatching {
+				watcher.generation++
+				watcher.dataWatches = map[string]bool{}
+				watcher.childWatches = map[string]bool{}
+				err = watcher.sync(watcher.key, true)
+				break
+			}
+
+			if fired.children {
+				delete(watcher.childWatches, fired.key)
+			} else {
+				delete(watcher.dataWatches, fired.key)
+			}
+
+			err = watcher.sync(fired.key, false)
+
+		case <-retry:
+			retry = nil
+			err = watcher.sync(watcher.key, true)
+
+		case <-watcher.stop:
+			return
+		case <-watcher.ctx.Done():
+			return
+		case <-watcher.adapter.ctx.Done():
+			return
+		}
+
+		if err == errWatchStopped {
+			return
+		}
+
+		if err != nil && retry == nil {
+			retry = time.After(watchRetryInterval)
+		}
+	}
+}
+
+// sync reads the znode at key again, with the directories under it that have
+// not been read before, or all of them if deep is set, and sends events for
+// the leaves that have changed.
+func (watcher *treeWatcher) sync(key string, deep bool) error {
+	current, err := watcher.read(key)
+	if err != nil {
+		return err
+	}
+
+	if current == nil {
+		if key == watcher.key && !watcher.dataWatches[key] {
+			exists, _, events, err := watcher.adapter.conn.ExistsW(znodePath(key))
+			if err != nil {
+				return err
+			}
+
+			watcher.watch(key, false, events)
+			if exists {
+				return watcher.sync(key, deep)
+			}
+		}
+
+		if watcher.seen[key] == nil {
+			return nil
+		}
+
+		return watcher.remove(key, watcher.adapter.deletedAt(key))
+	}
+
+	previous := watcher.seen[key]
+	if previous != nil && previous.isDir() != current.isDir() {
+		if err := watcher.remove(key, uint64(current.stat.Czxid)); err != nil {
+			return err
+		}
+		previous = nil
+	}
+
+	watcher.seen[key] = current
+
+	if !current.isDir() {
+		return watcher.changed(previous, current)
+	}
+
+	keys, stat, err := watcher.children(key)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]bool{}
+	for _, child := range keys {
+		listed[child] = true
+		if deep || watcher.seen[child] == nil {
+			if err := watcher.sync(child, deep); err != nil {
+				return err
+			}
+		}
+	}
+
+	gone := []string{}
+	for seenKey := range watcher.seen {
+		if seenKey != key && parentKey(seenKey) == key && !listed[seenKey] {
+			gone = append(gone, seenKey)
+		}
+	}
+
+	sort.Strings(gone)
+	for _, goneKey := range gone {
+		if err := watcher.remove(goneKey, uint64(stat.Pzxid)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// read reads the znode at key, watching it unless it is watched already.
+func (watcher *treeWatcher) read(key string) (*znode, error) {
+	if watcher.dataWatches[key] {
+		return watcher.adapter.readNode(key)
+	}
+
+	data, stat, events, err := watcher.adapter.conn.GetW(znodePath(key))
+	if err == zk.ErrNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	watcher.watch(key, false, events)
+	return &znode{key: key, data: data, stat: stat}, nil
+}
+
+// children lists the znodes under key, watching it unless it is watched
+// already.
+func (watcher *treeWatcher) children(key string) ([]string, *zk.Stat, error) {
+	if watcher.childWatches[key] {
+		return watcher.adapter.children(key)
+	}
+
+	names, stat, events, err := watcher.adapter.conn.ChildrenW(znodePath(key))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	watcher.watch(key, true, events)
+	return childKeys(key, names), stat, nil
+}
+
+// watch passes on the watch's event once it fires.
+func (watcher *treeWatcher) watch(key string, children bool, events <-chan zk.Event) {
+	if children {
+		watcher.childWatches[key] = true
+	} else {
+		watcher.dataWatches[key] = true
+	}
+
+	generation := watcher.generation
+
+	go func() {
+		select {
+		case event := <-events:
+			select {
+			case watcher.fired <- firedWatch{key: key, children: children, generation: generation, event: event}:
+			case <-watcher.done:
+			}
+		case <-watcher.done:
+		}
+	}()
+}
+
+// changed sends an event for a leaf that has been created or modified.
+func (watcher *treeWatcher) changed(previous *znode, current *znode) error {
+	if previous != nil && previous.stat.Mzxid == current.stat.Mzxid {
+		return nil
+	}
+
+	node, err := current.storeNode()
+	if err != nil {
+		return nil
+	}
+
+	event := storeadapter.WatchEvent{
+		Type:  storeadapter.CreateEvent,
+		Node:  node,
+		Index: uint64(current.stat.Mzxid),
+	}
+
+	if previous != nil {
+		event.Type = storeadapter.UpdateEvent
+		event.PrevNode, _ = previous.storeNode()
+	}
+
+	return watcher.emit(event)
+}
+
+// remove forgets the znode at key and everything under it, sending an event
+// for each leaf, at index. A TTL leaf removed once its time was up is
+// reported as having expired.
+func (watcher *treeWatcher) remove(key string, index uint64) error {
+	gone := []string{}
+	for seenKey := range watcher.seen {
+		if isUnder(seenKey, key) {
+			gone = append(gone, seenKey)
+		}
+	}
+
+	sort.Strings(gone)
+	for _, goneKey := range gone {
+		previous := watcher.seen[goneKey]
+		delete(watcher.seen, goneKey)
+
+		if previous.isDir() {
+			continue
+		}
+
+		event := storeadapter.WatchEvent{
+			Type:  storeadapter.DeleteEvent,
+			Index: index,
+		}
+
+		if previous.expired() {
+			event.Type = storeadapter.ExpireEvent
+		}
+
+		event.PrevNode, _ = previous.storeNode()
+		if event.PrevNode == nil {
+			event.PrevNode = &storeadapter.StoreNode{Key: goneKey}
+		}
+
+		if err := watcher.emit(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (watcher *treeWatcher) emit(event storeadapter.WatchEvent) error {
+	if !watcher.emitting {
+		return nil
+	}
+
+	select {
+	case watcher.events <- event:
+		return nil
+	case <-watcher.stop:
+	case <-watcher.ctx.Done():
+	case <-watcher.adapter.ctx.Done():
+	}
+
+	return errWatchStopped
+}
+
+// latestZxid returns the latest zxid at which anything in the subtree was
+// created, modified or deleted.
+func (watcher *treeWatcher) latestZxid() uint64 {
+	latest := int64(0)
+	for _, node := range watcher.seen {
+		if node.stat.Mzxid > latest {
+			latest = node.stat.Mzxid
+		}
+		if node.stat.Pzxid > latest {
+			latest = node.stat.Pzxid
+		}
+	}
+
+	return uint64(latest)
+}
@@ -0,0 +1,1084 @@
+package zkstoreadapter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/workpool"
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/go-zookeeper/zk"
+)
+
+var errRootReadOnly = errors.New("the root directory is read only")
+
+// ZooKeeper keeps its own nodes under /zookeeper, which is left out of
+// listings and deletes.
+const reservedKey = "/zookeeper"
+
+var acl = zk.WorldACL(zk.PermAll)
+
+type ZKOptions struct {
+	// The host:port of each server.
+	Servers []string
+
+	// How long the session outlives a lost connection, and so how long a
+	// maintained node does. Defaults to 10 seconds, and is bounded by the
+	// server.
+	SessionTimeout time.Duration
+}
+
+// ZKStoreAdapter is a StoreAdapter on ZooKeeper.
+//
+// Each node is the znode at the same path. A leaf holds its value behind a
+// header recording its TTL, and a directory holds no data. Directories are
+// created as container znodes, which the server removes some time after their
+// last child has gone, and leaves with a TTL as TTL znodes, which it removes
+// once they have gone that long without a write. The server must run with
+// zookeeper.extendedTypesEnabled for either. A leaf's Index is the zxid it was
+// last modified at, and a directory's is the latest zxid within it.
+type ZKStoreAdapter struct {
+	options  ZKOptions
+	workPool *workpool.WorkPool
+
+	conn *zk.Conn
+
+	// ctx is cancelled by Disconnect, stopping watches and maintained nodes.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func New(options *ZKOptions, workPool *workpool.WorkPool) (*ZKStoreAdapter, error) {
+	if len(options.Servers) == 0 {
+		return nil, errors.New("no ZooKeeper servers given")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	zkOptions := *options
+	if zkOptions.SessionTimeout == 0 {
+		zkOptions.SessionTimeout = 10 * time.Second
+	}
+
+	return &ZKStoreAdapter{
+		options:  zkOptions,
+		workPool: workPool,
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// The client queues requests until it has a connection, so the methods
+// without a context are given requestTimeout, and report running out of time
+// as ErrorTimeout.
+const requestTimeout = 10 * time.Second
+
+func (adapter *ZKStoreAdapter) withTimeout(op string, key string, request func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(adapter.ctx, requestTimeout)
+	defer cancel()
+
+	err := request(ctx)
+	if err == context.DeadlineExceeded {
+		return &storeadapter.Error{Op: op, Key: cleanKey(key), Err: storeadapter.ErrorTimeout, Cause: err}
+	}
+
+	return err
+}
+
+// call makes a request, giving up on it when ctx is done. The client takes no
+// context, so a request given up on still runs to completion.
+func call(ctx context.Context, request func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- request()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (adapter *ZKStoreAdapter) Connect() error {
+	return adapter.withTimeout("Connect", "", func(ctx context.Context) error {
+		return adapter.ConnectContext(ctx)
+	})
+}
+
+// ConnectContext waits until a session has been established.
+func (adapter *ZKStoreAdapter) ConnectContext(ctx context.Context) error {
+	conn, events, err := zk.Connect(adapter.options.Servers, adapter.options.SessionTimeout, zk.WithLogInfo(false))
+	if err != nil {
+		return adapter.convertError("Connect", "", err)
+	}
+
+	for {
+		select {
+		case event := <-events:
+			if event.State == zk.StateHasSession {
+				adapter.conn = conn
+				return nil
+			}
+
+		case <-ctx.Done():
+			conn.Close()
+			return ctx.Err()
+		}
+	}
+}
+
+func (adapter *ZKStoreAdapter) Disconnect() error {
+	adapter.cancel()
+	adapter.workPool.Stop()
+	if adapter.conn != nil {
+		adapter.conn.Close()
+	}
+
+	return nil
+}
+
+// cleanKey returns key as it is stored: rooted, without a trailing slash, and
+// empty for the root itself.
+func cleanKey(key string) string {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" {
+		return ""
+	}
+
+	return cleaned
+}
+
+// znodePath returns the path of the znode at key.
+func znodePath(key string) string {
+	if key == "" {
+		return "/"
+	}
+
+	return key
+}
+
+// ancestors returns the directories above key, outermost first.
+func ancestors(key string) []string {
+	dirs := []string{}
+	for i := 1; i < len(key); i++ {
+		if key[i] == '/' {
+			dirs = append(dirs, key[:i])
+		}
+	}
+
+	return dirs
+}
+
+func parentKey(key string) string {
+	return key[:strings.LastIndex(key, "/")]
+}
+
+func isUnder(key, dir string) bool {
+	return key == dir || strings.HasPrefix(key, dir+"/")
+}
+
+// A leaf's data starts with a header byte, followed for a TTL znode by its TTL
+// in seconds, so that the time left can be worked out from when it was last
+// written.
+const (
+	leafHeader    byte = 0
+	leafHeaderTTL byte = 1
+)
+
+func encodeLeaf(value []byte, ttl uint64) []byte {
+	if ttl == 0 {
+		return append([]byte{leafHeader}, value...)
+	}
+
+	data := make([]byte, 9, 9+len(value))
+	data[0] = leafHeaderTTL
+	binary.BigEndian.PutUint64(data[1:], ttl)
+	return append(data, value...)
+}
+
+func decodeLeaf(data []byte) ([]byte, uint64, error) {
+	switch {
+	case len(data) >= 1 && data[0] == leafHeader:
+		return data[1:], 0, nil
+	case len(data) >= 9 && data[0] == leafHeaderTTL:
+		return data[9:], binary.BigEndian.Uint64(data[1:9]), nil
+	}
+
+	return nil, 0, storeadapter.ErrorInvalidFormat
+}
+
+// znode is a znode as read.
+type znode struct {
+	key  string
+	data []byte
+	stat *zk.Stat
+}
+
+// isDir reports whether the znode is a directory: the root, or one without
+// data or with children. Those created by other clients count too.
+func (node *znode) isDir() bool {
+	return node.key == "" || len(node.data) == 0 || node.stat.NumChildren > 0
+}
+
+func (node *znode) leaf() ([]byte, uint64, error) {
+	return decodeLeaf(node.data)
+}
+
+// storeNode returns the node without its children. A leaf's TTL is the time
+// left until it expires, rounded up.
+func (node *znode) storeNode() (*storeadapter.StoreNode, error) {
+	if node.isDir() {
+		return &storeadapter.StoreNode{Key: node.key, Dir: true, Index: uint64(node.stat.Pzxid)}, nil
+	}
+
+	value, ttl, err := node.leaf()
+	if err != nil {
+		return nil, err
+	}
+
+	return &storeadapter.StoreNode{
+		Key:   node.key,
+		Value: value,
+		TTL:   remainingTTL(ttl, node.stat),
+		Index: uint64(node.stat.Mzxid),
+	}, nil
+}
+
+// remainingTTL reports a leaf that has expired, but has not been removed yet,
+// as having a second left.
+func remainingTTL(ttl uint64, stat *zk.Stat) uint64 {
+	if ttl == 0 {
+		return 0
+	}
+
+	written := time.Unix(0, stat.Mtime*int64(time.Millisecond))
+	remaining := time.Until(written.Add(time.Duration(ttl) * time.Second))
+	if remaining < time.Second {
+		return 1
+	}
+
+	return uint64((remaining + time.Second - 1) / time.Second)
+}
+
+func (node *znode) expired() bool {
+	_, ttl, err := node.leaf()
+	if err != nil || ttl == 0 {
+		return false
+	}
+
+	written := time.Unix(0, node.stat.Mtime*int64(time.Millisecond))
+	return !time.Now().Before(written.Add(time.Duration(ttl) * time.Second))
+}
+
+// readNode reads the znode at key, or returns nil if there is none.
+func (adapter *ZKStoreAdapter) readNode(key string) (*znode, error) {
+	data, stat, err := adapter.conn.Get(znodePath(key))
+	if err == zk.ErrNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &znode{key: key, data: data, stat: stat}, nil
+}
+
+// children returns the keys of the znodes under key, sorted.
+func (adapter *ZKStoreAdapter) children(key string) ([]string, *zk.Stat, error) {
+	names, stat, err := adapter.conn.Children(znodePath(key))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return childKeys(key, names), stat, nil
+}
+
+func childKeys(key string, names []string) []string {
+	sort.Strings(names)
+
+	keys := []string{}
+	for _, name := range names {
+		if child := key + "/" + name; child != reservedKey {
+			keys = append(keys, child)
+		}
+	}
+
+	return keys
+}
+
+// isRace reports whether a conditional write failed because a znode changed
+// after it was read, so that it is worth reading it again and retrying.
+func isRace(err error) bool {
+	return err == zk.ErrBadVersion || err == zk.ErrNodeExists || err == zk.ErrNoNode || err == zk.ErrNotEmpty
+}
+
+// convertError wraps err in a *storeadapter.Error for the given operation and
+// key, unwrapping to the matching storeadapter sentinel. Lost connections and
+// sessions are marked Temporary. Context errors are returned as is.
+func (adapter *ZKStoreAdapter) convertError(op string, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch err.(type) {
+	case *storeadapter.Error, *storeadapter.MultiError:
+		return err
+	}
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+
+	converted := &storeadapter.Error{Op: op, Key: key, Err: err, Cause: err}
+
+	switch err {
+	case zk.ErrNoNode:
+		converted.Err = storeadapter.ErrorKeyNotFound
+	case zk.ErrNodeExists:
+		converted.Err = storeadapter.ErrorKeyExists
+	case zk.ErrBadVersion:
+		converted.Err = storeadapter.ErrorKeyComparisonFailed
+	case zk.ErrNotEmpty:
+		converted.Err = storeadapter.ErrorDirectoryNotEmpty
+	case zk.ErrNoChildrenForEphemerals:
+		converted.Err = storeadapter.ErrorNodeIsNotDirectory
+	case zk.ErrConnectionClosed, zk.ErrNoServer, zk.ErrSessionExpired, zk.ErrClosing:
+		converted.Err = storeadapter.ErrorTimeout
+		converted.Temporary = true
+	}
+
+	return converted
+}
+
+// keyError returns a *storeadapter.Error for one of the storeadapter
+// sentinels, reported at the given zxid.
+func keyError(op string, key string, zxid int64, err error) error {
+	return &storeadapter.Error{Op: op, Key: key, Index: uint64(zxid), Err: err, Cause: err}
+}
+
+// fanOut runs one request per key on the work pool. If any of them fail, it
+// returns a *storeadapter.MultiError holding the outcome for every key.
+func (adapter *ZKStoreAdapter) fanOut(ctx context.Context, op string, keys []string, request func(i int) (uint64, error)) error {
+	results := make([]storeadapter.KeyResult, len(keys))
+	done := make(chan bool, len(keys))
+
+	for i, key := range keys {
+		i := i
+		results[i].Key = key
+
+		adapter.workPool.Submit(func() {
+			defer func() {
+				done <- true
+			}()
+
+			if err := ctx.Err(); err != nil {
+				results[i].Err = err
+				return
+			}
+
+			index, err := request(i)
+			if err != nil {
+				results[i].Err = adapter.convertError(op, key, err)
+			} else {
+				results[i].Index = index
+			}
+		})
+	}
+
+	numReceived := 0
+	for numReceived < len(keys) {
+		select {
+		case <-done:
+			numReceived++
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return storeadapter.NewMultiError(results)
+}
+
+func nodeKeys(nodes []storeadapter.StoreNode) []string {
+	keys := make([]string, len(nodes))
+	for i, node := range nodes {
+		keys[i] = node.Key
+	}
+
+	return keys
+}
+
+func (adapter *ZKStoreAdapter) Get(key string) (storeadapter.StoreNode, error) {
+	var node storeadapter.StoreNode
+	err := adapter.withTimeout("Get", key, func(ctx context.Context) error {
+		var err error
+		node, err = adapter.GetContext(ctx, key)
+		return err
+	})
+	return node, err
+}
+
+func (adapter *ZKStoreAdapter) GetContext(ctx context.Context, key string) (storeadapter.StoreNode, error) {
+	key = cleanKey(key)
+
+	var node *storeadapter.StoreNode
+	err := call(ctx, func() error {
+		current, err := adapter.readNode(key)
+		if err != nil {
+			return adapter.convertError("Get", key, err)
+		}
+
+		if current == nil {
+			return keyError("Get", key, 0, storeadapter.ErrorKeyNotFound)
+		}
+
+		if current.isDir() {
+			return keyError("Get", key, current.stat.Pzxid, storeadapter.ErrorNodeIsDirectory)
+		}
+
+		node, err = current.storeNode()
+		if err != nil {
+			return keyError("Get", key, current.stat.Mzxid, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return storeadapter.StoreNode{}, err
+	}
+
+	return *node, nil
+}
+
+func (adapter *ZKStoreAdapter) ListRecursively(key string) (storeadapter.StoreNode, error) {
+	var node storeadapter.StoreNode
+	err := adapter.withTimeout("ListRecursively", key, func(ctx context.Context) error {
+		var err error
+		node, err = adapter.ListRecursivelyContext(ctx, key)
+		return err
+	})
+	return node, err
+}
+
+// ZooKeeper has no recursive read, so the listing is read a znode at a time,
+// and is not a snapshot of a single moment.
+func (adapter *ZKStoreAdapter) ListRecursivelyContext(ctx context.Context, key string) (storeadapter.StoreNode, error) {
+	key = cleanKey(key)
+
+	var dir storeadapter.StoreNode
+	err := call(ctx, func() error {
+		current, err := adapter.readNode(key)
+		if err != nil {
+			return adapter.convertError("ListRecursively", key, err)
+		}
+
+		if current == nil {
+			return keyError("ListRecursively", key, 0, storeadapter.ErrorKeyNotFound)
+		}
+
+		if !current.isDir() {
+			return keyError("ListRecursively", key, current.stat.Mzxid, storeadapter.ErrorNodeIsNotDirectory)
+		}
+
+		dir, err = adapter.listDir(ctx, current)
+		return adapter.convertError("ListRecursively", key, err)
+	})
+
+	return dir, err
+}
+
+// listDir reads the directory and everything under it. Its Index is the
+// latest zxid at which anything within it was created, modified or deleted.
+func (adapter *ZKStoreAdapter) listDir(ctx context.Context, current *znode) (storeadapter.StoreNode, error) {
+	dir := storeadapter.StoreNode{
+		Key:        current.key,
+		Dir:        true,
+		Value:      []byte{},
+		ChildNodes: []storeadapter.StoreNode{},
+		Index:      uint64(current.stat.Pzxid),
+	}
+
+	keys, stat, err := adapter.children(current.key)
+	if err == zk.ErrNoNode {
+		return dir, nil
+	}
+	if err != nil {
+		return dir, err
+	}
+
+	if uint64(stat.Pzxid) > dir.Index {
+		dir.Index = uint64(stat.Pzxid)
+	}
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return dir, err
+		}
+
+		child, err := adapter.readNode(key)
+		if err != nil {
+			return dir, err
+		}
+
+		if child == nil {
+			continue
+		}
+
+		var node storeadapter.StoreNode
+		if child.isDir() {
+			node, err = adapter.listDir(ctx, child)
+			if err != nil {
+				return dir, err
+			}
+		} else {
+			leaf, err := child.storeNode()
+			if err != nil {
+				return dir, keyError("ListRecursively", key, child.stat.Mzxid, err)
+			}
+			node = *leaf
+		}
+
+		if node.Index > dir.Index {
+			dir.Index = node.Index
+		}
+
+		dir.ChildNodes = append(dir.ChildNodes, node)
+	}
+
+	return dir, nil
+}
+
+func (adapter *ZKStoreAdapter) Create(node storeadapter.StoreNode) error {
+	return adapter.withTimeout("Create", node.Key, func(ctx context.Context) error {
+		return adapter.CreateContext(ctx, node)
+	})
+}
+
+func (adapter *ZKStoreAdapter) CreateContext(ctx context.Context, node storeadapter.StoreNode) error {
+	return call(ctx, func() error {
+		_, err := adapter.put(ctx, "Create", node, storeadapter.KeyMissing(node.Key))
+		return err
+	})
+}
+
+func (adapter *ZKStoreAdapter) Update(node storeadapter.StoreNode) error {
+	return adapter.withTimeout("Update", node.Key, func(ctx context.Context) error {
+		return adapter.UpdateContext(ctx, node)
+	})
+}
+
+func (adapter *ZKStoreAdapter) UpdateContext(ctx context.Context, node storeadapter.StoreNode) error {
+	return call(ctx, func() error {
+		_, err := adapter.put(ctx, "Update", node, storeadapter.KeyExists(node.Key))
+		return err
+	})
+}
+
+func (adapter *ZKStoreAdapter) CompareAndSwap(oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) error {
+	return adapter.withTimeout("CompareAndSwap", newNode.Key, func(ctx context.Context) error {
+		return adapter.CompareAndSwapContext(ctx, oldNode, newNode)
+	})
+}
+
+func (adapter *ZKStoreAdapter) CompareAndSwapContext(ctx context.Context, oldNode storeadapter.StoreNode, newNode storeadapter.StoreNode) error {
+	return call(ctx, func() error {
+		_, err := adapter.put(ctx, "CompareAndSwap", newNode, storeadapter.ValueEquals(newNode.Key, oldNode.Value))
+		return err
+	})
+}
+
+func (adapter *ZKStoreAdapter) CompareAndSwapByIndex(oldNodeIndex uint64, newNode storeadapter.StoreNode) error {
+	return adapter.withTimeout("CompareAndSwapByIndex", newNode.Key, func(ctx context.Context) error {
+		return adapter.CompareAndSwapByIndexContext(ctx, oldNodeIndex, newNode)
+	})
+}
+
+func (adapter *ZKStoreAdapter) CompareAndSwapByIndexContext(ctx context.Context, oldNodeIndex uint64, newNode storeadapter.StoreNode) error {
+	return call(ctx, func() error {
+		_, err := adapter.put(ctx, "CompareAndSwapByIndex", newNode, storeadapter.IndexEquals(newNode.Key, oldNodeIndex))
+		return err
+	})
+}
+
+func (adapter *ZKStoreAdapter) SetMulti(nodes []storeadapter.StoreNode) error {
+	return adapter.withTimeout("SetMulti", "", func(ctx context.Context) error {
+		return adapter.SetMultiContext(ctx, nodes)
+	})
+}
+
+func (adapter *ZKStoreAdapter) SetMultiContext(ctx context.Context, nodes []storeadapter.StoreNode) error {
+	return adapter.fanOut(ctx, "SetMulti", nodeKeys(nodes), func(i int) (uint64, error) {
+		return adapter.put(ctx, "SetMulti", nodes[i], storeadapter.TxnCompare{})
+	})
+}
+
+// put writes the leaf as long as the comparison holds, if one is given,
+// returning the zxid it was written at. A write that changes whether the leaf
+// has a TTL replaces its znode, as does one to an empty directory.
+func (adapter *ZKStoreAdapter) put(ctx context.Context, op string, node storeadapter.StoreNode, comparison storeadapter.TxnCompare) (uint64, error) {
+	key := cleanKey(node.Key)
+	if key == "" {
+		return 0, keyError(op, key, 0, storeadapter.ErrorNodeIsDirectory)
+	}
+
+	data := encodeLeaf(node.Value, node.TTL)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		current, err := adapter.readNode(key)
+		if err != nil {
+			return 0, adapter.convertError(op, key, err)
+		}
+
+		var currentNode *storeadapter.StoreNode
+		if current != nil {
+			currentNode, err = current.storeNode()
+			if err != nil {
+				return 0, keyError(op, key, current.stat.Mzxid, err)
+			}
+		}
+
+		if comparison.Type != storeadapter.InvalidCompare {
+			if err := comparison.Check(currentNode); err != nil {
+				return 0, keyError(op, key, zxidOf(current), err)
+			}
+		}
+
+		if currentNode != nil && currentNode.Dir && current.stat.NumChildren > 0 {
+			return 0, keyError(op, key, zxidOf(current), storeadapter.ErrorNodeIsDirectory)
+		}
+
+		var zxid int64
+		switch {
+		case current == nil:
+			zxid, err = adapter.createLeaf(op, key, data, node.TTL)
+		case current.isDir():
+			zxid, err = adapter.recreate(key, current.stat.Version, data, node.TTL)
+		default:
+			zxid, err = adapter.replaceLeaf(current, data, node.TTL)
+		}
+
+		if isRace(err) {
+			continue
+		}
+
+		if err != nil {
+			return 0, adapter.convertError(op, key, err)
+		}
+
+		return uint64(zxid), nil
+	}
+}
+
+func zxidOf(current *znode) int64 {
+	if current == nil {
+		return 0
+	}
+
+	return current.stat.Mzxid
+}
+
+// ensureParents creates the directories above key that do not exist yet.
+func (adapter *ZKStoreAdapter) ensureParents(op string, key string) error {
+	for _, dirKey := range ancestors(key) {
+		dir, err := adapter.readNode(dirKey)
+		if err != nil {
+			return err
+		}
+
+		if dir == nil {
+			_, err := adapter.conn.CreateContainer(dirKey, nil, zk.FlagContainer, acl)
+			if err != nil && err != zk.ErrNodeExists {
+				return err
+			}
+
+			if err == nil {
+				continue
+			}
+
+			dir, err = adapter.readNode(dirKey)
+			if err != nil {
+				return err
+			}
+
+			if dir == nil {
+				return zk.ErrNoNode
+			}
+		}
+
+		if !dir.isDir() {
+			return keyError(op, key, dir.stat.Mzxid, storeadapter.ErrorNodeIsNotDirectory)
+		}
+	}
+
+	return nil
+}
+
+// createLeaf creates the leaf, and the directories above it.
+func (adapter *ZKStoreAdapter) createLeaf(op string, key string, data []byte, ttl uint64) (int64, error) {
+	if err := adapter.ensureParents(op, key); err != nil {
+		return 0, err
+	}
+
+	var err error
+	if ttl > 0 {
+		_, err = adapter.conn.CreateTTL(key, data, zk.FlagTTL, acl, time.Duration(ttl)*time.Second)
+	} else {
+		_, err = adapter.conn.Create(key, data, zk.FlagPersistent, acl)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return adapter.modifiedAt(key)
+}
+
+// replaceLeaf writes the leaf over current, as long as it has not changed
+// since it was read. A TTL znode cannot be turned into a plain one or back,
+// so it is created again.
+func (adapter *ZKStoreAdapter) replaceLeaf(current *znode, data []byte, ttl uint64) (int64, error) {
+	_, currentTTL, err := current.leaf()
+	if err != nil {
+		return 0, err
+	}
+
+	if (currentTTL == 0) != (ttl == 0) {
+		return adapter.recreate(current.key, current.stat.Version, data, ttl)
+	}
+
+	stat, err := adapter.conn.Set(current.key, data, current.stat.Version)
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.Mzxid, nil
+}
+
+// recreate deletes the znode at key, as long as it is still at version, and
+// creates the leaf in its place. A persistent leaf is created in the same
+// atomic request, but a TTL znode cannot be created as part of one, so it is
+// created afterwards.
+func (adapter *ZKStoreAdapter) recreate(key string, version int32, data []byte, ttl uint64) (int64, error) {
+	if ttl == 0 {
+		_, err := adapter.conn.Multi(
+			&zk.DeleteRequest{Path: key, Version: version},
+			&zk.CreateRequest{Path: key, Data: data, Acl: acl, Flags: zk.FlagPersistent},
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		return adapter.modifiedAt(key)
+	}
+
+	if err := adapter.conn.Delete(key, version); err != nil {
+		return 0, err
+	}
+
+	_, err := adapter.conn.CreateTTL(key, data, zk.FlagTTL, acl, time.Duration(ttl)*time.Second)
+	if err != nil {
+		return 0, err
+	}
+
+	return adapter.modifiedAt(key)
+}
+
+// modifiedAt returns the zxid the znode at key was last modified at.
+func (adapter *ZKStoreAdapter) modifiedAt(key string) (int64, error) {
+	_, stat, err := adapter.conn.Exists(znodePath(key))
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.Mzxid, nil
+}
+
+// deletedAt returns the zxid the directory above key last lost or gained a
+// child at.
+func (adapter *ZKStoreAdapter) deletedAt(key string) uint64 {
+	if key == "" {
+		return 0
+	}
+
+	_, stat, err := adapter.conn.Exists(znodePath(parentKey(key)))
+	if err != nil {
+		return 0
+	}
+
+	return uint64(stat.Pzxid)
+}
+
+func (adapter *ZKStoreAdapter) Delete(keys ...string) error {
+	return adapter.withTimeout("Delete", "", func(ctx context.Context) error {
+		return adapter.DeleteContext(ctx, keys...)
+	})
+}
+
+// A directory is deleted with everything under it in one atomic request,
+// which is retried if anything is added to it in the meantime.
+func (adapter *ZKStoreAdapter) DeleteContext(ctx context.Context, keys ...string) error {
+	return adapter.fanOut(ctx, "Delete", keys, func(i int) (uint64, error) {
+		key := cleanKey(keys[i])
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+
+			current, err := adapter.readNode(key)
+			if err != nil {
+				return 0, err
+			}
+
+			if current == nil {
+				return 0, keyError("Delete", key, 0, storeadapter.ErrorKeyNotFound)
+			}
+
+			doomed, err := adapter.subtree(key)
+			if err != nil && !isRace(err) {
+				return 0, err
+			}
+
+			if err == nil && len(doomed) == 0 {
+				return 0, keyError("Delete", key, current.stat.Pzxid, storeadapter.ErrorKeyNotFound)
+			}
+
+			if err == nil {
+				requests := make([]interface{}, len(doomed))
+				for i, doomedKey := range doomed {
+					requests[i] = &zk.DeleteRequest{Path: doomedKey, Version: -1}
+				}
+
+				_, err = adapter.conn.Multi(requests...)
+			}
+
+			if isRace(err) {
+				continue
+			}
+
+			if err != nil {
+				return 0, err
+			}
+
+			return adapter.deletedAt(key), nil
+		}
+	})
+}
+
+// subtree returns the keys of the znodes at and under key, each after those
+// under it. The root itself is left out.
+func (adapter *ZKStoreAdapter) subtree(key string) ([]string, error) {
+	keys, _, err := adapter.children(key)
+	if err != nil {
+		return nil, err
+	}
+
+	doomed := []string{}
+	for _, child := range keys {
+		under, err := adapter.subtree(child)
+		if err != nil {
+			return nil, err
+		}
+
+		doomed = append(doomed, under...)
+	}
+
+	if key != "" {
+		doomed = append(doomed, key)
+	}
+
+	return doomed, nil
+}
+
+func (adapter *ZKStoreAdapter) DeleteLeaves(keys ...string) error {
+	return adapter.withTimeout("DeleteLeaves", "", func(ctx context.Context) error {
+		return adapter.DeleteLeavesContext(ctx, keys...)
+	})
+}
+
+// DeleteLeaves deletes leaves and empty directories, and fails for any
+// directory with something under it.
+func (adapter *ZKStoreAdapter) DeleteLeavesContext(ctx context.Context, keys ...string) error {
+	return adapter.fanOut(ctx, "DeleteLeaves", keys, func(i int) (uint64, error) {
+		key := cleanKey(keys[i])
+		if key == "" {
+			return 0, errRootReadOnly
+		}
+
+		err := adapter.conn.Delete(key, -1)
+		switch err {
+		case nil:
+			return adapter.deletedAt(key), nil
+		case zk.ErrNotEmpty:
+			return 0, keyError("DeleteLeaves", key, 0, storeadapter.ErrorDirectoryNotEmpty)
+		case zk.ErrNoNode:
+			return 0, keyError("DeleteLeaves", key, 0, storeadapter.ErrorKeyNotFound)
+		}
+
+		return 0, err
+	})
+}
+
+func (adapter *ZKStoreAdapter) CompareAndDelete(nodes ...storeadapter.StoreNode) error {
+	return adapter.withTimeout("CompareAndDelete", "", func(ctx context.Context) error {
+		return adapter.CompareAndDeleteContext(ctx, nodes...)
+	})
+}
+
+func (adapter *ZKStoreAdapter) CompareAndDeleteContext(ctx context.Context, nodes ...storeadapter.StoreNode) error {
+	return adapter.fanOut(ctx, "CompareAndDelete", nodeKeys(nodes), func(i int) (uint64, error) {
+		return adapter.compareAndDelete(ctx, "CompareAndDelete", storeadapter.ValueEquals(nodes[i].Key, nodes[i].Value))
+	})
+}
+
+func (adapter *ZKStoreAdapter) CompareAndDeleteByIndex(nodes ...storeadapter.StoreNode) error {
+	return adapter.withTimeout("CompareAndDeleteByIndex", "", func(ctx context.Context) error {
+		return adapter.CompareAndDeleteByIndexContext(ctx, nodes...)
+	})
+}
+
+func (adapter *ZKStoreAdapter) CompareAndDeleteByIndexContext(ctx context.Context, nodes ...storeadapter.StoreNode) error {
+	return adapter.fanOut(ctx, "CompareAndDeleteByIndex", nodeKeys(nodes), func(i int) (uint64, error) {
+		return adapter.compareAndDelete(ctx, "CompareAndDeleteByIndex", storeadapter.IndexEquals(nodes[i].Key, nodes[i].Index))
+	})
+}
+
+// compareAndDelete deletes the leaf compared as long as the comparison holds.
+func (adapter *ZKStoreAdapter) compareAndDelete(ctx context.Context, op string, comparison storeadapter.TxnCompare) (uint64, error) {
+	key := cleanKey(comparison.Node.Key)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		current, err := adapter.readNode(key)
+		if err != nil {
+			return 0, err
+		}
+
+		var currentNode *storeadapter.StoreNode
+		if current != nil {
+			currentNode, err = current.storeNode()
+			if err != nil {
+				return 0, keyError(op, key, current.stat.Mzxid, err)
+			}
+		}
+
+		if err := comparison.Check(currentNode); err != nil {
+			return 0, keyError(op, key, zxidOf(current), err)
+		}
+
+		err = adapter.conn.Delete(key, current.stat.Version)
+		if isRace(err) {
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		return adapter.deletedAt(key), nil
+	}
+}
+
+func (adapter *ZKStoreAdapter) Txn(comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	return adapter.withTimeout("Txn", "", func(ctx context.Context) error {
+		return adapter.TxnContext(ctx, comparisons, operations)
+	})
+}
+
+func (adapter *ZKStoreAdapter) TxnContext(ctx context.Context, comparisons []storeadapter.TxnCompare, operations []storeadapter.TxnOp) error {
+	return call(ctx, func() error {
+		return adapter.txn(ctx, comparisons, operations)
+	})
+}
+
+func (adapter *ZKStoreAdapter) UpdateDirTTL(key string, ttl uint64) error {
+	return adapter.withTimeout("UpdateDirTTL", key, func(ctx context.Context) error {
+		return adapter.UpdateDirTTLContext(ctx, key, ttl)
+	})
+}
+
+// A znode's TTL is fixed when it is created, and a directory cannot have one,
+// so UpdateDirTTL writes every leaf under the directory again with the TTL,
+// and each of them is reported to watchers as updated. Leaves written to the
+// directory afterwards do not get it.
+func (adapter *ZKStoreAdapter) UpdateDirTTLContext(ctx context.Context, key string, ttl uint64) error {
+	key = cleanKey(key)
+	if key == "" {
+		return adapter.convertError("UpdateDirTTL", key, errRootReadOnly)
+	}
+
+	return call(ctx, func() error {
+		current, err := adapter.readNode(key)
+		if err != nil {
+			return adapter.convertError("UpdateDirTTL", key, err)
+		}
+
+		if current == nil {
+			return keyError("UpdateDirTTL", key, 0, storeadapter.ErrorKeyNotFound)
+		}
+
+		if !current.isDir() {
+			return keyError("UpdateDirTTL", key, current.stat.Mzxid, storeadapter.ErrorNodeIsNotDirectory)
+		}
+
+		keys, err := adapter.subtree(key)
+		if err != nil {
+			return adapter.convertError("UpdateDirTTL", key, err)
+		}
+
+		for _, leafKey := range keys {
+			if err := adapter.rewriteLeaf(ctx, leafKey, ttl); err != nil {
+				return adapter.convertError("UpdateDirTTL", leafKey, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// rewriteLeaf writes the leaf at key again with the TTL, keeping its value.
+// It does nothing for a directory, or for a leaf that has gone.
+func (adapter *ZKStoreAdapter) rewriteLeaf(ctx context.Context, key string, ttl uint64) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		current, err := adapter.readNode(key)
+		if err != nil {
+			return err
+		}
+
+		if current == nil || current.isDir() {
+			return nil
+		}
+
+		value, _, err := current.leaf()
+		if err != nil {
+			return err
+		}
+
+		_, err = adapter.replaceLeaf(current, encodeLeaf(value, ttl), ttl)
+		if isRace(err) {
+			continue
+		}
+
+		return err
+	}
+}
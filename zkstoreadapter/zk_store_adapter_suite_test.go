@@ -0,0 +1,73 @@
+package zkstoreadapter_test
+
+import (
+	"os"
+	"os/signal"
+	"testing"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/config"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry/storeadapter/storerunner/zookeeperstorerunner"
+)
+
+var (
+	zkRunner *zookeeperstorerunner.ZookeeperClusterRunner
+	zkConn   *zk.Conn
+)
+
+func TestStoreAdapter(t *testing.T) {
+	registerSignalHandler()
+	RegisterFailHandler(Fail)
+
+	SetDefaultEventuallyTimeout(5 * time.Second)
+
+	RunSpecs(t, "ZooKeeper Store Adapter Suite")
+}
+
+var _ = SynchronizedBeforeSuite(func() []byte {
+	return nil
+}, func([]byte) {
+	zkPort := 5200 + (config.GinkgoConfig.ParallelNode)*10
+	zkRunner = zookeeperstorerunner.NewZookeeperClusterRunner(zkPort, 1)
+	zkRunner.Start()
+
+	var err error
+	zkConn, _, err = zk.Connect(zkRunner.NodeURLS(), 2*time.Second, zk.WithLogInfo(false))
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = SynchronizedAfterSuite(func() {
+	stopStores()
+}, func() {
+})
+
+var _ = BeforeEach(func() {
+	zkRunner.Reset()
+})
+
+func stopStores() {
+	if zkConn != nil {
+		zkConn.Close()
+	}
+
+	if zkRunner != nil {
+		zkRunner.Stop()
+	}
+}
+
+func registerSignalHandler() {
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, os.Kill)
+
+		select {
+		case <-c:
+			stopStores()
+			os.Exit(0)
+		}
+	}()
+}